@@ -15,6 +15,8 @@ import (
 
 func main() {
 	configFile := flag.String("config", "config.yaml", "Path to configuration file")
+	deterministic := flag.Bool("deterministic", false, "Use a reproducible backend selection order")
+	seed := flag.Int64("seed", 0, "Starting offset for deterministic selection")
 	flag.Parse()
 
 	// Load configuration
@@ -22,6 +24,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if *deterministic {
+		cfg.Deterministic = true
+		cfg.Seed = *seed
+	}
 
 	// Initialize metrics
 	metrics := metrics.New()
@@ -36,14 +42,20 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Handle shutdown signals
+	// Handle shutdown signals; SIGHUP reloads the configuration
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	go func() {
-		sig := <-sigChan
-		log.Printf("Received signal: %v", sig)
-		cancel()
+		for sig := range sigChan {
+			if sig == syscall.SIGHUP {
+				reload(lb, *configFile)
+				continue
+			}
+			log.Printf("Received signal: %v", sig)
+			cancel()
+			return
+		}
 	}()
 
 	// Start the load balancer
@@ -51,3 +63,18 @@ func main() {
 		log.Fatalf("Load balancer error: %v", err)
 	}
 }
+
+// reload re-reads the configuration file and applies it to lb. An invalid
+// file is reported and the running configuration kept.
+func reload(lb *balancer.LoadBalancer, configFile string) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Printf("Configuration reload failed: %v", err)
+		return
+	}
+	if err := lb.Reload(cfg); err != nil {
+		log.Printf("Configuration reload failed: %v", err)
+		return
+	}
+	log.Printf("Configuration reloaded from %s", configFile)
+}
@@ -0,0 +1,42 @@
+package balancer
+
+import (
+	"net/http"
+	"time"
+)
+
+// requestTiming records when a request passed each stage of ServeHTTP and
+// where it was sent
+type requestTiming struct {
+	received time.Time // request entered ServeHTTP
+	admitted time.Time // request passed the circuit breaker and rate limiter
+	backend  string    // URL of the last backend the request was sent to
+}
+
+// breakdown splits the request lifetime into time spent waiting to be
+// admitted, time to first byte from the backend, and total time. Stages
+// that were never reached are reported as zero.
+func (t *requestTiming) breakdown(firstByte, done time.Time) (queue, ttfb, total time.Duration) {
+	total = done.Sub(t.received)
+	if t.admitted.IsZero() {
+		return total, 0, total
+	}
+
+	queue = t.admitted.Sub(t.received)
+	if !firstByte.IsZero() {
+		ttfb = firstByte.Sub(t.admitted)
+	}
+	return queue, ttfb, total
+}
+
+// logAccess emits an access log line for a completed request when access
+// logging is enabled
+func (lb *LoadBalancer) logAccess(r *http.Request, rw *responseWriter, timing *requestTiming) {
+	if lb.config == nil || !lb.config.Logging.AccessLog || isCacheRefresh(r) {
+		return
+	}
+
+	queue, ttfb, total := timing.breakdown(rw.firstByte, time.Now())
+	lb.logger.Info("access", "method", r.Method, "path", r.URL.Path, "backend", timing.backend,
+		"status", rw.status, "bytes", rw.bytes, "queue", queue, "ttfb", ttfb, "total", total)
+}
@@ -0,0 +1,108 @@
+package balancer
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestAccessLogTimingBreakdown(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		Logging:  config.Logging{AccessLog: true},
+	}
+	lb, err := New(cfg, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	var buf bytes.Buffer
+	lb.logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	req := httptest.NewRequest("GET", "/report", nil)
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, req)
+
+	line := buf.String()
+	fields := regexp.MustCompile(`queue=(\S+) ttfb=(\S+) total=(\S+)`).FindStringSubmatch(line)
+	if fields == nil {
+		t.Fatalf("Expected timing fields in access log, got %q", line)
+	}
+
+	var durations []time.Duration
+	for _, field := range fields[1:] {
+		d, err := time.ParseDuration(field)
+		if err != nil {
+			t.Fatalf("Failed to parse duration %q: %v", field, err)
+		}
+		durations = append(durations, d)
+	}
+	queue, ttfb, total := durations[0], durations[1], durations[2]
+
+	if ttfb < 50*time.Millisecond {
+		t.Errorf("Expected ttfb to include backend latency, got %v", ttfb)
+	}
+	if total < queue+ttfb {
+		t.Errorf("Expected total %v to cover queue %v and ttfb %v", total, queue, ttfb)
+	}
+	want := "msg=access method=GET path=/report backend=" + backend.URL + " status=200 bytes=7 "
+	if !strings.Contains(line, want) {
+		t.Errorf("Expected request fields %q in access log, got %q", want, line)
+	}
+}
+
+func TestAccessLogDisabled(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{Backends: []config.Backend{{URL: backend.URL}}}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	var buf bytes.Buffer
+	lb.logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if buf.Len() != 0 {
+		t.Errorf("Expected no access log when disabled, got %q", buf.String())
+	}
+}
+
+func TestRequestTimingBreakdown(t *testing.T) {
+	received := time.Now()
+	timing := &requestTiming{received: received}
+
+	// Rejected before admission: everything counts as queue time
+	queue, ttfb, total := timing.breakdown(time.Time{}, received.Add(10*time.Millisecond))
+	if queue != 10*time.Millisecond || ttfb != 0 || total != 10*time.Millisecond {
+		t.Errorf("Unexpected breakdown for rejected request: %v %v %v", queue, ttfb, total)
+	}
+
+	timing.admitted = received.Add(2 * time.Millisecond)
+	queue, ttfb, total = timing.breakdown(received.Add(7*time.Millisecond), received.Add(9*time.Millisecond))
+	if queue != 2*time.Millisecond || ttfb != 5*time.Millisecond || total != 9*time.Millisecond {
+		t.Errorf("Unexpected breakdown: %v %v %v", queue, ttfb, total)
+	}
+}
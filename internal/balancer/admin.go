@@ -0,0 +1,339 @@
+package balancer
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+// backendStatus is the admin API's view of a backend
+type backendStatus struct {
+	URL               string `json:"url"`
+	Healthy           bool   `json:"healthy"`
+	ActiveConnections int64  `json:"activeConnections"`
+	TotalRequests     uint64 `json:"totalRequests"`
+
+	// Outcomes of requests proxied to the backend within the stats window
+	RecentSuccesses int     `json:"recentSuccesses"`
+	RecentFailures  int     `json:"recentFailures"`
+	ErrorRatio      float64 `json:"errorRatio"`
+
+	Circuit circuitStatus `json:"circuit"`
+}
+
+// circuitStatus is the admin API's view of a backend's circuit breaker.
+// While half-open, the trial counts show how close it is to closing or
+// reopening.
+type circuitStatus struct {
+	State             string `json:"state"`
+	Failures          int    `json:"failures"`
+	HalfOpenSuccesses int    `json:"halfOpenSuccesses"`
+	HalfOpenFailures  int    `json:"halfOpenFailures"`
+	SuccessesNeeded   int    `json:"successesNeeded"`
+	FailuresAllowed   int    `json:"failuresAllowed"`
+}
+
+// defaultStatsWindow is how far back backend outcomes are reported by default
+const defaultStatsWindow = time.Minute
+
+// statsWindow returns the span of the per-backend outcome counts
+func (lb *LoadBalancer) statsWindow() time.Duration {
+	if lb.config == nil || lb.config.Admin.StatsWindow <= 0 {
+		return defaultStatsWindow
+	}
+	return lb.config.Admin.StatsWindow
+}
+
+// serveAdmin runs the admin API until ctx is cancelled
+func (lb *LoadBalancer) serveAdmin(ctx context.Context, cfg config.Admin) error {
+	server := &http.Server{
+		Addr:    net.JoinHostPort(cfg.Address, strconv.Itoa(cfg.Port)),
+		Handler: lb.adminHandler(),
+	}
+	if lb.adminSSL != nil {
+		server.TLSConfig = lb.adminSSL.GetTLSConfig().Clone()
+		useCurrentCertificates(server.TLSConfig, lb.adminSSL)
+	}
+	if !cfg.Auth.Enabled() {
+		lb.logger.Warn("admin API has no authentication configured", "address", server.Addr)
+	}
+
+	if err := lb.serveUntilDone(ctx, "admin", server); err != nil {
+		return fmt.Errorf("admin server error: %v", err)
+	}
+	return nil
+}
+
+// adminHandler routes the admin API:
+//
+//	GET    /healthz                        liveness
+//	GET    /backends                       backend state
+//	POST   /backends                       add a backend
+//	DELETE /backends?url=                  remove a backend
+//	POST   /backends/health?url=           probe a backend now
+//	POST   /backends/pin?url=&percent=     pin a share of traffic to a backend
+//	DELETE /backends/pin                   remove the pin
+//	GET    /rollout                        rollout progress
+//	GET    /debug/stats                    goroutine, connection and memory counts
+//
+// Every route requires the credentials in admin.auth, when configured.
+func (lb *LoadBalancer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+
+	mux.HandleFunc("/backends", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, lb.backendStatuses())
+		case http.MethodPost:
+			var backend config.Backend
+			if err := json.NewDecoder(r.Body).Decode(&backend); err != nil {
+				http.Error(w, "invalid backend: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := lb.AddBackend(backend); err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			if err := lb.RemoveBackend(r.URL.Query().Get("url")); err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/backends/health", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		backendURL := r.URL.Query().Get("url")
+		if backendURL == "" {
+			http.Error(w, "missing url parameter", http.StatusBadRequest)
+			return
+		}
+
+		healthy, err := lb.ProbeBackend(r.Context(), backendURL)
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"url": backendURL, "healthy": healthy})
+	})
+
+	mux.HandleFunc("/backends/pin", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			percent, err := strconv.ParseFloat(r.URL.Query().Get("percent"), 64)
+			if err != nil {
+				http.Error(w, "invalid percent parameter", http.StatusBadRequest)
+				return
+			}
+			if err := lb.PinTraffic(r.URL.Query().Get("url"), percent); err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			lb.Unpin()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/rollout", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, lb.RolloutStatus())
+	})
+
+	mux.HandleFunc("/debug/stats", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, lb.debugStats())
+	})
+
+	if lb.config == nil || !lb.config.Admin.Auth.Enabled() {
+		return mux
+	}
+	return requireAdminAuth(lb.config.Admin.Auth, mux)
+}
+
+// requireAdminAuth rejects requests to next with 401 unless they carry the
+// bearer token or basic auth credentials in auth
+func requireAdminAuth(auth config.AdminAuth, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.Token != "" {
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && credentialsMatch(token, auth.Token) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if auth.Username != "" {
+			if username, password, ok := r.BasicAuth(); ok &&
+				credentialsMatch(username, auth.Username) && credentialsMatch(password, auth.Password) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if auth.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// credentialsMatch compares a presented credential with the configured one
+// in constant time. Hashing first keeps the comparison from revealing the
+// configured length.
+func credentialsMatch(presented, configured string) bool {
+	a, b := sha256.Sum256([]byte(presented)), sha256.Sum256([]byte(configured))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// debugStats is a quick look at the balancer's runtime state, lighter than
+// a pprof profile
+type debugStats struct {
+	Goroutines        int                  `json:"goroutines"`
+	ActiveConnections float64              `json:"activeConnections"`
+	Backends          []backendConnections `json:"backends"`
+	Memory            memoryStats          `json:"memory"`
+}
+
+type backendConnections struct {
+	URL               string `json:"url"`
+	ActiveConnections int64  `json:"activeConnections"`
+}
+
+type memoryStats struct {
+	AllocBytes     uint64 `json:"allocBytes"`
+	HeapInuseBytes uint64 `json:"heapInuseBytes"`
+	SysBytes       uint64 `json:"sysBytes"`
+	NumGC          uint32 `json:"numGC"`
+}
+
+// debugStats snapshots the runtime and connection counts
+func (lb *LoadBalancer) debugStats() debugStats {
+	var active dto.Metric
+	lb.metrics.ActiveConnections.Write(&active)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := debugStats{
+		Goroutines:        runtime.NumGoroutine(),
+		ActiveConnections: active.GetGauge().GetValue(),
+		Backends:          []backendConnections{},
+		Memory: memoryStats{
+			AllocBytes:     mem.Alloc,
+			HeapInuseBytes: mem.HeapInuse,
+			SysBytes:       mem.Sys,
+			NumGC:          mem.NumGC,
+		},
+	}
+
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	for _, b := range lb.backends {
+		stats.Backends = append(stats.Backends, backendConnections{
+			URL:               b.URL.String(),
+			ActiveConnections: b.ActiveConns.Load(),
+		})
+	}
+	return stats
+}
+
+// backendStatuses snapshots the state of every backend
+func (lb *LoadBalancer) backendStatuses() []backendStatus {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	now := time.Now()
+	statuses := make([]backendStatus, 0, len(lb.backends))
+	for _, b := range lb.backends {
+		requests, failures := b.outcomes.Counts(now)
+		circuit := b.CircuitBreaker.Stats()
+		status := backendStatus{
+			URL:               b.URL.String(),
+			Healthy:           b.Healthy.Load(),
+			ActiveConnections: b.ActiveConns.Load(),
+			TotalRequests:     b.TotalRequests.Load(),
+			RecentSuccesses:   requests - failures,
+			RecentFailures:    failures,
+			Circuit: circuitStatus{
+				State:             circuit.State.String(),
+				Failures:          circuit.Failures,
+				HalfOpenSuccesses: circuit.HalfOpenSuccesses,
+				HalfOpenFailures:  circuit.HalfOpenFailures,
+				SuccessesNeeded:   circuit.SuccessesNeeded,
+				FailuresAllowed:   circuit.FailuresAllowed,
+			},
+		}
+		if requests > 0 {
+			status.ErrorRatio = float64(failures) / float64(requests)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// allowMethod replies with 405 unless r uses method
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAdminError maps balancer errors onto HTTP status codes
+func writeAdminError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch errors.GetCode(err) {
+	case errors.ErrConfigInvalid:
+		status = http.StatusBadRequest
+	case errors.ErrBackendUnavailable:
+		status = http.StatusNotFound
+	case errors.ErrBackendExists, errors.ErrRolloutInProgress:
+		status = http.StatusConflict
+	}
+	http.Error(w, errors.GetMessage(err), status)
+}
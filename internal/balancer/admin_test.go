@@ -0,0 +1,523 @@
+package balancer
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestAdminHandler(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}, {URL: "http://localhost:8002"}},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	lb.backends[1].Healthy.Store(false)
+	lb.backends[0].TotalRequests.Add(3)
+
+	admin := httptest.NewServer(lb.adminHandler())
+	defer admin.Close()
+
+	// Liveness
+	resp, err := http.Get(admin.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected /healthz status 200, got %d", resp.StatusCode)
+	}
+
+	// Backend listing
+	resp, err = http.Get(admin.URL + "/backends")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	var statuses []backendStatus
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		t.Fatalf("Failed to decode /backends: %v", err)
+	}
+	resp.Body.Close()
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 backends, got %d", len(statuses))
+	}
+	if statuses[0].URL != backend.URL || !statuses[0].Healthy || statuses[0].TotalRequests != 3 {
+		t.Errorf("Unexpected status for first backend: %+v", statuses[0])
+	}
+	if statuses[1].Healthy {
+		t.Error("Expected second backend to be reported unhealthy")
+	}
+
+	// Forced probe and pinning map errors onto HTTP status codes
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"POST", "/backends/health?url=" + url.QueryEscape(backend.URL), http.StatusOK},
+		{"POST", "/backends/health?url=" + url.QueryEscape("http://localhost:1"), http.StatusNotFound},
+		{"GET", "/backends/health?url=" + url.QueryEscape(backend.URL), http.StatusMethodNotAllowed},
+		{"POST", "/backends/pin?url=" + url.QueryEscape(backend.URL) + "&percent=5", http.StatusNoContent},
+		{"POST", "/backends/pin?url=" + url.QueryEscape(backend.URL) + "&percent=500", http.StatusBadRequest},
+		{"DELETE", "/backends/pin", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, admin.URL+tt.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.wantStatus, resp.StatusCode)
+		}
+	}
+}
+
+func TestAdminBackendErrorRatio(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var urls []string
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		status := status
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+
+	lb, err := New(&config.Config{Backends: config.BackendsFromURLs(urls)}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	// Alternate between the backends, staying below the circuit threshold
+	for i := 0; i < 8; i++ {
+		lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	admin := httptest.NewServer(lb.adminHandler())
+	defer admin.Close()
+
+	resp, err := http.Get(admin.URL + "/backends")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var statuses []backendStatus
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		t.Fatalf("Failed to decode /backends: %v", err)
+	}
+
+	healthy, failing := statuses[0], statuses[1]
+	if healthy.RecentSuccesses != 4 || healthy.RecentFailures != 0 || healthy.ErrorRatio != 0 {
+		t.Errorf("Unexpected outcomes for healthy backend: %+v", healthy)
+	}
+	if failing.RecentSuccesses != 0 || failing.RecentFailures != 4 || failing.ErrorRatio != 1 {
+		t.Errorf("Unexpected outcomes for failing backend: %+v", failing)
+	}
+}
+
+func TestAdminDebugStats(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: "http://localhost:8001"}, {URL: "http://localhost:8002"}},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	lb.backends[1].ActiveConns.Add(2)
+
+	// Client connections are counted by the frontend server
+	server, err := lb.newFrontendServer(config.Frontend{})
+	if err != nil {
+		t.Fatalf("Failed to build frontend server: %v", err)
+	}
+	frontend := httptest.NewUnstartedServer(server.Handler)
+	frontend.Config = server
+	frontend.Start()
+	defer frontend.Close()
+
+	admin := httptest.NewServer(lb.adminHandler())
+	defer admin.Close()
+
+	getStats := func() debugStats {
+		t.Helper()
+		resp, err := http.Get(admin.URL + "/debug/stats")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+		var stats debugStats
+		if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+			t.Fatalf("Failed to decode /debug/stats: %v", err)
+		}
+		return stats
+	}
+	awaitConnections := func(want float64) debugStats {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			stats := getStats()
+			if stats.ActiveConnections == want {
+				return stats
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %v active connections, got %v", want, stats.ActiveConnections)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Open two keep-alive connections, each serving a request the balancer
+	// answers itself
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte("OPTIONS * HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+			t.Fatalf("Failed to write request: %v", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		resp.Body.Close()
+		conns = append(conns, conn)
+	}
+
+	stats := awaitConnections(2)
+	if stats.Goroutines <= 0 {
+		t.Errorf("Expected a positive goroutine count, got %d", stats.Goroutines)
+	}
+	if len(stats.Backends) != 2 || stats.Backends[0].ActiveConnections != 0 || stats.Backends[1].ActiveConnections != 2 {
+		t.Errorf("Unexpected backend connections: %+v", stats.Backends)
+	}
+	if stats.Memory.AllocBytes == 0 || stats.Memory.SysBytes < stats.Memory.HeapInuseBytes {
+		t.Errorf("Implausible memory stats: %+v", stats.Memory)
+	}
+
+	// A closed connection is no longer counted
+	conns[0].Close()
+	awaitConnections(1)
+
+	resp, err := http.Post(admin.URL+"/debug/stats", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for POST, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminServer(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Admin: config.Admin{Address: "127.0.0.1", Port: 19091}, // Use high port number to avoid conflicts
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- lb.Start(ctx)
+	}()
+
+	// Poll until the server is listening
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err := http.Get("http://127.0.0.1:19091/healthz")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Admin server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The server stops with the balancer
+	cancel()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("Expected no error on shutdown, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for admin server shutdown")
+	}
+}
+
+// writeCertificate writes a certificate for name into dir, signed by parent
+// or self-signed when parent is nil, returning the written files and the
+// parsed certificate and key for signing further certificates
+func writeCertificate(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (certFile, keyFile string, cert *x509.Certificate, key *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	certFile = filepath.Join(dir, name+"-cert.pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	return certFile, keyFile, cert, key
+}
+
+func TestAdminServerMutualTLS(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	dir := t.TempDir()
+	caFile, _, ca, caKey := writeCertificate(t, dir, "ca", nil, nil)
+	serverCert, serverKey, _, _ := writeCertificate(t, dir, "server", ca, caKey)
+	clientCert, clientKey, _, _ := writeCertificate(t, dir, "client", ca, caKey)
+
+	lb, err := New(&config.Config{
+		Admin: config.Admin{
+			Address: "127.0.0.1",
+			Port:    19092, // Use high port number to avoid conflicts
+			TLS:     &config.SSL{CertFile: serverCert, KeyFile: serverKey, CAFile: caFile},
+		},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- lb.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-errChan
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(certificates ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates},
+		}}
+		return client.Get("https://127.0.0.1:19092/healthz")
+	}
+
+	// Poll with a client certificate until the server is listening
+	pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatalf("Failed to load client certificate: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err := get(pair)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Expected status 200 with a client certificate, got %d", resp.StatusCode)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Admin server did not accept a client certificate: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Without a client certificate the handshake is refused
+	if resp, err := get(); err == nil {
+		resp.Body.Close()
+		t.Error("Expected admin request without a client certificate to fail")
+	}
+
+	// Plain HTTP is not served
+	if resp, err := http.Get("http://127.0.0.1:19092/healthz"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Error("Expected plain HTTP admin request to be refused")
+		}
+	}
+}
+
+func TestAdminAddRemoveBackend(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: "http://localhost:8001"}},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	admin := httptest.NewServer(lb.adminHandler())
+	defer admin.Close()
+
+	do := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, admin.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"POST", "/backends", `{"url":"http://localhost:8002","weight":3}`, http.StatusCreated},
+		{"POST", "/backends", `{"url":"http://localhost:8002"}`, http.StatusConflict},
+		{"POST", "/backends", `{"url":"not-a-url"}`, http.StatusBadRequest},
+		{"POST", "/backends", `not json`, http.StatusBadRequest},
+		{"DELETE", "/backends?url=" + url.QueryEscape("http://localhost:9999"), "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if got := do(tt.method, tt.path, tt.body); got != tt.wantStatus {
+			t.Errorf("%s %s %s: expected status %d, got %d", tt.method, tt.path, tt.body, tt.wantStatus, got)
+		}
+	}
+
+	// The added backend takes traffic at its weight
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		counts[lb.nextBackend().ID]++
+	}
+	if counts["http://localhost:8001"] != 2 || counts["http://localhost:8002"] != 6 {
+		t.Errorf("Expected a 1:3 split after adding a backend, got %v", counts)
+	}
+
+	// A removed backend stops receiving traffic
+	if got := do("DELETE", "/backends?url="+url.QueryEscape("http://localhost:8001"), ""); got != http.StatusNoContent {
+		t.Fatalf("Expected status 204 removing backend, got %d", got)
+	}
+	for i := 0; i < 4; i++ {
+		if b := lb.nextBackend(); b == nil || b.ID != "http://localhost:8002" {
+			t.Fatalf("Expected only the remaining backend to be selected, got %v", b)
+		}
+	}
+	if statuses := lb.backendStatuses(); len(statuses) != 1 {
+		t.Errorf("Expected 1 backend listed after removal, got %d", len(statuses))
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: "http://localhost:8001"}},
+		Admin: config.Admin{Auth: config.AdminAuth{
+			Token:    "s3cret-token",
+			Username: "ops",
+			Password: "s3cret-password",
+		}},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	admin := httptest.NewServer(lb.adminHandler())
+	defer admin.Close()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		authorize  func(*http.Request)
+		wantStatus int
+	}{
+		{"no credentials", "GET", "/backends", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"no credentials on healthz", "GET", "/healthz", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"no credentials on remove", "DELETE", "/backends?url=" + url.QueryEscape("http://localhost:8001"), func(r *http.Request) {}, http.StatusUnauthorized},
+		{"wrong token", "GET", "/backends", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusUnauthorized},
+		{"token without scheme", "GET", "/backends", func(r *http.Request) { r.Header.Set("Authorization", "s3cret-token") }, http.StatusUnauthorized},
+		{"wrong password", "GET", "/backends", func(r *http.Request) { r.SetBasicAuth("ops", "s3cret-token") }, http.StatusUnauthorized},
+		{"token", "GET", "/backends", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret-token") }, http.StatusOK},
+		{"basic auth", "GET", "/debug/stats", func(r *http.Request) { r.SetBasicAuth("ops", "s3cret-password") }, http.StatusOK},
+		{"token on remove", "DELETE", "/backends?url=" + url.QueryEscape("http://localhost:8001"), func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer s3cret-token")
+		}, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, admin.URL+tt.path, nil)
+		tt.authorize(req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.wantStatus, resp.StatusCode)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate challenge", tt.name)
+		}
+	}
+
+	// The backend was left in place until the authorized remove
+	if len(lb.backendStatuses()) != 0 {
+		t.Errorf("Expected the authorized remove to take effect, got %d backends", len(lb.backendStatuses()))
+	}
+}
@@ -0,0 +1,108 @@
+package algorithm
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Names of the available selection algorithms
+const (
+	WeightedRoundRobinName = "weighted_round_robin"
+	IPHashName             = "iphash"
+	RandomName             = "random"
+	WeightedRandomName     = "weighted_random"
+)
+
+// Selector chooses which backend, identified by ID, receives the next
+// request. Implementations must be safe for concurrent use.
+type Selector interface {
+	// Add adds a backend, or updates its weight if the ID is present
+	Add(id string, weight int)
+	// Remove removes a backend by ID
+	Remove(id string)
+	// Next returns the next backend, or nil if there are none
+	Next() *WeightedBackend
+	// UpdateWeight changes a backend's weight, reporting whether it exists
+	UpdateWeight(id string, weight int) bool
+	// TotalWeight returns the sum of backend weights. Callers that skip
+	// unusable backends retry Next at most this many times.
+	TotalWeight() int
+}
+
+// Freezer is implemented by balancers whose weights can otherwise drift at
+// runtime. Freeze pins them to the configured values.
+type Freezer interface {
+	Freeze()
+}
+
+// WeightAdjuster is implemented by balancers whose effective weights can be
+// shifted at runtime, leaving the configured weights unchanged
+type WeightAdjuster interface {
+	// AdjustWeight adds delta to a backend's effective weight, reporting
+	// whether the adjustment was applied
+	AdjustWeight(id string, delta int) bool
+	// Weights returns a backend's configured and effective weights
+	Weights(id string) (weight, effective int, ok bool)
+}
+
+// KeyedSelector is implemented by selectors that choose by a key for the
+// request, the client IP, rather than in turn. NextFor returns the backend
+// for key, or with skip > 0 the skip-th fallback after it, so callers can
+// pass over unusable backends; nil once there are no more.
+type KeyedSelector interface {
+	NextFor(key string, skip int) *WeightedBackend
+}
+
+var (
+	_ Selector       = (*WeightedRoundRobin)(nil)
+	_ WeightAdjuster = (*WeightedRoundRobin)(nil)
+	_ Selector       = (*IPHash)(nil)
+	_ KeyedSelector  = (*IPHash)(nil)
+	_ Selector       = (*Random)(nil)
+	_ Selector       = (*WeightedRandom)(nil)
+)
+
+// Factory returns a new, empty Selector
+type Factory func() Selector
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{
+		WeightedRoundRobinName: func() Selector { return NewWeightedRoundRobin() },
+		IPHashName:             func() Selector { return NewIPHash() },
+		RandomName:             func() Selector { return NewRandom() },
+		WeightedRandomName:     func() Selector { return NewWeightedRandom() },
+	}
+)
+
+// RegisterSelector makes a selection algorithm available to New, and so to
+// the config, under name. It is meant to be called from an init function
+// and panics if name is empty or already registered, or factory is nil.
+func RegisterSelector(name string, factory Factory) {
+	if name == "" || factory == nil {
+		panic("algorithm: RegisterSelector needs a name and a factory")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("algorithm: selector %q registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// New returns an empty selector for the named algorithm. An empty name
+// selects weighted round-robin.
+func New(name string) (Selector, error) {
+	if name == "" {
+		name = WeightedRoundRobinName
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown balancing algorithm %q", name)
+	}
+	return factory(), nil
+}
@@ -0,0 +1,75 @@
+package algorithm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{"", WeightedRoundRobinName} {
+		b, err := New(name)
+		if err != nil {
+			t.Fatalf("Expected %q to be accepted, got %v", name, err)
+		}
+		if _, ok := b.(*WeightedRoundRobin); !ok {
+			t.Errorf("Expected %q to select weighted round-robin, got %T", name, b)
+		}
+	}
+
+	for name, want := range map[string]Selector{
+		IPHashName:         &IPHash{},
+		RandomName:         &Random{},
+		WeightedRandomName: &WeightedRandom{},
+	} {
+		b, err := New(name)
+		if err != nil {
+			t.Errorf("Expected %q to be accepted, got %v", name, err)
+		} else if fmt.Sprintf("%T", b) != fmt.Sprintf("%T", want) {
+			t.Errorf("Expected %q to select %T, got %T", name, want, b)
+		}
+	}
+
+	if _, err := New("nonexistent"); err == nil {
+		t.Error("Expected error for unknown algorithm")
+	}
+}
+
+// fixedSelector stands in for a third-party algorithm
+type fixedSelector struct {
+	WeightedRoundRobin
+}
+
+func TestRegisterSelector(t *testing.T) {
+	RegisterSelector("test_fixed", func() Selector { return &fixedSelector{} })
+
+	s, err := New("test_fixed")
+	if err != nil {
+		t.Fatalf("Expected registered selector to be found, got %v", err)
+	}
+	if _, ok := s.(*fixedSelector); !ok {
+		t.Errorf("Expected a fixedSelector, got %T", s)
+	}
+
+	// Every call gets a fresh selector
+	if other, _ := New("test_fixed"); other == s {
+		t.Error("Expected New to return a new selector each time")
+	}
+
+	for _, tt := range []struct {
+		name    string
+		factory Factory
+	}{
+		{"test_fixed", func() Selector { return &fixedSelector{} }},
+		{"", func() Selector { return &fixedSelector{} }},
+		{"test_nil", nil},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected registering %q to panic", tt.name)
+				}
+			}()
+			RegisterSelector(tt.name, tt.factory)
+		}()
+	}
+}
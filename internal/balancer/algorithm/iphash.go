@@ -0,0 +1,167 @@
+package algorithm
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// virtualNodes is the number of ring positions per unit of weight. More
+// positions spread clients more evenly at the cost of a larger ring.
+const virtualNodes = 160
+
+// ringNode is one position of a backend on the hash ring
+type ringNode struct {
+	hash    uint64
+	backend *WeightedBackend
+}
+
+// IPHash maps each client, identified by its IP address, to a backend on a
+// consistent hash ring. A client keeps landing on the same backend while the
+// backend set is stable, and adding or removing a backend only moves the
+// clients on its share of the ring. Each backend gets ring positions in
+// proportion to its weight.
+type IPHash struct {
+	mu       sync.Mutex
+	backends []*WeightedBackend
+	ring     []ringNode
+	next     int
+}
+
+// NewIPHash creates an empty IPHash ring
+func NewIPHash() *IPHash {
+	return &IPHash{}
+}
+
+// hashKey hashes a client key or ring position
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// fnv alone leaves similar keys close together; mix the bits so
+	// positions spread evenly around the ring
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// rebuild recomputes the ring from the backends. Callers must hold mu.
+func (h *IPHash) rebuild() {
+	h.ring = h.ring[:0]
+	for _, backend := range h.backends {
+		for i := 0; i < backend.Weight*virtualNodes; i++ {
+			h.ring = append(h.ring, ringNode{
+				hash:    hashKey(backend.ID + "#" + strconv.Itoa(i)),
+				backend: backend,
+			})
+		}
+	}
+	sort.Slice(h.ring, func(i, j int) bool { return h.ring[i].hash < h.ring[j].hash })
+}
+
+// Add adds a backend with a specified weight, or updates its weight if the
+// ID is already present
+func (h *IPHash) Add(id string, weight int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if weight <= 0 {
+		weight = 1
+	}
+	for _, backend := range h.backends {
+		if backend.ID == id {
+			backend.Weight = weight
+			backend.EffectiveWeight = int64(weight)
+			h.rebuild()
+			return
+		}
+	}
+	h.backends = append(h.backends, &WeightedBackend{ID: id, Weight: weight, EffectiveWeight: int64(weight)})
+	h.rebuild()
+}
+
+// Remove removes a backend by ID
+func (h *IPHash) Remove(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, backend := range h.backends {
+		if backend.ID == id {
+			h.backends = append(h.backends[:i], h.backends[i+1:]...)
+			h.rebuild()
+			return
+		}
+	}
+}
+
+// Next returns backends in turn. It serves requests that carry no client
+// key; keyed requests use NextFor.
+func (h *IPHash) Next() *WeightedBackend {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.backends) == 0 {
+		return nil
+	}
+	backend := h.backends[h.next%len(h.backends)]
+	h.next++
+	return backend
+}
+
+// NextFor returns the backend owning key on the ring. With skip > 0 it
+// returns the skip-th distinct backend after that one going round the ring,
+// so a client whose backend is down moves to the same fallback every time.
+func (h *IPHash) NextFor(key string, skip int) *WeightedBackend {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if skip >= len(h.backends) {
+		return nil
+	}
+	hash := hashKey(key)
+	start := sort.Search(len(h.ring), func(i int) bool { return h.ring[i].hash >= hash })
+
+	seen := make(map[*WeightedBackend]bool, skip+1)
+	for i := 0; i < len(h.ring); i++ {
+		backend := h.ring[(start+i)%len(h.ring)].backend
+		if seen[backend] {
+			continue
+		}
+		if len(seen) == skip {
+			return backend
+		}
+		seen[backend] = true
+	}
+	return nil
+}
+
+// UpdateWeight changes a backend's weight, and so its share of the ring
+func (h *IPHash) UpdateWeight(id string, weight int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if weight <= 0 {
+		weight = 1
+	}
+	for _, backend := range h.backends {
+		if backend.ID == id {
+			backend.Weight = weight
+			backend.EffectiveWeight = int64(weight)
+			h.rebuild()
+			return true
+		}
+	}
+	return false
+}
+
+// TotalWeight returns the number of backends: a client can be passed over
+// to each of them in turn
+func (h *IPHash) TotalWeight() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.backends)
+}
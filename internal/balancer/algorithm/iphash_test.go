@@ -0,0 +1,98 @@
+package algorithm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIPHashStable(t *testing.T) {
+	h := NewIPHash()
+	h.Add("backend1", 1)
+	h.Add("backend2", 1)
+	h.Add("backend3", 1)
+
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		first := h.NextFor(key, 0)
+		if first == nil {
+			t.Fatal("Expected a backend for every key")
+		}
+		if again := h.NextFor(key, 0); again != first {
+			t.Fatalf("Expected %s to keep landing on %s, got %s", key, first.ID, again.ID)
+		}
+		counts[first.ID]++
+	}
+	for id, count := range counts {
+		if count < 700 || count > 1300 {
+			t.Errorf("Expected roughly a third of clients on %s, got %d", id, count)
+		}
+	}
+
+	// Fallbacks are the other backends, each once
+	seen := map[string]bool{}
+	for skip := 0; skip < 3; skip++ {
+		b := h.NextFor("10.0.0.1", skip)
+		if b == nil || seen[b.ID] {
+			t.Fatalf("Expected a distinct backend for skip %d, got %v", skip, b)
+		}
+		seen[b.ID] = true
+	}
+	if b := h.NextFor("10.0.0.1", 3); b != nil {
+		t.Errorf("Expected no backend once all were skipped, got %s", b.ID)
+	}
+}
+
+func TestIPHashRemoveRemapsItsShare(t *testing.T) {
+	h := NewIPHash()
+	for i := 1; i <= 4; i++ {
+		h.Add(fmt.Sprintf("backend%d", i), 1)
+	}
+
+	const keys = 10000
+	before := make([]string, keys)
+	for i := range before {
+		before[i] = h.NextFor(fmt.Sprintf("client-%d", i), 0).ID
+	}
+
+	h.Remove("backend4")
+	moved := 0
+	for i, id := range before {
+		after := h.NextFor(fmt.Sprintf("client-%d", i), 0).ID
+		if after == id {
+			continue
+		}
+		moved++
+		if id != "backend4" {
+			t.Fatalf("Expected only backend4's clients to move, client-%d moved from %s to %s", i, id, after)
+		}
+	}
+
+	if ratio := float64(moved) / keys; ratio < 0.18 || ratio > 0.32 {
+		t.Errorf("Expected roughly a quarter of clients to be remapped, got %.2f", ratio)
+	}
+}
+
+func TestIPHashEdgeCases(t *testing.T) {
+	h := NewIPHash()
+	if b := h.NextFor("10.0.0.1", 0); b != nil {
+		t.Error("Expected nil backend when no backends available")
+	}
+	if b := h.Next(); b != nil {
+		t.Error("Expected nil backend when no backends available")
+	}
+
+	// Without a key, backends are used in turn
+	h.Add("backend1", 0)
+	h.Add("backend2", 2)
+	if a, b := h.Next(), h.Next(); a == nil || b == nil || a == b {
+		t.Error("Expected Next to rotate through backends")
+	}
+	if h.TotalWeight() != 2 {
+		t.Errorf("Expected total weight to count backends, got %d", h.TotalWeight())
+	}
+
+	if !h.UpdateWeight("backend1", 3) || h.UpdateWeight("nonexistent", 1) {
+		t.Error("Expected UpdateWeight to report whether the backend exists")
+	}
+}
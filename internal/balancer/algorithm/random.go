@@ -0,0 +1,193 @@
+package algorithm
+
+import (
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+// randomDraws is how many draws per unit of TotalWeight the random
+// selectors allow callers skipping unusable backends. Draws can repeat, so
+// a single draw per backend would often miss the one usable backend.
+const randomDraws = 4
+
+// Random picks a backend uniformly at random, ignoring weights. Selection
+// only takes a read lock, so it does not contend under load.
+type Random struct {
+	mu       sync.RWMutex
+	backends []*WeightedBackend
+}
+
+// NewRandom creates an empty Random selector
+func NewRandom() *Random {
+	return &Random{}
+}
+
+// Add adds a backend, or updates its weight if the ID is already present
+func (r *Random) Add(id string, weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if weight <= 0 {
+		weight = 1
+	}
+	for _, backend := range r.backends {
+		if backend.ID == id {
+			backend.Weight = weight
+			backend.EffectiveWeight = int64(weight)
+			return
+		}
+	}
+	r.backends = append(r.backends, &WeightedBackend{ID: id, Weight: weight, EffectiveWeight: int64(weight)})
+}
+
+// Remove removes a backend by ID
+func (r *Random) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, backend := range r.backends {
+		if backend.ID == id {
+			r.backends = append(r.backends[:i], r.backends[i+1:]...)
+			return
+		}
+	}
+}
+
+// Next returns a random backend
+func (r *Random) Next() *WeightedBackend {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.backends) == 0 {
+		return nil
+	}
+	return r.backends[rand.IntN(len(r.backends))]
+}
+
+// UpdateWeight records a backend's weight, which does not affect selection
+func (r *Random) UpdateWeight(id string, weight int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if weight <= 0 {
+		weight = 1
+	}
+	for _, backend := range r.backends {
+		if backend.ID == id {
+			backend.Weight = weight
+			backend.EffectiveWeight = int64(weight)
+			return true
+		}
+	}
+	return false
+}
+
+// TotalWeight returns randomDraws per backend
+func (r *Random) TotalWeight() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.backends) * randomDraws
+}
+
+// WeightedRandom picks a backend at random with probability proportional
+// to its weight. The cumulative weights are rebuilt only when the backends
+// or their weights change, so selection is a binary search under a read
+// lock.
+type WeightedRandom struct {
+	mu         sync.RWMutex
+	backends   []*WeightedBackend
+	cumulative []int
+}
+
+// NewWeightedRandom creates an empty WeightedRandom selector
+func NewWeightedRandom() *WeightedRandom {
+	return &WeightedRandom{}
+}
+
+// rebuild recomputes the cumulative weights. Callers must hold mu.
+func (w *WeightedRandom) rebuild() {
+	w.cumulative = w.cumulative[:0]
+	total := 0
+	for _, backend := range w.backends {
+		total += backend.Weight
+		w.cumulative = append(w.cumulative, total)
+	}
+}
+
+// Add adds a backend, or updates its weight if the ID is already present
+func (w *WeightedRandom) Add(id string, weight int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if weight <= 0 {
+		weight = 1
+	}
+	for _, backend := range w.backends {
+		if backend.ID == id {
+			backend.Weight = weight
+			backend.EffectiveWeight = int64(weight)
+			w.rebuild()
+			return
+		}
+	}
+	w.backends = append(w.backends, &WeightedBackend{ID: id, Weight: weight, EffectiveWeight: int64(weight)})
+	w.rebuild()
+}
+
+// Remove removes a backend by ID
+func (w *WeightedRandom) Remove(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, backend := range w.backends {
+		if backend.ID == id {
+			w.backends = append(w.backends[:i], w.backends[i+1:]...)
+			w.rebuild()
+			return
+		}
+	}
+}
+
+// Next returns a random backend, weighted by its weight
+func (w *WeightedRandom) Next() *WeightedBackend {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if len(w.backends) == 0 {
+		return nil
+	}
+	n := rand.IntN(w.cumulative[len(w.cumulative)-1])
+	i := sort.Search(len(w.cumulative), func(i int) bool { return w.cumulative[i] > n })
+	return w.backends[i]
+}
+
+// UpdateWeight changes a backend's weight, reporting whether it exists
+func (w *WeightedRandom) UpdateWeight(id string, weight int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if weight <= 0 {
+		weight = 1
+	}
+	for _, backend := range w.backends {
+		if backend.ID == id {
+			backend.Weight = weight
+			backend.EffectiveWeight = int64(weight)
+			w.rebuild()
+			return true
+		}
+	}
+	return false
+}
+
+// TotalWeight returns randomDraws per unit of weight
+func (w *WeightedRandom) TotalWeight() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if len(w.cumulative) == 0 {
+		return 0
+	}
+	return w.cumulative[len(w.cumulative)-1] * randomDraws
+}
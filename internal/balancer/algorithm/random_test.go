@@ -0,0 +1,93 @@
+package algorithm
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRandom(t *testing.T) {
+	r := NewRandom()
+	if b := r.Next(); b != nil {
+		t.Error("Expected nil backend when no backends available")
+	}
+
+	r.Add("backend1", 5)
+	r.Add("backend2", 1)
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[r.Next().ID]++
+	}
+	// Weights are ignored
+	for id, count := range counts {
+		if count < 4500 || count > 5500 {
+			t.Errorf("Expected about half the picks on %s, got %d", id, count)
+		}
+	}
+
+	r.Remove("backend1")
+	if b := r.Next(); b == nil || b.ID != "backend2" {
+		t.Error("Expected only backend2 after removing backend1")
+	}
+	if r.TotalWeight() != randomDraws {
+		t.Errorf("Expected %d draws for one backend, got %d", randomDraws, r.TotalWeight())
+	}
+}
+
+func TestWeightedRandomDistribution(t *testing.T) {
+	w := NewWeightedRandom()
+	if b := w.Next(); b != nil {
+		t.Error("Expected nil backend when no backends available")
+	}
+
+	w.Add("backend1", 5)
+	w.Add("backend2", 3)
+	w.Add("backend3", 2)
+
+	check := func(want map[string]float64) {
+		t.Helper()
+		const samples = 100000
+		counts := map[string]int{}
+		for i := 0; i < samples; i++ {
+			counts[w.Next().ID]++
+		}
+		for id, ratio := range want {
+			got := float64(counts[id]) / samples
+			if got < ratio-0.02 || got > ratio+0.02 {
+				t.Errorf("Expected %s to get %.2f of picks, got %.3f", id, ratio, got)
+			}
+		}
+	}
+	check(map[string]float64{"backend1": 0.5, "backend2": 0.3, "backend3": 0.2})
+
+	// Weight changes and removals rebuild the distribution
+	if !w.UpdateWeight("backend3", 5) || w.UpdateWeight("nonexistent", 1) {
+		t.Error("Expected UpdateWeight to report whether the backend exists")
+	}
+	w.Remove("backend2")
+	check(map[string]float64{"backend1": 0.5, "backend2": 0, "backend3": 0.5})
+}
+
+func TestWeightedRandomConcurrency(t *testing.T) {
+	w := NewWeightedRandom()
+	w.Add("backend1", 1)
+	w.Add("backend2", 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if w.Next() == nil {
+					t.Error("Expected a backend")
+					return
+				}
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			w.UpdateWeight("backend1", i+1)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -17,6 +17,7 @@ type WeightedBackend struct {
 type WeightedRoundRobin struct {
 	backends []*WeightedBackend
 	mu       sync.RWMutex
+	frozen   bool
 }
 
 // New creates a new WeightedRoundRobin instance
@@ -26,7 +27,8 @@ func NewWeightedRoundRobin() *WeightedRoundRobin {
 	}
 }
 
-// Add adds a new backend with a specified weight
+// Add adds a new backend with a specified weight. Adding an ID that is
+// already present updates its weight instead of creating a duplicate.
 func (wrr *WeightedRoundRobin) Add(id string, weight int) {
 	wrr.mu.Lock()
 	defer wrr.mu.Unlock()
@@ -35,6 +37,14 @@ func (wrr *WeightedRoundRobin) Add(id string, weight int) {
 		weight = 1
 	}
 
+	for _, backend := range wrr.backends {
+		if backend.ID == id {
+			backend.Weight = weight
+			atomic.StoreInt64(&backend.EffectiveWeight, int64(weight))
+			return
+		}
+	}
+
 	backend := &WeightedBackend{
 		ID:              id,
 		Weight:          weight,
@@ -114,6 +124,10 @@ func (wrr *WeightedRoundRobin) AdjustWeight(id string, delta int) bool {
 	wrr.mu.Lock()
 	defer wrr.mu.Unlock()
 
+	if wrr.frozen {
+		return false
+	}
+
 	for _, backend := range wrr.backends {
 		if backend.ID == id {
 			newWeight := atomic.LoadInt64(&backend.EffectiveWeight) + int64(delta)
@@ -130,6 +144,27 @@ func (wrr *WeightedRoundRobin) AdjustWeight(id string, delta int) bool {
 	return false
 }
 
+// Weights returns the configured and effective weights of a backend
+func (wrr *WeightedRoundRobin) Weights(id string) (weight, effective int, ok bool) {
+	wrr.mu.RLock()
+	defer wrr.mu.RUnlock()
+
+	for _, backend := range wrr.backends {
+		if backend.ID == id {
+			return backend.Weight, int(atomic.LoadInt64(&backend.EffectiveWeight)), true
+		}
+	}
+	return 0, 0, false
+}
+
+// Freeze disables dynamic weight adjustments so that the selection order
+// depends only on the configured weights and the order backends were added
+func (wrr *WeightedRoundRobin) Freeze() {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+	wrr.frozen = true
+}
+
 // Reset resets all current weights to their original values
 func (wrr *WeightedRoundRobin) Reset() {
 	wrr.mu.Lock()
@@ -141,6 +176,19 @@ func (wrr *WeightedRoundRobin) Reset() {
 	}
 }
 
+// TotalWeight returns the sum of effective weights. Every backend is
+// selected at least once in any run of TotalWeight consecutive calls to Next.
+func (wrr *WeightedRoundRobin) TotalWeight() int {
+	wrr.mu.RLock()
+	defer wrr.mu.RUnlock()
+
+	var total int64
+	for _, backend := range wrr.backends {
+		total += atomic.LoadInt64(&backend.EffectiveWeight)
+	}
+	return int(total)
+}
+
 // GetBackends returns a copy of the current backend list
 func (wrr *WeightedRoundRobin) GetBackends() []WeightedBackend {
 	wrr.mu.RLock()
@@ -188,3 +188,56 @@ func TestWeightedRoundRobinGetBackends(t *testing.T) {
 		}
 	}
 }
+
+func TestWeightedRoundRobinFreeze(t *testing.T) {
+	wrr := NewWeightedRoundRobin()
+	wrr.Add("backend1", 2)
+	wrr.Freeze()
+
+	if wrr.AdjustWeight("backend1", 2) {
+		t.Error("Expected AdjustWeight to be rejected when frozen")
+	}
+
+	backends := wrr.GetBackends()
+	if backends[0].EffectiveWeight != 2 {
+		t.Errorf("Expected effective weight to stay 2, got %d", backends[0].EffectiveWeight)
+	}
+
+	// Explicit weight updates are still honoured
+	if !wrr.UpdateWeight("backend1", 4) {
+		t.Error("Expected UpdateWeight to succeed when frozen")
+	}
+}
+
+func TestWeightedRoundRobinAddExisting(t *testing.T) {
+	wrr := NewWeightedRoundRobin()
+	wrr.Add("backend1", 1)
+	wrr.Add("backend1", 4)
+
+	backends := wrr.GetBackends()
+	if len(backends) != 1 {
+		t.Fatalf("Expected 1 backend after re-adding the same ID, got %d", len(backends))
+	}
+	if backends[0].Weight != 4 {
+		t.Errorf("Expected weight to be updated to 4, got %d", backends[0].Weight)
+	}
+}
+
+func TestWeightedRoundRobinTotalWeight(t *testing.T) {
+	wrr := NewWeightedRoundRobin()
+	wrr.Add("backend1", 5)
+	wrr.Add("backend2", 1)
+
+	if got := wrr.TotalWeight(); got != 6 {
+		t.Fatalf("Expected total weight 6, got %d", got)
+	}
+
+	// Every backend appears within one rotation
+	seen := make(map[string]bool)
+	for i := 0; i < wrr.TotalWeight(); i++ {
+		seen[wrr.Next().ID] = true
+	}
+	if !seen["backend1"] || !seen["backend2"] {
+		t.Errorf("Expected both backends within one rotation, got %v", seen)
+	}
+}
@@ -2,181 +2,955 @@ package balancer
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/http2"
+
 	"loadbalancer/internal/balancer/algorithm"
 	"loadbalancer/internal/circuitbreaker"
 	"loadbalancer/internal/config"
+	"loadbalancer/internal/discovery"
 	"loadbalancer/internal/errors"
+	"loadbalancer/internal/logging"
 	"loadbalancer/internal/metrics"
 	"loadbalancer/internal/ratelimit"
+	"loadbalancer/internal/rolling"
 	"loadbalancer/internal/ssl"
 )
 
 type Backend struct {
+	ID            string
 	URL           *url.URL
 	Proxy         *httputil.ReverseProxy
 	Healthy       atomic.Bool
 	ActiveConns   atomic.Int64
 	TotalRequests atomic.Uint64
 	CircuitBreaker *circuitbreaker.CircuitBreaker
-	RateLimiter    *ratelimit.TokenBucket
+	RateLimiter    ratelimit.Limiter
+
+	affinityToken   string
+	healthPath      string
+	maxConns        int64 // 0 means no limit
+	stopHealthCheck context.CancelFunc
+	adaptive        *ratelimit.AdaptiveLimiter // nil unless adaptive concurrency is enabled
+	outcomes        *rolling.Window            // recent request outcomes, for the admin API
+}
+
+// acquireConn counts a new request in flight to b, refusing it if b is
+// already at its connection limit. Callers release the slot by decrementing
+// ActiveConns.
+func (b *Backend) acquireConn() bool {
+	for {
+		active := b.ActiveConns.Load()
+		if b.maxConns > 0 && active >= b.maxConns {
+			return false
+		}
+		if b.ActiveConns.CompareAndSwap(active, active+1) {
+			return true
+		}
+	}
+}
+
+// saturated reports whether b is at its connection limit
+func (b *Backend) saturated() bool {
+	return b.maxConns > 0 && b.ActiveConns.Load() >= b.maxConns
+}
+
+// stopHealthChecks stops the backend's health-check loop, if running
+func (b *Backend) stopHealthChecks() {
+	if b.stopHealthCheck != nil {
+		b.stopHealthCheck()
+	}
 }
 
 type LoadBalancer struct {
 	backends []*Backend
+	byID     map[string]*Backend
+	pool     []config.Backend // main pool as last configured
+	blue     []config.Backend // main pool before the last SwitchTraffic
 	mu       sync.RWMutex
 	metrics  *metrics.Metrics
 	config   *config.Config
 	ssl      *ssl.Manager
-	wrr      *algorithm.WeightedRoundRobin
+	selector algorithm.Selector
+	logger   *slog.Logger
+
+	limiterFailureMode ratelimit.FailureMode
+	backoff            *ratelimit.Backoff
+	pin                atomic.Pointer[trafficPin]
+	sticky             *stickySessions
+	canary             *canaryPool
+	large              *largeRequestPool
+	compression        *compressor
+	cache              *responseCache
+	adminSSL           *ssl.Manager
+	routePools         []*routePool
+	vhosts             *virtualHosts
+	hosts              *hostAllowlist
+	dns                *dnsCache
+	healthClient       *http.Client
+	inherited          *inheritedListeners
+	schedule           *weightSchedule
+	discovery          discovery.Provider
+	rolloutState       *RolloutState
+	healthCtx          context.Context
+	healthWG           sync.WaitGroup
+
+	// Settings Reload can change. limiterFailureMode, defaultWeight,
+	// halfOpenFailures and halfOpenSuccesses are guarded by mu.
+	defaultWeight     int
+	halfOpenFailures  int
+	halfOpenSuccesses int
+	countRateLimited  atomic.Bool
+	grpcFailures      atomic.Pointer[map[string]bool] // grpc-status values counted as failures
 }
 
 func New(cfg *config.Config, metrics *metrics.Metrics) (*LoadBalancer, error) {
+	logger, err := logging.New(cfg.Logging, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
 	lb := &LoadBalancer{
-		metrics: metrics,
-		config:  cfg,
-		wrr:     algorithm.NewWeightedRoundRobin(),
+		metrics:      metrics,
+		config:       cfg,
+		logger:       logger,
+		rolloutState: &RolloutState{},
+	}
+	if cfg.Transport.DNSRefreshInterval > 0 {
+		lb.dns = newDNSCache(cfg.Transport.DNSRefreshInterval, lb.newDialer(), logger, lb.closeIdleConnections)
+	}
+	lb.healthClient = &http.Client{Transport: lb.newTransport()}
+
+	failureMode, err := ratelimit.ParseFailureMode(cfg.RateLimit.FailureMode)
+	if err != nil {
+		return nil, err
+	}
+	lb.limiterFailureMode = failureMode
+	lb.defaultWeight = cfg.Balancing.DefaultWeight
+	lb.halfOpenFailures = cfg.CircuitBreaker.HalfOpenFailureThreshold
+	lb.halfOpenSuccesses = halfOpenSuccesses(cfg.CircuitBreaker)
+	lb.countRateLimited.Store(cfg.CircuitBreaker.CountRateLimited)
+	lb.setGRPCFailures(cfg.CircuitBreaker)
+	if cfg.RateLimit.RetryAfterBackoff {
+		lb.backoff = ratelimit.NewBackoff(ratelimit.BackoffConfig{
+			Base: cfg.RateLimit.BackoffBase,
+			Max:  cfg.RateLimit.BackoffMax,
+		})
+	}
+
+	if len(cfg.WeightSchedule) > 0 {
+		lb.schedule = newWeightSchedule(cfg.WeightSchedule)
+	}
+
+	if cfg.Sticky.Enabled {
+		sticky, err := newStickySessions(cfg.Sticky)
+		if err != nil {
+			return nil, err
+		}
+		lb.sticky = sticky
+	}
+
+	if len(cfg.AllowedHosts) > 0 {
+		lb.hosts = newHostAllowlist(cfg.AllowedHosts)
+	}
+
+	if len(cfg.Canary.Backends) > 0 {
+		canary, err := newCanaryPool(cfg.Canary)
+		if err != nil {
+			return nil, err
+		}
+		lb.canary = canary
+	}
+
+	if len(cfg.LargeRequests.Backends) > 0 {
+		large, err := newLargeRequestPool(cfg.LargeRequests)
+		if err != nil {
+			return nil, err
+		}
+		lb.large = large
+	}
+
+	if cfg.Compression.Enabled {
+		lb.compression = newCompressor(cfg.Compression)
+	}
+	if cfg.Cache.Enabled {
+		lb.cache = newResponseCache(cfg.Cache)
 	}
 
 	// Initialize SSL if configured
 	if cfg.SSL != nil {
-		sslManager, err := ssl.New(&ssl.Config{
-			CertFile:   cfg.SSL.CertFile,
-			KeyFile:    cfg.SSL.KeyFile,
-			CAFile:     cfg.SSL.CAFile,
-			ClientAuth: cfg.SSL.ClientAuth,
-		})
+		sslManager, err := lb.newSSLManager("frontend", *cfg.SSL)
 		if err != nil {
 			return nil, fmt.Errorf("failed to initialize SSL: %v", err)
 		}
 		lb.ssl = sslManager
 	}
+	if cfg.Admin.TLS != nil {
+		adminTLS := *cfg.Admin.TLS
+		if adminTLS.CAFile != "" && adminTLS.ClientAuth == tls.NoClientCert {
+			adminTLS.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		sslManager, err := lb.newSSLManager("admin", adminTLS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize admin TLS: %v", err)
+		}
+		lb.adminSSL = sslManager
+	}
+
+	if cfg.Balancing.OverloadFactor != 0 && cfg.Balancing.OverloadFactor < 1 {
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("overload factor must be at least 1, got %v", cfg.Balancing.OverloadFactor), nil)
+	}
+	if cfg.Balancing.DefaultWeight < 0 {
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("default weight must be positive, got %d", cfg.Balancing.DefaultWeight), nil)
+	}
+	if d := cfg.AdaptiveConcurrency.Decrease; d < 0 || d >= 1 {
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("adaptive concurrency decrease must be between 0 and 1, got %v", d), nil)
+	}
 
-	if err := lb.updateBackends(cfg.Backends); err != nil {
+	if err := validateForwardedFor(cfg.ForwardedHeaders.XForwardedFor); err != nil {
+		return nil, err
+	}
+	if err := validateHeaderMappings(cfg.ForwardedHeaders.Mappings); err != nil {
+		return nil, err
+	}
+
+	if err := validateRoutes(cfg.Routes); err != nil {
+		return nil, err
+	}
+	routePools, err := newRoutePools(cfg)
+	if err != nil {
+		return nil, err
+	}
+	lb.routePools = routePools
+	if len(cfg.VirtualHosts) > 0 {
+		if lb.vhosts, err = newVirtualHosts(cfg.VirtualHosts, routePools); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateTrailingSlash(cfg.TrailingSlash); err != nil {
+		return nil, err
+	}
+
+	// With discovery the main pool starts empty and fills once Start
+	// hears from the registry
+	backends := cfg.Backends
+	if cfg.Discovery != nil {
+		if lb.discovery, err = discovery.New(*cfg.Discovery, logger); err != nil {
+			return nil, err
+		}
+		backends = nil
+	}
+	if err := lb.updateBackends(backends); err != nil {
 		return nil, err
 	}
 
 	return lb, nil
 }
 
-func (lb *LoadBalancer) updateBackends(backends []string) error {
+// updateBackends replaces the main pool with backends, rebuilding the side
+// pools alongside it. Backends already running with the same settings are
+// kept, with their health, circuit and connection state; the rest are built
+// afresh, and those no longer wanted drain.
+func (lb *LoadBalancer) updateBackends(backends []config.Backend) error {
 	lb.mu.Lock()
 	defer lb.mu.Unlock()
 
-	// Reset weighted round-robin
-	lb.wrr = algorithm.NewWeightedRoundRobin()
+	// Side pool backends are pooled and health checked alongside the main
+	// backends but rotate separately
+	all := backends
+	pools := lb.sidePools()
+	if len(pools) > 0 {
+		all = append([]config.Backend(nil), backends...)
+		for _, pool := range pools {
+			all = append(all, pool.backends...)
+		}
+	}
 
 	var newBackends []*Backend
-	for i, backend := range backends {
-		url, err := url.Parse(backend)
-		if err != nil || url.Scheme == "" || url.Host == "" {
-			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid backend URL %s", backend), err)
-		}
-
-		proxy := httputil.NewSingleHostReverseProxy(url)
-		b := &Backend{
-			URL:   url,
-			Proxy: proxy,
-			CircuitBreaker: circuitbreaker.New(circuitbreaker.Config{
-				Threshold:   5,
-				Timeout:     10 * time.Second,
-				HalfOpenMax: 2,
-			}),
-			RateLimiter: ratelimit.New(ratelimit.Config{
-				Rate:     100,
-				Capacity: 100,
-			}),
-		}
-		b.Healthy.Store(true)
+	weights := make([]int, 0, len(all))
+	byID := make(map[string]*Backend, len(all))
+	for _, backend := range all {
+		weight, err := configuredWeight(backend, lb.defaultWeight)
+		if err != nil {
+			return err
+		}
+		if _, err := parseBackendURL(backend.URL); err != nil {
+			return err
+		}
+		id := backendID(backend.URL)
+		if _, exists := byID[id]; exists {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("duplicate backend URL %s", backend.URL), nil)
+		}
+
+		b, running := lb.byID[id]
+		if !running || b.healthPath != backend.HealthPath || b.maxConns != int64(backend.MaxConns) {
+			if b, err = lb.buildBackend(backend.URL); err != nil {
+				return err
+			}
+			b.healthPath = backend.HealthPath
+			b.maxConns = int64(backend.MaxConns)
+		}
+		byID[id] = b
 		newBackends = append(newBackends, b)
+		weights = append(weights, weight)
+	}
+
+	// Build fresh selectors so the new pool starts a clean rotation
+	selector, err := lb.newSelector()
+	if err != nil {
+		return err
+	}
+	for i, b := range newBackends[:len(backends)] {
+		selector.Add(b.ID, weights[i])
+	}
+	poolSelectors := make([]algorithm.Selector, len(pools))
+	offset := len(backends)
+	for p, pool := range pools {
+		if poolSelectors[p], err = lb.newSelector(); err != nil {
+			return err
+		}
+		for i := offset; i < offset+len(pool.backends); i++ {
+			poolSelectors[p].Add(newBackends[i].ID, weights[i])
+		}
+		offset += len(pool.backends)
+	}
+
+	if lb.config != nil && lb.config.Deterministic {
+		if freezer, ok := selector.(algorithm.Freezer); ok {
+			freezer.Freeze()
+		}
+		// Advance the rotation so a given seed always starts at the same backend
+		if len(backends) > 0 {
+			for i := int64(0); i < lb.config.Seed%int64(len(backends)); i++ {
+				selector.Next()
+			}
+		}
+	}
 
-		// Add to weighted round-robin with default weight of 1
-		lb.wrr.Add(fmt.Sprintf("backend-%d", i), 1)
+	lb.selector = selector
+	for p, pool := range pools {
+		pool.selector = poolSelectors[p]
 	}
 
+	// Backends that were replaced or dropped drain
+	kept := make(map[*Backend]bool, len(lb.backends))
+	for _, old := range lb.backends {
+		if byID[old.ID] == old {
+			kept[old] = true
+			continue
+		}
+		old.stopHealthChecks()
+		if _, replaced := byID[old.ID]; !replaced {
+			lb.dropBackendMetrics(old)
+		}
+		lb.startDrain(old)
+	}
 	lb.backends = newBackends
+	lb.byID = byID
+	lb.pool = append([]config.Backend(nil), backends...)
+	for _, b := range newBackends {
+		if kept[b] {
+			continue
+		}
+		lb.reportHealth(b)
+		lb.reportCircuit(b, b.CircuitBreaker.GetState())
+		lb.watchBackend(b)
+	}
+	return nil
+}
+
+// newSSLManager loads the certificates in cfg, reporting reloads of the
+// named server's certificates through the logs and metrics
+func (lb *LoadBalancer) newSSLManager(server string, cfg config.SSL) (*ssl.Manager, error) {
+	var certificates []ssl.CertificatePair
+	for _, pair := range cfg.Certificates {
+		certificates = append(certificates, ssl.CertificatePair{CertFile: pair.CertFile, KeyFile: pair.KeyFile})
+	}
+	manager, err := ssl.New(&ssl.Config{
+		CertFile:     cfg.CertFile,
+		KeyFile:      cfg.KeyFile,
+		CAFile:       cfg.CAFile,
+		ClientAuth:   cfg.ClientAuth,
+		Certificates: certificates,
+		MinVersion:   cfg.MinVersion,
+		CipherSuites: cfg.CipherSuites,
+	})
+	if err != nil {
+		return nil, err
+	}
+	manager.SetCertReloadHook(func() {
+		lb.logger.Info("certificates reloaded", "server", server)
+	})
+	manager.SetCertReloadFailureHook(func(err error) {
+		lb.metrics.CertReloadErrors.Inc()
+		lb.logger.Error("certificate reload failed, keeping the current certificate", "server", server, "error", err)
+	})
+	return manager, nil
+}
+
+// newSelector returns an empty selector for the configured algorithm
+func (lb *LoadBalancer) newSelector() (algorithm.Selector, error) {
+	var name string
+	if lb.config != nil {
+		name = lb.config.Algorithm
+	}
+	selector, err := algorithm.New(name)
+	if err != nil {
+		return nil, errors.New(errors.ErrConfigInvalid, "invalid balancing algorithm", err)
+	}
+	return selector, nil
+}
+
+// backendWeight returns the configured weight of backend, defaulting to the
+// balancing default weight
+func (lb *LoadBalancer) backendWeight(backend config.Backend) (int, error) {
+	lb.mu.RLock()
+	defaultWeight := lb.defaultWeight
+	lb.mu.RUnlock()
+	return configuredWeight(backend, defaultWeight)
+}
+
+// configuredWeight returns the weight of backend, or defaultWeight (one if
+// unset) when the backend has none
+func configuredWeight(backend config.Backend, defaultWeight int) (int, error) {
+	if backend.Weight < 0 {
+		return 0, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid weight %d for backend %s", backend.Weight, backend.URL), nil)
+	}
+	if backend.Weight == 0 {
+		if defaultWeight > 0 {
+			return defaultWeight, nil
+		}
+		return 1, nil
+	}
+	return backend.Weight, nil
+}
+
+// parseBackendURL parses the URL of a configured backend
+func parseBackendURL(rawURL string) (*url.URL, error) {
+	url, err := url.Parse(rawURL)
+	if err != nil || url.Scheme == "" || url.Host == "" {
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid backend URL %s", rawURL), err)
+	}
+	return url, nil
+}
+
+// newBackend parses rawURL and builds a Backend with its own proxy,
+// circuit breaker and rate limiter. The backend URL doubles as its ID.
+func (lb *LoadBalancer) newBackend(rawURL string) (*Backend, error) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.buildBackend(rawURL)
+}
+
+// buildBackend is newBackend for callers that hold lb.mu
+func (lb *LoadBalancer) buildBackend(rawURL string) (*Backend, error) {
+	url, err := parseBackendURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	failureMode, halfOpenFailures, halfOpenMax := lb.limiterFailureMode, lb.halfOpenFailures, lb.halfOpenSuccesses
+
+	proxy := httputil.NewSingleHostReverseProxy(url)
+	proxy.Director = lb.hostDirector(lb.forwardingDirector(proxy.Director), url)
+	proxy.Transport = lb.newTransport()
+	proxy.ErrorHandler = lb.proxyErrorHandler
+	b := &Backend{
+		ID:    url.String(),
+		URL:   url,
+		Proxy: proxy,
+		CircuitBreaker: circuitbreaker.New(circuitbreaker.Config{
+			Threshold:   5,
+			Timeout:     10 * time.Second,
+			HalfOpenMax: halfOpenMax,
+
+			HalfOpenFailureThreshold: halfOpenFailures,
+		}),
+		RateLimiter: ratelimit.WithFailureMode(ratelimit.New(ratelimit.Config{
+			Rate:     100,
+			Capacity: 100,
+		}), failureMode),
+		outcomes: rolling.New(lb.statsWindow()),
+	}
+	if lb.config != nil && lb.config.AdaptiveConcurrency.TargetLatency > 0 {
+		ac := lb.config.AdaptiveConcurrency
+		b.adaptive = ratelimit.NewAdaptive(ratelimit.AdaptiveConfig{
+			TargetLatency: ac.TargetLatency,
+			MinLimit:      ac.MinLimit,
+			MaxLimit:      ac.MaxLimit,
+			Increase:      ac.Increase,
+			Decrease:      ac.Decrease,
+		})
+	}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		return lb.modifyResponse(resp, b)
+	}
+	b.CircuitBreaker.SetOnStateChange(func(from, to circuitbreaker.State) {
+		lb.onCircuitStateChange(b, from, to)
+	})
+	b.CircuitBreaker.SetOnHalfOpenResult(func(success bool) {
+		lb.onHalfOpenResult(b, success)
+	})
+	b.affinityToken = affinityToken(b.ID)
+	b.Healthy.Store(true)
+	return b, nil
+}
+
+// AddBackend adds a backend to the main pool at runtime. It fails with
+// ErrBackendExists if a backend with the same URL is already registered.
+func (lb *LoadBalancer) AddBackend(backend config.Backend) error {
+	weight, err := lb.backendWeight(backend)
+	if err != nil {
+		return err
+	}
+
+	b, err := lb.newBackend(backend.URL)
+	if err != nil {
+		return err
+	}
+	b.healthPath = backend.HealthPath
+	b.maxConns = int64(backend.MaxConns)
+
+	if !lb.addBackend(b, weight) {
+		return errors.New(errors.ErrBackendExists, fmt.Sprintf("backend %s already exists", b.ID), nil)
+	}
+	return nil
+}
+
+// RemoveBackend removes the backend with the given URL so it stops
+// receiving traffic. It fails with ErrBackendUnavailable if no such backend
+// is registered.
+func (lb *LoadBalancer) RemoveBackend(backendURL string) error {
+	if lb.removeBackend(backendURL) == nil {
+		return errors.New(errors.ErrBackendUnavailable, fmt.Sprintf("unknown backend %s", backendURL), nil)
+	}
 	return nil
 }
 
+// addBackend registers b with the pool and the weighted round-robin
+// under a single lock so the two never disagree
+func (lb *LoadBalancer) addBackend(b *Backend, weight int) bool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if _, exists := lb.byID[b.ID]; exists {
+		return false
+	}
+	lb.attachBackend(b, weight)
+	lb.pool = append(lb.pool, config.Backend{URL: b.ID, Weight: weight, HealthPath: b.healthPath, MaxConns: int(b.maxConns)})
+	return true
+}
+
+// attachBackend puts b into rotation in the main pool and starts checking
+// its health. Callers must hold lb.mu.
+func (lb *LoadBalancer) attachBackend(b *Backend, weight int) {
+	if lb.byID == nil {
+		lb.byID = make(map[string]*Backend)
+	}
+
+	lb.backends = append(lb.backends, b)
+	lb.byID[b.ID] = b
+	lb.selector.Add(b.ID, weight)
+	lb.reportHealth(b)
+	lb.reportCircuit(b, b.CircuitBreaker.GetState())
+	lb.watchBackend(b)
+}
+
+// dropBackendMetrics stops exporting the per-backend series of b once it
+// has left the pool
+func (lb *LoadBalancer) dropBackendMetrics(b *Backend) {
+	lb.metrics.BackendHealth.DeleteLabelValues(b.URL.String())
+	lb.metrics.CircuitBreakerState.DeleteLabelValues(b.URL.String())
+	lb.metrics.RequestsByBackend.DeletePartialMatch(prometheus.Labels{"backend_url": b.URL.String()})
+	lb.metrics.CircuitHalfOpenProbes.DeletePartialMatch(prometheus.Labels{"backend_url": b.URL.String()})
+}
+
+// removeBackend drops the backend with the given ID from the pool and the
+// weighted round-robin, returning the removed backend if it was present
+func (lb *LoadBalancer) removeBackend(id string) *Backend {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	b, exists := lb.byID[id]
+	if !exists {
+		return nil
+	}
+
+	lb.detachBackend(b)
+	for i, entry := range lb.pool {
+		if entry.URL == id {
+			lb.pool = append(lb.pool[:i:i], lb.pool[i+1:]...)
+			break
+		}
+	}
+	return b
+}
+
+// detachBackend takes b out of rotation in the main pool and stops checking
+// its health. Callers must hold lb.mu.
+func (lb *LoadBalancer) detachBackend(b *Backend) {
+	lb.selector.Remove(b.ID)
+	delete(lb.byID, b.ID)
+	b.stopHealthChecks()
+	lb.dropBackendMetrics(b)
+	for i, candidate := range lb.backends {
+		if candidate == b {
+			lb.backends = append(lb.backends[:i:i], lb.backends[i+1:]...)
+			break
+		}
+	}
+	lb.startDrain(b)
+}
+
 func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	backend := lb.nextBackend()
+	// Wrap the response writer to capture status and timing; every response,
+	// including the balancer's own errors, is written through it
+	wrapped := &responseWriter{ResponseWriter: w}
+	timing := &requestTiming{received: time.Now()}
+	defer lb.logAccess(r, wrapped, timing)
+	w = wrapped
+
+	// HTTP/1.0 clients never get chunked responses: net/http delimits the
+	// body by closing the connection when the length is unknown. Optionally
+	// refuse 1.0 keep-alive too, which some legacy clients mishandle.
+	if !r.ProtoAtLeast(1, 1) && lb.config != nil && lb.config.LegacyHTTP.CloseConnections {
+		w.Header().Set("Connection", "close")
+	}
+
+	// Reject unknown Host values before anything reaches a backend
+	if lb.hosts != nil && !lb.hosts.allows(r.Host) {
+		http.Error(w, "Misdirected request", http.StatusMisdirectedRequest)
+		lb.metrics.ErrorsTotal.Inc()
+		return
+	}
+
+	if lb.limitRequestBody(wrapped, r) {
+		return
+	}
+
+	// OPTIONS * is about the balancer itself, so it is never forwarded
+	if isServerOptions(r) {
+		lb.serveServerOptions(w)
+		return
+	}
+
+	if lb.config != nil && lb.config.Tracing.GenerateTraceparent {
+		ensureTraceparent(r)
+	}
+
+	if lb.normalizeTrailingSlash(w, r) {
+		return
+	}
+
+	route := lb.routeFor(r.URL.Path)
+
+	// Requests for a named pool are balanced within it; the rest go to the
+	// main pool, if there is one
+	pool := lb.poolFor(r, route)
+	if pool == nil && lb.unrouted() {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	// Cacheable requests are answered from the cache when it can, and
+	// their responses recorded for it otherwise. A response is only stored
+	// once proxied is set; one cut short panics with http.ErrAbortHandler
+	// before it is.
+	proxied := false
+	if lb.cache != nil {
+		if key, ok := cacheKey(r); ok {
+			if !isCacheRefresh(r) && lb.serveCached(w, r, key) {
+				return
+			}
+			recorder := &cacheRecorder{ResponseWriter: wrapped.ResponseWriter, cache: lb.cache, key: key}
+			wrapped.ResponseWriter = recorder
+			r = withCacheRecorder(r, recorder)
+			defer func() {
+				if proxied {
+					recorder.finish()
+				}
+			}()
+		}
+	}
+
+	if route != nil && route.Buffering == bufferingBuffer {
+		if err := bufferRequestBody(r); err != nil {
+			lb.requestBodyError(w, err)
+			return
+		}
+	}
+
+	// Requests that may be retried keep their body so it can be replayed
+	retries := lb.maxRetries(r)
+	if retries > 0 && r.GetBody == nil {
+		if err := bufferRequestBody(r); err != nil {
+			lb.requestBodyError(w, err)
+			return
+		}
+	}
+
+	var backend *Backend
+	if lb.sticky != nil && pool == nil {
+		r = withSticky(r)
+		backend = lb.stickyBackend(r)
+	}
+	if backend == nil {
+		backend = lb.selectBackend(r)
+	}
 	if backend == nil {
-		http.Error(w, "No available backends", http.StatusServiceUnavailable)
 		lb.metrics.ErrorsTotal.Inc()
+		if lb.allCircuitsOpen() {
+			lb.metrics.AllCircuitsOpen.Inc()
+			lb.logger.Warn("no backend selected: all backend circuits are open", "method", r.Method, "path", r.URL.Path)
+			lb.writeCircuitOpen(w)
+			return
+		}
+		http.Error(w, "No available backends", http.StatusServiceUnavailable)
+		return
+	}
+	// Record the backend that ended up serving the request, after any
+	// retries, with the status the client got
+	if !isCacheRefresh(r) {
+		defer func() { lb.recordBackendStatus(backend, wrapped.status) }()
+	}
+
+	// Slow requests may be hedged on a second backend instead of retried
+	hedge := lb.hedgeDelay(r)
+
+	tried := make(map[*Backend]bool)
+	for attempt := 0; ; attempt++ {
+		var err error
+		var retry *retryAttempt
+		proxied = false
+		if hedge > 0 {
+			backend, err = lb.forwardHedged(wrapped, r, backend, route, timing, hedge)
+		} else {
+			if attempt < retries {
+				retry = &retryAttempt{}
+			}
+			err = lb.forward(wrapped, r, backend, route, timing, retry)
+		}
+		proxied = true
+		if errors.GetCode(err) == errors.ErrBackendSaturated {
+			// Nothing was sent to the backend, so the request can go to
+			// one with room without using up a retry
+			tried[backend] = true
+			if next := lb.retryBackend(r, tried); next != nil {
+				backend = next
+				attempt--
+				continue
+			}
+		}
+		if retry != nil && retry.err != nil {
+			// Nothing has been sent to the client yet, so the request can
+			// go to a backend that has not failed it
+			tried[backend] = true
+			next := lb.retryBackend(r, tried)
+			if next == nil {
+				lb.metrics.ErrorsTotal.Inc()
+				lb.proxyErrorHandler(w, r, retry.err)
+				return
+			}
+			lb.logger.Info("retrying request on another backend",
+				"method", r.Method, "path", r.URL.Path, "failed", backend.URL.String(), "next", next.URL.String(), "error", retry.err)
+			lb.metrics.RetriesTotal.Inc()
+			if r.GetBody != nil {
+				r.Body, _ = r.GetBody()
+			}
+			backend = next
+			continue
+		}
+
+		if err != nil {
+			lb.metrics.ErrorsTotal.Inc()
+			// The proxy has already answered for backend errors and timeouts
+			if wrapped.status != 0 {
+				return
+			}
+			var lbErr *errors.LoadBalancerError
+			if errors.As(err, &lbErr) {
+				switch lbErr.Code {
+				case errors.ErrCircuitOpen:
+					http.Error(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+				case errors.ErrRateLimitExceeded:
+					lb.metrics.RateLimitRejections.Inc()
+					lb.logger.Debug("request rejected by rate limit", "backend", backend.URL.String(), "path", r.URL.Path)
+					lb.setRetryAfter(w, r, backend)
+					http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				case errors.ErrBackendSaturated:
+					lb.metrics.SaturationRejections.Inc()
+					lb.logger.Warn("request rejected: every available backend is at its connection limit", "method", r.Method, "path", r.URL.Path)
+					http.Error(w, "All backends are at capacity", http.StatusServiceUnavailable)
+				case errors.ErrLimiterUnavailable:
+					lb.logger.Warn("request rejected: rate limiter unavailable", "backend", backend.URL.String(), "error", err)
+					http.Error(w, "Rate limiter unavailable", http.StatusServiceUnavailable)
+				default:
+					http.Error(w, "Backend error", http.StatusBadGateway)
+				}
+			} else {
+				http.Error(w, "Backend error", http.StatusBadGateway)
+			}
+		}
 		return
 	}
+}
 
-	// Check circuit breaker
-	if err := backend.CircuitBreaker.Execute(func() error {
+// forward proxies r to backend through its circuit breaker and limiters.
+// When retry is non-nil, a retryable backend failure is recorded in it and
+// returned without writing a response.
+func (lb *LoadBalancer) forward(wrapped *responseWriter, r *http.Request, backend *Backend, route *config.Route, timing *requestTiming, retry *retryAttempt) error {
+	timing.backend = backend.URL.String()
+
+	// A backend at its connection limit is passed over before its circuit
+	// breaker sees the request, so being busy never counts as failing
+	if !backend.acquireConn() {
+		return errors.New(errors.ErrBackendSaturated, fmt.Sprintf("backend %s is at its connection limit", backend.ID), nil)
+	}
+	defer backend.ActiveConns.Add(-1)
+
+	return backend.CircuitBreaker.Execute(func() error {
 		// Check rate limiter
 		if err := backend.RateLimiter.Allow(); err != nil {
 			return err
 		}
+		// Shed load from backends whose latency has degraded
+		if backend.adaptive != nil {
+			if err := backend.adaptive.Acquire(); err != nil {
+				return err
+			}
+			acquired := time.Now()
+			defer func() { backend.adaptive.Release(time.Since(acquired)) }()
+		}
+		timing.admitted = time.Now()
+
+		// Every request that reaches the backend counts towards its recent
+		// error ratio, unless it was abandoned for a hedged copy
+		succeeded, abandoned := false, false
+		defer func() {
+			if !abandoned {
+				backend.outcomes.Record(time.Now(), !succeeded)
+			}
+		}()
 
-		backend.ActiveConns.Add(1)
-		defer backend.ActiveConns.Add(-1)
 		backend.TotalRequests.Add(1)
 
+		// Cache refreshes are not client requests
+		refresh := isCacheRefresh(r)
 		start := time.Now()
-		lb.metrics.RequestsTotal.Inc()
-		
-		// Create error channel for proxy errors
-		errChan := make(chan error, 1)
-		
-		// Wrap the response writer to capture status
-		wrapped := &responseWriter{ResponseWriter: w}
-		
-		// Proxy the request
-		go func() {
-			backend.Proxy.ServeHTTP(wrapped, r)
-			if wrapped.status >= 500 {
-				errChan <- fmt.Errorf("backend error: %d", wrapped.status)
-			} else {
-				errChan <- nil
-			}
-		}()
+		if !refresh {
+			lb.metrics.RequestsTotal.Inc()
+		}
 
-		// Wait for response or timeout
-		select {
-		case err := <-errChan:
-			if err != nil {
-				lb.metrics.ErrorsTotal.Inc()
-				return err
+		// Proxy the request in this goroutine so nothing writes to the
+		// response after ServeHTTP returns; on timeout the context cancels
+		// the backend request and the proxy replies 504 itself
+		ctx, cancel := lb.requestContext(r, route)
+		defer cancel()
+		outReq := r.WithContext(ctx)
+		if retry != nil {
+			outReq = withRetryAttempt(outReq, retry)
+		}
+
+		var out http.ResponseWriter = wrapped
+		if lb.compression != nil {
+			compressed := lb.compression.wrap(wrapped, r)
+			defer compressed.close()
+			out = compressed
+		}
+
+		switch {
+		case route != nil && route.Buffering == bufferingBuffer:
+			buffered := &bufferedResponse{w: out}
+			backend.Proxy.ServeHTTP(buffered, outReq)
+			if retry == nil || retry.err == nil {
+				buffered.commit()
 			}
-		case <-time.After(30 * time.Second):
+		case route != nil && route.Buffering == bufferingStream:
+			backend.Proxy.ServeHTTP(&streamingResponse{ResponseWriter: out}, outReq)
+		default:
+			backend.Proxy.ServeHTTP(out, outReq)
+		}
+
+		if retry != nil && retry.err != nil {
+			lb.metrics.ErrorsTotal.Inc()
+			return retry.err
+		}
+		if context.Cause(ctx) == errHedgeLost {
+			abandoned = true
+			return circuitbreaker.ErrAbandoned
+		}
+		if ctx.Err() == context.DeadlineExceeded {
 			lb.metrics.ErrorsTotal.Inc()
 			return errors.New(errors.ErrTimeout, "request timeout", nil)
 		}
+		if wrapped.status >= 500 {
+			lb.metrics.ErrorsTotal.Inc()
+			return fmt.Errorf("backend error: %d", wrapped.status)
+		}
+		// A backend's own 429 reaches the client as sent, Retry-After and
+		// rate limit headers included; it only trips the circuit if asked to
+		if wrapped.status == http.StatusTooManyRequests && lb.countRateLimited.Load() {
+			return fmt.Errorf("backend rate limited: %d", wrapped.status)
+		}
+		if status, failed := lb.grpcFailure(wrapped.Header()); failed {
+			lb.metrics.ErrorsTotal.Inc()
+			return fmt.Errorf("backend gRPC error: grpc-status %s", status)
+		}
 
-		lb.metrics.ResponseTime.Observe(time.Since(start).Seconds())
+		if !refresh {
+			lb.metrics.ResponseTime.Observe(time.Since(start).Seconds())
+		}
+		succeeded = true
 		return nil
-	}); err != nil {
-		var lbErr *errors.LoadBalancerError
-		if errors.As(err, &lbErr) {
-			switch lbErr.Code {
-			case errors.ErrCircuitOpen:
-				http.Error(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
-			case errors.ErrRateLimitExceeded:
-				http.Error(w, "Too many requests", http.StatusTooManyRequests)
-			default:
-				http.Error(w, "Backend error", http.StatusBadGateway)
-			}
-		} else {
-			http.Error(w, "Backend error", http.StatusBadGateway)
+	})
+}
+
+// recordBackendStatus counts a request served through b. A zero status
+// means the handler wrote nothing, which net/http sends as 200.
+func (lb *LoadBalancer) recordBackendStatus(b *Backend, status int) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	lb.metrics.RequestsByBackend.WithLabelValues(b.URL.String(), strconv.Itoa(status)).Inc()
+}
+
+// retryBackend selects a backend for retrying r that is not in tried, or
+// returns nil if there is none
+func (lb *LoadBalancer) retryBackend(r *http.Request, tried map[*Backend]bool) *Backend {
+	lb.mu.RLock()
+	candidates := len(lb.backends)
+	lb.mu.RUnlock()
+
+	for i := 0; i < candidates; i++ {
+		backend := lb.selectBackend(r)
+		if backend == nil {
+			return nil
+		}
+		if !tried[backend] {
+			return backend
 		}
-		lb.metrics.ErrorsTotal.Inc()
-		return
 	}
+	return nil
 }
 
 func (lb *LoadBalancer) nextBackend() *Backend {
+	return lb.nextBackendFor("")
+}
+
+// nextBackendFor returns the next main pool backend for a request with the
+// given client key, which keyed selectors such as IP hash choose by
+func (lb *LoadBalancer) nextBackendFor(key string) *Backend {
 	lb.mu.RLock()
 	defer lb.mu.RUnlock()
 
@@ -184,73 +958,275 @@ func (lb *LoadBalancer) nextBackend() *Backend {
 		return nil
 	}
 
-	// Use weighted round-robin to select backend
-	selected := lb.wrr.Next()
-	if selected == nil {
-		return nil
+	// A debugging pin takes precedence over the normal algorithm
+	if backend := lb.pinnedBackend(); backend != nil && backend.CircuitBreaker.Ready() {
+		return backend
 	}
 
-	// Convert backend ID to index
-	var index int
-	fmt.Sscanf(selected.ID, "backend-%d", &index)
-	
-	if index >= 0 && index < len(lb.backends) {
-		return lb.backends[index]
+	return lb.pickFrom(lb.selector, key)
+}
+
+// pickFrom uses selector to choose a backend, skipping backends that are
+// unhealthy or whose circuit is open. Retries are bounded by the selector's
+// total weight, one full rotation for weighted round-robin, so that
+// selection returns nil rather than spinning when every backend is down.
+// Keyed selectors choose by key when it is set. Callers must hold lb.mu.
+//
+// When an overload factor is configured, a backend whose active connections
+// exceed that multiple of the pool average is passed over for the next
+// candidate in selection order, so a momentarily stuck backend does not keep
+// its share of new requests. If every candidate is overloaded the least
+// loaded one is used.
+//
+// Backends at their connection limit are passed over the same way. One is
+// returned only when no other candidate is left, so that forwarding rejects
+// the request as saturated rather than as having no backends.
+func (lb *LoadBalancer) pickFrom(selector algorithm.Selector, key string) *Backend {
+	limit := lb.overloadLimit()
+	keyed, _ := selector.(algorithm.KeyedSelector)
+
+	var fallback, saturated *Backend
+	for i, n := 0, selector.TotalWeight(); i < n; i++ {
+		var selected *algorithm.WeightedBackend
+		if keyed != nil && key != "" {
+			selected = keyed.NextFor(key, i)
+		} else {
+			selected = selector.Next()
+		}
+		if selected == nil {
+			return nil
+		}
+
+		backend := lb.byID[selected.ID]
+		if backend == nil || !backend.Healthy.Load() || !backend.CircuitBreaker.Ready() {
+			continue
+		}
+		if backend.saturated() {
+			if saturated == nil {
+				saturated = backend
+			}
+			continue
+		}
+		if limit > 0 && float64(backend.ActiveConns.Load()) > limit {
+			if fallback == nil || backend.ActiveConns.Load() < fallback.ActiveConns.Load() {
+				fallback = backend
+			}
+			continue
+		}
+		return backend
 	}
 
-	return nil
+	if fallback != nil {
+		return fallback
+	}
+	return saturated
 }
 
-// responseWriter wraps http.ResponseWriter to capture status code
+// overloadLimit returns the active connection count above which a backend
+// is treated as overloaded, or 0 when the check is disabled or the pool is
+// idle. Callers must hold lb.mu.
+func (lb *LoadBalancer) overloadLimit() float64 {
+	if lb.config == nil || lb.config.Balancing.OverloadFactor <= 0 || len(lb.backends) == 0 {
+		return 0
+	}
+
+	var total int64
+	for _, b := range lb.backends {
+		total += b.ActiveConns.Load()
+	}
+	return lb.config.Balancing.OverloadFactor * float64(total) / float64(len(lb.backends))
+}
+
+// allCircuitsOpen reports whether every backend is rejecting requests
+// because its circuit breaker is open
+func (lb *LoadBalancer) allCircuitsOpen() bool {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	if len(lb.backends) == 0 {
+		return false
+	}
+	for _, b := range lb.backends {
+		if b.CircuitBreaker.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// responseWriter wraps http.ResponseWriter to capture status code and body
+// size. The proxy sets backend trailers on Header() after the body has been
+// written, so the wrapper must always hand out the live header map.
 type responseWriter struct {
 	http.ResponseWriter
-	status int
+	status    int
+	bytes     int64
+	firstByte time.Time
 }
 
+// WriteHeader ignores all but the first final status so that an error reply
+// cannot follow a response that has already started. Informational 1xx
+// responses are passed through.
 func (rw *responseWriter) WriteHeader(status int) {
+	if status >= 100 && status <= 199 && status != http.StatusSwitchingProtocols {
+		rw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if rw.status != 0 {
+		return
+	}
+	if rw.firstByte.IsZero() {
+		rw.firstByte = time.Now()
+	}
 	rw.status = status
 	rw.ResponseWriter.WriteHeader(status)
 }
 
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.firstByte = time.Now()
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer so
+// flushing works through the wrapper
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Start runs the frontend, admin and metrics servers, health checks, DNS
+// re-resolution and service discovery until ctx is cancelled or a server
+// fails, then shuts everything down. Every server and background goroutine
+// has stopped by the time Start returns.
 func (lb *LoadBalancer) Start(ctx context.Context) error {
+	// Keep the metrics from being reset underneath a running balancer
+	defer lb.metrics.Use()()
+
+	// Adopt sockets passed down by the parent only now, so a balancer that
+	// is built but never started leaves them alone
+	if lb.inherited == nil {
+		inherited, err := inheritListeners()
+		if err != nil {
+			return err
+		}
+		lb.inherited = inherited
+	}
+	defer lb.inherited.close()
+
+	// A failing server stops the others too
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// Start health checks, DNS re-resolution, the weight schedule and
+	// service discovery; they are stopped before Start returns
+	healthCtx, stopHealthChecks := context.WithCancel(ctx)
+	defer func() {
+		stopHealthChecks()
+		lb.healthWG.Wait()
+	}()
+	lb.mu.Lock()
+	lb.startHealthChecks(healthCtx)
+	lb.mu.Unlock()
+	for server, manager := range map[string]*ssl.Manager{"frontend": lb.ssl, "admin": lb.adminSSL} {
+		if manager == nil {
+			continue
+		}
+		if err := manager.WatchCertificates(ctx); err != nil {
+			lb.logger.Warn("certificate files are not watched for changes", "server", server, "error", err)
+		}
+	}
+	if lb.dns != nil {
+		lb.healthWG.Add(1)
+		go func() {
+			defer lb.healthWG.Done()
+			lb.dns.run(healthCtx)
+		}()
+	}
+	if lb.schedule != nil {
+		lb.healthWG.Add(1)
+		go func() {
+			defer lb.healthWG.Done()
+			lb.runWeightSchedule(healthCtx)
+		}()
+	}
+	if lb.discovery != nil {
+		lb.healthWG.Add(1)
+		go func() {
+			defer lb.healthWG.Done()
+			lb.runDiscovery(healthCtx)
+		}()
+	}
+
+	// Build every frontend server first so a bad config fails before
+	// anything starts listening
+	servers := make([]*http.Server, 0, len(lb.config.Frontends))
+	for _, frontend := range lb.config.Frontends {
+		server, err := lb.newFrontendServer(frontend)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, server)
+	}
+
 	// Start frontend servers
-	errChan := make(chan error, len(lb.config.Frontends))
+	errChan := make(chan error, len(servers)+2)
 	var wg sync.WaitGroup
 
-	for _, frontend := range lb.config.Frontends {
+	if lb.config.Admin.Port != 0 {
 		wg.Add(1)
-		go func(port int) {
+		go func() {
 			defer wg.Done()
-
-			var handler http.Handler = lb
-			server := &http.Server{
-				Addr:    fmt.Sprintf(":%d", port),
-				Handler: handler,
+			if err := lb.serveAdmin(ctx, lb.config.Admin); err != nil {
+				errChan <- err
 			}
+		}()
+	}
 
-			if lb.ssl != nil {
-				server.TLSConfig = lb.ssl.GetTLSConfig()
+	if lb.config.Metrics.Enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := lb.serveMetrics(ctx, lb.config.Metrics.Port); err != nil {
+				errChan <- err
 			}
+		}()
+	}
 
-			// Handle graceful shutdown
-			go func() {
-				<-ctx.Done()
-				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-				defer cancel()
-				server.Shutdown(shutdownCtx)
-			}()
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			defer lb.shutdownWhenDone(ctx, "frontend", server).Wait()
 
-			var err error
-			if lb.ssl != nil {
-				err = server.ListenAndServeTLS("", "")
-			} else {
-				err = server.ListenAndServe()
+			ln, err := lb.listen(ctx, server.Addr, lb.config.Startup.BindTimeout, lb.config.Startup.BindBackoff)
+			if err != nil {
+				if ctx.Err() == nil {
+					errChan <- fmt.Errorf("frontend server error: %v", err)
+				}
+				return
+			}
+
+			lb.logger.Info("frontend listening", "addr", ln.Addr().String(), "tls", lb.ssl != nil)
+			switch {
+			case lb.config.StrictFraming:
+				err = server.Serve(lb.checkFraming(server, ln))
+			case lb.ssl != nil:
+				err = server.ServeTLS(ln, "", "")
+			default:
+				err = server.Serve(ln)
 			}
 
 			if err != nil && err != http.ErrServerClosed {
+				lb.logger.Error("frontend failed", "addr", server.Addr, "error", err)
 				errChan <- fmt.Errorf("frontend server error: %v", err)
+				return
 			}
-		}(frontend.Port)
+			lb.logger.Info("frontend stopped", "addr", server.Addr)
+		}(server)
 	}
 
 	// Wait for shutdown or error
@@ -259,17 +1235,104 @@ func (lb *LoadBalancer) Start(ctx context.Context) error {
 		close(errChan)
 	}()
 
-	// Return first error if any
+	// Return the first error, if any, once everything has stopped
+	var firstErr error
 	for err := range errChan {
-		if err != nil {
-			return err
+		if err != nil && firstErr == nil {
+			firstErr = err
+			stop()
 		}
 	}
 
+	return firstErr
+}
+
+// newFrontendServer builds the HTTP server for a frontend. HTTP/2 is
+// negotiated over TLS, with the frontend's stream limit applied.
+func (lb *LoadBalancer) newFrontendServer(frontend config.Frontend) (*http.Server, error) {
+	var handler http.Handler = lb
+	if frontend.MaxConcurrentRequests > 0 {
+		handler = lb.limitConcurrency(handler, frontend.MaxConcurrentRequests)
+	}
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", frontend.Port),
+		Handler: handler,
+		// Let OPTIONS * reach the balancer, which sets the Allow header
+		DisableGeneralOptionsHandler: true,
+	}
+	lb.countConnections(server)
+
+	if frontend.MaxRequestsPerConnection > 0 {
+		limitRequestsPerConn(server, frontend.MaxRequestsPerConnection)
+	}
+
+	if lb.ssl != nil {
+		server.TLSConfig = lb.ssl.GetTLSConfig().Clone()
+	}
+
+	if err := http2.ConfigureServer(server, &http2.Server{
+		MaxConcurrentStreams: frontend.MaxConcurrentStreams,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to configure HTTP/2 for frontend %d: %v", frontend.Port, err)
+	}
+
+	if lb.ssl != nil {
+		useCurrentCertificates(server.TLSConfig, lb.ssl)
+	}
+
+	return server, nil
+}
+
+// useCurrentCertificates makes every handshake on config use manager's
+// current certificates, so reloaded certificates take effect without a
+// restart
+func useCurrentCertificates(config *tls.Config, manager *ssl.Manager) {
+	nextProtos := config.NextProtos
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		current := manager.GetTLSConfig().Clone()
+		current.NextProtos = nextProtos
+		return current, nil
+	}
+}
+
+// serveMetrics exposes the Prometheus registry at /metrics on port until ctx
+// is cancelled
+func (lb *LoadBalancer) serveMetrics(ctx context.Context, port int) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(lb.metrics.GetRegistry(), promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: mux,
+	}
+
+	if err := lb.serveUntilDone(ctx, "metrics", server); err != nil {
+		return fmt.Errorf("metrics server error: %v", err)
+	}
 	return nil
 }
 
-func (lb *LoadBalancer) startAdminServer() {
-	// Implementation of admin server
-	// TODO: Add admin endpoints for configuration and monitoring
+// serveUntilDone runs server until ctx is cancelled, then shuts it down
+// gracefully, returning once the shutdown has finished. name identifies
+// the server in log messages.
+func (lb *LoadBalancer) serveUntilDone(ctx context.Context, name string, server *http.Server) error {
+	defer lb.shutdownWhenDone(ctx, name, server).Wait()
+
+	ln, err := lb.listen(ctx, server.Addr, 0, 0)
+	if err != nil {
+		lb.logger.Error("server failed", "server", name, "addr", server.Addr, "error", err)
+		return err
+	}
+
+	lb.logger.Info("server listening", "server", name, "addr", server.Addr, "tls", server.TLSConfig != nil)
+	if server.TLSConfig != nil {
+		err = server.ServeTLS(ln, "", "")
+	} else {
+		err = server.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		lb.logger.Error("server failed", "server", name, "addr", server.Addr, "error", err)
+		return err
+	}
+	lb.logger.Info("server stopped", "server", name, "addr", server.Addr)
+	return nil
 }
@@ -1,21 +1,33 @@
 package balancer
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
 	"loadbalancer/internal/balancer/algorithm"
+	"loadbalancer/internal/circuitbreaker"
 	"loadbalancer/internal/config"
 	"loadbalancer/internal/metrics"
+	"loadbalancer/internal/ratelimit"
 )
 
 func TestNew(t *testing.T) {
 	metrics.Reset() // Reset metrics before test
 	cfg := &config.Config{
-		Backends: []string{"http://localhost:8001", "http://localhost:8002"},
+		Backends: []config.Backend{{URL: "http://localhost:8001"}, {URL: "http://localhost:8002"}},
 	}
 	m := metrics.New()
 	lb, err := New(cfg, m)
@@ -30,16 +42,146 @@ func TestNew(t *testing.T) {
 	if len(lb.backends) != 2 {
 		t.Errorf("Expected 2 backends, got %d", len(lb.backends))
 	}
+
+	// Unknown algorithms are rejected
+	metrics.Reset()
+	cfg.Algorithm = "nonexistent"
+	if _, err := New(cfg, metrics.New()); err == nil {
+		t.Error("Expected error for unknown algorithm")
+	}
+}
+
+// lastSelector sends every request to the most recently added backend
+type lastSelector struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (s *lastSelector) Add(id string, weight int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids = append(s.ids, id)
+}
+
+func (s *lastSelector) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, candidate := range s.ids {
+		if candidate == id {
+			s.ids = append(s.ids[:i:i], s.ids[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *lastSelector) Next() *algorithm.WeightedBackend {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ids) == 0 {
+		return nil
+	}
+	return &algorithm.WeightedBackend{ID: s.ids[len(s.ids)-1], Weight: 1}
+}
+
+func (s *lastSelector) UpdateWeight(id string, weight int) bool { return true }
+
+func (s *lastSelector) TotalWeight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.ids)
+}
+
+func TestCustomSelector(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	algorithm.RegisterSelector("test_last", func() algorithm.Selector { return &lastSelector{} })
+
+	var urls []string
+	for _, name := range []string{"backend1", "backend2", "backend3"} {
+		name := name
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+
+	lb, err := New(&config.Config{
+		Backends:  config.BackendsFromURLs(urls),
+		Algorithm: "test_last",
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	if _, ok := lb.selector.(*lastSelector); !ok {
+		t.Fatalf("Expected the registered selector to be used, got %T", lb.selector)
+	}
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if body := w.Body.String(); body != "backend3" {
+			t.Errorf("Expected request %d on backend3, got %q", i, body)
+		}
+	}
+}
+
+func TestIPHashAffinity(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var urls []string
+	for _, name := range []string{"backend1", "backend2", "backend3"} {
+		name := name
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+
+	lb, err := New(&config.Config{
+		Backends:  config.BackendsFromURLs(urls),
+		Algorithm: algorithm.IPHashName,
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	send := func(remoteAddr string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	// The client port does not matter, only its IP
+	first := send("192.0.2.10:40000")
+	for i := 1; i <= 5; i++ {
+		if got := send(fmt.Sprintf("192.0.2.10:%d", 40000+i)); got != first {
+			t.Errorf("Expected request %d from the same client on %s, got %s", i, first, got)
+		}
+	}
+
+	// A client whose backend is down moves, and moves back on recovery
+	pinned := lb.nextBackendFor("192.0.2.10")
+	pinned.Healthy.Store(false)
+	if got := send("192.0.2.10:1"); got == first || got == "" {
+		t.Errorf("Expected client to fail over from %s, got %q", first, got)
+	}
+	pinned.Healthy.Store(true)
+	if got := send("192.0.2.10:1"); got != first {
+		t.Errorf("Expected client back on %s after recovery, got %s", first, got)
+	}
 }
 
 func TestUpdateBackends(t *testing.T) {
 	metrics.Reset() // Reset metrics before test
 	lb := &LoadBalancer{
-		metrics: metrics.New(),
-		wrr:     algorithm.NewWeightedRoundRobin(),
+		metrics:  metrics.New(),
+		selector: algorithm.NewWeightedRoundRobin(),
 	}
 
-	backends := []string{"http://localhost:8001", "http://localhost:8002"}
+	backends := []config.Backend{{URL: "http://localhost:8001"}, {URL: "http://localhost:8002"}}
 	err := lb.updateBackends(backends)
 	if err != nil {
 		t.Fatalf("Failed to update backends: %v", err)
@@ -50,7 +192,7 @@ func TestUpdateBackends(t *testing.T) {
 	}
 
 	// Test invalid backend URL
-	err = lb.updateBackends([]string{"not-a-valid-url"})
+	err = lb.updateBackends([]config.Backend{{URL: "not-a-valid-url"}})
 	if err == nil {
 		t.Error("Expected error for invalid backend URL")
 	}
@@ -71,7 +213,7 @@ func TestServeHTTP(t *testing.T) {
 
 	// Create load balancer
 	cfg := &config.Config{
-		Backends: []string{backend1.URL, backend2.URL},
+		Backends: []config.Backend{{URL: backend1.URL}, {URL: backend2.URL}},
 	}
 	lb, err := New(cfg, metrics.New())
 	if err != nil {
@@ -131,3 +273,752 @@ func TestGracefulShutdown(t *testing.T) {
 		t.Error("Timeout waiting for graceful shutdown")
 	}
 }
+
+func TestDeterministicSelection(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var urls []string
+	for _, name := range []string{"backend1", "backend2", "backend3"} {
+		name := name
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+
+	cfg := &config.Config{
+		Backends:      config.BackendsFromURLs(urls),
+		Deterministic: true,
+		Seed:          1,
+	}
+	lb, err := New(cfg, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// Dynamic adjustments must not perturb the order
+	lb.selector.(*algorithm.WeightedRoundRobin).AdjustWeight(urls[0], 5)
+
+	expected := []string{"backend2", "backend3", "backend1", "backend2", "backend3", "backend1"}
+	for i, want := range expected {
+		req := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+		if got := w.Body.String(); got != want {
+			t.Errorf("Request %d: expected %s, got %s", i, want, got)
+		}
+	}
+}
+
+func TestDefaultWeight(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	backends := []config.Backend{
+		{URL: "http://localhost:8001"},
+		{URL: "http://localhost:8002"},
+		{URL: "http://localhost:8003", Weight: 1},
+	}
+	lb, err := New(&config.Config{
+		Backends:  backends,
+		Balancing: config.Balancing{DefaultWeight: 3},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// Backends without a weight get the default; explicit weights are kept
+	counts := make(map[string]int)
+	for i := 0; i < 70; i++ {
+		counts[lb.nextBackend().ID]++
+	}
+	want := map[string]int{"http://localhost:8001": 30, "http://localhost:8002": 30, "http://localhost:8003": 10}
+	for id, n := range want {
+		if counts[id] != n {
+			t.Errorf("Expected %d requests on %s, got %d", n, id, counts[id])
+		}
+	}
+
+	if _, err := New(&config.Config{
+		Backends:  backends,
+		Balancing: config.Balancing{DefaultWeight: -1},
+	}, metrics.New()); err == nil {
+		t.Error("Expected error for negative default weight")
+	}
+}
+
+func TestConcurrentAddRemoveNext(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: "http://localhost:8001"}, {URL: "http://localhost:8002"}},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	// Selection must always return a backend that is still in the pool
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if b := lb.nextBackend(); b != nil && b.URL == nil {
+					t.Error("Selected backend has no URL")
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		b, err := lb.newBackend(fmt.Sprintf("http://localhost:%d", 9000+i%10))
+		if err != nil {
+			t.Fatalf("Failed to create backend: %v", err)
+		}
+		lb.addBackend(b, i%3+1)
+		lb.removeBackend(fmt.Sprintf("http://localhost:%d", 9000+(i+5)%10))
+		lb.removeBackend("http://localhost:8001")
+	}
+	close(done)
+	wg.Wait()
+
+	// The pool and the weighted round-robin must agree on membership
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	ids := make(map[string]bool)
+	for _, b := range lb.backends {
+		ids[b.ID] = true
+		if lb.byID[b.ID] != b {
+			t.Errorf("Backend %s missing from ID index", b.ID)
+		}
+	}
+	wrrBackends := lb.selector.(*algorithm.WeightedRoundRobin).GetBackends()
+	if len(wrrBackends) != len(lb.backends) {
+		t.Errorf("Expected %d backends in round-robin, got %d", len(lb.backends), len(wrrBackends))
+	}
+	for _, wb := range wrrBackends {
+		if !ids[wb.ID] {
+			t.Errorf("Round-robin has unknown backend %s", wb.ID)
+		}
+	}
+}
+
+// unavailableLimiter simulates a rate limiter whose backing store is down
+type unavailableLimiter struct{}
+
+func (unavailableLimiter) Allow() error {
+	return stderrors.New("limiter store unreachable")
+}
+
+func TestLimiterFailureMode(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		mode       string
+		wantStatus int
+	}{
+		{"open", http.StatusOK},
+		{"closed", http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			metrics.Reset() // Reset metrics before test
+			cfg := &config.Config{
+				Backends:  []config.Backend{{URL: backend.URL}},
+				RateLimit: config.RateLimit{FailureMode: tt.mode},
+			}
+			lb, err := New(cfg, metrics.New())
+			if err != nil {
+				t.Fatalf("Failed to create load balancer: %v", err)
+			}
+			lb.backends[0].RateLimiter = ratelimit.WithFailureMode(unavailableLimiter{}, lb.limiterFailureMode)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			w := httptest.NewRecorder()
+			lb.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status code %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+
+	// Unknown modes are rejected at construction
+	metrics.Reset()
+	_, err := New(&config.Config{
+		Backends:  []config.Backend{{URL: backend.URL}},
+		RateLimit: config.RateLimit{FailureMode: "maybe"},
+	}, metrics.New())
+	if err == nil {
+		t.Error("Expected error for unknown failure mode")
+	}
+}
+
+func TestHTTP10Client(t *testing.T) {
+	// Backend streams its body without a Content-Length
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello "))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("legacy client"))
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name             string
+		closeConnections bool
+		request          string
+	}{
+		{"default", false, "GET / HTTP/1.0\r\nHost: lb\r\n\r\n"},
+		{"keep-alive forced closed", true, "GET / HTTP/1.0\r\nHost: lb\r\nConnection: keep-alive\r\n\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics.Reset() // Reset metrics before test
+			lb, err := New(&config.Config{
+				Backends:   []config.Backend{{URL: backend.URL}},
+				LegacyHTTP: config.LegacyHTTP{CloseConnections: tt.closeConnections},
+			}, metrics.New())
+			if err != nil {
+				t.Fatalf("Failed to create load balancer: %v", err)
+			}
+			frontend := httptest.NewServer(lb)
+			defer frontend.Close()
+
+			conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			if _, err := conn.Write([]byte(tt.request)); err != nil {
+				t.Fatalf("Failed to write request: %v", err)
+			}
+
+			// Reading to EOF proves the connection was closed after the response
+			raw, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
+			if err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.ProtoMinor != 0 {
+				t.Errorf("Expected HTTP/1.0 response, got %s", resp.Proto)
+			}
+			if len(resp.TransferEncoding) != 0 {
+				t.Errorf("Expected no transfer encoding, got %v", resp.TransferEncoding)
+			}
+			if tt.closeConnections && resp.Header.Get("Connection") != "close" {
+				t.Errorf("Expected Connection: close, got %q", resp.Header.Get("Connection"))
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != "hello legacy client" {
+				t.Errorf("Expected complete body, got %q", body)
+			}
+		})
+	}
+}
+
+func TestTrailersForwarded(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("payload"))
+		w.(http.Flusher).Flush()
+		w.Header().Set("X-Checksum", "abc123")
+		// Trailers not announced up front, as gRPC servers send grpc-status
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{Backends: []config.Backend{{URL: backend.URL}}}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	frontend := httptest.NewServer(lb)
+	defer frontend.Close()
+
+	resp, err := http.Get(frontend.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Trailers are only populated once the body has been fully read
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	if string(body) != "payload" {
+		t.Errorf("Expected payload body, got %q", body)
+	}
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc123" {
+		t.Errorf("Expected X-Checksum trailer abc123, got %q", got)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Expected Grpc-Status trailer 0, got %q", got)
+	}
+}
+
+func TestAllCircuitsOpen(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	m := metrics.New()
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: "http://localhost:8001"}, {URL: "http://localhost:8002"}},
+	}, m)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// Open one circuit: selection routes around it
+	for i := 0; i < 5; i++ {
+		lb.backends[0].CircuitBreaker.RecordResult(stderrors.New("backend failure"))
+	}
+	for i := 0; i < 4; i++ {
+		if b := lb.nextBackend(); b != lb.backends[1] {
+			t.Fatalf("Expected selection to skip the open circuit, got %v", b)
+		}
+	}
+	if lb.allCircuitsOpen() {
+		t.Error("Expected allCircuitsOpen to be false with one closed circuit")
+	}
+
+	// Open the remaining circuit
+	for i := 0; i < 5; i++ {
+		lb.backends[1].CircuitBreaker.RecordResult(stderrors.New("backend failure"))
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if got := testutil.ToFloat64(m.AllCircuitsOpen); got != 1 {
+		t.Errorf("Expected AllCircuitsOpen to be 1, got %f", got)
+	}
+	// Clients are told to come back when the breaker timeout expires
+	if got := w.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Expected Retry-After of 10 seconds, got %q", got)
+	}
+}
+
+func TestCircuitOpenResponse(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: "http://localhost:8001"}},
+		CircuitBreaker: config.CircuitBreaker{
+			OpenStatus:  529,
+			OpenHeaders: map[string]string{"X-Circuit": "open"},
+		},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		lb.backends[0].CircuitBreaker.RecordResult(stderrors.New("backend failure"))
+	}
+
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 529 {
+		t.Errorf("Expected configured status 529, got %d", w.Code)
+	}
+	if got := w.Header().Get("X-Circuit"); got != "open" {
+		t.Errorf("Expected configured header, got %q", got)
+	}
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 10 {
+		t.Errorf("Expected Retry-After within the breaker timeout, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// A configured Retry-After is sent as is
+	lb.config.CircuitBreaker.OpenHeaders["Retry-After"] = "120"
+	w = httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Header().Get("Retry-After"); got != "120" {
+		t.Errorf("Expected configured Retry-After, got %q", got)
+	}
+}
+
+func TestRequestsByBackendMetrics(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	m := metrics.New()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{Backends: []config.Backend{{URL: backend.URL}}}, m)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	// Two requests fit in the bucket; the third is rejected
+	lb.backends[0].RateLimiter = ratelimit.New(ratelimit.Config{Rate: 0.001, Capacity: 2})
+
+	for _, path := range []string{"/", "/missing", "/"} {
+		lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	for status, want := range map[string]float64{"200": 1, "404": 1, "429": 1} {
+		if got := testutil.ToFloat64(m.RequestsByBackend.WithLabelValues(backend.URL, status)); got != want {
+			t.Errorf("Expected %v requests with status %s, got %v", want, status, got)
+		}
+	}
+	if got := testutil.ToFloat64(m.RateLimitRejections); got != 1 {
+		t.Errorf("Expected 1 rate limit rejection, got %v", got)
+	}
+
+	// Removed backends stop being exported
+	lb.removeBackend(backend.URL)
+	if got := testutil.CollectAndCount(m.RequestsByBackend); got != 0 {
+		t.Errorf("Expected no request series after removal, got %d", got)
+	}
+}
+
+func TestNextBackendSkipsUnhealthy(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: "http://localhost:8001"}, {URL: "http://localhost:8002"}},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	lb.backends[0].Healthy.Store(false)
+	for i := 0; i < 10; i++ {
+		if b := lb.nextBackend(); b != lb.backends[1] {
+			t.Fatalf("Expected request %d on the healthy backend, got %v", i, b)
+		}
+	}
+
+	// With every backend down selection gives up instead of looping
+	lb.backends[1].Healthy.Store(false)
+	if b := lb.nextBackend(); b != nil {
+		t.Errorf("Expected no backend when all are unhealthy, got %v", b.URL)
+	}
+}
+
+func TestMetricsServer(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	cfg := &config.Config{
+		Metrics: config.Metrics{Enabled: true, Port: 19090}, // Use high port number to avoid conflicts
+	}
+	lb, err := New(cfg, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- lb.Start(ctx)
+	}()
+
+	// Poll until the server is listening
+	var resp *http.Response
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err = http.Get("http://localhost:19090/metrics")
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Metrics endpoint failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected metrics status 200, got %d", resp.StatusCode)
+	}
+	if !bytes.Contains(body, []byte("loadbalancer_requests_total")) {
+		t.Error("Expected metrics output to include loadbalancer_requests_total")
+	}
+
+	// The server stops with the balancer
+	cancel()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("Expected no error on shutdown, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for metrics server shutdown")
+	}
+	if _, err := http.Get("http://localhost:19090/metrics"); err == nil {
+		t.Error("Expected metrics server to be stopped")
+	}
+}
+
+func TestOverloadedBackendSkipped(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{
+			{URL: "http://localhost:8001", Weight: 5},
+			{URL: "http://localhost:8002"},
+			{URL: "http://localhost:8003"},
+		},
+		Balancing: config.Balancing{OverloadFactor: 2},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// The heavily weighted backend is next in WRR order but is stuck with
+	// far more connections than the pool average
+	stuck := lb.backends[0]
+	stuck.ActiveConns.Store(10)
+	for i := 0; i < 7; i++ {
+		if b := lb.nextBackend(); b == stuck {
+			t.Fatalf("Expected overloaded backend to be skipped on request %d", i)
+		}
+	}
+
+	// Once it drains it rejoins the rotation
+	stuck.ActiveConns.Store(0)
+	counts := make(map[*Backend]int)
+	for i := 0; i < 7; i++ {
+		counts[lb.nextBackend()]++
+	}
+	if counts[stuck] != 5 {
+		t.Errorf("Expected recovered backend to get its 5 of 7 requests, got %d", counts[stuck])
+	}
+
+	// Invalid factors are rejected at construction
+	metrics.Reset()
+	_, err = New(&config.Config{Balancing: config.Balancing{OverloadFactor: 0.5}}, metrics.New())
+	if err == nil {
+		t.Error("Expected error for overload factor below 1")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hold the response until the balancer gives up on the request
+		<-r.Context().Done()
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends:       []config.Backend{{URL: backend.URL}},
+		RequestTimeout: 50 * time.Millisecond,
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status code %d, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+	if body := w.Body.String(); body != "Backend timeout\n" {
+		t.Errorf("Expected a single timeout reply, got %q", body)
+	}
+}
+
+func TestRouteRequestTimeout(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(100 * time.Millisecond):
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends:       []config.Backend{{URL: backend.URL}},
+		RequestTimeout: 50 * time.Millisecond,
+		Routes: []config.Route{
+			{Path: "/export", Timeout: time.Second},
+			{Path: "/api"},
+		},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// A slow export fits within its own timeout
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("GET", "/export/report", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Errorf("Expected slow export to succeed, got %d %q", w.Code, w.Body.String())
+	}
+
+	// The same latency elsewhere hits the global timeout
+	w = httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("GET", "/api/report", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status code %d, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+}
+
+func TestBackendErrorNotOverwritten(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("backend failure"))
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// The backend's own error response reaches the client unchanged
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	if body := w.Body.String(); body != "backend failure" {
+		t.Errorf("Expected backend body only, got %q", body)
+	}
+}
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	held := make(chan struct{})
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(50 * time.Millisecond)
+		case "/hold":
+			close(held)
+			<-release
+		}
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		AdaptiveConcurrency: config.AdaptiveConcurrency{
+			TargetLatency: 10 * time.Millisecond,
+			MinLimit:      1,
+			MaxLimit:      2,
+			Decrease:      0.5,
+		},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// A slow response halves the backend's concurrency limit
+	lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	if limit := lb.backends[0].adaptive.Limit(); limit != 1 {
+		t.Fatalf("Expected limit to drop to 1 after a slow response, got %d", limit)
+	}
+
+	// With one request in flight a second is now turned away
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hold", nil))
+	}()
+	<-held
+
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	close(release)
+	<-done
+}
+
+func TestMaxConns(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	backendA, backendB := newBackend("a"), newBackend("b")
+	defer backendA.Close()
+	defer backendB.Close()
+
+	m := metrics.New()
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{
+			{URL: backendA.URL, MaxConns: 1},
+			{URL: backendB.URL, MaxConns: 1},
+		},
+	}, m)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	a, b := lb.byID[backendA.URL], lb.byID[backendB.URL]
+
+	serve := func() (int, string) {
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Code, w.Body.String()
+	}
+
+	// With a request already in flight to a, everything goes to b
+	a.ActiveConns.Store(1)
+	for i := 0; i < 4; i++ {
+		if code, body := serve(); code != http.StatusOK || body != "b" {
+			t.Fatalf("Expected saturated backend to be passed over, got %d %q", code, body)
+		}
+	}
+
+	// Only once both are full are requests turned away
+	b.ActiveConns.Store(1)
+	if code, _ := serve(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d with every backend full, got %d", http.StatusServiceUnavailable, code)
+	}
+	if got := testutil.ToFloat64(m.SaturationRejections); got != 1 {
+		t.Errorf("Expected 1 saturation rejection, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.RateLimitRejections); got != 0 {
+		t.Errorf("Expected saturation not to count as rate limiting, got %v", got)
+	}
+	if a.CircuitBreaker.GetState() != circuitbreaker.StateClosed || b.CircuitBreaker.GetState() != circuitbreaker.StateClosed {
+		t.Error("Expected saturation not to count against the circuit breakers")
+	}
+
+	// A freed slot is used again
+	a.ActiveConns.Store(0)
+	if code, body := serve(); code != http.StatusOK || body != "a" {
+		t.Errorf("Expected request to use the freed backend, got %d %q", code, body)
+	}
+	if got := a.ActiveConns.Load(); got != 0 {
+		t.Errorf("Expected slot to be released after the request, got %d active", got)
+	}
+}
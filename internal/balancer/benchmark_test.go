@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -65,9 +66,10 @@ func BenchmarkLoadBalancer(b *testing.B) {
 			defer backend.Close()
 
 			// Configure load balancer
+			metrics.Reset()
 			cfg := &config.Config{
-				Frontends: []config.Frontend{{Port: 0}}, // Use random port
-				Backends:  []string{backend.URL},
+				Frontends: []config.Frontend{{Port: freePort(b)}},
+				Backends:  []config.Backend{{URL: backend.URL}},
 			}
 
 			if scenario.ssl {
@@ -82,6 +84,8 @@ func BenchmarkLoadBalancer(b *testing.B) {
 			if err != nil {
 				b.Fatalf("Failed to create load balancer: %v", err)
 			}
+			// Measure the proxy, not the default 100 requests per second
+			lb.backends[0].RateLimiter = ratelimit.New(ratelimit.Config{Rate: 1e9, Capacity: 1e9})
 
 			// Start load balancer
 			ctx, cancel := context.WithCancel(context.Background())
@@ -93,6 +97,24 @@ func BenchmarkLoadBalancer(b *testing.B) {
 				}
 			}()
 
+			// Wait for the frontend to accept connections
+			addr := fmt.Sprintf("localhost:%d", cfg.Frontends[0].Port)
+			for deadline := time.Now().Add(5 * time.Second); ; {
+				conn, err := net.Dial("tcp", addr)
+				if err == nil {
+					conn.Close()
+					break
+				}
+				if time.Now().After(deadline) {
+					b.Fatalf("Load balancer did not start listening: %v", err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			target := "http://" + addr
+			if scenario.ssl {
+				target = "https://" + addr
+			}
+
 			// Create test client
 			client := &http.Client{
 				Transport: &http.Transport{
@@ -106,7 +128,7 @@ func BenchmarkLoadBalancer(b *testing.B) {
 			b.ResetTimer()
 			b.RunParallel(func(pb *testing.PB) {
 				for pb.Next() {
-					resp, err := client.Get(fmt.Sprintf("http://localhost:%d", cfg.Frontends[0].Port))
+					resp, err := client.Get(target)
 					if err != nil {
 						b.Errorf("Request failed: %v", err)
 						continue
@@ -121,6 +143,41 @@ func BenchmarkLoadBalancer(b *testing.B) {
 	}
 }
 
+// BenchmarkProxyTimeout measures a proxied request with and without a
+// request timeout. Both proxy in the calling goroutine; the timeout only
+// adds its timer and cancellable context.
+func BenchmarkProxyTimeout(b *testing.B) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer backend.Close()
+
+	for _, timeout := range []time.Duration{-1, 30 * time.Second} {
+		b.Run(fmt.Sprintf("Timeout=%v", timeout), func(b *testing.B) {
+			metrics.Reset()
+			lb, err := New(&config.Config{
+				Backends:       []config.Backend{{URL: backend.URL}},
+				RequestTimeout: timeout,
+			}, metrics.New())
+			if err != nil {
+				b.Fatalf("Failed to create load balancer: %v", err)
+			}
+			// Measure the proxy, not the default 100 requests per second
+			lb.backends[0].RateLimiter = ratelimit.New(ratelimit.Config{Rate: 1e9, Capacity: 1e9})
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rec := httptest.NewRecorder()
+				lb.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+				if rec.Code != http.StatusOK {
+					b.Fatalf("Expected status 200, got %d", rec.Code)
+				}
+			}
+		})
+	}
+}
+
 // BenchmarkWeightedRoundRobin measures the performance of the weighted round-robin algorithm
 func BenchmarkWeightedRoundRobin(b *testing.B) {
 	scenarios := []struct {
@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+
+	"loadbalancer/internal/errors"
+)
+
+// SwitchTraffic replaces the whole main pool with the green backends in a
+// single swap, once every one of them passes a health check. The replaced
+// blue backends are kept so RevertTraffic can bring them straight back. It
+// is refused while a rollout runs, and reported by RolloutStatus like one.
+func (lb *LoadBalancer) SwitchTraffic(ctx context.Context, green []string) error {
+	if len(green) == 0 {
+		return fmt.Errorf("no green backends provided for switch")
+	}
+
+	state := lb.rolloutState
+	if err := state.start(); err != nil {
+		return err
+	}
+	state.update("switching", 0, nil)
+
+	if err := lb.probeURLs(ctx, green); err != nil {
+		err = fmt.Errorf("switch refused: %w", err)
+		state.finish("failed", err)
+		return err
+	}
+
+	blue := lb.poolSnapshot()
+	if err := lb.updateBackendURLs(green); err != nil {
+		err = fmt.Errorf("switch failed: %w", err)
+		state.finish("failed", err)
+		return err
+	}
+	lb.mu.Lock()
+	lb.blue = blue
+	lb.mu.Unlock()
+
+	lb.logger.Info("traffic switched to green backends", "backends", len(green))
+	state.finish("switched", nil)
+	return nil
+}
+
+// RevertTraffic swaps the blue backends replaced by the last SwitchTraffic
+// back in, without health checking them first
+func (lb *LoadBalancer) RevertTraffic() error {
+	state := lb.rolloutState
+	if err := state.start(); err != nil {
+		return err
+	}
+
+	lb.mu.RLock()
+	blue := lb.blue
+	lb.mu.RUnlock()
+	if blue == nil {
+		err := errors.New(errors.ErrConfigInvalid, "no switched traffic to revert", nil)
+		state.finish("failed", err)
+		return err
+	}
+	if err := lb.updateBackends(blue); err != nil {
+		err = fmt.Errorf("revert failed: %w", err)
+		state.finish("failed", err)
+		return err
+	}
+	lb.mu.Lock()
+	lb.blue = nil
+	lb.mu.Unlock()
+
+	lb.logger.Info("traffic reverted to blue backends", "backends", len(blue))
+	state.finish("reverted", nil)
+	return nil
+}
+
+// probeURLs health checks a backend for each of urls, which need not be in
+// the pool, failing on the first that is invalid or unhealthy
+func (lb *LoadBalancer) probeURLs(ctx context.Context, urls []string) error {
+	for _, u := range urls {
+		b, err := lb.newBackend(u)
+		if err != nil {
+			return err
+		}
+		if err := lb.probe(ctx, b); err != nil {
+			return errors.New(errors.ErrBackendUnavailable, fmt.Sprintf("backend %s is not healthy", u), err)
+		}
+	}
+	return nil
+}
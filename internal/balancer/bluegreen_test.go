@@ -0,0 +1,99 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+	"loadbalancer/internal/metrics"
+)
+
+func TestSwitchTraffic(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	servers, urls := setupTestBackends(t, 4)
+	defer func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}()
+	blue, green := urls[:2], urls[2:]
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs(blue),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	poolURLs := func() []string {
+		var urls []string
+		for _, backend := range lb.poolSnapshot() {
+			urls = append(urls, backend.URL)
+		}
+		return urls
+	}
+
+	if err := lb.RevertTraffic(); errors.GetCode(err) != errors.ErrConfigInvalid {
+		t.Errorf("Expected nothing to revert before a switch, got %v", err)
+	}
+
+	if err := lb.SwitchTraffic(context.Background(), green); err != nil {
+		t.Fatalf("SwitchTraffic failed: %v", err)
+	}
+	if got := poolURLs(); len(got) != 2 || got[0] != green[0] || got[1] != green[1] {
+		t.Errorf("Expected the green backends after the switch, got %v", got)
+	}
+	if status := lb.RolloutStatus(); status.Phase != "switched" || status.InProgress {
+		t.Errorf("Expected the switch reported, got %+v", status)
+	}
+
+	if err := lb.RevertTraffic(); err != nil {
+		t.Fatalf("RevertTraffic failed: %v", err)
+	}
+	if got := poolURLs(); len(got) != 2 || got[0] != blue[0] || got[1] != blue[1] {
+		t.Errorf("Expected the blue backends after the revert, got %v", got)
+	}
+	if lb.blue != nil {
+		t.Errorf("Expected no standby set after the revert, got %v", lb.blue)
+	}
+}
+
+func TestSwitchTrafficUnhealthyGreen(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	servers, urls := setupTestBackends(t, 2)
+	defer func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs(urls[:1]),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	err = lb.SwitchTraffic(context.Background(), []string{urls[1], unhealthy.URL})
+	if errors.GetCode(err) != errors.ErrBackendUnavailable {
+		t.Fatalf("Expected the switch refused for an unhealthy green backend, got %v", err)
+	}
+	if pool := lb.poolSnapshot(); len(pool) != 1 || pool[0].URL != urls[0] {
+		t.Errorf("Expected the blue backend still serving, got %v", pool)
+	}
+	if lb.blue != nil {
+		t.Errorf("Expected no standby set after a refused switch, got %v", lb.blue)
+	}
+	if status := lb.RolloutStatus(); status.Phase != "failed" || status.Error == "" {
+		t.Errorf("Expected the refusal reported, got %+v", status)
+	}
+}
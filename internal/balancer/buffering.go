@@ -0,0 +1,96 @@
+package balancer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// bufferRequestBody reads the whole request body into memory so it is sent
+// to the backend with a known Content-Length. GetBody is set so the body can
+// be replayed.
+func bufferRequestBody(r *http.Request) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.Body, _ = r.GetBody()
+	r.ContentLength = int64(len(body))
+	r.Header.Del("Transfer-Encoding")
+	r.TransferEncoding = nil
+	return nil
+}
+
+// bufferedResponse holds the backend response in memory and only sends it
+// to the client once complete, with a Content-Length. Trailers cannot be
+// sent on a length-delimited response and are dropped.
+type bufferedResponse struct {
+	w      http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.w.Header()
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// commit writes the buffered response to the client
+func (b *bufferedResponse) commit() {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+
+	header := b.w.Header()
+	header.Del("Trailer")
+	for key := range header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			header.Del(key)
+		}
+	}
+	header.Set("Content-Length", strconv.Itoa(b.body.Len()))
+
+	b.w.WriteHeader(b.status)
+	io.Copy(b.w, &b.body)
+}
+
+// streamingResponse flushes every write straight through to the client
+type streamingResponse struct {
+	http.ResponseWriter
+}
+
+func (s *streamingResponse) Write(p []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(p)
+	if err == nil {
+		http.NewResponseController(s.ResponseWriter).Flush()
+	}
+	return n, err
+}
+
+func (s *streamingResponse) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
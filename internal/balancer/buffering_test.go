@@ -0,0 +1,134 @@
+package balancer
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestRouteBuffering(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	// Each path gets its own channel to hold the backend mid-response
+	releases := map[string]chan struct{}{
+		"/download":  make(chan struct{}),
+		"/api/items": make(chan struct{}),
+	}
+	requestLengths := make(chan int64, 2)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestLengths <- r.ContentLength
+		io.Copy(io.Discard, r.Body)
+
+		w.Write([]byte("first\n"))
+		w.(http.Flusher).Flush()
+		<-releases[r.URL.Path]
+		w.Write([]byte("second\n"))
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		Routes: []config.Route{
+			{Path: "/download", Buffering: "stream"},
+			{Path: "/api", Buffering: "buffer"},
+		},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	frontend := httptest.NewServer(lb)
+	defer frontend.Close()
+
+	// post sends a chunked request body and returns a channel that yields the
+	// first line of the response once it arrives
+	post := func(path string) (*http.Response, <-chan string) {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.Write([]byte("upload"))
+			pw.Close()
+		}()
+		resp, err := http.Post(frontend.URL+path, "text/plain", pr)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		lines := make(chan string, 1)
+		go func() {
+			line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+			lines <- line
+		}()
+		return resp, lines
+	}
+
+	// Streaming route: the request is not buffered and the first chunk
+	// reaches the client while the backend is still writing
+	resp, lines := post("/download")
+	if length := <-requestLengths; length != -1 {
+		t.Errorf("Expected streamed request body of unknown length, got %d", length)
+	}
+	select {
+	case line := <-lines:
+		if line != "first\n" {
+			t.Errorf("Expected first chunk, got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected first chunk to be streamed before the backend finished")
+	}
+	close(releases["/download"])
+	resp.Body.Close()
+
+	// Buffered route: the request arrives with a Content-Length and nothing
+	// reaches the client until the backend has finished
+	frontendResp := make(chan *http.Response, 1)
+	go func() {
+		// Wrapping the reader hides its length so the client sends it chunked
+		body := io.MultiReader(strings.NewReader("upload"))
+		resp, err := http.Post(frontend.URL+"/api/items", "text/plain", body)
+		if err != nil {
+			t.Errorf("Request to /api failed: %v", err)
+			close(frontendResp)
+			return
+		}
+		frontendResp <- resp
+	}()
+	if length := <-requestLengths; length != int64(len("upload")) {
+		t.Errorf("Expected buffered request body length %d, got %d", len("upload"), length)
+	}
+	select {
+	case <-frontendResp:
+		t.Error("Expected buffered response to wait for the backend to finish")
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(releases["/api/items"])
+
+	resp = <-frontendResp
+	if resp == nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "first\nsecond\n" {
+		t.Errorf("Expected complete body, got %q", body)
+	}
+	if resp.ContentLength != int64(len("first\nsecond\n")) {
+		t.Errorf("Expected Content-Length %d, got %d", len("first\nsecond\n"), resp.ContentLength)
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	if err := validateRoutes([]config.Route{{Path: "/api", Buffering: "buffer"}}); err != nil {
+		t.Errorf("Expected valid route, got %v", err)
+	}
+	if err := validateRoutes([]config.Route{{Path: "/api", Buffering: "sometimes"}}); err == nil {
+		t.Error("Expected error for unknown buffering mode")
+	}
+	if err := validateRoutes([]config.Route{{Path: "api"}}); err == nil {
+		t.Error("Expected error for relative route path")
+	}
+}
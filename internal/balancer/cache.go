@@ -0,0 +1,425 @@
+package balancer
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"loadbalancer/internal/config"
+)
+
+const (
+	defaultCacheEntries    = 1000
+	defaultCacheEntryBytes = 1 << 20
+)
+
+// cacheRefreshKey marks a request refreshing a stale cache entry, which
+// must reach a backend rather than be answered from the cache
+type cacheRefreshKey struct{}
+
+// cacheRecorderKey is the context key of the recorder copying a request's
+// response for the cache
+type cacheRecorderKey struct{}
+
+// representationHeaders describe the body as it reaches the client, which
+// compression and response buffering may change from the backend's
+var representationHeaders = []string{"Content-Encoding", "Content-Length", "ETag", "Vary"}
+
+// responseCache holds cacheable GET responses in memory, evicting the least
+// recently used entry once full
+type responseCache struct {
+	maxEntries int
+	maxBytes   int64
+	stale      time.Duration
+	now        func() time.Time
+
+	mu         sync.Mutex
+	entries    map[string]*list.Element // of *cacheEntry
+	lru        *list.List               // most recently used first
+	refreshing map[string]bool
+}
+
+// cacheEntry is a stored response
+type cacheEntry struct {
+	key    string
+	header http.Header
+	body   []byte
+	// stored is when the response was generated, allowing for any Age it
+	// arrived with
+	stored time.Time
+	fresh  time.Duration // how long the response is fresh for
+	stale  time.Duration // how long after that it may be served while refreshed
+}
+
+func newResponseCache(cfg config.Cache) *responseCache {
+	c := &responseCache{
+		maxEntries: cfg.MaxEntries,
+		maxBytes:   cfg.MaxEntryBytes,
+		stale:      cfg.StaleWhileRevalidate,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		refreshing: make(map[string]bool),
+	}
+	if c.maxEntries == 0 {
+		c.maxEntries = defaultCacheEntries
+	}
+	if c.maxBytes == 0 {
+		c.maxBytes = defaultCacheEntryBytes
+	}
+	return c
+}
+
+// cacheKey returns the key r's response is cached under, and false if r
+// must not be answered from or stored in the cache. Responses may differ by
+// Accept-Encoding, so it is part of the key.
+func cacheKey(r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet || r.ContentLength != 0 {
+		return "", false
+	}
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" {
+		return "", false
+	}
+	directives := cacheControl(r.Header)
+	if _, ok := directives["no-store"]; ok {
+		return "", false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return "", false
+	}
+	return r.Host + " " + r.URL.RequestURI() + " " + r.Header.Get("Accept-Encoding"), true
+}
+
+// cacheControl parses the Cache-Control directives in header, lower-casing
+// their names
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// seconds parses a delta-seconds directive argument, reporting whether it
+// is present and valid
+func seconds(directives map[string]string, name string) (time.Duration, bool) {
+	arg, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// entryFor builds the cache entry for a response with the given status and
+// header, or returns nil if the response must not be cached. Only complete
+// 200 responses a backend marks shareable for a while are kept.
+func (c *responseCache) entryFor(key string, status int, header http.Header) *cacheEntry {
+	if status != http.StatusOK {
+		return nil
+	}
+	if header.Get("Set-Cookie") != "" || header.Get("Trailer") != "" || header.Get("Content-Range") != "" {
+		return nil
+	}
+	for _, vary := range header.Values("Vary") {
+		for _, field := range strings.Split(vary, ",") {
+			if !strings.EqualFold(strings.TrimSpace(field), "Accept-Encoding") {
+				return nil
+			}
+		}
+	}
+
+	directives := cacheControl(header)
+	for _, name := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[name]; ok {
+			return nil
+		}
+	}
+	fresh, ok := seconds(directives, "s-maxage")
+	if !ok {
+		fresh, _ = seconds(directives, "max-age")
+	}
+	if fresh <= 0 {
+		return nil
+	}
+	stale, ok := seconds(directives, "stale-while-revalidate")
+	if !ok {
+		stale = c.stale
+	}
+
+	entry := &cacheEntry{key: key, header: header.Clone(), stored: c.now(), fresh: fresh, stale: stale}
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+		entry.stored = entry.stored.Add(-time.Duration(age) * time.Second)
+	}
+	entry.header.Del("Age")
+	return entry
+}
+
+// cacheState is how a looked up entry may be used
+type cacheState int
+
+const (
+	cacheMiss  cacheState = iota
+	cacheFresh            // served as is
+	cacheStale            // served while a refresh is started
+)
+
+// lookup returns the entry for key and how it may be used. Entries past
+// their staleness window are dropped.
+func (c *responseCache) lookup(key string) (*cacheEntry, cacheState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, cacheMiss
+	}
+	entry := elem.Value.(*cacheEntry)
+	age := c.now().Sub(entry.stored)
+	switch {
+	case age < entry.fresh:
+		c.lru.MoveToFront(elem)
+		return entry, cacheFresh
+	case age < entry.fresh+entry.stale:
+		c.lru.MoveToFront(elem)
+		return entry, cacheStale
+	default:
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, cacheMiss
+	}
+}
+
+// store adds or replaces entry, evicting the least recently used entries
+// beyond the limit
+func (c *responseCache) store(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// startRefresh reports whether the caller should refresh key, making sure
+// only one refresh of an entry runs at a time
+func (c *responseCache) startRefresh(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing[key] {
+		return false
+	}
+	c.refreshing[key] = true
+	return true
+}
+
+func (c *responseCache) endRefresh(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.refreshing, key)
+}
+
+// serveCached answers r from the cache if it holds a usable entry for key,
+// starting a background refresh of a stale one. It reports whether r was
+// answered.
+func (lb *LoadBalancer) serveCached(w http.ResponseWriter, r *http.Request, key string) bool {
+	entry, state := lb.cache.lookup(key)
+	switch state {
+	case cacheFresh:
+		lb.metrics.CacheRequests.WithLabelValues("hit").Inc()
+	case cacheStale:
+		lb.metrics.CacheRequests.WithLabelValues("stale").Inc()
+		lb.refreshCached(r, key)
+	default:
+		lb.metrics.CacheRequests.WithLabelValues("miss").Inc()
+		return false
+	}
+
+	header := w.Header()
+	for name, values := range entry.header {
+		header[name] = values
+	}
+	age := lb.cache.now().Sub(entry.stored) / time.Second
+	header.Set("Age", strconv.FormatInt(int64(age), 10))
+	w.WriteHeader(http.StatusOK)
+	w.Write(entry.body)
+	return true
+}
+
+// refreshCached fetches r again in the background so the stale entry
+// under key is replaced, unless a refresh is already running. The refresh
+// goes through the balancer like any request, but outlives the client; it
+// runs alongside the health checks and is cancelled when they stop. It is
+// left out of the request metrics and access log, since no client sent it.
+func (lb *LoadBalancer) refreshCached(r *http.Request, key string) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	if lb.healthCtx != nil && lb.healthCtx.Err() != nil {
+		return
+	}
+	if !lb.cache.startRefresh(key) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.WithoutCancel(r.Context()), cacheRefreshKey{}, true))
+	stop := func() bool { return false }
+	if lb.healthCtx != nil {
+		stop = context.AfterFunc(lb.healthCtx, cancel)
+	}
+	refresh := r.Clone(ctx)
+	refresh.Body = http.NoBody
+
+	lb.healthWG.Add(1)
+	go func() {
+		defer lb.healthWG.Done()
+		defer lb.cache.endRefresh(key)
+		defer stop()
+		defer cancel()
+		defer func() {
+			// A refresh cut short by its backend is simply dropped
+			if p := recover(); p != nil && p != http.ErrAbortHandler {
+				panic(p)
+			}
+		}()
+		lb.ServeHTTP(&discardResponse{header: make(http.Header)}, refresh)
+	}()
+}
+
+// isCacheRefresh reports whether r refreshes a stale cache entry
+func isCacheRefresh(r *http.Request) bool {
+	refresh, _ := r.Context().Value(cacheRefreshKey{}).(bool)
+	return refresh
+}
+
+// cacheRecorder copies a response on its way to the client so it can be
+// stored once complete. Only the backend's own response header is kept, not
+// what the balancer adds to it for this client, such as an affinity cookie.
+type cacheRecorder struct {
+	http.ResponseWriter
+	cache    *responseCache
+	key      string
+	entry    *cacheEntry // nil once the response turns out not to be cacheable
+	recorded bool
+
+	mu        sync.Mutex
+	upstream  http.Header // the backend's response header
+	responses int         // backend responses seen; hedged attempts may both answer
+}
+
+// withCacheRecorder returns r carrying recorder, for the proxy to hand it
+// the backend's response header
+func withCacheRecorder(r *http.Request, recorder *cacheRecorder) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), cacheRecorderKey{}, recorder))
+}
+
+// cacheRecorderFrom returns the recorder carried by ctx, if any
+func cacheRecorderFrom(ctx context.Context) *cacheRecorder {
+	recorder, _ := ctx.Value(cacheRecorderKey{}).(*cacheRecorder)
+	return recorder
+}
+
+// captureUpstream keeps a copy of the header of a backend response about
+// to be relayed
+func (c *cacheRecorder) captureUpstream(header http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.upstream = header.Clone()
+	c.responses++
+}
+
+// upstreamHeader returns the header to record for the response being
+// written: the backend's, with the representation headers as sent. It
+// returns nil when the response is the balancer's own, or when hedged
+// attempts both answered and it is not known whose is being relayed.
+func (c *cacheRecorder) upstreamHeader() http.Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.responses != 1 {
+		return nil
+	}
+	header := c.upstream.Clone()
+	for _, name := range representationHeaders {
+		if values := c.Header().Values(name); len(values) > 0 {
+			header[name] = values
+		} else {
+			header.Del(name)
+		}
+	}
+	return header
+}
+
+func (c *cacheRecorder) WriteHeader(status int) {
+	if status >= 100 && status <= 199 {
+		c.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if !c.recorded {
+		c.recorded = true
+		if header := c.upstreamHeader(); header != nil {
+			c.entry = c.cache.entryFor(c.key, status, header)
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *cacheRecorder) Write(p []byte) (int, error) {
+	if !c.recorded {
+		c.WriteHeader(http.StatusOK)
+	}
+	n, err := c.ResponseWriter.Write(p)
+	if c.entry != nil {
+		if err != nil || int64(len(c.entry.body)+n) > c.cache.maxBytes {
+			c.entry = nil
+		} else {
+			c.entry.body = append(c.entry.body, p[:n]...)
+		}
+	}
+	return n, err
+}
+
+func (c *cacheRecorder) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// finish stores the recorded response if it was cacheable and arrived
+// whole
+func (c *cacheRecorder) finish() {
+	if c.entry == nil {
+		return
+	}
+	if length := c.entry.header.Get("Content-Length"); length != "" && length != strconv.Itoa(len(c.entry.body)) {
+		return
+	}
+	c.cache.store(c.entry)
+}
+
+// discardResponse is the response writer of a background refresh, whose
+// response only goes to the cache
+type discardResponse struct {
+	header http.Header
+}
+
+func (d *discardResponse) Header() http.Header         { return d.header }
+func (d *discardResponse) WriteHeader(int)             {}
+func (d *discardResponse) Write(p []byte) (int, error) { return len(p), nil }
@@ -0,0 +1,323 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var version atomic.Int32
+	version.Store(1)
+	var hits atomic.Int32
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Refreshes are slow, so a stale response served at once cannot
+		// have waited for one
+		if hits.Add(1) > 1 {
+			<-release
+		}
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=30")
+		fmt.Fprintf(w, "v%d", version.Load())
+	}))
+	defer backend.Close()
+
+	m := metrics.New()
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		Cache:    config.Cache{Enabled: true},
+	}, m)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	var clock sync.Mutex
+	now := time.Now()
+	lb.cache.now = func() time.Time {
+		clock.Lock()
+		defer clock.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		clock.Lock()
+		defer clock.Unlock()
+		now = now.Add(d)
+	}
+
+	get := func() *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/page", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+		return rec
+	}
+
+	if body := get().Body.String(); body != "v1" {
+		t.Fatalf("Expected v1, got %q", body)
+	}
+	advance(5 * time.Second)
+	rec := get()
+	if body := rec.Body.String(); body != "v1" || hits.Load() != 1 {
+		t.Errorf("Expected a fresh entry served from the cache, got %q after %d backend requests", body, hits.Load())
+	}
+	if age := rec.Header().Get("Age"); age != "5" {
+		t.Errorf("Expected Age 5, got %q", age)
+	}
+
+	// Once expired, the entry is still served straight away while a refresh
+	// fetches the new version
+	version.Store(2)
+	advance(10 * time.Second)
+	start := time.Now()
+	if body := get().Body.String(); body != "v1" {
+		t.Errorf("Expected the stale entry, got %q", body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the stale entry without waiting for the refresh, took %v", elapsed)
+	}
+	// Only one refresh runs however many stale hits there are
+	get()
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if entry, state := lb.cache.lookup("example.com /page "); state == cacheFresh && string(entry.body) == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the background refresh")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if body := get().Body.String(); body != "v2" {
+		t.Errorf("Expected the refreshed entry, got %q", body)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("Expected a single refresh, got %d backend requests", got)
+	}
+	// The refresh is not a client request
+	if got := testutil.ToFloat64(m.RequestsTotal); got != 1 {
+		t.Errorf("Expected only the client's request counted, got %v", got)
+	}
+
+	// Past the staleness window the request waits for a backend
+	advance(time.Minute)
+	if body := get().Body.String(); body != "v2" || hits.Load() != 3 {
+		t.Errorf("Expected an expired entry to be fetched again, got %q after %d backend requests", body, hits.Load())
+	}
+}
+
+func TestCacheRefreshStopsWithBalancer(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var hits atomic.Int32
+	cancelled := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/page" {
+			return
+		}
+		// The refresh hangs until the balancer gives up on it
+		if hits.Add(1) > 1 {
+			<-r.Context().Done()
+			close(cancelled)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=30")
+		fmt.Fprint(w, "v1")
+	}))
+	defer backend.Close()
+
+	port := freePort(t)
+	lb, err := New(&config.Config{
+		Frontends: []config.Frontend{{Port: port}},
+		Backends:  []config.Backend{{URL: backend.URL}},
+		Cache:     config.Cache{Enabled: true},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	now := time.Now()
+	lb.cache.now = func() time.Time { return now }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() { errChan <- lb.Start(ctx) }()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Frontend did not start: %v", err)
+		}
+	}
+
+	get := func() {
+		lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+	}
+	get()
+	now = now.Add(15 * time.Second)
+	get() // stale, so a refresh starts
+	for deadline := time.Now().Add(2 * time.Second); hits.Load() < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the refresh to start")
+		}
+	}
+
+	// Stopping the balancer cancels the refresh and waits for it
+	cancel()
+	select {
+	case <-errChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for Start to return")
+	}
+	lb.cache.mu.Lock()
+	running := len(lb.cache.refreshing)
+	lb.cache.mu.Unlock()
+	if running != 0 {
+		t.Errorf("Expected the refresh finished before Start returned, %d still running", running)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the backend to see the refresh cancelled")
+	}
+}
+
+func TestCacheRecordsUpstreamHeader(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var hits atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("page ", 10)))
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends:    []config.Backend{{URL: backend.URL}},
+		Cache:       config.Cache{Enabled: true},
+		Sticky:      config.Sticky{Enabled: true},
+		LegacyHTTP:  config.LegacyHTTP{CloseConnections: true},
+		Compression: config.Compression{Enabled: true, MinSize: 1},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	get := func(proto string, encoding string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/page", nil)
+		if proto == "HTTP/1.0" {
+			req.Proto, req.ProtoMinor = proto, 0
+		}
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+		return rec
+	}
+
+	// The affinity cookie and Connection: close are for the first client
+	// only, and do not keep the backend's response from being stored
+	first := get("HTTP/1.0", "")
+	if first.Header().Get("Set-Cookie") == "" || first.Header().Get("Connection") != "close" {
+		t.Fatalf("Expected the first response to carry an affinity cookie and Connection: close, got %v", first.Header())
+	}
+	second := get("HTTP/1.1", "")
+	if hits.Load() != 1 {
+		t.Fatalf("Expected the second request served from the cache, got %d backend requests", hits.Load())
+	}
+	if got := second.Header().Get("Set-Cookie"); got != "" {
+		t.Errorf("Expected no affinity cookie from the cache, got %q", got)
+	}
+	if got := second.Header().Get("Connection"); got != "" {
+		t.Errorf("Expected no Connection header from the cache, got %q", got)
+	}
+
+	// A compressed response is stored with the headers describing its body
+	get("HTTP/1.1", "gzip")
+	compressed := get("HTTP/1.1", "gzip")
+	if hits.Load() != 2 {
+		t.Fatalf("Expected the compressed response served from the cache, got %d backend requests", hits.Load())
+	}
+	if got := compressed.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Expected the cached compressed response to keep Content-Encoding: gzip, got %q", got)
+	}
+	if got := compressed.Header().Get("Set-Cookie"); got != "" {
+		t.Errorf("Expected no affinity cookie from the cache, got %q", got)
+	}
+}
+
+func TestCacheStorable(t *testing.T) {
+	c := newResponseCache(config.Cache{StaleWhileRevalidate: time.Minute})
+	tests := []struct {
+		name      string
+		status    int
+		header    http.Header
+		wantFresh time.Duration
+		wantStale time.Duration
+	}{
+		{"max-age", 200, http.Header{"Cache-Control": {"public, max-age=60"}}, time.Minute, time.Minute},
+		{"s-maxage preferred", 200, http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}}, 10 * time.Second, time.Minute},
+		{"stale directive", 200, http.Header{"Cache-Control": {"max-age=60, stale-while-revalidate=5"}}, time.Minute, 5 * time.Second},
+		{"vary accept-encoding", 200, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Encoding"}}, time.Minute, time.Minute},
+		{"no lifetime", 200, http.Header{}, 0, 0},
+		{"no-store", 200, http.Header{"Cache-Control": {"max-age=60, no-store"}}, 0, 0},
+		{"private", 200, http.Header{"Cache-Control": {"private, max-age=60"}}, 0, 0},
+		{"cookie", 200, http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, 0, 0},
+		{"vary cookie", 200, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Cookie"}}, 0, 0},
+		{"not ok", 404, http.Header{"Cache-Control": {"max-age=60"}}, 0, 0},
+	}
+	for _, tt := range tests {
+		entry := c.entryFor("key", tt.status, tt.header)
+		if tt.wantFresh == 0 {
+			if entry != nil {
+				t.Errorf("%s: Expected the response not to be cached", tt.name)
+			}
+			continue
+		}
+		if entry == nil {
+			t.Errorf("%s: Expected the response to be cached", tt.name)
+			continue
+		}
+		if entry.fresh != tt.wantFresh || entry.stale != tt.wantStale {
+			t.Errorf("%s: Expected fresh %v and stale %v, got %v and %v", tt.name, tt.wantFresh, tt.wantStale, entry.fresh, entry.stale)
+		}
+	}
+
+	for _, header := range []http.Header{{"Authorization": {"Bearer x"}}, {"Cache-Control": {"no-cache"}}, {"Range": {"bytes=0-1"}}} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header = header
+		if _, ok := cacheKey(r); ok {
+			t.Errorf("Expected a request with %v to bypass the cache", header)
+		}
+	}
+}
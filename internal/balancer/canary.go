@@ -0,0 +1,108 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+// defaultCanaryHeaderValue is the header value that forces canary routing
+// when none is configured
+const defaultCanaryHeaderValue = "canary"
+
+// canaryPool holds the canary backends, which receive a fixed share of
+// traffic instead of joining the main rotation
+type canaryPool struct {
+	sidePool
+	share       trafficShare
+	header      string
+	headerValue string
+	trusted     []*net.IPNet
+}
+
+func newCanaryPool(cfg config.Canary) (*canaryPool, error) {
+	if cfg.Percent < 0 || cfg.Percent > 100 {
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("canary percentage must be in [0, 100], got %v", cfg.Percent), nil)
+	}
+
+	pool := &canaryPool{
+		sidePool:    sidePool{backends: cfg.Backends},
+		share:       trafficShare{percent: cfg.Percent},
+		header:      cfg.Header,
+		headerValue: cfg.HeaderValue,
+	}
+	if pool.headerValue == "" {
+		pool.headerValue = defaultCanaryHeaderValue
+	}
+	for _, cidr := range cfg.TrustedSources {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid canary trusted source %q", cidr), err)
+		}
+		pool.trusted = append(pool.trusted, network)
+	}
+	return pool, nil
+}
+
+// forced reports whether r asks to be routed to the canary pool and comes
+// from a trusted source
+func (c *canaryPool) forced(r *http.Request) bool {
+	if c.header == "" || !strings.EqualFold(r.Header.Get(c.header), c.headerValue) {
+		return false
+	}
+	if len(c.trusted) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(clientHost(r))
+	if ip == nil {
+		return false
+	}
+	for _, network := range c.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectBackend picks the backend for r. Requests forced by the canary
+// header always go to the canary pool; otherwise the canary share is taken
+// first, falling back to the main rotation if no canary is available.
+func (lb *LoadBalancer) selectBackend(r *http.Request) *Backend {
+	key := clientHost(r)
+	if pool := lb.poolFor(r, lb.routeFor(r.URL.Path)); pool != nil {
+		return lb.pickSide(&pool.sidePool, key)
+	}
+
+	// Large uploads go to their own pool, falling back to the rest of the
+	// rotation if none of its backends is available
+	if lb.large != nil && lb.large.matches(r) {
+		if backend := lb.pickSide(&lb.large.sidePool, key); backend != nil {
+			return backend
+		}
+	}
+
+	if lb.canary == nil {
+		return lb.nextBackendFor(key)
+	}
+	if lb.canary.forced(r) {
+		return lb.canaryBackend(key)
+	}
+	if lb.canary.share.take() {
+		if backend := lb.canaryBackend(key); backend != nil {
+			return backend
+		}
+	}
+	return lb.nextBackendFor(key)
+}
+
+// canaryBackend returns the next available canary backend for a request
+// with the given client key
+func (lb *LoadBalancer) canaryBackend(key string) *Backend {
+	return lb.pickSide(&lb.canary.sidePool, key)
+}
@@ -0,0 +1,87 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestCanaryHeader(t *testing.T) {
+	var urls []string
+	for _, name := range []string{"stable", "canary"} {
+		name := name
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+
+	newLB := func(canary config.Canary) *LoadBalancer {
+		metrics.Reset() // Reset metrics before test
+		canary.Backends = config.BackendsFromURLs(urls[1:])
+		lb, err := New(&config.Config{Backends: config.BackendsFromURLs(urls[:1]), Canary: canary}, metrics.New())
+		if err != nil {
+			t.Fatalf("Failed to create load balancer: %v", err)
+		}
+		return lb
+	}
+
+	send := func(lb *LoadBalancer, remoteAddr string, header bool) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		if remoteAddr != "" {
+			req.RemoteAddr = remoteAddr
+		}
+		if header {
+			req.Header.Set("X-Route-To", "canary")
+		}
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	// Normal requests follow the percentage split
+	lb := newLB(config.Canary{Percent: 25, Header: "X-Route-To"})
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		counts[send(lb, "", false)]++
+	}
+	if counts["canary"] != 2 || counts["stable"] != 6 {
+		t.Errorf("Expected 2 canary and 6 stable requests, got %v", counts)
+	}
+
+	// The header forces every request to the canary
+	for i := 0; i < 5; i++ {
+		if got := send(lb, "", true); got != "canary" {
+			t.Errorf("Expected header to route request %d to canary, got %s", i, got)
+		}
+	}
+
+	// With trusted sources configured, only those clients may use the header
+	lb = newLB(config.Canary{Header: "X-Route-To", TrustedSources: []string{"10.0.0.0/8"}})
+	if got := send(lb, "192.0.2.1:1234", true); got != "stable" {
+		t.Errorf("Expected header from untrusted source to be ignored, got %s", got)
+	}
+	if got := send(lb, "10.1.2.3:1234", true); got != "canary" {
+		t.Errorf("Expected header from trusted source to route to canary, got %s", got)
+	}
+}
+
+func TestCanaryConfigValidation(t *testing.T) {
+	for _, canary := range []config.Canary{
+		{Backends: []config.Backend{{URL: "http://localhost:8002"}}, Percent: 150},
+		{Backends: []config.Backend{{URL: "http://localhost:8002"}}, TrustedSources: []string{"not-a-cidr"}},
+	} {
+		metrics.Reset()
+		_, err := New(&config.Config{
+			Backends: []config.Backend{{URL: "http://localhost:8001"}},
+			Canary:   canary,
+		}, metrics.New())
+		if err == nil {
+			t.Errorf("Expected error for canary config %+v", canary)
+		}
+	}
+}
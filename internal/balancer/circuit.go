@@ -0,0 +1,97 @@
+package balancer
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"loadbalancer/internal/circuitbreaker"
+	"loadbalancer/internal/config"
+)
+
+// onCircuitStateChange logs a backend's circuit breaker transition and
+// exports its new state, counting every trip to open
+func (lb *LoadBalancer) onCircuitStateChange(b *Backend, from, to circuitbreaker.State) {
+	level := slog.LevelInfo
+	if to == circuitbreaker.StateOpen {
+		level = slog.LevelWarn
+		lb.metrics.CircuitBreakerTrips.Inc()
+	}
+	lb.logger.Log(context.Background(), level, "circuit breaker state changed",
+		"backend", b.URL.String(), "from", from.String(), "to", to.String())
+	lb.reportCircuit(b, to)
+}
+
+// defaultHalfOpenSuccesses is how many trial requests must succeed before a
+// backend's circuit closes when none is configured
+const defaultHalfOpenSuccesses = 2
+
+// halfOpenSuccesses returns the trial successes that close a circuit
+func halfOpenSuccesses(cfg config.CircuitBreaker) int {
+	if cfg.HalfOpenSuccesses <= 0 {
+		return defaultHalfOpenSuccesses
+	}
+	return cfg.HalfOpenSuccesses
+}
+
+// onHalfOpenResult counts the outcome of a trial request to b while its
+// circuit is half-open
+func (lb *LoadBalancer) onHalfOpenResult(b *Backend, success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	lb.metrics.CircuitHalfOpenProbes.WithLabelValues(b.URL.String(), result).Inc()
+}
+
+// reportCircuit exports state as b's circuit state gauge: 0 closed,
+// 1 half-open, 2 open
+func (lb *LoadBalancer) reportCircuit(b *Backend, state circuitbreaker.State) {
+	lb.metrics.CircuitBreakerState.WithLabelValues(b.URL.String()).Set(float64(state))
+}
+
+// writeCircuitOpen replies to a request that found every backend's circuit
+// open, with the configured status and headers. Retry-After, unless
+// configured, is when the first circuit will admit a trial request.
+func (lb *LoadBalancer) writeCircuitOpen(w http.ResponseWriter) {
+	status := http.StatusServiceUnavailable
+	var headers map[string]string
+	if lb.config != nil {
+		if lb.config.CircuitBreaker.OpenStatus != 0 {
+			status = lb.config.CircuitBreaker.OpenStatus
+		}
+		headers = lb.config.CircuitBreaker.OpenHeaders
+	}
+
+	for name, value := range headers {
+		w.Header().Set(name, value)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		// Retry-After takes whole seconds; round up so clients never come
+		// back early
+		wait := lb.circuitRetryAfter()
+		seconds := int((wait + time.Second - 1) / time.Second)
+		if seconds < 1 {
+			seconds = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	http.Error(w, "No available backends", status)
+}
+
+// circuitRetryAfter returns how long until the first open circuit admits a
+// trial request
+func (lb *LoadBalancer) circuitRetryAfter() time.Duration {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	var soonest time.Duration
+	for i, b := range lb.backends {
+		if wait := b.CircuitBreaker.RetryAfter(); i == 0 || wait < soonest {
+			soonest = wait
+		}
+	}
+	return soonest
+}
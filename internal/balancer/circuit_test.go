@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	"bytes"
+	stderrors "errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestCircuitStateReported(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	m := metrics.New()
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: "http://localhost:8001"}},
+	}, m)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	var buf bytes.Buffer
+	lb.logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	b := lb.backends[0]
+	gauge := m.CircuitBreakerState.WithLabelValues(b.URL.String())
+	if got := testutil.ToFloat64(gauge); got != 0 {
+		t.Errorf("Expected closed circuit state 0, got %f", got)
+	}
+
+	for i := 0; i < 5; i++ {
+		b.CircuitBreaker.RecordResult(stderrors.New("backend failure"))
+	}
+	if got := testutil.ToFloat64(gauge); got != 2 {
+		t.Errorf("Expected open circuit state 2, got %f", got)
+	}
+	if got := testutil.ToFloat64(m.CircuitBreakerTrips); got != 1 {
+		t.Errorf("Expected 1 circuit breaker trip, got %f", got)
+	}
+	if !strings.Contains(buf.String(), "from=closed to=open") || !strings.Contains(buf.String(), "backend=http://localhost:8001") {
+		t.Errorf("Expected the transition to be logged, got %q", buf.String())
+	}
+
+	b.CircuitBreaker.Reset()
+	if got := testutil.ToFloat64(gauge); got != 0 {
+		t.Errorf("Expected closed circuit state 0 after reset, got %f", got)
+	}
+
+	// Removed backends stop being exported
+	lb.removeBackend(b.ID)
+	if got := testutil.CollectAndCount(m.CircuitBreakerState); got != 0 {
+		t.Errorf("Expected no circuit state series after removal, got %d", got)
+	}
+}
+
+func TestHalfOpenSuccesses(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	m := metrics.New()
+	lb, err := New(&config.Config{
+		Backends:       []config.Backend{{URL: "http://localhost:8001"}},
+		CircuitBreaker: config.CircuitBreaker{HalfOpenSuccesses: 4, HalfOpenFailureThreshold: 2},
+	}, m)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	circuit := lb.backendStatuses()[0].Circuit
+	if circuit.State != "closed" || circuit.SuccessesNeeded != 4 || circuit.FailuresAllowed != 2 {
+		t.Errorf("Unexpected circuit status: %+v", circuit)
+	}
+
+	// Probe outcomes are counted per backend
+	lb.onHalfOpenResult(lb.backends[0], true)
+	lb.onHalfOpenResult(lb.backends[0], false)
+	if got := testutil.ToFloat64(m.CircuitHalfOpenProbes.WithLabelValues("http://localhost:8001", "success")); got != 1 {
+		t.Errorf("Expected 1 successful probe, got %v", got)
+	}
+
+	// Reload applies the default to running breakers
+	if err := lb.Reload(&config.Config{Backends: []config.Backend{{URL: "http://localhost:8001"}}}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if circuit := lb.backendStatuses()[0].Circuit; circuit.SuccessesNeeded != defaultHalfOpenSuccesses {
+		t.Errorf("Expected %d successes needed after reload, got %d", defaultHalfOpenSuccesses, circuit.SuccessesNeeded)
+	}
+}
@@ -0,0 +1,96 @@
+package balancer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+// closeDelimitedBackend answers every request with a body that has no
+// Content-Length and is delimited by closing the connection
+func closeDelimitedBackend(t *testing.T, body string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n")
+				// Write the body in pieces so it spans several reads
+				for i := 0; i < len(body); i += 1000 {
+					end := i + 1000
+					if end > len(body) {
+						end = len(body)
+					}
+					io.WriteString(conn, body[i:end])
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestCloseDelimitedBackendResponse(t *testing.T) {
+	body := strings.Repeat("0123456789", 5000)
+	ln := closeDelimitedBackend(t, body)
+	defer ln.Close()
+
+	for _, buffering := range []string{"", bufferingStream, bufferingBuffer} {
+		metrics.Reset() // Reset metrics before test
+		lb, err := New(&config.Config{
+			Backends: []config.Backend{{URL: "http://" + ln.Addr().String()}},
+			Routes:   []config.Route{{Path: "/", Buffering: buffering}},
+		}, metrics.New())
+		if err != nil {
+			t.Fatalf("Failed to create load balancer: %v", err)
+		}
+		frontend := httptest.NewServer(lb)
+
+		// Two requests on one keep-alive connection: the first response
+		// must be delimited for the client without closing the connection
+		var reused []bool
+		ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) { reused = append(reused, info.Reused) },
+		})
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequestWithContext(ctx, "GET", frontend.URL, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("mode %q: request failed: %v", buffering, err)
+			}
+			got, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil || string(got) != body {
+				t.Errorf("mode %q: expected the complete %d byte body, got %d bytes (%v)", buffering, len(body), len(got), err)
+			}
+			if resp.Close {
+				t.Errorf("mode %q: expected the client connection to stay open", buffering)
+			}
+			if buffering == bufferingBuffer && resp.ContentLength != int64(len(body)) {
+				t.Errorf("mode %q: expected Content-Length %d, got %d", buffering, len(body), resp.ContentLength)
+			}
+		}
+		if len(reused) != 2 || !reused[1] {
+			t.Errorf("mode %q: expected the second request to reuse the connection, got %v", buffering, reused)
+		}
+		frontend.Close()
+	}
+}
@@ -0,0 +1,204 @@
+package balancer
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"loadbalancer/internal/config"
+)
+
+// defaultCompressionMinSize is the smallest Content-Length compressed when
+// none is configured
+const defaultCompressionMinSize = 1024
+
+// defaultCompressibleTypes are the media types compressed when none are
+// configured
+var defaultCompressibleTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// compressor gzips responses that backends sent uncompressed
+type compressor struct {
+	minSize int64
+	types   []string
+	writers sync.Pool // *gzip.Writer
+}
+
+func newCompressor(cfg config.Compression) *compressor {
+	c := &compressor{minSize: cfg.MinSize}
+	if c.minSize == 0 {
+		c.minSize = defaultCompressionMinSize
+	}
+	types := cfg.ContentTypes
+	if len(types) == 0 {
+		types = defaultCompressibleTypes
+	}
+	for _, t := range types {
+		c.types = append(c.types, strings.ToLower(t))
+	}
+	return c
+}
+
+// wrap returns w wrapped to gzip the response to r if r accepts gzip and
+// the response turns out to be worth compressing. Callers must close the
+// wrapper once the response is complete.
+func (c *compressor) wrap(w http.ResponseWriter, r *http.Request) *gzipResponse {
+	return &gzipResponse{
+		ResponseWriter: w,
+		c:              c,
+		accepted:       r.Method != http.MethodHead && acceptsGzip(r),
+	}
+}
+
+// compressible reports whether a response with the given status and header
+// is one the compressor handles, whatever the client accepts
+func (c *compressor) compressible(status int, header http.Header) bool {
+	switch status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	if strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+
+	mediaType, _, _ := strings.Cut(header.Get("Content-Type"), ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, t := range c.types {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether r's Accept-Encoding allows a gzip response
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.TrimSpace(coding)
+			if !strings.EqualFold(coding, "gzip") && coding != "*" {
+				continue
+			}
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+			return q > 0
+		}
+	}
+	return false
+}
+
+// gzipResponse compresses a response on its way to the client once its
+// header shows it is worth it
+type gzipResponse struct {
+	http.ResponseWriter
+	c           *compressor
+	accepted    bool
+	wroteHeader bool
+	gz          *gzip.Writer // set once compression has started
+}
+
+// WriteHeader decides whether to compress the response. Informational
+// responses, including protocol switches, pass straight through.
+func (g *gzipResponse) WriteHeader(status int) {
+	if status >= 100 && status <= 199 {
+		g.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	header := g.Header()
+	if g.c.compressible(status, header) {
+		header.Add("Vary", "Accept-Encoding")
+		if g.accepted && g.largeEnough(header) {
+			g.start(header)
+		}
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+// largeEnough reports whether the declared body length, if any, reaches
+// the compression threshold
+func (g *gzipResponse) largeEnough(header http.Header) bool {
+	length := header.Get("Content-Length")
+	if length == "" {
+		return true
+	}
+	n, err := strconv.ParseInt(length, 10, 64)
+	return err == nil && n >= g.c.minSize
+}
+
+// start switches the response to gzip. The compressed length is not known
+// up front, and a strong ETag no longer matches the bytes sent.
+func (g *gzipResponse) start(header http.Header) {
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", "gzip")
+	if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		header.Set("ETag", "W/"+etag)
+	}
+
+	if gz, ok := g.c.writers.Get().(*gzip.Writer); ok {
+		gz.Reset(g.ResponseWriter)
+		g.gz = gz
+	} else {
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+}
+
+func (g *gzipResponse) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// Flush sends everything compressed so far, so streamed responses keep
+// streaming
+func (g *gzipResponse) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+func (g *gzipResponse) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// close ends the gzip stream, if one was started, and returns its writer to
+// the pool
+func (g *gzipResponse) close() {
+	if g.gz == nil {
+		return
+	}
+	g.gz.Close()
+	g.c.writers.Put(g.gz)
+	g.gz = nil
+}
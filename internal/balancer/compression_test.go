@@ -0,0 +1,126 @@
+package balancer
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestCompression(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	large := strings.Repeat(`{"message":"hello"}`, 200)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := large
+		switch r.URL.Path {
+		case "/small":
+			body = `{"ok":true}`
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "br")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		case "/etag":
+			w.Header().Set("ETag", `"v1"`)
+		}
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		}
+		if r.URL.Path == "/chunked" {
+			// Unknown length: the proxy streams it to the client
+			w.Write([]byte(body[:100]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(body[100:]))
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write([]byte(body))
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends:    []config.Backend{{URL: backend.URL}},
+		Compression: config.Compression{Enabled: true, MinSize: 1000},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"/", "gzip, deflate", true},
+		{"/chunked", "gzip", true},
+		{"/", "br;q=1.0, gzip;q=0.8", true},
+		{"/", "", false},
+		{"/", "gzip;q=0", false},
+		{"/small", "gzip", false},
+		{"/encoded", "gzip", false},
+		{"/image", "gzip", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+		resp := w.Result()
+
+		gzipped := resp.Header.Get("Content-Encoding") == "gzip"
+		if gzipped != tt.wantGzip {
+			t.Errorf("%s with Accept-Encoding %q: expected gzip %v, got Content-Encoding %q",
+				tt.path, tt.acceptEncoding, tt.wantGzip, resp.Header.Get("Content-Encoding"))
+			continue
+		}
+		if !gzipped {
+			continue
+		}
+
+		if length := resp.Header.Get("Content-Length"); length != "" {
+			t.Errorf("%s: expected no Content-Length on a compressed response, got %s", tt.path, length)
+		}
+		if vary := resp.Header.Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("%s: expected Vary: Accept-Encoding, got %q", tt.path, vary)
+		}
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			t.Fatalf("%s: invalid gzip stream: %v", tt.path, err)
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("%s: failed to decompress response: %v", tt.path, err)
+		}
+		if string(body) != large {
+			t.Errorf("%s: decompressed body does not match the backend's", tt.path)
+		}
+		if w.Body.Len() >= len(large) {
+			t.Errorf("%s: expected a smaller body when compressed, got %d bytes", tt.path, w.Body.Len())
+		}
+	}
+
+	// Strong validators no longer match the compressed bytes
+	req := httptest.NewRequest("GET", "/etag", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, req)
+	if got := w.Header().Get("ETag"); got != `W/"v1"` {
+		t.Errorf(`Expected ETag W/"v1" on a compressed response, got %q`, got)
+	}
+
+	// Uncompressed bodies keep their length
+	req = httptest.NewRequest("GET", "/", nil)
+	w = httptest.NewRecorder()
+	lb.ServeHTTP(w, req)
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(large)) {
+		t.Errorf("Expected Content-Length %d without compression, got %q", len(large), got)
+	}
+}
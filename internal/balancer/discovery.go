@@ -0,0 +1,60 @@
+package balancer
+
+import (
+	"context"
+
+	"loadbalancer/internal/config"
+)
+
+// runDiscovery keeps the main pool in step with the discovery provider
+// until ctx is cancelled
+func (lb *LoadBalancer) runDiscovery(ctx context.Context) {
+	lb.discovery.Watch(ctx, lb.syncDiscovered)
+}
+
+// syncDiscovered reconciles the main pool with the discovered backend URLs,
+// adding new backends and removing those no longer listed. Backends in both
+// keep their health, circuit and connection state. An empty list leaves
+// the pool as it is, so a registry outage or a misregistered service does
+// not take every backend out of rotation.
+func (lb *LoadBalancer) syncDiscovered(urls []string) {
+	if len(urls) == 0 {
+		lb.logger.Warn("service discovery found no healthy instances, keeping the current backends")
+		return
+	}
+
+	wanted := make(map[string]string, len(urls))
+	for _, rawURL := range urls {
+		wanted[backendID(rawURL)] = rawURL
+	}
+	lb.mu.RLock()
+	current := make(map[string]bool, len(lb.pool))
+	for _, entry := range lb.pool {
+		current[backendID(entry.URL)] = true
+	}
+	lb.mu.RUnlock()
+
+	var added, removed int
+	for id := range current {
+		if _, kept := wanted[id]; !kept {
+			if err := lb.RemoveBackend(id); err != nil {
+				lb.logger.Warn("failed to remove undiscovered backend", "backend", id, "error", err)
+				continue
+			}
+			removed++
+		}
+	}
+	for id, rawURL := range wanted {
+		if current[id] {
+			continue
+		}
+		if err := lb.AddBackend(config.Backend{URL: rawURL}); err != nil {
+			lb.logger.Warn("failed to add discovered backend", "backend", rawURL, "error", err)
+			continue
+		}
+		added++
+	}
+	if added > 0 || removed > 0 {
+		lb.logger.Info("discovered backends changed", "added", added, "removed", removed, "backends", len(urls))
+	}
+}
@@ -0,0 +1,114 @@
+package balancer
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+// fakeProvider reports each list sent on updates, then waits for the
+// context to end
+type fakeProvider struct {
+	updates chan []string
+}
+
+func (p *fakeProvider) Watch(ctx context.Context, update func(urls []string)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case urls := <-p.updates:
+			update(urls)
+		}
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	lb, err := New(&config.Config{
+		Backends:  []config.Backend{{URL: "http://localhost:8009"}},
+		Discovery: &config.Discovery{Type: "consul", Address: "http://127.0.0.1:8500", Service: "web"},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	backends := func() []string {
+		t.Helper()
+		lb.mu.RLock()
+		defer lb.mu.RUnlock()
+		var ids []string
+		for _, entry := range lb.pool {
+			ids = append(ids, entry.URL)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	// The static list is ignored; the pool waits for the registry
+	if got := backends(); len(got) != 0 {
+		t.Fatalf("Expected an empty pool before discovery, got %v", got)
+	}
+
+	lb.syncDiscovered([]string{"http://localhost:8001", "http://localhost:8002"})
+	if got, want := backends(), []string{"http://localhost:8001", "http://localhost:8002"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	kept := lb.byID["http://localhost:8002"]
+
+	lb.syncDiscovered([]string{"http://localhost:8002", "http://localhost:8003"})
+	if got, want := backends(), []string{"http://localhost:8002", "http://localhost:8003"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if lb.byID["http://localhost:8002"] != kept {
+		t.Error("Expected a backend still discovered to keep running")
+	}
+
+	// An empty list keeps the current backends
+	lb.syncDiscovered(nil)
+	if got := backends(); len(got) != 2 {
+		t.Errorf("Expected the pool kept when nothing is discovered, got %v", got)
+	}
+
+	// Reload applies other settings but leaves the discovered pool alone
+	if err := lb.Reload(&config.Config{Backends: []config.Backend{{URL: "http://localhost:8009"}}}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if got, want := backends(), []string{"http://localhost:8002", "http://localhost:8003"}; !slices.Equal(got, want) {
+		t.Errorf("Expected reload to keep the discovered pool %v, got %v", want, got)
+	}
+}
+
+func TestRunDiscovery(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	lb, err := New(&config.Config{
+		Discovery: &config.Discovery{Type: "consul", Address: "http://127.0.0.1:8500", Service: "web"},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	provider := &fakeProvider{updates: make(chan []string)}
+	lb.discovery = provider
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		lb.runDiscovery(ctx)
+		close(done)
+	}()
+	provider.updates <- []string{"http://localhost:8001"}
+	// A second send returns only once the first update has been applied
+	provider.updates <- []string{"http://localhost:8001"}
+	cancel()
+	<-done
+
+	if b := lb.nextBackend(); b == nil || b.ID != "http://localhost:8001" {
+		t.Errorf("Expected the discovered backend to take traffic, got %v", b)
+	}
+}
@@ -0,0 +1,173 @@
+package balancer
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hostResolver looks up the addresses of a host. *net.Resolver satisfies it.
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// dnsCache resolves backend hostnames for the transport's dialer and
+// periodically re-resolves them. Without it the transport resolves a name
+// once per connection and keep-alive connections stay pinned to the old IP
+// after a backend moves.
+type dnsCache struct {
+	resolver hostResolver
+	dialer   *net.Dialer
+	interval time.Duration
+	logger   *slog.Logger
+	// onChange is called after a refresh finds new addresses for host
+	onChange func(host string)
+
+	mu      sync.Mutex
+	entries map[string][]string
+}
+
+func newDNSCache(interval time.Duration, dialer *net.Dialer, logger *slog.Logger, onChange func(host string)) *dnsCache {
+	return &dnsCache{
+		resolver: net.DefaultResolver,
+		dialer:   dialer,
+		interval: interval,
+		logger:   logger,
+		onChange: onChange,
+		entries:  make(map[string][]string),
+	}
+}
+
+// dialContext dials addr using the cached addresses for its host. IP
+// literals are dialled directly.
+func (d *dnsCache) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, ip := range addrs {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// lookup returns the cached addresses for host, resolving it on first use
+func (d *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	d.mu.Lock()
+	addrs, ok := d.entries[host]
+	d.mu.Unlock()
+	if ok {
+		return addrs, nil
+	}
+
+	addrs, err := d.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.entries[host] = addrs
+	d.mu.Unlock()
+	return addrs, nil
+}
+
+func (d *dnsCache) resolve(ctx context.Context, host string) ([]string, error) {
+	addrs, err := d.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// refresh re-resolves every cached host. A failed lookup keeps the previous
+// addresses so a DNS outage does not take backends down.
+func (d *dnsCache) refresh(ctx context.Context) {
+	d.mu.Lock()
+	hosts := make([]string, 0, len(d.entries))
+	for host := range d.entries {
+		hosts = append(hosts, host)
+	}
+	d.mu.Unlock()
+
+	for _, host := range hosts {
+		addrs, err := d.resolve(ctx, host)
+		if err != nil {
+			d.logger.Warn("failed to re-resolve backend host", "host", host, "error", err)
+			continue
+		}
+
+		d.mu.Lock()
+		changed := !equalAddrs(d.entries[host], addrs)
+		d.entries[host] = addrs
+		d.mu.Unlock()
+
+		if changed {
+			d.logger.Info("backend host resolves to new addresses", "host", host, "addrs", addrs)
+			if d.onChange != nil {
+				d.onChange(host)
+			}
+		}
+	}
+}
+
+// run refreshes the cache every interval until ctx is cancelled
+func (d *dnsCache) run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.refresh(ctx)
+		}
+	}
+}
+
+func equalAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// closeIdleConnections drops pooled connections to backends on host so the
+// next request dials a freshly resolved address
+func (lb *LoadBalancer) closeIdleConnections(host string) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	for _, b := range lb.backends {
+		if b.URL.Hostname() != host {
+			continue
+		}
+		if transport, ok := b.Proxy.Transport.(*http.Transport); ok {
+			transport.CloseIdleConnections()
+		}
+	}
+	lb.healthClient.CloseIdleConnections()
+}
@@ -0,0 +1,99 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+// fakeResolver answers every lookup with a fixed address that tests can change
+type fakeResolver struct {
+	mu   sync.Mutex
+	addr string
+}
+
+func (f *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return []string{f.addr}, nil
+}
+
+func (f *fakeResolver) set(addr string) {
+	f.mu.Lock()
+	f.addr = addr
+	f.mu.Unlock()
+}
+
+func TestDNSReresolution(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	// Two servers on the same port but different loopback addresses stand in
+	// for one backend whose IP changes
+	first, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := first.Addr().(*net.TCPAddr).Port
+	second, err := net.Listen("tcp", fmt.Sprintf("127.0.0.2:%d", port))
+	if err != nil {
+		first.Close()
+		t.Skipf("127.0.0.2 not available: %v", err)
+	}
+
+	for listener, name := range map[net.Listener]string{first: "old", second: "new"} {
+		name := name
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		server.Listener.Close()
+		server.Listener = listener
+		server.Start()
+		defer server.Close()
+	}
+
+	lb, err := New(&config.Config{
+		Backends:  []config.Backend{{URL: fmt.Sprintf("http://backend.test:%d", port)}},
+		Transport: config.Transport{DNSRefreshInterval: time.Minute},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	resolver := &fakeResolver{addr: "127.0.0.1"}
+	lb.dns.resolver = resolver
+
+	send := func() string {
+		frontend := httptest.NewServer(lb)
+		defer frontend.Close()
+		resp, err := http.Get(frontend.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if got := send(); got != "old" {
+		t.Fatalf("Expected request on the original address, got %q", got)
+	}
+
+	// Until the next refresh the pooled connection and cached address are used
+	resolver.set("127.0.0.2")
+	if got := send(); got != "old" {
+		t.Errorf("Expected cached address before refresh, got %q", got)
+	}
+
+	lb.dns.refresh(context.Background())
+	if got := send(); got != "new" {
+		t.Errorf("Expected request on the new address after refresh, got %q", got)
+	}
+}
@@ -75,9 +75,9 @@ Usage Example:
 
 	cfg := &config.Config{
 	    Frontends: []config.Frontend{{Port: 8080}},
-	    Backends: []string{
-	        "http://backend1:9001",
-	        "http://backend2:9002",
+	    Backends: []config.Backend{
+	        {URL: "http://backend1:9001"},
+	        {URL: "http://backend2:9002", Weight: 2, HealthPath: "/healthz"},
 	    },
 	}
 
@@ -0,0 +1,119 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultDrainTimeout bounds the wait for a removed backend's requests
+// when no drain timeout is configured
+const defaultDrainTimeout = 30 * time.Second
+
+// defaultShutdownTimeout bounds the wait for in-flight requests on shutdown
+// when no shutdown timeout is configured
+const defaultShutdownTimeout = 5 * time.Second
+
+// drainPollInterval is how often a draining backend's active requests are
+// checked
+const drainPollInterval = 10 * time.Millisecond
+
+// drainTimeout returns how long a removed backend's in-flight requests are
+// waited for
+func (lb *LoadBalancer) drainTimeout() time.Duration {
+	if lb.config == nil || lb.config.DrainTimeout <= 0 {
+		return defaultDrainTimeout
+	}
+	return lb.config.DrainTimeout
+}
+
+// startDrain drains b in the background alongside the health checks, so
+// Start waits for it before returning. Callers must hold lb.mu.
+func (lb *LoadBalancer) startDrain(b *Backend) {
+	ctx := lb.healthCtx
+	if ctx == nil {
+		ctx = context.Background()
+	} else if ctx.Err() != nil {
+		// Shutting down: nothing is left to wait for
+		lb.drain(ctx, b)
+		return
+	}
+
+	lb.healthWG.Add(1)
+	go func() {
+		defer lb.healthWG.Done()
+		lb.drain(ctx, b)
+	}()
+}
+
+// drain waits for the requests in flight on b, which no longer receives
+// new ones, to finish, then closes its idle connections to the backend.
+// Requests still running after the drain timeout, or once ctx is
+// cancelled, are left to complete on their own connections.
+func (lb *LoadBalancer) drain(ctx context.Context, b *Backend) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(lb.drainTimeout())
+	defer timeout.Stop()
+wait:
+	for b.ActiveConns.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-timeout.C:
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	if active := b.ActiveConns.Load(); active > 0 {
+		lb.logger.Warn("backend drain timed out", "backend", b.URL.String(), "active", active)
+	} else {
+		lb.logger.Debug("backend drained", "backend", b.URL.String())
+	}
+	if transport, ok := b.Proxy.Transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+}
+
+// shutdownTimeout returns how long shutdown waits for in-flight requests
+func (lb *LoadBalancer) shutdownTimeout() time.Duration {
+	if lb.config == nil || lb.config.ShutdownTimeout <= 0 {
+		return defaultShutdownTimeout
+	}
+	return lb.config.ShutdownTimeout
+}
+
+// shutdownWhenDone shuts server down once ctx is cancelled, waiting up to
+// the shutdown timeout for in-flight requests before closing the
+// connections still open. Wait on the result returns when it has finished.
+func (lb *LoadBalancer) shutdownWhenDone(ctx context.Context, name string, server *http.Server) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), lb.shutdownTimeout())
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			lb.logger.Warn("shutdown deadline reached, closing open connections",
+				"server", name, "addr", server.Addr, "active_backend_requests", lb.activeRequests(), "error", err)
+			server.Close()
+		}
+	}()
+	return &wg
+}
+
+// activeRequests returns the number of requests in flight to backends
+func (lb *LoadBalancer) activeRequests() int64 {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	var active int64
+	for _, b := range lb.backends {
+		active += b.ActiveConns.Load()
+	}
+	return active
+}
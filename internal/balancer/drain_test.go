@@ -0,0 +1,241 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestRemoveBackendDrains(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	release := make(chan struct{})
+	closed := make(chan struct{}, 1)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("finished"))
+	}))
+	backend.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			select {
+			case closed <- struct{}{}:
+			default:
+			}
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends:     []config.Backend{{URL: backend.URL}},
+		DrainTimeout: 5 * time.Second,
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	b := lb.backends[0]
+
+	// Start a slow request and remove its backend while it is in flight
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		done <- w
+	}()
+	for deadline := time.Now().Add(2 * time.Second); b.ActiveConns.Load() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected request to reach the backend")
+		}
+	}
+	if err := lb.RemoveBackend(b.ID); err != nil {
+		t.Fatalf("RemoveBackend failed: %v", err)
+	}
+
+	// New requests no longer go to the draining backend
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected no backend for new requests, got %d", w.Code)
+	}
+
+	// The in-flight request completes
+	close(release)
+	select {
+	case w := <-done:
+		if w.Code != http.StatusOK || w.Body.String() != "finished" {
+			t.Errorf("Expected in-flight request to complete, got %d %q", w.Code, w.Body.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected in-flight request to complete")
+	}
+
+	// Once drained, the connection to the backend is closed
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the drained backend's connection to be closed")
+	}
+}
+
+// freePort returns a TCP port that was free a moment ago
+func freePort(t testing.TB) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestDrainTrackedUntilStopped(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	lb, err := New(&config.Config{
+		Backends:     []config.Backend{{URL: "http://127.0.0.1:1"}},
+		DrainTimeout: time.Minute,
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	b := lb.backends[0]
+	b.ActiveConns.Store(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lb.mu.Lock()
+	lb.healthCtx = ctx
+	lb.startDrain(b)
+	lb.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		lb.healthWG.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		t.Fatal("Expected the drain to wait for the active request")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Stopping the balancer ends the drain without waiting out its timeout
+	cancel()
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the drain to stop with the balancer")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	release := make(chan struct{})
+	defer close(release)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	port := freePort(t)
+	lb, err := New(&config.Config{
+		Frontends:       []config.Frontend{{Port: port}},
+		Backends:        []config.Backend{{URL: backend.URL}},
+		ShutdownTimeout: 100 * time.Millisecond,
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() { errChan <- lb.Start(ctx) }()
+
+	// Send a request that never finishes on its own once the frontend is up
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Frontend did not start: %v", err)
+		}
+	}
+	go func() {
+		if resp, err := http.Get("http://" + addr + "/"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	for deadline := time.Now().Add(2 * time.Second); lb.activeRequests() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected request to reach the backend")
+		}
+	}
+
+	// Shutdown gives up on it after the timeout instead of hanging
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("Expected no error on shutdown, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected shutdown to give up on the in-flight request")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected shutdown to wait for the in-flight request first, took %v", elapsed)
+	}
+}
+
+func TestStartStopsEverythingOnError(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	holder, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer holder.Close()
+	busy := holder.Addr().(*net.TCPAddr).Port
+	free := freePort(t)
+
+	lb, err := New(&config.Config{
+		Frontends: []config.Frontend{{Port: free}, {Port: busy}},
+		Backends:  []config.Backend{{URL: "http://localhost:8001"}},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- lb.Start(context.Background()) }()
+	select {
+	case err := <-errChan:
+		if err == nil {
+			t.Fatal("Expected Start to fail on the busy port")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Start to return when a frontend fails")
+	}
+
+	// The frontend that did start has been shut down with it
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(free))
+	if err != nil {
+		t.Fatalf("Expected the other frontend's port to be released, got %v", err)
+	}
+	ln.Close()
+}
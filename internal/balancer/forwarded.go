@@ -0,0 +1,94 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+// Headers describing the client certificate of a mutual TLS connection
+const (
+	clientCertCNHeader          = "X-Client-Cert-CN"
+	clientCertFingerprintHeader = "X-Client-Cert-Fingerprint"
+)
+
+// X-Forwarded-For handling modes
+const (
+	forwardedForAppend    = "append"
+	forwardedForOverwrite = "overwrite"
+)
+
+// validateForwardedFor checks the configured X-Forwarded-For mode
+func validateForwardedFor(mode string) error {
+	switch mode {
+	case "", forwardedForAppend, forwardedForOverwrite:
+		return nil
+	default:
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown X-Forwarded-For mode %q", mode), nil)
+	}
+}
+
+// forwardingDirector wraps a proxy director to tell the backend who the
+// client is and how it connected. The proxy itself appends the client
+// address to X-Forwarded-For after the director runs, so overwriting only
+// needs to drop the incoming value.
+func (lb *LoadBalancer) forwardingDirector(director func(*http.Request)) func(*http.Request) {
+	overwrite := lb.config != nil && lb.config.ForwardedHeaders.XForwardedFor == forwardedForOverwrite
+	clientCert := lb.config != nil && lb.config.ForwardedHeaders.ClientCert
+	var mappings []config.HeaderMapping
+	if lb.config != nil {
+		mappings = lb.config.ForwardedHeaders.Mappings
+	}
+	proto := "http"
+	if lb.ssl != nil {
+		proto = "https"
+	}
+
+	return func(req *http.Request) {
+		director(req)
+		if overwrite {
+			req.Header.Del("X-Forwarded-For")
+		}
+		req.Header.Set("X-Real-IP", clientHost(req))
+		req.Header.Set("X-Forwarded-Proto", proto)
+		if clientCert {
+			setClientCertHeaders(req)
+		}
+		if len(mappings) > 0 {
+			mapHeaders(req, mappings)
+		}
+	}
+}
+
+// setClientCertHeaders replaces any client-supplied certificate headers on
+// req with the details of the certificate the client was verified with
+func setClientCertHeaders(req *http.Request) {
+	req.Header.Del(clientCertCNHeader)
+	req.Header.Del(clientCertFingerprintHeader)
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return
+	}
+
+	leaf := req.TLS.VerifiedChains[0][0]
+	fingerprint := sha256.Sum256(leaf.Raw)
+	req.Header.Set(clientCertCNHeader, leaf.Subject.CommonName)
+	req.Header.Set(clientCertFingerprintHeader, hex.EncodeToString(fingerprint[:]))
+}
+
+// hostDirector wraps a proxy director to set the Host header sent to target:
+// the client's, or the backend's own host when RewriteHost is set
+func (lb *LoadBalancer) hostDirector(director func(*http.Request), target *url.URL) func(*http.Request) {
+	if lb.config == nil || !lb.config.RewriteHost {
+		return director
+	}
+
+	return func(req *http.Request) {
+		director(req)
+		req.Host = target.Host
+	}
+}
@@ -0,0 +1,187 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+	"loadbalancer/internal/ssl"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		mode          string
+		wantForwarded string
+	}{
+		{"", "198.51.100.1, 203.0.113.5"},
+		{forwardedForAppend, "198.51.100.1, 203.0.113.5"},
+		{forwardedForOverwrite, "203.0.113.5"},
+	}
+
+	for _, tt := range tests {
+		metrics.Reset() // Reset metrics before test
+		lb, err := New(&config.Config{
+			Backends:         []config.Backend{{URL: backend.URL}},
+			ForwardedHeaders: config.ForwardedHeaders{XForwardedFor: tt.mode},
+		}, metrics.New())
+		if err != nil {
+			t.Fatalf("Failed to create load balancer: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "203.0.113.5:41234"
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+		req.Header.Set("X-Real-IP", "198.51.100.1")
+		lb.ServeHTTP(httptest.NewRecorder(), req)
+
+		header := <-received
+		if got := header.Get("X-Forwarded-For"); got != tt.wantForwarded {
+			t.Errorf("mode %q: expected X-Forwarded-For %q, got %q", tt.mode, tt.wantForwarded, got)
+		}
+		if got := header.Get("X-Real-IP"); got != "203.0.113.5" {
+			t.Errorf("mode %q: expected X-Real-IP of the connecting client, got %q", tt.mode, got)
+		}
+		if got := header.Get("X-Forwarded-Proto"); got != "http" {
+			t.Errorf("mode %q: expected X-Forwarded-Proto http, got %q", tt.mode, got)
+		}
+	}
+
+	// With SSL termination the backend is told the client used https
+	metrics.Reset()
+	lb, err := New(&config.Config{}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	lb.ssl = &ssl.Manager{}
+	req := httptest.NewRequest("GET", "/", nil)
+	lb.forwardingDirector(func(*http.Request) {})(req)
+	if got := req.Header.Get("X-Forwarded-Proto"); got != "https" {
+		t.Errorf("Expected X-Forwarded-Proto https behind SSL termination, got %q", got)
+	}
+
+	if _, err := New(&config.Config{
+		ForwardedHeaders: config.ForwardedHeaders{XForwardedFor: "prepend"},
+	}, metrics.New()); err == nil {
+		t.Error("Expected error for unknown X-Forwarded-For mode")
+	}
+}
+
+func TestRewriteHost(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer backend.Close()
+	backendHost := strings.TrimPrefix(backend.URL, "http://")
+
+	for _, rewrite := range []bool{false, true} {
+		metrics.Reset() // Reset metrics before test
+		lb, err := New(&config.Config{
+			Backends:    []config.Backend{{URL: backend.URL}},
+			RewriteHost: rewrite,
+		}, metrics.New())
+		if err != nil {
+			t.Fatalf("Failed to create load balancer: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "http://shop.example.com/", nil)
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+
+		want := "shop.example.com"
+		if rewrite {
+			want = backendHost
+		}
+		if got := w.Body.String(); got != want {
+			t.Errorf("RewriteHost %v: expected backend to see Host %q, got %q", rewrite, want, got)
+		}
+	}
+}
+
+func TestClientCertHeaders(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	caFile, _, ca, caKey := writeCertificate(t, dir, "ca", nil, nil)
+	serverCert, serverKey, _, _ := writeCertificate(t, dir, "server", ca, caKey)
+	clientCertFile, clientKeyFile, clientCert, _ := writeCertificate(t, dir, "client", ca, caKey)
+
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		SSL: &config.SSL{
+			CertFile:   serverCert,
+			KeyFile:    serverKey,
+			CAFile:     caFile,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		},
+		ForwardedHeaders: config.ForwardedHeaders{ClientCert: true},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	frontend := httptest.NewUnstartedServer(lb)
+	frontend.TLS = lb.ssl.GetTLSConfig().Clone()
+	frontend.StartTLS()
+	defer frontend.Close()
+
+	pair, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatalf("Failed to load client certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	send := func(certificates ...tls.Certificate) http.Header {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates},
+		}}
+		req, _ := http.NewRequest("GET", frontend.URL, nil)
+		req.Header.Set("X-Client-Cert-CN", "spoofed")
+		req.Header.Set("X-Client-Cert-Fingerprint", "spoofed")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return <-received
+	}
+
+	// The verified certificate is described to the backend
+	header := send(pair)
+	fingerprint := sha256.Sum256(clientCert.Raw)
+	if got := header.Get("X-Client-Cert-CN"); got != "client" {
+		t.Errorf("Expected client certificate CN %q, got %q", "client", got)
+	}
+	if got, want := header.Get("X-Client-Cert-Fingerprint"), hex.EncodeToString(fingerprint[:]); got != want {
+		t.Errorf("Expected client certificate fingerprint %s, got %s", want, got)
+	}
+	if values := header.Values("X-Client-Cert-CN"); len(values) != 1 {
+		t.Errorf("Expected a single CN header, got %v", values)
+	}
+
+	// Without a certificate the client's own headers are dropped
+	header = send()
+	if got := header.Get("X-Client-Cert-CN"); got != "" {
+		t.Errorf("Expected spoofed CN header to be dropped, got %q", got)
+	}
+	if got := header.Get("X-Client-Cert-Fingerprint"); got != "" {
+		t.Errorf("Expected spoofed fingerprint header to be dropped, got %q", got)
+	}
+}
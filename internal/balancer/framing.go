@@ -0,0 +1,409 @@
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// net/http merges repeated Content-Length values, drops Content-Length when
+// the request is chunked and reads folded header lines as one, so by the
+// time a handler sees a request the evidence of ambiguous framing is gone.
+// With strict framing on, each HTTP/1 request head is checked as it comes
+// off the connection, before net/http parses it.
+
+const (
+	// maxFramingHeadBytes is how much of a request head is buffered for
+	// checking. Longer heads are passed on unchecked for net/http to refuse
+	// with 431.
+	maxFramingHeadBytes = http.DefaultMaxHeaderBytes + 4096
+
+	// maxChunkLineBytes bounds a chunk size or trailer line
+	maxChunkLineBytes = 4096
+
+	// framingHandshakeTimeout bounds the TLS handshake of a connection
+	// whose request heads are checked
+	framingHandshakeTimeout = 10 * time.Second
+)
+
+// refusedHead stands in for a refused request head. Its conflicting
+// lengths make net/http answer it with a 400 and close the connection once
+// the requests before it are answered, keeping responses in order.
+const refusedHead = "GET / HTTP/1.1\r\nHost: invalid\r\nContent-Length: 0\r\nContent-Length: 1\r\n\r\n"
+
+// framingState is where a framingConn is in the request stream
+type framingState int
+
+const (
+	framingHead        framingState = iota // reading a request head
+	framingBody                            // passing on body bytes, or the data of a chunk
+	framingChunkSize                       // reading a chunk size line
+	framingChunkEnd                        // reading the line ending a chunk's data
+	framingTrailer                         // reading trailer lines after the last chunk
+	framingPassthrough                     // no longer checking
+)
+
+// framingConn checks the head of every HTTP/1 request read from the
+// connection, and only lets net/http read a request once its head is
+// found unambiguous. Bodies are followed, by length or chunk by chunk, to
+// find where the next pipelined request starts. Anything the conn cannot
+// follow, such as an upgraded connection, is passed on unchecked.
+type framingConn struct {
+	net.Conn
+	lb *LoadBalancer
+
+	// tlsState is the connection's TLS state, which net/http does not fill
+	// in for a wrapped *tls.Conn
+	tlsState *tls.ConnectionState
+
+	buf       []byte // bytes read from the client not yet handed to net/http
+	checked   int    // how many bytes at the front of buf are checked
+	state     framingState
+	remaining int64        // body bytes left in framingBody
+	afterBody framingState // state to go to once they are passed on
+
+	// rejected is set once a request head is refused; nothing after it is
+	// handed on
+	rejected bool
+}
+
+// Read hands out checked bytes, reading and checking more as needed. Once
+// a request head is refused, nothing more is handed out after its stand-in.
+func (c *framingConn) Read(p []byte) (int, error) {
+	for c.checked == 0 {
+		if c.rejected {
+			return 0, c.discard()
+		}
+		if c.state == framingPassthrough && len(c.buf) == 0 {
+			return c.Conn.Read(p)
+		}
+
+		var chunk [4096]byte
+		n, err := c.Conn.Read(chunk[:])
+		c.buf = append(c.buf, chunk[:n]...)
+		c.scan()
+		if c.checked == 0 && err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.buf[:c.checked])
+	c.buf = c.buf[n:]
+	c.checked -= n
+	return n, nil
+}
+
+// discard drops what the client sends after a refused request head until
+// the read fails. net/http waits on such a read in the background while it
+// answers the requests before the refused one, and ends it with a
+// deadline; reporting the end of the connection instead would cancel them.
+func (c *framingConn) discard() error {
+	var chunk [4096]byte
+	for {
+		if _, err := c.Conn.Read(chunk[:]); err != nil {
+			return err
+		}
+	}
+}
+
+// scan checks as much of the unchecked input as it can
+func (c *framingConn) scan() {
+	for c.checked < len(c.buf) && !c.rejected {
+		rest := c.buf[c.checked:]
+		switch c.state {
+		case framingPassthrough:
+			c.checked = len(c.buf)
+
+		case framingHead:
+			end := headEnd(rest)
+			if end < 0 {
+				if len(rest) > maxFramingHeadBytes {
+					c.state = framingPassthrough
+				}
+				return
+			}
+			if err := c.checkHead(rest[:end]); err != nil {
+				c.lb.logger.Warn("rejecting ambiguously framed request", "client", c.RemoteAddr().String(), "error", err)
+				c.lb.metrics.ErrorsTotal.Inc()
+				c.rejected = true
+				c.buf = append(c.buf[:c.checked], refusedHead...)
+				c.checked = len(c.buf)
+				return
+			}
+			c.checked += end
+
+		case framingBody:
+			n := min(int64(len(rest)), c.remaining)
+			c.checked += int(n)
+			c.remaining -= n
+			if c.remaining == 0 {
+				c.state = c.afterBody
+			}
+
+		default:
+			i := bytes.IndexByte(rest, '\n')
+			if i < 0 {
+				if len(rest) > maxChunkLineBytes {
+					c.state = framingPassthrough
+				}
+				return
+			}
+			c.checked += i + 1
+			c.chunkLine(bytes.TrimSuffix(rest[:i], []byte("\r")))
+		}
+	}
+}
+
+// checkHead refuses a request head whose body length is ambiguous, and
+// otherwise sets up to follow its body
+func (c *framingConn) checkHead(head []byte) error {
+	lines := strings.Split(string(head), "\n")
+	requestLine := strings.TrimSuffix(lines[0], "\r")
+	method, _, _ := strings.Cut(requestLine, " ")
+	passthrough := method == http.MethodConnect || method == "PRI"
+
+	var lengths, encodings []string
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return fmt.Errorf("folded header line")
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, " \t")
+		switch {
+		case strings.EqualFold(name, "Content-Length"):
+			lengths = append(lengths, value)
+		case strings.EqualFold(name, "Transfer-Encoding"):
+			encodings = append(encodings, value)
+		case strings.EqualFold(name, "Upgrade"):
+			passthrough = true
+		}
+	}
+
+	if len(lengths) > 0 && len(encodings) > 0 {
+		return fmt.Errorf("both Transfer-Encoding and Content-Length present")
+	}
+	if len(lengths) > 1 {
+		return fmt.Errorf("%d Content-Length headers", len(lengths))
+	}
+	if len(encodings) > 1 {
+		return fmt.Errorf("%d Transfer-Encoding headers", len(encodings))
+	}
+
+	c.state = framingHead
+	switch {
+	case len(lengths) == 1:
+		length, err := parseDecimal(lengths[0])
+		if err != nil {
+			return fmt.Errorf("malformed Content-Length %q", lengths[0])
+		}
+		if length > 0 {
+			c.state, c.remaining, c.afterBody = framingBody, length, framingHead
+		}
+	case len(encodings) == 1:
+		if !strings.EqualFold(encodings[0], "chunked") {
+			return fmt.Errorf("unsupported Transfer-Encoding %q", encodings[0])
+		}
+		c.state = framingChunkSize
+	}
+
+	// The bytes after an upgrade or tunnel are not HTTP requests
+	if passthrough {
+		c.state = framingPassthrough
+	}
+	return nil
+}
+
+// chunkLine follows a chunked body through one of its lines. A body the
+// conn cannot follow is passed on for net/http to refuse.
+func (c *framingConn) chunkLine(line []byte) {
+	switch c.state {
+	case framingChunkSize:
+		sizeField, _, _ := bytes.Cut(line, []byte(";"))
+		size, err := strconv.ParseInt(string(bytes.TrimRight(sizeField, " \t")), 16, 64)
+		switch {
+		case err != nil || size < 0:
+			c.state = framingPassthrough
+		case size == 0:
+			c.state = framingTrailer
+		default:
+			c.state, c.remaining, c.afterBody = framingBody, size, framingChunkEnd
+		}
+	case framingChunkEnd:
+		if len(line) != 0 {
+			c.state = framingPassthrough
+			return
+		}
+		c.state = framingChunkSize
+	case framingTrailer:
+		if len(line) == 0 {
+			c.state = framingHead
+		}
+	}
+}
+
+// headEnd returns the length of the request head at the start of b,
+// through the blank line ending it, or -1 if b does not hold all of it
+func headEnd(b []byte) int {
+	end := -1
+	if i := bytes.Index(b, []byte("\n\r\n")); i >= 0 {
+		end = i + 3
+	}
+	if i := bytes.Index(b, []byte("\n\n")); i >= 0 && (end < 0 || i+2 < end) {
+		end = i + 2
+	}
+	return end
+}
+
+// parseDecimal parses a Content-Length value, which must be a plain run of
+// ASCII digits
+func parseDecimal(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("not a decimal number")
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// tlsStateKey is the context key of a checked connection's TLS state
+type tlsStateKey struct{}
+
+// checkFraming wraps ln so server only reads HTTP/1 requests whose heads
+// have been checked. For TLS the handshake is done here instead of by
+// net/http: HTTP/2 connections go on to server as they are, since HTTP/2
+// frames every request itself, while HTTP/1 connections are wrapped and
+// their requests given back the TLS state net/http leaves out.
+func (lb *LoadBalancer) checkFraming(server *http.Server, ln net.Listener) net.Listener {
+	if lb.ssl == nil {
+		return &framingListener{Listener: ln, lb: lb}
+	}
+
+	config := server.TLSConfig.Clone()
+	if !slices.Contains(config.NextProtos, "http/1.1") {
+		config.NextProtos = append(config.NextProtos, "http/1.1")
+	}
+
+	connContext := server.ConnContext
+	server.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, conn)
+		}
+		if fc, ok := conn.(*framingConn); ok && fc.tlsState != nil {
+			ctx = context.WithValue(ctx, tlsStateKey{}, fc.tlsState)
+		}
+		return ctx
+	}
+	next := server.Handler
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state, ok := r.Context().Value(tlsStateKey{}).(*tls.ConnectionState); ok && r.TLS == nil {
+			r.TLS = state
+		}
+		next.ServeHTTP(w, r)
+	})
+
+	tlsLn := &framingTLSListener{
+		Listener: tls.NewListener(ln, config),
+		lb:       lb,
+		accepted: make(chan acceptResult),
+		done:     make(chan struct{}),
+	}
+	go tlsLn.acceptLoop()
+	return tlsLn
+}
+
+// framingListener wraps each accepted connection in a framingConn
+type framingListener struct {
+	net.Listener
+	lb *LoadBalancer
+}
+
+func (l *framingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &framingConn{Conn: conn, lb: l.lb}, nil
+}
+
+// acceptResult is a connection, or error, from a framingTLSListener
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// framingTLSListener completes each TLS handshake in the background, so a
+// slow client does not hold up the others, and hands out the connections
+// that finish it
+type framingTLSListener struct {
+	net.Listener
+	lb        *LoadBalancer
+	accepted  chan acceptResult
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *framingTLSListener) Accept() (net.Conn, error) {
+	select {
+	case result := <-l.accepted:
+		return result.conn, result.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *framingTLSListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// acceptLoop accepts connections until the listener is closed, passing on
+// errors for the server to handle as it would its own
+func (l *framingTLSListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.accepted <- acceptResult{err: err}:
+			case <-l.done:
+				return
+			}
+			continue
+		}
+		go l.handshake(conn.(*tls.Conn))
+	}
+}
+
+// handshake completes conn's TLS handshake and hands it out, wrapped
+// unless it speaks HTTP/2
+func (l *framingTLSListener) handshake(conn *tls.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), framingHandshakeTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		l.lb.logger.Debug("TLS handshake failed", "client", conn.RemoteAddr().String(), "error", err)
+		conn.Close()
+		return
+	}
+
+	var accepted net.Conn = conn
+	if state := conn.ConnectionState(); state.NegotiatedProtocol != "h2" {
+		accepted = &framingConn{Conn: conn, lb: l.lb, tlsState: &state}
+	}
+	select {
+	case l.accepted <- acceptResult{conn: accepted}:
+	case <-l.done:
+		conn.Close()
+	}
+}
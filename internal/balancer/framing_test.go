@@ -0,0 +1,316 @@
+package balancer
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+// serveStrictFraming serves lb's frontend with its request heads checked,
+// returning the address to connect to
+func serveStrictFraming(t *testing.T, lb *LoadBalancer) string {
+	server, err := lb.newFrontendServer(config.Frontend{})
+	if err != nil {
+		t.Fatalf("Failed to create frontend server: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go server.Serve(lb.checkFraming(server, ln))
+	t.Cleanup(func() { server.Close() })
+	return ln.Addr().String()
+}
+
+// recordingBackend records the path and body of every request it serves
+type recordingBackend struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (b *recordingBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests = append(b.requests, r.URL.Path+" "+string(body))
+}
+
+func (b *recordingBackend) take() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	requests := b.requests
+	b.requests = nil
+	return requests
+}
+
+// TestStrictFraming sends raw requests over TCP so the framing headers
+// reach the balancer untouched by any client library
+func TestStrictFraming(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	recorder := &recordingBackend{}
+	backend := httptest.NewServer(recorder)
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends:      []config.Backend{{URL: backend.URL}},
+		StrictFraming: true,
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	addr := serveStrictFraming(t, lb)
+
+	tests := []struct {
+		name    string
+		headers string
+		body    string
+		want    int
+	}{
+		{
+			name:    "content length",
+			headers: "Content-Length: 4\r\n",
+			body:    "body",
+			want:    http.StatusOK,
+		},
+		{
+			name:    "chunked",
+			headers: "Transfer-Encoding: chunked\r\n",
+			body:    "4\r\nbody\r\n0\r\n\r\n",
+			want:    http.StatusOK,
+		},
+		{
+			name:    "content length and chunked",
+			headers: "Content-Length: 4\r\nTransfer-Encoding: chunked\r\n",
+			body:    "4\r\nbody\r\n0\r\n\r\n",
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "repeated content length",
+			headers: "Content-Length: 4\r\nContent-Length: 4\r\n",
+			body:    "body",
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "conflicting content length",
+			headers: "Content-Length: 4\r\nContent-Length: 5\r\n",
+			body:    "body",
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "content length list",
+			headers: "Content-Length: 4, 4\r\n",
+			body:    "body",
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "signed content length",
+			headers: "Content-Length: +4\r\n",
+			body:    "body",
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "unsupported encoding",
+			headers: "Transfer-Encoding: gzip, chunked\r\n",
+			body:    "4\r\nbody\r\n0\r\n\r\n",
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "repeated encoding",
+			headers: "Transfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n",
+			body:    "4\r\nbody\r\n0\r\n\r\n",
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "folded header",
+			headers: "Content-Length: 4\r\nX-Note: a\r\n b\r\n",
+			body:    "body",
+			want:    http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("%s: failed to connect: %v", tt.name, err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		raw := "POST /upload HTTP/1.1\r\nHost: example.com\r\n" + tt.headers + "\r\n" + tt.body
+		if _, err := io.WriteString(conn, raw); err != nil {
+			t.Fatalf("%s: failed to write request: %v", tt.name, err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("%s: failed to read response: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		conn.Close()
+
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, resp.StatusCode)
+		}
+		got := recorder.take()
+		if tt.want == http.StatusOK && (len(got) != 1 || got[0] != "/upload body") {
+			t.Errorf("%s: expected the backend to receive the request once, got %q", tt.name, got)
+		}
+		if tt.want != http.StatusOK && len(got) != 0 {
+			t.Errorf("%s: expected the request not to reach the backend, got %q", tt.name, got)
+		}
+	}
+}
+
+func TestStrictFramingPipelined(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	recorder := &recordingBackend{}
+	backend := httptest.NewServer(recorder)
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends:      []config.Backend{{URL: backend.URL}},
+		StrictFraming: true,
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	addr := serveStrictFraming(t, lb)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Bodies are followed through chunk extensions and trailers to the next
+	// request, whose smuggling attempt is refused while the requests before
+	// it are answered
+	raw := "POST /first HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nfirst" +
+		"POST /second HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"3;ext=1\r\nsec\r\n3\r\nond\r\n0\r\nX-Trailer: done\r\n\r\n" +
+		"POST /third HTTP/1.1\r\nHost: example.com\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"0\r\n\r\n" +
+		"GET /smuggled HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatalf("Failed to write requests: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusBadRequest} {
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read response %d: %v", i+1, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Expected response %d to be %d, got %d", i+1, want, resp.StatusCode)
+		}
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the connection to be closed after the refused request, got %v", err)
+	}
+
+	got := recorder.take()
+	if len(got) != 2 || got[0] != "/first first" || got[1] != "/second second" {
+		t.Errorf("Expected the backend to see only /first and /second, got %q", got)
+	}
+}
+
+func TestStrictFramingTLS(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	caFile, _, ca, caKey := writeCertificate(t, dir, "ca", nil, nil)
+	serverCert, serverKey, _, _ := writeCertificate(t, dir, "server", ca, caKey)
+	clientCertFile, clientKeyFile, _, _ := writeCertificate(t, dir, "client", ca, caKey)
+
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		SSL: &config.SSL{
+			CertFile:   serverCert,
+			KeyFile:    serverKey,
+			CAFile:     caFile,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		},
+		ForwardedHeaders: config.ForwardedHeaders{ClientCert: true},
+		StrictFraming:    true,
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	addr := serveStrictFraming(t, lb)
+
+	pair, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatalf("Failed to load client certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	tlsConfig := &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{pair}}
+
+	// HTTP/1.1 requests are checked, and still see the client's certificate
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := client.Get("https://" + addr)
+	if err != nil {
+		t.Fatalf("HTTP/1.1 request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected an HTTP/1.1 200, got %s %d", resp.Proto, resp.StatusCode)
+	}
+	if got := (<-received).Get("X-Client-Cert-CN"); got != "client" {
+		t.Errorf("Expected client certificate CN %q, got %q", "client", got)
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, NextProtos: []string{"http/1.1"}})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	raw := "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nbody\r\n0\r\n\r\n"
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatalf("Failed to write request: %v", err)
+	}
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", resp.StatusCode)
+	}
+
+	// HTTP/2 is still negotiated
+	client = &http.Client{Transport: &http2.Transport{TLSClientConfig: tlsConfig}}
+	resp, err = client.Get("https://" + addr)
+	if err != nil {
+		t.Fatalf("HTTP/2 request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected an HTTP/2 200, got %s %d", resp.Proto, resp.StatusCode)
+	}
+	if got := (<-received).Get("X-Client-Cert-CN"); got != "client" {
+		t.Errorf("Expected client certificate CN %q over HTTP/2, got %q", "client", got)
+	}
+}
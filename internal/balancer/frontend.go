@@ -0,0 +1,60 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// limitConcurrency wraps next so at most max requests are in progress at
+// once. Each frontend gets its own limit, so a busy frontend cannot starve
+// another. Requests over the limit are rejected rather than queued.
+func (lb *LoadBalancer) limitConcurrency(next http.Handler, max int) http.Handler {
+	sem := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+		default:
+			lb.logger.Debug("frontend at concurrency limit", "method", r.Method, "path", r.URL.Path, "limit", max)
+			http.Error(w, "Too many concurrent requests", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-sem }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// connRequestsKey is the context key of a connection's request count
+type connRequestsKey struct{}
+
+// limitRequestsPerConn makes server close each HTTP/1.x client connection
+// once it has carried max requests, by answering the last one with
+// Connection: close. Clients reconnect, possibly to another balancer.
+func limitRequestsPerConn(server *http.Server, max int) {
+	server.ConnContext = func(ctx context.Context, _ net.Conn) context.Context {
+		return context.WithValue(ctx, connRequestsKey{}, new(atomic.Int64))
+	}
+	next := server.Handler
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count, ok := r.Context().Value(connRequestsKey{}).(*atomic.Int64)
+		if ok && r.ProtoMajor == 1 && count.Add(1) >= int64(max) {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// countConnections keeps the active connections gauge up to date with the
+// client connections open on server. A hijacked connection, such as an
+// upgraded WebSocket, is no longer the server's and stops being counted.
+func (lb *LoadBalancer) countConnections(server *http.Server) {
+	server.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			lb.metrics.ActiveConnections.Inc()
+		case http.StateHijacked, http.StateClosed:
+			lb.metrics.ActiveConnections.Dec()
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package balancer
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestFrontendConcurrencyLimit(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			started <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{Backends: []config.Backend{{URL: backend.URL}}}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	start := func(frontend config.Frontend) *httptest.Server {
+		server, err := lb.newFrontendServer(frontend)
+		if err != nil {
+			t.Fatalf("Failed to create frontend server: %v", err)
+		}
+		return httptest.NewServer(server.Handler)
+	}
+	admin := start(config.Frontend{MaxConcurrentRequests: 1})
+	defer admin.Close()
+	public := start(config.Frontend{MaxConcurrentRequests: 1})
+	defer public.Close()
+
+	get := func(url string) int {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", url, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Fill the admin frontend's only slot
+	blocked := make(chan int, 1)
+	go func() { blocked <- get(admin.URL + "/block") }()
+	<-started
+
+	if status := get(admin.URL); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected admin frontend over its limit to return 503, got %d", status)
+	}
+
+	// The public frontend is unaffected
+	for i := 0; i < 3; i++ {
+		if status := get(public.URL); status != http.StatusOK {
+			t.Errorf("Expected public frontend request %d to succeed, got %d", i, status)
+		}
+	}
+
+	close(release)
+	if status := <-blocked; status != http.StatusOK {
+		t.Errorf("Expected blocked request to succeed, got %d", status)
+	}
+	if status := get(admin.URL); status != http.StatusOK {
+		t.Errorf("Expected admin frontend to accept requests once its slot is free, got %d", status)
+	}
+}
+
+func TestFrontendMaxRequestsPerConnection(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{Backends: []config.Backend{{URL: backend.URL}}}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	server, err := lb.newFrontendServer(config.Frontend{MaxRequestsPerConnection: 3})
+	if err != nil {
+		t.Fatalf("Failed to create frontend server: %v", err)
+	}
+	frontend := httptest.NewUnstartedServer(server.Handler)
+	frontend.Config.ConnContext = server.ConnContext
+	frontend.Start()
+	defer frontend.Close()
+
+	conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// Every request goes over the same connection until the cap
+	for i := 1; i <= 3; i++ {
+		if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+			t.Fatalf("Failed to send request %d: %v", i, err)
+		}
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read response %d: %v", i, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected request %d to succeed, got %d", i, resp.StatusCode)
+		}
+		if resp.Close != (i == 3) {
+			t.Errorf("Expected Connection: close only on request 3, request %d had close=%v", i, resp.Close)
+		}
+	}
+
+	// The balancer then closes the connection
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected connection to be closed after 3 requests, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package balancer
+
+import (
+	"net/http"
+
+	"loadbalancer/internal/config"
+)
+
+// grpcStatusHeader carries a gRPC call's outcome. It arrives as a trailer
+// after the response body, or as a header on responses without a body.
+const grpcStatusHeader = "Grpc-Status"
+
+// setGRPCFailures records which grpc-status values count as backend failures
+func (lb *LoadBalancer) setGRPCFailures(cfg config.CircuitBreaker) {
+	statuses := cfg.GRPCFailureStatuses()
+	lb.grpcFailures.Store(&statuses)
+}
+
+// grpcFailure reports the grpc-status of a proxied response if it is one
+// configured to count as a failure. header is the client response's header
+// map, where the proxy also leaves the backend's trailers once the body has
+// been copied.
+func (lb *LoadBalancer) grpcFailure(header http.Header) (string, bool) {
+	failures := lb.grpcFailures.Load()
+	if failures == nil || len(*failures) == 0 {
+		return "", false
+	}
+
+	status := header.Get(grpcStatusHeader)
+	if status == "" {
+		status = header.Get(http.TrailerPrefix + grpcStatusHeader)
+	}
+	return status, (*failures)[status]
+}
@@ -0,0 +1,74 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"loadbalancer/internal/circuitbreaker"
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+// grpcBackend answers like a gRPC server: HTTP 200 with the call's outcome
+// in the grpc-status trailer, or in a header when trailersOnly is set
+func grpcBackend(status string, trailersOnly bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		if trailersOnly {
+			w.Header().Set(grpcStatusHeader, status)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Trailer", grpcStatusHeader)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set(grpcStatusHeader, status)
+	}))
+}
+
+func TestGRPCFailuresOpenCircuit(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		trailersOnly bool
+		codes        []string
+		wantOpen     bool
+	}{
+		{name: "unavailable in trailer", status: "14", codes: []string{"UNAVAILABLE"}, wantOpen: true},
+		{name: "unavailable trailers-only", status: "14", trailersOnly: true, codes: []string{"UNAVAILABLE"}, wantOpen: true},
+		{name: "ok", status: "0", codes: []string{"UNAVAILABLE"}},
+		{name: "status not configured", status: "5", codes: []string{"UNAVAILABLE"}},
+		{name: "disabled", status: "14"},
+	}
+
+	for _, tt := range tests {
+		metrics.Reset() // Reset metrics before test
+		backend := grpcBackend(tt.status, tt.trailersOnly)
+
+		lb, err := New(&config.Config{
+			Backends:       []config.Backend{{URL: backend.URL}},
+			CircuitBreaker: config.CircuitBreaker{GRPCFailureCodes: tt.codes},
+		}, metrics.New())
+		if err != nil {
+			t.Fatalf("%s: Failed to create load balancer: %v", tt.name, err)
+		}
+
+		// The breaker opens after five consecutive failures
+		for i := 0; i < 5; i++ {
+			req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+			req.Header.Set("Content-Type", "application/grpc")
+			w := httptest.NewRecorder()
+			lb.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: Expected gRPC response to be relayed with status 200, got %d", tt.name, w.Code)
+			}
+		}
+
+		open := lb.backends[0].CircuitBreaker.GetState() == circuitbreaker.StateOpen
+		if open != tt.wantOpen {
+			t.Errorf("%s: Expected circuit open to be %v, got %v", tt.name, tt.wantOpen, open)
+		}
+		backend.Close()
+	}
+}
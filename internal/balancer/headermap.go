@@ -0,0 +1,115 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+// Sources a header mapping can read from
+const (
+	mapFromClaim  = "claim"
+	mapFromCookie = "cookie"
+	mapFromHeader = "header"
+)
+
+// validateHeaderMappings checks that every mapping names a known source,
+// what to read from it and the header to set
+func validateHeaderMappings(mappings []config.HeaderMapping) error {
+	for _, m := range mappings {
+		switch m.From {
+		case mapFromClaim, mapFromCookie, mapFromHeader:
+		default:
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown header mapping source %q", m.From), nil)
+		}
+		if m.Name == "" || m.Header == "" {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("header mapping from %s needs a name and a header", m.From), nil)
+		}
+	}
+	return nil
+}
+
+// mapHeaders sets the header of each mapping on req from its source. All
+// sources are read before any header is replaced, so mappings cannot feed
+// one another, and target headers are cleared even when their source is
+// missing so clients cannot supply them.
+func mapHeaders(req *http.Request, mappings []config.HeaderMapping) {
+	var claims map[string]interface{}
+	values := make([]string, len(mappings))
+	for i, m := range mappings {
+		switch m.From {
+		case mapFromClaim:
+			if claims == nil {
+				claims = bearerClaims(req)
+			}
+			values[i] = claimString(claims[m.Name])
+		case mapFromCookie:
+			if cookie, err := req.Cookie(m.Name); err == nil {
+				values[i] = cookie.Value
+			}
+		case mapFromHeader:
+			values[i] = req.Header.Get(m.Name)
+		}
+	}
+
+	for _, m := range mappings {
+		req.Header.Del(m.Header)
+	}
+	for i, m := range mappings {
+		if values[i] != "" {
+			req.Header.Set(m.Header, values[i])
+		}
+	}
+}
+
+// bearerClaims decodes the claims of the JWT in req's Authorization header.
+// The signature is not checked. A missing or malformed token yields an
+// empty, non-nil map.
+func bearerClaims(req *http.Request) map[string]interface{} {
+	claims := map[string]interface{}{}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return claims
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return claims
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return map[string]interface{}{}
+	}
+	return claims
+}
+
+// claimString renders a claim as a header value. Only strings, numbers and
+// booleans are mapped; other claims, and strings that cannot be sent in a
+// header, yield "".
+func claimString(claim interface{}) string {
+	switch v := claim.(type) {
+	case string:
+		if strings.ContainsAny(v, "\r\n\x00") {
+			return ""
+		}
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
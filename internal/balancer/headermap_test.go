@@ -0,0 +1,93 @@
+package balancer
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+// testJWT builds an unsigned token carrying payload as its claims
+func testJWT(payload string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + encode([]byte(payload)) + ".c2lnbmF0dXJl"
+}
+
+func TestHeaderMappings(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		ForwardedHeaders: config.ForwardedHeaders{Mappings: []config.HeaderMapping{
+			{From: "claim", Name: "sub", Header: "X-User-ID"},
+			{From: "claim", Name: "tenant", Header: "X-Tenant-ID"},
+			{From: "cookie", Name: "session", Header: "X-Session"},
+			{From: "header", Name: "X-Request-ID", Header: "X-Correlation-ID"},
+		}},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	send := func(prepare func(*http.Request)) http.Header {
+		req := httptest.NewRequest("GET", "/", nil)
+		prepare(req)
+		lb.ServeHTTP(httptest.NewRecorder(), req)
+		return <-received
+	}
+
+	header := send(func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+testJWT(`{"sub":"user-42","tenant":7,"roles":["admin"]}`))
+		req.AddCookie(&http.Cookie{Name: "session", Value: "abc123"})
+		req.Header.Set("X-Request-ID", "req-1")
+	})
+	want := map[string]string{
+		"X-User-ID":        "user-42",
+		"X-Tenant-ID":      "7",
+		"X-Session":        "abc123",
+		"X-Correlation-ID": "req-1",
+		"Authorization":    "Bearer " + testJWT(`{"sub":"user-42","tenant":7,"roles":["admin"]}`),
+	}
+	for name, value := range want {
+		if got := header.Get(name); got != value {
+			t.Errorf("Expected %s %q, got %q", name, value, got)
+		}
+	}
+
+	// Without a token, clients cannot supply the mapped headers themselves
+	header = send(func(req *http.Request) {
+		req.Header.Set("X-User-ID", "admin")
+		req.Header.Set("X-Session", "forged")
+	})
+	for _, name := range []string{"X-User-ID", "X-Tenant-ID", "X-Session", "X-Correlation-ID"} {
+		if got := header.Values(name); len(got) != 0 {
+			t.Errorf("Expected no %s without its source, got %q", name, got)
+		}
+	}
+
+	// Malformed tokens and claims that are not scalars are ignored
+	for _, token := range []string{"not-a-jwt", "a.%%%.c", testJWT(`{"sub":{"id":1}}`), testJWT(`{"sub":"a\nb"}`)} {
+		header = send(func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) })
+		if got := header.Get("X-User-ID"); got != "" {
+			t.Errorf("Expected no X-User-ID for token %q, got %q", token, got)
+		}
+	}
+
+	for _, mapping := range []config.HeaderMapping{
+		{From: "query", Name: "user", Header: "X-User-ID"},
+		{From: "claim", Name: "sub"},
+	} {
+		_, err := New(&config.Config{ForwardedHeaders: config.ForwardedHeaders{Mappings: []config.HeaderMapping{mapping}}}, metrics.New())
+		if err == nil {
+			t.Errorf("Expected error for header mapping %+v", mapping)
+		}
+	}
+}
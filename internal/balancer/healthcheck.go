@@ -0,0 +1,203 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"loadbalancer/internal/balancer/algorithm"
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+// healthCheckConfig returns the health check settings with defaults applied
+func (lb *LoadBalancer) healthCheckConfig() config.HealthCheck {
+	var hc config.HealthCheck
+	if lb.config != nil {
+		hc = lb.config.HealthCheck
+	}
+	if hc.Path == "" {
+		hc.Path = "/health"
+	}
+	if hc.Interval <= 0 {
+		hc.Interval = 10 * time.Second
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = 2 * time.Second
+	}
+	return hc
+}
+
+// probe issues a single health check against b, on its own health path if
+// one is configured. A transport error or a non-2xx response is reported as
+// an error. Load reported by a healthy backend adjusts its weight.
+func (lb *LoadBalancer) probe(ctx context.Context, b *Backend) error {
+	hc := lb.healthCheckConfig()
+	path := hc.Path
+	if b.healthPath != "" {
+		path = b.healthPath
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.URL.String()+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := lb.healthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("health check returned %d", resp.StatusCode)
+	}
+	if hc.LoadHeader != "" {
+		if value := resp.Header.Get(hc.LoadHeader); value != "" {
+			lb.applyLoad(b, value)
+		}
+	}
+	return nil
+}
+
+// applyLoad scales b's effective weight down by the load it reported, a
+// fraction from 0 (idle) to 1 (saturated). Values outside that range are
+// clamped; unparseable values are ignored.
+func (lb *LoadBalancer) applyLoad(b *Backend, value string) {
+	load, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(load) {
+		lb.logger.Debug("ignoring invalid load report", "backend", b.URL.String(), "value", value)
+		return
+	}
+	load = math.Min(math.Max(load, 0), 1)
+
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	selectors := []algorithm.Selector{lb.selector}
+	for _, pool := range lb.sidePools() {
+		selectors = append(selectors, pool.selector)
+	}
+	for _, selector := range selectors {
+		adjuster, ok := selector.(algorithm.WeightAdjuster)
+		if !ok {
+			continue
+		}
+		weight, effective, ok := adjuster.Weights(b.ID)
+		if !ok {
+			continue
+		}
+		target := int(math.Round(float64(weight) * (1 - load)))
+		adjuster.AdjustWeight(b.ID, target-effective)
+	}
+}
+
+// ProbeBackend runs an immediate health check against the backend with the
+// given URL and updates its health state from the result, without waiting
+// for the next scheduled check
+func (lb *LoadBalancer) ProbeBackend(ctx context.Context, backendURL string) (bool, error) {
+	lb.mu.RLock()
+	b, exists := lb.byID[backendURL]
+	lb.mu.RUnlock()
+	if !exists {
+		return false, errors.New(errors.ErrBackendUnavailable, fmt.Sprintf("unknown backend %s", backendURL), nil)
+	}
+
+	healthy := lb.probe(ctx, b) == nil
+	lb.setHealthy(b, healthy)
+	return healthy, nil
+}
+
+// setHealthy updates b's health state, reporting whether it changed. The
+// backend health gauge is updated on every transition.
+func (lb *LoadBalancer) setHealthy(b *Backend, healthy bool) bool {
+	if b.Healthy.Swap(healthy) == healthy {
+		return false
+	}
+	lb.reportHealth(b)
+	return true
+}
+
+// reportHealth exports b's current health state as the backend health gauge
+func (lb *LoadBalancer) reportHealth(b *Backend) {
+	value := 0.0
+	if b.Healthy.Load() {
+		value = 1
+	}
+	lb.metrics.BackendHealth.With(prometheus.Labels{"backend_url": b.URL.String()}).Set(value)
+}
+
+// healthyThreshold is the number of consecutive successful checks needed
+// before an unhealthy backend rejoins the pool
+const healthyThreshold = 2
+
+// startHealthChecks launches a checker for every current backend. Backends
+// added afterwards get a checker when they join the pool. All checkers stop
+// when ctx is cancelled. Callers must hold lb.mu.
+func (lb *LoadBalancer) startHealthChecks(ctx context.Context) {
+	lb.healthCtx = ctx
+	for _, b := range lb.backends {
+		lb.watchBackend(b)
+	}
+}
+
+// watchBackend starts the health-check loop for b if health checking is
+// running. Callers must hold lb.mu.
+func (lb *LoadBalancer) watchBackend(b *Backend) {
+	if lb.healthCtx == nil || lb.healthCtx.Err() != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(lb.healthCtx)
+	b.stopHealthCheck = cancel
+
+	lb.healthWG.Add(1)
+	go func() {
+		defer lb.healthWG.Done()
+		lb.healthCheckLoop(ctx, b)
+	}()
+}
+
+// healthCheckLoop probes b until ctx is cancelled. A single failure marks the
+// backend unhealthy; healthyThreshold consecutive successes bring it back.
+func (lb *LoadBalancer) healthCheckLoop(ctx context.Context, b *Backend) {
+	successes := 0
+	for {
+		err := lb.probe(ctx, b)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			successes = 0
+			if lb.setHealthy(b, false) {
+				lb.logger.Warn("backend is unhealthy", "backend", b.URL.String(), "error", err)
+			}
+		} else if !b.Healthy.Load() {
+			successes++
+			if successes >= healthyThreshold {
+				lb.setHealthy(b, true)
+				lb.logger.Info("backend is healthy again", "backend", b.URL.String())
+			}
+		}
+
+		timer := time.NewTimer(lb.healthCheckConfig().IntervalFor(b.Healthy.Load()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"loadbalancer/internal/balancer/algorithm"
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestProbeBackend(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var healthy atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			t.Errorf("Expected probe on /health, got %s", r.URL.Path)
+		}
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{Backends: []config.Backend{{URL: backend.URL}}}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// A failing probe marks the backend down
+	ok, err := lb.ProbeBackend(context.Background(), backend.URL)
+	if err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if ok || lb.backends[0].Healthy.Load() {
+		t.Error("Expected backend to be marked unhealthy")
+	}
+
+	// Once fixed, a forced probe flips it back immediately
+	healthy.Store(true)
+	ok, err = lb.ProbeBackend(context.Background(), backend.URL)
+	if err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if !ok || !lb.backends[0].Healthy.Load() {
+		t.Error("Expected backend to be marked healthy after forced probe")
+	}
+
+	if _, err := lb.ProbeBackend(context.Background(), "http://localhost:1"); err == nil {
+		t.Error("Expected error probing unknown backend")
+	}
+}
+
+// startTestHealthChecks runs the health checkers the way Start does and
+// returns a function that stops them and waits for them to exit
+func startTestHealthChecks(lb *LoadBalancer) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	lb.mu.Lock()
+	lb.startHealthChecks(ctx)
+	lb.mu.Unlock()
+	return func() {
+		cancel()
+		lb.healthWG.Wait()
+	}
+}
+
+func TestHealthCheckLoop(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var healthy atomic.Bool
+	probes := make(chan struct{}, 100)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		probes <- struct{}{}
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		HealthCheck: config.HealthCheck{
+			Interval: 50 * time.Millisecond,
+			Timeout:  time.Second,
+			Path:     "/health",
+		},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	b := lb.backends[0]
+
+	// health returns the exported health gauge for the backend
+	health := func() float64 {
+		return testutil.ToFloat64(lb.metrics.BackendHealth.WithLabelValues(backend.URL))
+	}
+	if got := health(); got != 1 {
+		t.Errorf("Expected health gauge of 1 at registration, got %f", got)
+	}
+
+	// waitProbe waits for the next probe to complete and be recorded
+	waitProbe := func() {
+		select {
+		case <-probes:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for health probe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stop := startTestHealthChecks(lb)
+
+	waitProbe()
+	if b.Healthy.Load() {
+		t.Error("Expected backend to be unhealthy after a failed check")
+	}
+	if got := health(); got != 0 {
+		t.Errorf("Expected health gauge of 0 after a failed check, got %f", got)
+	}
+
+	// One success is not enough to rejoin the pool
+	healthy.Store(true)
+	waitProbe()
+	if b.Healthy.Load() {
+		t.Error("Expected backend to stay unhealthy after a single success")
+	}
+	waitProbe()
+	if !b.Healthy.Load() {
+		t.Error("Expected backend to be healthy after two consecutive successes")
+	}
+	if got := health(); got != 1 {
+		t.Errorf("Expected health gauge of 1 after recovery, got %f", got)
+	}
+
+	// Cancelling the context stops the checkers
+	done := make(chan struct{})
+	go func() {
+		stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Health checkers did not stop after cancellation")
+	}
+}
+
+func TestHealthCheckUnhealthyInterval(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var probes atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		HealthCheck: config.HealthCheck{
+			Interval:          time.Hour,
+			UnhealthyInterval: 20 * time.Millisecond,
+			Timeout:           time.Second,
+			Path:              "/health",
+		},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	stop := startTestHealthChecks(lb)
+	time.Sleep(300 * time.Millisecond)
+	stop()
+
+	// With the healthy interval of an hour only the first probe would run
+	if n := probes.Load(); n < 5 {
+		t.Errorf("Expected unhealthy backend to be probed at the faster cadence, got %d probes", n)
+	}
+}
+
+func TestHealthCheckFollowsPoolChanges(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var probes atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		HealthCheck: config.HealthCheck{Interval: 10 * time.Millisecond, Timeout: time.Second},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	stop := startTestHealthChecks(lb)
+	defer stop()
+
+	// A backend added after start gets a checker
+	b, err := lb.newBackend(backend.URL)
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	lb.addBackend(b, 1)
+	time.Sleep(50 * time.Millisecond)
+	if probes.Load() == 0 {
+		t.Fatal("Expected added backend to be health checked")
+	}
+
+	// Removing it stops its checker
+	lb.removeBackend(b.ID)
+	time.Sleep(20 * time.Millisecond)
+	before := probes.Load()
+	time.Sleep(50 * time.Millisecond)
+	if after := probes.Load(); after != before {
+		t.Errorf("Expected no probes after removal, got %d more", after-before)
+	}
+}
+
+func TestHealthCheckPerBackendPath(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	// Each backend only answers health checks on its own path
+	var urls []string
+	for _, path := range []string{"/healthz", "/status"} {
+		path := path
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != path {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{
+			{URL: urls[0], HealthPath: "/healthz"},
+			{URL: urls[1], HealthPath: "/status"},
+		},
+		HealthCheck: config.HealthCheck{Path: "/health"},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	for _, url := range urls {
+		healthy, err := lb.ProbeBackend(context.Background(), url)
+		if err != nil {
+			t.Fatalf("Probe failed: %v", err)
+		}
+		if !healthy {
+			t.Errorf("Expected %s to pass its own health check", url)
+		}
+	}
+
+	// Without an override the global path is used
+	lb.backends[0].healthPath = ""
+	if healthy, _ := lb.ProbeBackend(context.Background(), urls[0]); healthy {
+		t.Error("Expected global path to be checked when no override is set")
+	}
+}
+
+func TestReportedLoadAdjustsWeight(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var urls []string
+	for _, load := range []string{"0.8", "0"} {
+		load := load
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Load", load)
+		}))
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+
+	lb, err := New(&config.Config{
+		Backends:    []config.Backend{{URL: urls[0], Weight: 10}, {URL: urls[1], Weight: 10}},
+		HealthCheck: config.HealthCheck{LoadHeader: "X-Load"},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	for _, url := range urls {
+		if _, err := lb.ProbeBackend(context.Background(), url); err != nil {
+			t.Fatalf("Probe failed: %v", err)
+		}
+	}
+
+	// The busy backend's weight drops from 10 to 2
+	counts := make(map[string]int)
+	for i := 0; i < 60; i++ {
+		counts[lb.nextBackend().ID]++
+	}
+	if counts[urls[0]] != 10 || counts[urls[1]] != 50 {
+		t.Errorf("Expected 10 requests on the busy backend and 50 on the idle one, got %d and %d", counts[urls[0]], counts[urls[1]])
+	}
+
+	// Invalid reports leave the weight alone
+	lb.applyLoad(lb.backends[0], "busy")
+	if _, effective, _ := lb.selector.(algorithm.WeightAdjuster).Weights(urls[0]); effective != 2 {
+		t.Errorf("Expected effective weight 2 after an invalid report, got %d", effective)
+	}
+}
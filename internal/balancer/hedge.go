@@ -0,0 +1,198 @@
+package balancer
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"loadbalancer/internal/config"
+)
+
+// errHedgeLost cancels a hedged attempt once another backend has started
+// responding
+var errHedgeLost = stderrors.New("request answered by another backend")
+
+// hedgeDelay returns how long r waits for its first backend before being
+// sent to a second, or 0 if r is not hedged. Only safe methods without a
+// body are hedged, as both backends must be able to serve the same
+// request; protocol upgrades cannot be answered twice.
+func (lb *LoadBalancer) hedgeDelay(r *http.Request) time.Duration {
+	if lb.config == nil || lb.config.HedgeDelay <= 0 {
+		return 0
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return 0
+	}
+	if r.ContentLength != 0 || r.Header.Get("Upgrade") != "" {
+		return 0
+	}
+	return lb.config.HedgeDelay
+}
+
+// hedgeRace relays the response of whichever hedged attempt starts
+// responding first, and cancels the others
+type hedgeRace struct {
+	mu       sync.Mutex
+	w        http.ResponseWriter
+	attempts []*hedgeAttempt
+	winner   *hedgeAttempt
+}
+
+// join enters a into the race, unless it has already been won
+func (h *hedgeRace) join(a *hedgeAttempt) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.winner != nil {
+		return false
+	}
+	h.attempts = append(h.attempts, a)
+	return true
+}
+
+// claim makes a the winner if no attempt has won yet, cancelling the rest,
+// and reports whether a won
+func (h *hedgeRace) claim(a *hedgeAttempt) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.winner == nil {
+		h.winner = a
+		for _, other := range h.attempts {
+			if other != a {
+				other.cancel(errHedgeLost)
+			}
+		}
+	}
+	return h.winner == a
+}
+
+// won returns the winning attempt, if any
+func (h *hedgeRace) won() *hedgeAttempt {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.winner
+}
+
+// hedgeAttempt is the response writer of one hedged attempt. Headers are
+// collected separately until the attempt responds; the first to do so
+// writes through to the client, and the response of any other is dropped.
+type hedgeAttempt struct {
+	race   *hedgeRace
+	cancel context.CancelCauseFunc
+	header http.Header
+	won    bool
+	lost   bool
+}
+
+func (a *hedgeAttempt) Header() http.Header {
+	if a.won {
+		return a.race.w.Header()
+	}
+	return a.header
+}
+
+// WriteHeader enters the attempt's response into the race. Informational
+// responses are dropped, since the attempt may yet lose.
+func (a *hedgeAttempt) WriteHeader(status int) {
+	switch {
+	case a.won:
+		a.race.w.WriteHeader(status)
+		return
+	case a.lost, status >= 100 && status <= 199:
+		return
+	}
+
+	if !a.race.claim(a) {
+		a.lost = true
+		return
+	}
+	a.won = true
+	header := a.race.w.Header()
+	for key, values := range a.header {
+		header[key] = values
+	}
+	a.race.w.WriteHeader(status)
+}
+
+func (a *hedgeAttempt) Write(p []byte) (int, error) {
+	if !a.won && !a.lost {
+		a.WriteHeader(http.StatusOK)
+	}
+	if a.lost {
+		return len(p), nil
+	}
+	return a.race.w.Write(p)
+}
+
+func (a *hedgeAttempt) Flush() {
+	if a.won {
+		http.NewResponseController(a.race.w).Flush()
+	}
+}
+
+// hedgeResult is the outcome of one hedged attempt
+type hedgeResult struct {
+	backend *Backend
+	attempt *hedgeAttempt
+	timing  *requestTiming
+	err     error
+}
+
+// forwardHedged forwards r to backend and, if it has not started
+// responding after delay, to a second backend as well. The first response
+// is relayed to the client and the other attempt cancelled. It returns the
+// backend whose response was relayed and the result of forwarding to it.
+func (lb *LoadBalancer) forwardHedged(wrapped *responseWriter, r *http.Request, backend *Backend, route *config.Route, timing *requestTiming, delay time.Duration) (*Backend, error) {
+	race := &hedgeRace{w: wrapped}
+	results := make(chan hedgeResult, 2)
+	launched := 0
+	launch := func(b *Backend) bool {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		attempt := &hedgeAttempt{race: race, cancel: cancel, header: make(http.Header)}
+		if !race.join(attempt) {
+			cancel(nil)
+			return false
+		}
+		launched++
+
+		attemptTiming := &requestTiming{received: timing.received}
+		go func() {
+			defer cancel(nil)
+			err := lb.forward(&responseWriter{ResponseWriter: attempt}, r.WithContext(ctx), b, route, attemptTiming, nil)
+			results <- hedgeResult{backend: b, attempt: attempt, timing: attemptTiming, err: err}
+		}()
+		return true
+	}
+	launch(backend)
+
+	var done []hedgeResult
+	timer := time.NewTimer(delay)
+	select {
+	case result := <-results:
+		done = append(done, result)
+	case <-timer.C:
+		if second := lb.retryBackend(r, map[*Backend]bool{backend: true}); second != nil && launch(second) {
+			lb.metrics.HedgedRequests.Inc()
+			lb.logger.Debug("hedging slow request", "method", r.Method, "path", r.URL.Path, "slow", backend.URL.String(), "hedge", second.URL.String())
+		}
+	}
+	timer.Stop()
+	for len(done) < launched {
+		done = append(done, <-results)
+	}
+
+	// Report the attempt whose response the client got; if none responded,
+	// the first to finish
+	chosen := done[0]
+	winner := race.won()
+	for _, result := range done {
+		if result.attempt == winner {
+			chosen = result
+		}
+	}
+	timing.admitted, timing.backend = chosen.timing.admitted, chosen.timing.backend
+	return chosen.backend, chosen.err
+}
@@ -0,0 +1,87 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"loadbalancer/internal/circuitbreaker"
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestHedgedRequest(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	cancelled := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Write([]byte("slow"))
+			return
+		}
+		select {
+		case <-time.After(2 * time.Second):
+			w.Write([]byte("slow"))
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", "fast")
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	m := metrics.New()
+	lb, err := New(&config.Config{
+		Backends:   []config.Backend{{URL: slow.URL}, {URL: fast.URL}},
+		HedgeDelay: 50 * time.Millisecond,
+	}, m)
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	// Round-robin sends the first request to the slow backend
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	elapsed := time.Since(start)
+
+	if w.Code != http.StatusOK || w.Body.String() != "fast" {
+		t.Fatalf("Expected the fast backend's response, got %d %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Backend"); got != "fast" {
+		t.Errorf("Expected the fast backend's headers, got X-Backend %q", got)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Expected the hedge to answer well before the slow backend, took %v", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the slow backend's request to be cancelled")
+	}
+	if got := testutil.ToFloat64(m.HedgedRequests); got != 1 {
+		t.Errorf("Expected 1 hedged request, got %v", got)
+	}
+
+	// The abandoned request does not count against the slow backend
+	slowBackend := lb.byID[slow.URL]
+	if requests, _ := slowBackend.outcomes.Counts(time.Now()); requests != 0 {
+		t.Errorf("Expected no outcome recorded for the abandoned request, got %d", requests)
+	}
+	if slowBackend.CircuitBreaker.GetState() != circuitbreaker.StateClosed {
+		t.Error("Expected the slow backend's circuit to stay closed")
+	}
+
+	// Requests with a body are never hedged
+	w = httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("payload")))
+	if got := testutil.ToFloat64(m.HedgedRequests); got != 1 {
+		t.Errorf("Expected POST not to be hedged, got %v hedged requests", got)
+	}
+}
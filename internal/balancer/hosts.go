@@ -0,0 +1,115 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+// hostAllowlist holds the Host values the balancer accepts. Entries of the
+// form "*.example.com" match any subdomain of example.com.
+type hostAllowlist struct {
+	exact    map[string]bool
+	suffixes []string
+}
+
+func newHostAllowlist(hosts []string) *hostAllowlist {
+	allowlist := &hostAllowlist{exact: make(map[string]bool)}
+	for _, host := range hosts {
+		host = strings.ToLower(host)
+		if strings.HasPrefix(host, "*.") {
+			allowlist.suffixes = append(allowlist.suffixes, host[1:])
+			continue
+		}
+		allowlist.exact[host] = true
+	}
+	return allowlist
+}
+
+// allows reports whether the request Host, with any port removed, is listed
+func (a *hostAllowlist) allows(host string) bool {
+	host = normalizeHost(host)
+	if a.exact[host] {
+		return true
+	}
+	for _, suffix := range a.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHost strips any port and trailing dot from a request Host and
+// lowercases it
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// virtualHosts maps request hosts to backend pools
+type virtualHosts struct {
+	exact     map[string]*routePool
+	wildcards []wildcardHost // longest suffix first
+}
+
+// wildcardHost sends any subdomain of suffix, which starts with a dot, to pool
+type wildcardHost struct {
+	suffix string
+	pool   *routePool
+}
+
+// newVirtualHosts resolves the pool of every virtual host
+func newVirtualHosts(hosts []config.VirtualHost, pools []*routePool) (*virtualHosts, error) {
+	byName := make(map[string]*routePool, len(pools))
+	for _, pool := range pools {
+		byName[pool.name] = pool
+	}
+
+	v := &virtualHosts{exact: make(map[string]*routePool)}
+	seen := make(map[string]bool, len(hosts))
+	for _, vhost := range hosts {
+		host := strings.ToLower(vhost.Host)
+		if host == "" || host == "*." {
+			return nil, errors.New(errors.ErrConfigInvalid, "virtual host without a host name", nil)
+		}
+		if seen[host] {
+			return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("duplicate virtual host %q", vhost.Host), nil)
+		}
+		seen[host] = true
+		pool, ok := byName[vhost.Pool]
+		if !ok {
+			return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("virtual host %s uses unknown backend pool %q", vhost.Host, vhost.Pool), nil)
+		}
+
+		if strings.HasPrefix(host, "*.") {
+			v.wildcards = append(v.wildcards, wildcardHost{suffix: host[1:], pool: pool})
+			continue
+		}
+		v.exact[host] = pool
+	}
+	sort.SliceStable(v.wildcards, func(i, j int) bool {
+		return len(v.wildcards[i].suffix) > len(v.wildcards[j].suffix)
+	})
+	return v, nil
+}
+
+// poolFor returns the pool for the request Host, or nil if none matches
+func (v *virtualHosts) poolFor(host string) *routePool {
+	host = normalizeHost(host)
+	if pool, ok := v.exact[host]; ok {
+		return pool
+	}
+	for _, wildcard := range v.wildcards {
+		if strings.HasSuffix(host, wildcard.suffix) {
+			return wildcard.pool
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestHostAllowlist(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends:     []config.Backend{{URL: backend.URL}},
+		AllowedHosts: []string{"example.com", "*.apps.example.com"},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	tests := []struct {
+		host       string
+		wantStatus int
+	}{
+		{"example.com", http.StatusOK},
+		{"EXAMPLE.com:8080", http.StatusOK},
+		{"api.apps.example.com", http.StatusOK},
+		{"evil.com", http.StatusMisdirectedRequest},
+		{"example.com.evil.com", http.StatusMisdirectedRequest},
+		{"apps.example.com", http.StatusMisdirectedRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("Host %q: expected status %d, got %d", tt.host, tt.wantStatus, w.Code)
+		}
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	servers := map[string]string{}
+	for _, name := range []string{"api", "www", "tenants", "eu-tenants", "default"} {
+		name := name
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer server.Close()
+		servers[name] = server.URL
+	}
+
+	var pools []config.Pool
+	for _, name := range []string{"api", "www", "tenants", "eu-tenants", "default"} {
+		pools = append(pools, config.Pool{Name: name, Backends: []config.Backend{{URL: servers[name]}}})
+	}
+
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Pools: pools,
+		VirtualHosts: []config.VirtualHost{
+			{Host: "api.example.com", Pool: "api"},
+			{Host: "www.example.com", Pool: "www"},
+			{Host: "*.example.com", Pool: "tenants"},
+			{Host: "*.eu.example.com", Pool: "eu-tenants"},
+		},
+		Routes:      []config.Route{{Path: "/api/", Pool: "api"}},
+		DefaultPool: "default",
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	for _, tt := range []struct{ host, path, want string }{
+		{"api.example.com", "/", "api"},
+		{"WWW.example.com:8443", "/", "www"},       // case and port are ignored
+		{"www.example.com", "/api/users", "www"},   // hosts take precedence over paths
+		{"acme.example.com", "/", "tenants"},       // wildcard
+		{"acme.eu.example.com", "/", "eu-tenants"}, // longest wildcard wins
+		{"example.com", "/", "default"},            // wildcards need a subdomain
+		{"other.test", "/", "default"},             // no match falls through
+		{"other.test", "/api/users", "api"},        // to path routing
+	} {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+		if body := w.Body.String(); body != tt.want {
+			t.Errorf("Expected %s%s to be served by %s, got %q", tt.host, tt.path, tt.want, body)
+		}
+	}
+
+	for _, vhosts := range [][]config.VirtualHost{
+		{{Host: "api.example.com", Pool: "missing"}},
+		{{Host: "", Pool: "api"}},
+		{{Host: "api.example.com", Pool: "api"}, {Host: "API.example.com", Pool: "www"}},
+	} {
+		metrics.Reset() // Reset metrics before test
+		if _, err := New(&config.Config{Pools: pools, VirtualHosts: vhosts}, metrics.New()); err == nil {
+			t.Errorf("Expected error for virtual hosts %+v", vhosts)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestHTTP2MaxConcurrentStreams(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			started <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{Backends: []config.Backend{{URL: backend.URL}}}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	server, err := lb.newFrontendServer(config.Frontend{MaxConcurrentStreams: 1})
+	if err != nil {
+		t.Fatalf("Failed to create frontend server: %v", err)
+	}
+
+	frontend := httptest.NewUnstartedServer(server.Handler)
+	frontend.Config = server
+	frontend.EnableHTTP2 = true
+	frontend.StartTLS()
+	defer frontend.Close()
+
+	// A strict client queues streams beyond the server's limit on the same
+	// connection instead of opening another
+	client := &http.Client{Transport: &http2.Transport{
+		TLSClientConfig:            frontend.Client().Transport.(*http.Transport).TLSClientConfig,
+		StrictMaxConcurrentStreams: true,
+	}}
+
+	// The first request completes the settings exchange
+	resp, err := client.Get(frontend.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2, got %s", resp.Proto)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(frontend.URL + "/block")
+			if err != nil {
+				t.Errorf("Request failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", resp.StatusCode)
+			}
+		}()
+	}
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the first stream")
+	}
+	select {
+	case <-started:
+		t.Error("Expected the second stream to wait for the first to finish")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(release)
+	wg.Wait()
+}
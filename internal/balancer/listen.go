@@ -0,0 +1,163 @@
+package balancer
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"loadbalancer/internal/errors"
+)
+
+// maxBindBackoff caps the delay between bind attempts
+const maxBindBackoff = time.Second
+
+// listenWithRetry binds addr, retrying with exponential backoff while the
+// address is in use, for up to timeout. This lets a quick restart ride out a
+// socket still held by the previous process, while a port that stays
+// occupied fails once the timeout expires. Other errors fail immediately.
+func listenWithRetry(ctx context.Context, addr string, timeout, backoff time.Duration) (net.Listener, error) {
+	if backoff <= 0 {
+		backoff = 50 * time.Millisecond
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		ln, err := net.Listen("tcp", addr)
+		if err == nil {
+			return ln, nil
+		}
+		if !stderrors.Is(err, syscall.EADDRINUSE) || time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > maxBindBackoff {
+			backoff = maxBindBackoff
+		}
+	}
+}
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+// inheritedListeners holds listening sockets passed down by a parent
+// process with systemd socket activation. Each is taken at most once, by
+// the server whose address it is bound to.
+type inheritedListeners struct {
+	mu        sync.Mutex
+	listeners []net.Listener
+}
+
+// inheritListeners adopts the sockets passed with the systemd protocol:
+// LISTEN_FDS descriptors starting at 3, meant for the process in LISTEN_PID
+// if that is set. The variables are cleared so child processes do not
+// inherit them too.
+func inheritListeners() (*inheritedListeners, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return &inheritedListeners{}, nil
+	}
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return &inheritedListeners{}, nil
+	}
+
+	files := make([]*os.File, count)
+	for i := range files {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		files[i] = os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+	}
+	return newInheritedListeners(files)
+}
+
+// newInheritedListeners wraps each file as a listener, closing the files
+func newInheritedListeners(files []*os.File) (*inheritedListeners, error) {
+	inherited := &inheritedListeners{}
+	for _, f := range files {
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			inherited.close()
+			return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("inherited file %s is not a listening socket", f.Name()), err)
+		}
+		inherited.listeners = append(inherited.listeners, ln)
+	}
+	return inherited, nil
+}
+
+// take removes and returns the inherited listener bound to addr, or nil if
+// there is none
+func (l *inheritedListeners) take(addr string) net.Listener {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, ln := range l.listeners {
+		if boundTo(ln, addr) {
+			l.listeners = append(l.listeners[:i], l.listeners[i+1:]...)
+			return ln
+		}
+	}
+	return nil
+}
+
+// close closes the listeners no server has taken
+func (l *inheritedListeners) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, ln := range l.listeners {
+		ln.Close()
+	}
+	l.listeners = nil
+}
+
+// boundTo reports whether ln listens on addr. An address without a host
+// matches only a listener bound to all interfaces.
+func boundTo(ln net.Listener, addr string) bool {
+	got, ok := ln.Addr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	want, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil || got.Port != want.Port {
+		return false
+	}
+	if want.IP == nil || want.IP.IsUnspecified() {
+		return got.IP == nil || got.IP.IsUnspecified()
+	}
+	return got.IP.Equal(want.IP)
+}
+
+// listen returns the inherited listener for addr if there is one, and
+// otherwise binds addr, retrying for up to timeout while it is in use
+func (lb *LoadBalancer) listen(ctx context.Context, addr string, timeout, backoff time.Duration) (net.Listener, error) {
+	if ln := lb.inherited.take(addr); ln != nil {
+		lb.logger.Info("using inherited listener", "addr", ln.Addr().String())
+		return ln, nil
+	}
+	return listenWithRetry(ctx, addr, timeout, backoff)
+}
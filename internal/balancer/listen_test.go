@@ -0,0 +1,151 @@
+package balancer
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestListenWithRetry(t *testing.T) {
+	// Simulate a previous process still holding the port
+	holder, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := holder.Addr().String()
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		holder.Close()
+	}()
+
+	ln, err := listenWithRetry(context.Background(), addr, 2*time.Second, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected bind to succeed once the port was released, got %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenWithRetryTimeout(t *testing.T) {
+	holder, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer holder.Close()
+
+	// A port that stays occupied fails once the timeout expires
+	start := time.Now()
+	_, err = listenWithRetry(context.Background(), holder.Addr().String(), 200*time.Millisecond, 10*time.Millisecond)
+	if err == nil {
+		t.Fatal("Expected bind to an occupied port to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected failure shortly after the timeout, took %v", elapsed)
+	}
+
+	// Cancellation stops the retries
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := listenWithRetry(ctx, holder.Addr().String(), time.Minute, 10*time.Millisecond); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestInheritedListener(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	// Simulate a parent process passing down its listening socket. The
+	// duplicate keeps the port bound, so binding it afresh would fail.
+	parent, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := parent.Addr().(*net.TCPAddr).Port
+	file, err := parent.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("Failed to get listener file: %v", err)
+	}
+	parent.Close()
+
+	lb, err := New(&config.Config{
+		Frontends: []config.Frontend{{Port: port}},
+		Backends:  []config.Backend{{URL: backend.URL}},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	if lb.inherited, err = newInheritedListeners([]*os.File{file}); err != nil {
+		t.Fatalf("Failed to inherit listener: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() { errChan <- lb.Start(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-errChan; err != nil {
+			t.Errorf("Start failed: %v", err)
+		}
+	}()
+
+	url := "http://127.0.0.1:" + strconv.Itoa(port) + "/"
+	var resp *http.Response
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if resp, err = http.Get(url); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("Failed to reach frontend on inherited listener: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("Expected backend response, got %d %q", resp.StatusCode, body)
+	}
+	if lb.inherited.take(":"+strconv.Itoa(port)) != nil {
+		t.Error("Expected inherited listener to be taken by the frontend")
+	}
+}
+
+func TestInheritListenersForOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+
+	inherited, err := inheritListeners()
+	if err != nil {
+		t.Fatalf("Expected sockets meant for another process to be ignored, got %v", err)
+	}
+	if len(inherited.listeners) != 0 {
+		t.Errorf("Expected no inherited listeners, got %d", len(inherited.listeners))
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("Expected LISTEN_FDS to be cleared")
+	}
+}
+
+func TestNewLeavesListenFDs(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+
+	metrics.Reset() // Reset metrics before test
+	if _, err := New(&config.Config{}, metrics.New()); err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	if os.Getenv("LISTEN_FDS") != "1" {
+		t.Error("Expected New to leave LISTEN_FDS for Start")
+	}
+}
@@ -0,0 +1,36 @@
+package balancer
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultAllowedMethods is advertised in reply to OPTIONS * when no method
+// list is configured
+var defaultAllowedMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// isServerOptions reports whether r is an asterisk-form "OPTIONS *" request,
+// which asks about the server as a whole rather than any resource
+func isServerOptions(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.RequestURI == "*"
+}
+
+// serveServerOptions answers OPTIONS * without involving a backend
+func (lb *LoadBalancer) serveServerOptions(w http.ResponseWriter) {
+	methods := defaultAllowedMethods
+	if lb.config != nil && len(lb.config.ServerOptions.Allow) > 0 {
+		methods = lb.config.ServerOptions.Allow
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+}
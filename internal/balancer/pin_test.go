@@ -0,0 +1,69 @@
+package balancer
+
+import (
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestPinTraffic(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	backends := []string{"http://localhost:8001", "http://localhost:8002", "http://localhost:8003"}
+	lb, err := New(&config.Config{Backends: config.BackendsFromURLs(backends)}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	if err := lb.PinTraffic(backends[2], 10); err != nil {
+		t.Fatalf("Failed to pin traffic: %v", err)
+	}
+
+	total := 3000
+	selections := make(map[string]int)
+	for i := 0; i < total; i++ {
+		selections[lb.nextBackend().ID]++
+	}
+
+	// 10% pinned plus a third of the remaining 90% via round-robin
+	pinned := float64(selections[backends[2]]) / float64(total)
+	if pinned < 0.38 || pinned > 0.42 {
+		t.Errorf("Expected ~40%% of traffic on pinned backend, got %f", pinned)
+	}
+	for _, id := range backends[:2] {
+		ratio := float64(selections[id]) / float64(total)
+		if ratio < 0.28 || ratio > 0.32 {
+			t.Errorf("Expected ~30%% of traffic on %s, got %f", id, ratio)
+		}
+	}
+
+	// Unpinning restores the normal distribution
+	lb.Unpin()
+	selections = make(map[string]int)
+	for i := 0; i < total; i++ {
+		selections[lb.nextBackend().ID]++
+	}
+	for _, id := range backends {
+		if selections[id] != total/len(backends) {
+			t.Errorf("Expected %d requests to %s after unpin, got %d", total/len(backends), id, selections[id])
+		}
+	}
+}
+
+func TestPinTrafficErrors(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{Backends: []config.Backend{{URL: "http://localhost:8001"}}}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	if err := lb.PinTraffic("http://localhost:9999", 5); err == nil {
+		t.Error("Expected error pinning unknown backend")
+	}
+	if err := lb.PinTraffic("http://localhost:8001", 0); err == nil {
+		t.Error("Expected error for zero percentage")
+	}
+	if err := lb.PinTraffic("http://localhost:8001", 150); err == nil {
+		t.Error("Expected error for percentage above 100")
+	}
+}
@@ -0,0 +1,114 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+
+	"loadbalancer/internal/balancer/algorithm"
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+// sidePool is a set of backends that are pooled and health checked with the
+// main backends but rotate separately, for traffic that is routed to them
+// explicitly. The backends themselves live in lb.backends like any other;
+// selector is guarded by lb.mu.
+type sidePool struct {
+	backends []config.Backend
+	selector algorithm.Selector
+}
+
+// sidePools returns the configured side pools in a fixed order
+func (lb *LoadBalancer) sidePools() []*sidePool {
+	var pools []*sidePool
+	if lb.canary != nil {
+		pools = append(pools, &lb.canary.sidePool)
+	}
+	if lb.large != nil {
+		pools = append(pools, &lb.large.sidePool)
+	}
+	for _, pool := range lb.routePools {
+		pools = append(pools, &pool.sidePool)
+	}
+	return pools
+}
+
+// routePool is a named pool that routes send traffic to
+type routePool struct {
+	sidePool
+	name string
+}
+
+// newRoutePools builds the named pools, checking that every pool the routes
+// and the default pool refer to exists
+func newRoutePools(cfg *config.Config) ([]*routePool, error) {
+	pools := make([]*routePool, 0, len(cfg.Pools))
+	names := make(map[string]bool, len(cfg.Pools))
+	for _, pool := range cfg.Pools {
+		if pool.Name == "" {
+			return nil, errors.New(errors.ErrConfigInvalid, "backend pool without a name", nil)
+		}
+		if names[pool.Name] {
+			return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("duplicate backend pool %q", pool.Name), nil)
+		}
+		if len(pool.Backends) == 0 {
+			return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("backend pool %q has no backends", pool.Name), nil)
+		}
+		names[pool.Name] = true
+		pools = append(pools, &routePool{sidePool: sidePool{backends: pool.Backends}, name: pool.Name})
+	}
+
+	for _, route := range cfg.Routes {
+		if route.Pool != "" && !names[route.Pool] {
+			return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("route %s uses unknown backend pool %q", route.Path, route.Pool), nil)
+		}
+	}
+	if cfg.DefaultPool != "" && !names[cfg.DefaultPool] {
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown default backend pool %q", cfg.DefaultPool), nil)
+	}
+	return pools, nil
+}
+
+// poolFor returns the named pool serving r, or nil if it goes to the main
+// pool. A virtual host matching r takes precedence over route, the route
+// matching r's path or nil if there is none.
+func (lb *LoadBalancer) poolFor(r *http.Request, route *config.Route) *routePool {
+	if len(lb.routePools) == 0 {
+		return nil
+	}
+	if lb.vhosts != nil {
+		if pool := lb.vhosts.poolFor(r.Host); pool != nil {
+			return pool
+		}
+	}
+
+	name := lb.config.DefaultPool
+	if route != nil && route.Pool != "" {
+		name = route.Pool
+	}
+	for _, pool := range lb.routePools {
+		if pool.name == name {
+			return pool
+		}
+	}
+	return nil
+}
+
+// unrouted reports whether requests for the main pool have nowhere to go
+// because only named pools are configured
+func (lb *LoadBalancer) unrouted() bool {
+	if len(lb.routePools) == 0 {
+		return false
+	}
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return len(lb.pool) == 0
+}
+
+// pickSide returns the next available backend in pool for a request with
+// the given client key
+func (lb *LoadBalancer) pickSide(pool *sidePool, key string) *Backend {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.pickFrom(pool.selector, key)
+}
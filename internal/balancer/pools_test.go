@@ -0,0 +1,106 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestRoutePools(t *testing.T) {
+	servers := map[string]string{}
+	for _, name := range []string{"api1", "api2", "static", "main"} {
+		name := name
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer server.Close()
+		servers[name] = server.URL
+	}
+
+	pools := []config.Pool{
+		{Name: "api", Backends: []config.Backend{{URL: servers["api1"]}, {URL: servers["api2"]}}},
+		{Name: "static", Backends: []config.Backend{{URL: servers["static"]}}},
+	}
+	routes := []config.Route{
+		{Path: "/api/", Pool: "api"},
+		{Path: "/static/", Pool: "static"},
+		{Path: "/api/legacy/"}, // longest prefix wins, back to the default pool
+	}
+
+	newBalancer := func(cfg *config.Config) *LoadBalancer {
+		metrics.Reset() // Reset metrics before test
+		cfg.Pools, cfg.Routes = pools, routes
+		lb, err := New(cfg, metrics.New())
+		if err != nil {
+			t.Fatalf("Failed to create load balancer: %v", err)
+		}
+		return lb
+	}
+	get := func(lb *LoadBalancer, path string) (int, string) {
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	// Each pool has its own rotation; unmatched requests use the main pool
+	lb := newBalancer(&config.Config{Backends: []config.Backend{{URL: servers["main"]}}})
+	for _, tt := range []struct{ path, want string }{
+		{"/api/users", "api1"},
+		{"/static/app.js", "static"},
+		{"/api/orders", "api2"},
+		{"/api/legacy/users", "main"},
+		{"/", "main"},
+		{"/api/items", "api1"},
+	} {
+		if _, body := get(lb, tt.path); body != tt.want {
+			t.Errorf("Expected %s to be served by %s, got %q", tt.path, tt.want, body)
+		}
+	}
+
+	// A pool's health is its own: with its backends down it does not spill
+	// over to other pools
+	for _, b := range lb.backends {
+		if b.ID == servers["static"] {
+			b.Healthy.Store(false)
+		}
+	}
+	if status, _ := get(lb, "/static/app.js"); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with the static pool down, got %d", status)
+	}
+
+	// Without main backends unmatched requests get 404...
+	lb = newBalancer(&config.Config{})
+	if status, _ := get(lb, "/"); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unmatched request, got %d", status)
+	}
+	if _, body := get(lb, "/api/users"); body != "api1" {
+		t.Errorf("Expected routed request to be served, got %q", body)
+	}
+
+	// ...unless a default pool is configured
+	lb = newBalancer(&config.Config{DefaultPool: "static"})
+	if _, body := get(lb, "/"); body != "static" {
+		t.Errorf("Expected unmatched request on the default pool, got %q", body)
+	}
+}
+
+func TestRoutePoolsValidation(t *testing.T) {
+	for _, cfg := range []*config.Config{
+		{Pools: []config.Pool{{Backends: []config.Backend{{URL: "http://localhost:8001"}}}}},
+		{Pools: []config.Pool{{Name: "api"}}},
+		{Pools: []config.Pool{
+			{Name: "api", Backends: []config.Backend{{URL: "http://localhost:8001"}}},
+			{Name: "api", Backends: []config.Backend{{URL: "http://localhost:8002"}}},
+		}},
+		{Routes: []config.Route{{Path: "/api/", Pool: "api"}}},
+		{DefaultPool: "api"},
+	} {
+		metrics.Reset() // Reset metrics before test
+		if _, err := New(cfg, metrics.New()); err == nil {
+			t.Errorf("Expected error for pools %+v, routes %+v, default %q", cfg.Pools, cfg.Routes, cfg.DefaultPool)
+		}
+	}
+}
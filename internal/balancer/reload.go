@@ -0,0 +1,151 @@
+package balancer
+
+import (
+	"fmt"
+	"net/url"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+	"loadbalancer/internal/ratelimit"
+)
+
+// Reload applies cfg to the running balancer without dropping connections.
+// The backends are diffed against the main pool: new ones are added,
+// missing ones removed and the weights of the rest updated in place,
+// keeping their health, circuit and connection state. A backend whose
+// health path changed is replaced. The default weight, the rate limiter
+// failure mode and the circuit breaker settings apply to every backend.
+// When backends are discovered, the main pool follows the registry and is
+// left alone. Other settings only take effect on restart.
+//
+// Everything is validated before anything changes, so a failed reload
+// leaves the running configuration untouched. Only backends that are new or
+// replaced are built, under the write lock the pool is swapped under, so a
+// request picks from either the old pool or the new one, never a mix, and
+// requests already forwarded to a removed backend finish while it drains.
+func (lb *LoadBalancer) Reload(cfg *config.Config) error {
+	failureMode, err := ratelimit.ParseFailureMode(cfg.RateLimit.FailureMode)
+	if err != nil {
+		return err
+	}
+	if cfg.Balancing.DefaultWeight < 0 {
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("default weight must be positive, got %d", cfg.Balancing.DefaultWeight), nil)
+	}
+
+	backends := cfg.Backends
+	if lb.discovery != nil {
+		backends = nil
+	}
+
+	// Check every entry up front so invalid ones fail the reload
+	weights := make(map[string]int, len(backends))
+	for _, backend := range backends {
+		weight, err := configuredWeight(backend, cfg.Balancing.DefaultWeight)
+		if err != nil {
+			return err
+		}
+		if _, err := parseBackendURL(backend.URL); err != nil {
+			return err
+		}
+		id := backendID(backend.URL)
+		if _, exists := weights[id]; exists {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("duplicate backend URL %s", backend.URL), nil)
+		}
+		weights[id] = weight
+	}
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	// Weights of the main pool as last applied, by backend ID. A discovered
+	// pool is not the reload's to change.
+	pool := lb.pool
+	if lb.discovery != nil {
+		pool = nil
+	}
+	current := make(map[string]int, len(pool))
+	for _, entry := range pool {
+		weight, _ := configuredWeight(entry, lb.defaultWeight)
+		current[backendID(entry.URL)] = weight
+	}
+	for id := range weights {
+		if _, running := lb.byID[id]; running {
+			if _, main := current[id]; !main {
+				return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("backend %s is already in another pool", id), nil)
+			}
+		}
+	}
+
+	// Build only the backends that are not running yet, or whose settings
+	// changed so they must be replaced
+	fresh := make(map[string]*Backend)
+	for _, backend := range backends {
+		id := backendID(backend.URL)
+		running, exists := lb.byID[id]
+		if exists && running.healthPath == backend.HealthPath && running.maxConns == int64(backend.MaxConns) {
+			continue
+		}
+		b, err := lb.buildBackend(backend.URL)
+		if err != nil {
+			return err
+		}
+		b.healthPath = backend.HealthPath
+		b.maxConns = int64(backend.MaxConns)
+		fresh[id] = b
+	}
+
+	var added, removed, updated int
+	for id := range current {
+		if _, kept := weights[id]; !kept {
+			if b, exists := lb.byID[id]; exists {
+				lb.detachBackend(b)
+				removed++
+			}
+		}
+	}
+	for _, backend := range backends {
+		id := backendID(backend.URL)
+		running, exists := lb.byID[id]
+		b := fresh[id]
+		switch {
+		case !exists:
+			lb.attachBackend(b, weights[id])
+			added++
+		case b != nil:
+			lb.detachBackend(running)
+			lb.attachBackend(b, weights[id])
+			updated++
+		case current[id] != weights[id]:
+			lb.selector.UpdateWeight(id, weights[id])
+			updated++
+		}
+	}
+	if lb.discovery == nil {
+		lb.pool = append([]config.Backend(nil), cfg.Backends...)
+	}
+
+	lb.defaultWeight = cfg.Balancing.DefaultWeight
+	lb.limiterFailureMode = failureMode
+	lb.halfOpenFailures = cfg.CircuitBreaker.HalfOpenFailureThreshold
+	lb.halfOpenSuccesses = halfOpenSuccesses(cfg.CircuitBreaker)
+	lb.countRateLimited.Store(cfg.CircuitBreaker.CountRateLimited)
+	lb.setGRPCFailures(cfg.CircuitBreaker)
+	for _, b := range lb.backends {
+		b.CircuitBreaker.SetHalfOpenFailureThreshold(lb.halfOpenFailures)
+		b.CircuitBreaker.SetHalfOpenMax(lb.halfOpenSuccesses)
+		if setter, ok := b.RateLimiter.(ratelimit.FailureModeSetter); ok {
+			setter.SetFailureMode(failureMode)
+		}
+	}
+
+	lb.logger.Info("configuration reloaded", "added", added, "removed", removed, "updated", updated)
+	return nil
+}
+
+// backendID returns the ID a backend configured with rawURL runs under
+func backendID(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.String()
+	}
+	return rawURL
+}
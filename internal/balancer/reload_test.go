@@ -0,0 +1,147 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"loadbalancer/internal/balancer/algorithm"
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestReload(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{
+			{URL: "http://localhost:8001", Weight: 1},
+			{URL: "http://localhost:8002", Weight: 1},
+			{URL: "http://localhost:8003"},
+		},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	kept := lb.byID["http://localhost:8001"]
+	kept.TotalRequests.Add(5)
+
+	weight := func(id string) int {
+		t.Helper()
+		weight, _, ok := lb.selector.(algorithm.WeightAdjuster).Weights(id)
+		if !ok {
+			t.Fatalf("Expected %s to be in rotation", id)
+		}
+		return weight
+	}
+
+	err = lb.Reload(&config.Config{
+		Backends: []config.Backend{
+			{URL: "http://localhost:8001", Weight: 4},
+			{URL: "http://localhost:8003"},
+			{URL: "http://localhost:8004"},
+		},
+		Balancing:      config.Balancing{DefaultWeight: 2},
+		CircuitBreaker: config.CircuitBreaker{CountRateLimited: true},
+	})
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if len(lb.backends) != 3 {
+		t.Errorf("Expected 3 backends after reload, got %d", len(lb.backends))
+	}
+	if _, exists := lb.byID["http://localhost:8002"]; exists {
+		t.Error("Expected removed backend to be gone")
+	}
+	if lb.byID["http://localhost:8001"] != kept || kept.TotalRequests.Load() != 5 {
+		t.Error("Expected kept backend to be updated in place")
+	}
+	for id, want := range map[string]int{
+		"http://localhost:8001": 4,
+		"http://localhost:8003": 2, // new default weight
+		"http://localhost:8004": 2,
+	} {
+		if got := weight(id); got != want {
+			t.Errorf("Expected %s to have weight %d, got %d", id, want, got)
+		}
+	}
+	if !lb.countRateLimited.Load() {
+		t.Error("Expected circuit breaker settings to be reloaded")
+	}
+	if len(lb.poolSnapshot()) != 3 {
+		t.Errorf("Expected pool to list 3 backends, got %v", lb.poolSnapshot())
+	}
+
+	// A failed reload leaves everything as it was
+	for i, cfg := range []*config.Config{
+		{Backends: []config.Backend{{URL: "not-a-valid-url"}}},
+		{Backends: []config.Backend{{URL: "http://localhost:8001"}, {URL: "http://localhost:8001"}}},
+		{Backends: []config.Backend{{URL: "http://localhost:8001", Weight: -1}}},
+		{RateLimit: config.RateLimit{FailureMode: "sometimes"}},
+	} {
+		if err := lb.Reload(cfg); err == nil {
+			t.Errorf("Expected invalid reload %d to fail", i)
+		}
+	}
+	if len(lb.backends) != 3 || weight("http://localhost:8001") != 4 || !lb.countRateLimited.Load() {
+		t.Error("Expected failed reloads to leave the running configuration untouched")
+	}
+}
+
+func TestReloadUnderLoad(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var urls []string
+	for i := 0; i < 4; i++ {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer backend.Close()
+		urls = append(urls, backend.URL)
+	}
+	configs := []*config.Config{
+		{Backends: []config.Backend{{URL: urls[0]}, {URL: urls[1], Weight: 3}}},
+		{Backends: []config.Backend{{URL: urls[1]}, {URL: urls[2], HealthPath: "/ready"}, {URL: urls[3], MaxConns: 100}}},
+		{Backends: []config.Backend{{URL: urls[3], Weight: 2}}},
+	}
+
+	lb, err := New(configs[0], metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// Requests running across reloads must always find a complete pool
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	var served, failed atomic.Int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				rec := httptest.NewRecorder()
+				lb.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+				if rec.Code == http.StatusOK {
+					served.Add(1)
+				} else {
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		if err := lb.Reload(configs[i%len(configs)]); err != nil {
+			t.Errorf("Reload %d failed: %v", i, err)
+		}
+	}
+	cancel()
+	wg.Wait()
+
+	if failed.Load() > 0 {
+		t.Errorf("Expected every request to be served across reloads, %d of %d failed", failed.Load(), served.Load()+failed.Load())
+	}
+}
@@ -0,0 +1,78 @@
+package balancer
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"syscall"
+)
+
+// retryAttempt is attached to the context of a proxied request that may be
+// retried. A retryable failure is recorded here instead of being written to
+// the client, so the balancer can try another backend.
+type retryAttempt struct {
+	err error
+}
+
+type retryAttemptKey struct{}
+
+// withRetryAttempt returns a copy of r carrying attempt
+func withRetryAttempt(r *http.Request, attempt *retryAttempt) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), retryAttemptKey{}, attempt))
+}
+
+// retryAttemptFrom returns the retry attempt carried by ctx, if any
+func retryAttemptFrom(ctx context.Context) *retryAttempt {
+	attempt, _ := ctx.Value(retryAttemptKey{}).(*retryAttempt)
+	return attempt
+}
+
+// upstreamStatusError reports a backend response whose status is worth
+// retrying on another backend
+type upstreamStatusError struct {
+	status int
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("backend returned %d", e.status)
+}
+
+// checkRetryableResponse runs from the proxy's ModifyResponse hook. For
+// requests that may be retried it turns 502, 503 and 504 responses into errors so
+// that they reach proxyErrorHandler before anything is sent to the client.
+func (lb *LoadBalancer) checkRetryableResponse(resp *http.Response) error {
+	if retryAttemptFrom(resp.Request.Context()) == nil {
+		return nil
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &upstreamStatusError{status: resp.StatusCode}
+	}
+	return nil
+}
+
+// isRetryable reports whether a proxy error is worth retrying on another
+// backend: the backend refused the connection or answered 502, 503 or 504
+func isRetryable(err error) bool {
+	var status *upstreamStatusError
+	return stderrors.Is(err, syscall.ECONNREFUSED) || stderrors.As(err, &status)
+}
+
+// retryableMethod reports whether requests with method are safe to send
+// again
+func retryableMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// maxRetries returns how many times r may be retried on another backend
+func (lb *LoadBalancer) maxRetries(r *http.Request) int {
+	if lb.config == nil || lb.config.MaxRetries <= 0 || !retryableMethod(r.Method) {
+		return 0
+	}
+	return lb.config.MaxRetries
+}
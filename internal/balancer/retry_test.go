@@ -0,0 +1,95 @@
+package balancer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestRetryOnNextBackend(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	// A closed server refuses connections
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("ok " + string(body)))
+	}))
+	defer healthy.Close()
+
+	lb, err := New(&config.Config{
+		Backends:   config.BackendsFromURLs([]string{unavailable.URL, refused.URL, healthy.URL}),
+		MaxRetries: 2,
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	m := metrics.New()
+
+	// A 503 and a refused connection are both retried, and the body is
+	// replayed to the backend that finally serves the request
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("PUT", "/", strings.NewReader("payload")))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if body := w.Body.String(); body != "ok payload" {
+		t.Errorf("Expected replayed body to reach the healthy backend, got %q", body)
+	}
+	if retries := testutil.ToFloat64(m.RetriesTotal); retries != 2 {
+		t.Errorf("Expected 2 retries, got %v", retries)
+	}
+
+	// Non-idempotent requests are never retried; the rotation is back on
+	// the unavailable backend
+	w = httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("payload")))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected POST to get the backend's %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if retries := testutil.ToFloat64(m.RetriesTotal); retries != 2 {
+		t.Errorf("Expected POST not to be retried, got %v retries", retries)
+	}
+}
+
+func TestRetryWithoutAlternative(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	lb, err := New(&config.Config{
+		Backends:   []config.Backend{{URL: unavailable.URL}},
+		MaxRetries: 3,
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// With no other backend to try the client gets the failure once
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if body := w.Body.String(); body != "Backend error\n" {
+		t.Errorf("Expected a single error reply, got %q", body)
+	}
+	if retries := testutil.ToFloat64(metrics.New().RetriesTotal); retries != 0 {
+		t.Errorf("Expected no retries, got %v", retries)
+	}
+}
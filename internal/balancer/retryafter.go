@@ -0,0 +1,42 @@
+package balancer
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"loadbalancer/internal/ratelimit"
+)
+
+// setRetryAfter suggests when a client rejected by backend's rate limiter
+// should retry: no sooner than the limiter will have room, and, with
+// backoff enabled, later each time the same client is rejected
+func (lb *LoadBalancer) setRetryAfter(w http.ResponseWriter, r *http.Request, backend *Backend) {
+	var wait time.Duration
+	if advisor, ok := backend.RateLimiter.(ratelimit.RetryAdvisor); ok {
+		wait = advisor.RetryAfter()
+	}
+	if lb.backoff != nil {
+		if backoff := lb.backoff.Next(clientHost(r)); backoff > wait {
+			wait = backoff
+		}
+	}
+	if wait <= 0 {
+		return
+	}
+	// Retry-After takes whole seconds; round up so clients never come back
+	// early
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// clientHost returns the address of the client that sent r, without the
+// port
+func clientHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
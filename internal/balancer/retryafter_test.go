@@ -0,0 +1,134 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"loadbalancer/internal/circuitbreaker"
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+	"loadbalancer/internal/ratelimit"
+)
+
+func TestRetryAfterBackoff(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends:  []config.Backend{{URL: backend.URL}},
+		RateLimit: config.RateLimit{RetryAfterBackoff: true},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	// One request, then every request is over the limit until the bucket
+	// refills a second later
+	lb.backends[0].RateLimiter = ratelimit.New(ratelimit.Config{Rate: 1, Capacity: 1})
+
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("10.0.0.1:1234"); w.Code != http.StatusOK || w.Header().Get("Retry-After") != "" {
+		t.Fatalf("Expected first request to succeed without Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Stay below the circuit breaker threshold, which rejections count towards
+	var waits []int
+	for i := 0; i < 4; i++ {
+		w := send("10.0.0.1:1234")
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+		}
+		seconds, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		if err != nil {
+			t.Fatalf("Expected Retry-After in seconds, got %q", w.Header().Get("Retry-After"))
+		}
+		waits = append(waits, seconds)
+	}
+	for i := 1; i < len(waits); i++ {
+		if waits[i] < waits[i-1] {
+			t.Errorf("Expected Retry-After to grow, got %v", waits)
+		}
+	}
+	if waits[3] <= waits[0] {
+		t.Errorf("Expected repeated rejections to back off further, got %v", waits)
+	}
+}
+
+func TestRetryAfterFromLimiter(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{Backends: []config.Backend{{URL: backend.URL}}}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	// A token every four seconds, so the next is at most four seconds away
+	lb.backends[0].RateLimiter = ratelimit.WithFailureMode(ratelimit.New(ratelimit.Config{Rate: 0.25, Capacity: 1}), ratelimit.FailOpen)
+
+	lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "4" {
+		t.Errorf("Expected Retry-After of 4 seconds, got %q", got)
+	}
+}
+
+func TestBackendRateLimitRelayed(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.Header().Set("X-RateLimit-Limit", "10")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("slow down"))
+	}))
+	defer backend.Close()
+
+	for _, countRateLimited := range []bool{false, true} {
+		metrics.Reset() // Reset metrics before test
+		lb, err := New(&config.Config{
+			Backends:       []config.Backend{{URL: backend.URL}},
+			CircuitBreaker: config.CircuitBreaker{CountRateLimited: countRateLimited},
+		}, metrics.New())
+		if err != nil {
+			t.Fatalf("Failed to create load balancer: %v", err)
+		}
+
+		// Stay at the circuit breaker threshold
+		for i := 0; i < 5; i++ {
+			w := httptest.NewRecorder()
+			lb.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			if w.Code != http.StatusTooManyRequests || w.Body.String() != "slow down" {
+				t.Fatalf("Expected the backend's 429 to be relayed, got %d %q", w.Code, w.Body.String())
+			}
+			for name, want := range map[string]string{"Retry-After": "7", "X-RateLimit-Limit": "10", "X-RateLimit-Remaining": "0"} {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("Expected %s %q, got %q", name, want, got)
+				}
+			}
+		}
+
+		open := lb.backends[0].CircuitBreaker.GetState() == circuitbreaker.StateOpen
+		if open != countRateLimited {
+			t.Errorf("countRateLimited %v: expected circuit open to be %v", countRateLimited, countRateLimited)
+		}
+	}
+}
@@ -2,16 +2,49 @@ package balancer
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
 )
 
 // RolloutConfig defines the configuration for a rollout
 type RolloutConfig struct {
 	NewBackends []string
 	BatchSize   int
-	Interval    time.Duration
+	// Interval is how often a new batch is health checked while waiting for
+	// it to come up, 1s by default
+	Interval time.Duration
+	// HealthTimeout is how long a batch may take to pass its health checks
+	// before the rollout is abandoned and the previous backends restored,
+	// 2 minutes by default
+	HealthTimeout time.Duration
+	// ErrorThreshold is the error ratio, from 0 to 1, of requests to the
+	// backends rolled out so far above which the previous backends are
+	// restored; 0.05 by default, and negative to turn the check off.
+	// Requests are counted as the per-backend requests metric records them,
+	// with 5xx statuses, including the 502 for a failed connection, as
+	// errors. Only requests since the current batch went live are counted.
+	// It is checked every Interval while a batch comes up and for
+	// SampleWindow after it is healthy.
+	ErrorThreshold float64
+	// MinRequests is how many requests the backends rolled out so far must
+	// have served since the current batch went live before their error
+	// ratio is judged, 5 by default
+	MinRequests int
+	// SampleWindow is how long each healthy batch keeps serving, with its
+	// error ratio watched, before the next batch is added. Zero moves on
+	// as soon as the batch is healthy.
+	SampleWindow time.Duration
 }
 
 // RollbackConfig defines the configuration for a rollback
@@ -19,117 +52,479 @@ type RollbackConfig struct {
 	PreviousBackends []string
 	BatchSize        int
 	Interval         time.Duration
+	HealthTimeout    time.Duration
+	ErrorThreshold   float64
+	MinRequests      int
+	SampleWindow     time.Duration
 }
 
-// Rollout performs a gradual rollout of new backends
+const (
+	defaultRolloutInterval      = time.Second
+	defaultRolloutHealthTimeout = 2 * time.Minute
+	// defaultErrorThreshold is the error ratio above which rollouts and
+	// canaries are rolled back
+	defaultErrorThreshold = 0.05
+	// defaultMinRequests is how many requests an error ratio needs before
+	// it is trusted. It matches the circuit breaker threshold, so backends
+	// that fail until their circuit opens are always judged.
+	defaultMinRequests = 5
+)
+
+// errElevatedErrors reports backends failing too many requests to keep
+var errElevatedErrors = stderrors.New("error ratio too high")
+
+// Rollout performs a gradual rollout of new backends. Each batch must pass
+// its health checks before the next is added; if it does not within the
+// health timeout, or the new backends' error ratio exceeds the threshold,
+// the previous backends are restored. Only one rollout or rollback runs at
+// a time; its progress is reported by RolloutStatus.
 func (lb *LoadBalancer) Rollout(ctx context.Context, config RolloutConfig) error {
 	if len(config.NewBackends) == 0 {
 		return fmt.Errorf("no new backends provided for rollout")
 	}
+	return lb.rollBatches(ctx, "rollout", config, "complete")
+}
 
-	if config.BatchSize <= 0 {
-		config.BatchSize = 1
+// Rollback reverts to a previous backend configuration, batch by batch as
+// Rollout does
+func (lb *LoadBalancer) Rollback(ctx context.Context, config RollbackConfig) error {
+	if len(config.PreviousBackends) == 0 {
+		return fmt.Errorf("no previous backends provided for rollback")
 	}
+	return lb.rollBatches(ctx, "rollback", RolloutConfig{
+		NewBackends:    config.PreviousBackends,
+		BatchSize:      config.BatchSize,
+		Interval:       config.Interval,
+		HealthTimeout:  config.HealthTimeout,
+		ErrorThreshold: config.ErrorThreshold,
+		MinRequests:    config.MinRequests,
+		SampleWindow:   config.SampleWindow,
+	}, "rolled-back")
+}
 
+// rollBatches moves the main pool to config.NewBackends a batch at a time,
+// waiting for each batch to become healthy before adding the next. The
+// pool is restored if a batch fails to apply or to become healthy, if
+// the backends rolled out so far fail too many requests, which ends the
+// rollout as "aborted", or if ctx is cancelled, which ends it as
+// "cancelled". Progress is recorded in lb.rolloutState, ending in the done
+// phase.
+func (lb *LoadBalancer) rollBatches(ctx context.Context, kind string, config RolloutConfig, done string) error {
+	if config.ErrorThreshold > 1 {
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("%s error threshold must be at most 1, got %v", kind, config.ErrorThreshold), nil)
+	}
+	urls, batchSize := config.NewBackends, config.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
 	if config.Interval <= 0 {
-		config.Interval = 30 * time.Second
+		config.Interval = defaultRolloutInterval
+	}
+	if config.HealthTimeout <= 0 {
+		config.HealthTimeout = defaultRolloutHealthTimeout
+	}
+	if config.ErrorThreshold == 0 {
+		config.ErrorThreshold = defaultErrorThreshold
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = defaultMinRequests
 	}
 
-	// Store current backends for potential rollback
-	lb.mu.RLock()
-	oldBackends := make([]string, len(lb.backends))
-	for i, b := range lb.backends {
-		oldBackends[i] = b.URL.String()
+	state := lb.rolloutState
+	if err := state.start(); err != nil {
+		return err
 	}
-	lb.mu.RUnlock()
 
-	// Perform rollout in batches
-	for i := 0; i < len(config.NewBackends); i += config.BatchSize {
+	// Store current backends to restore on failure
+	previous := lb.poolSnapshot()
+	abandon := func(err error) error {
+		_ = lb.updateBackends(previous)
+		phase := "failed"
+		if stderrors.Is(err, errElevatedErrors) {
+			phase = "aborted"
+		}
+		err = fmt.Errorf("%s failed: %w", kind, err)
+		state.finish(phase, err)
+		return err
+	}
+	cancel := func() error {
+		_ = lb.updateBackends(previous)
+		state.finish("cancelled", ctx.Err())
+		return ctx.Err()
+	}
+
+	batches := (len(urls) + batchSize - 1) / batchSize
+	for batch := 1; batch <= batches; batch++ {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return cancel()
 		default:
-			end := i + config.BatchSize
-			if end > len(config.NewBackends) {
-				end = len(config.NewBackends)
-			}
+		}
 
-			// Replace backends with current batch
-			batch := make([]string, end)
-			copy(batch, config.NewBackends[:end])
+		// Each batch replaces the pool with the first batch*batchSize URLs
+		start, end := (batch-1)*batchSize, min(batch*batchSize, len(urls))
+		if err := lb.updateBackendURLs(append([]string(nil), urls[:end]...)); err != nil {
+			return abandon(err)
+		}
+		phase := fmt.Sprintf("batch %d/%d", batch, batches)
+		state.update(phase, 100*float64(batch-1)/float64(batches), nil)
 
-			if err := lb.updateBackends(batch); err != nil {
-				// Rollback on error
-				_ = lb.updateBackends(oldBackends)
-				return fmt.Errorf("rollout failed: %v", err)
+		rolledOut := urls[:end]
+		since := lb.outcomeCounts(rolledOut)
+		checkErrors := func() error {
+			if config.ErrorThreshold < 0 {
+				return nil
 			}
-
-			// Wait for health checks to stabilize
-			time.Sleep(config.Interval)
+			return lb.checkErrorRatio(rolledOut, since, config.ErrorThreshold, config.MinRequests)
 		}
+		err := lb.awaitHealthy(ctx, urls[start:end], config.Interval, config.HealthTimeout, checkErrors)
+		if err == nil {
+			err = watchErrors(ctx, config.SampleWindow, config.Interval, checkErrors)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return cancel()
+			}
+			return abandon(fmt.Errorf("%s: %w", phase, err))
+		}
+		state.update(phase, 100*float64(batch)/float64(batches), nil)
 	}
 
+	state.finish(done, nil)
 	return nil
 }
 
-// Rollback reverts to a previous backend configuration
-func (lb *LoadBalancer) Rollback(ctx context.Context, config RollbackConfig) error {
-	if len(config.PreviousBackends) == 0 {
-		return fmt.Errorf("no previous backends provided for rollback")
+// awaitHealthy probes the backends at urls every interval until all of them
+// pass in the same round, giving up after timeout or as soon as check fails
+func (lb *LoadBalancer) awaitHealthy(ctx context.Context, urls []string, interval, timeout time.Duration, check func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := check(); err != nil {
+			return err
+		}
+		var unhealthy []string
+		for _, u := range urls {
+			healthy, err := lb.ProbeBackend(ctx, backendID(u))
+			if err != nil {
+				return err
+			}
+			if !healthy {
+				unhealthy = append(unhealthy, u)
+			}
+		}
+		if len(unhealthy) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("backends not healthy after %v: %s", timeout, strings.Join(unhealthy, ", "))
+		case <-ticker.C:
+		}
 	}
+}
 
-	if config.BatchSize <= 0 {
-		config.BatchSize = 1
+// watchErrors runs check every interval for window, returning its first
+// failure
+func watchErrors(ctx context.Context, window, interval time.Duration, check func() error) error {
+	if window <= 0 {
+		return check()
 	}
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := check(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return check()
+		case <-ticker.C:
+		}
+	}
+}
 
+// CanaryConfig defines the configuration for a canary rollout
+type CanaryConfig struct {
+	NewBackends []string
+	// Percent is the share of requests sent to the new backends while they
+	// are watched, in (0, 100)
+	Percent float64
+	// ErrorThreshold is the error ratio of the new backends, from 0 to 1,
+	// above which the canary is rolled back; 0.05 by default
+	ErrorThreshold float64
+	// MinRequests is how many requests the new backends must have served
+	// before their error ratio is judged, 5 by default
+	MinRequests int
+	// Duration is how long the new backends are watched before they are
+	// promoted to take all traffic, 5 minutes by default
+	Duration time.Duration
+	// Interval is how often their error ratio is checked, 1s by default
+	Interval time.Duration
+}
+
+const defaultCanaryDuration = 5 * time.Minute
+
+// Canary adds the new backends to the main pool with weights that send them
+// config.Percent of its requests, then watches their error ratio from then
+// on. If it exceeds the threshold once they have served enough requests, or ctx is cancelled, the
+// previous backends are restored; otherwise after config.Duration the new
+// backends replace the old ones. It shares RolloutStatus and the one at a
+// time rule with Rollout.
+func (lb *LoadBalancer) Canary(ctx context.Context, config CanaryConfig) error {
+	if len(config.NewBackends) == 0 {
+		return fmt.Errorf("no new backends provided for canary")
+	}
+	if config.Percent <= 0 || config.Percent >= 100 {
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("canary percentage must be in (0, 100), got %v", config.Percent), nil)
+	}
+	if config.ErrorThreshold < 0 || config.ErrorThreshold > 1 {
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("canary error threshold must be in [0, 1], got %v", config.ErrorThreshold), nil)
+	}
+	if config.ErrorThreshold == 0 {
+		config.ErrorThreshold = defaultErrorThreshold
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = defaultMinRequests
+	}
+	if config.Duration <= 0 {
+		config.Duration = defaultCanaryDuration
+	}
 	if config.Interval <= 0 {
-		config.Interval = 30 * time.Second
+		config.Interval = defaultRolloutInterval
 	}
 
-	// Store current backends in case rollback fails
-	lb.mu.RLock()
-	currentBackends := make([]string, len(lb.backends))
-	for i, b := range lb.backends {
-		currentBackends[i] = b.URL.String()
+	state := lb.rolloutState
+	if err := state.start(); err != nil {
+		return err
 	}
+
+	previous := lb.poolSnapshot()
+	lb.mu.RLock()
+	pool, weight, err := canaryWeights(previous, config.NewBackends, config.Percent, lb.defaultWeight)
 	lb.mu.RUnlock()
+	if err == nil {
+		err = lb.updateBackends(pool)
+	}
+	if err != nil {
+		err = fmt.Errorf("canary failed: %v", err)
+		state.finish("failed", err)
+		return err
+	}
+	state.setCanaryWeight(weight)
+	since := lb.outcomeCounts(config.NewBackends)
+
+	rollback := func(phase string, err error) error {
+		_ = lb.updateBackends(previous)
+		state.setCanaryWeight(0)
+		state.finish(phase, err)
+		return err
+	}
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	started := time.Now()
+	for {
+		if err := lb.checkErrorRatio(config.NewBackends, since, config.ErrorThreshold, config.MinRequests); err != nil {
+			return rollback("rolled-back", fmt.Errorf("canary failed: %w", err))
+		}
+		elapsed := time.Since(started)
+		if elapsed >= config.Duration {
+			break
+		}
+		state.update("canary", 100*float64(elapsed)/float64(config.Duration), nil)
 
-	// Perform rollback in batches
-	for i := 0; i < len(config.PreviousBackends); i += config.BatchSize {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			end := i + config.BatchSize
-			if end > len(config.PreviousBackends) {
-				end = len(config.PreviousBackends)
-			}
+			return rollback("cancelled", ctx.Err())
+		case <-ticker.C:
+		}
+	}
 
-			// Replace backends with current batch
-			batch := make([]string, end)
-			copy(batch, config.PreviousBackends[:end])
+	if err := lb.updateBackendURLs(config.NewBackends); err != nil {
+		return rollback("failed", fmt.Errorf("canary promotion failed: %v", err))
+	}
+	state.setCanaryWeight(100)
+	state.finish("promoted", nil)
+	return nil
+}
 
-			if err := lb.updateBackends(batch); err != nil {
-				// Attempt to restore current configuration
-				_ = lb.updateBackends(currentBackends)
-				return fmt.Errorf("rollback failed: %v", err)
-			}
+// canaryWeights returns the main pool with the canary backends added, weighted
+// so that they take percent of the requests, and the canary share of the
+// total weight actually achieved. The current weights are scaled up as
+// needed, since weights are whole numbers.
+func canaryWeights(current []config.Backend, canaries []string, percent float64, defaultWeight int) ([]config.Backend, float64, error) {
+	if len(current) == 0 {
+		return nil, 0, errors.New(errors.ErrConfigInvalid, "canary needs backends in the main pool", nil)
+	}
+	weights := make([]int, len(current))
+	total := 0
+	for i, backend := range current {
+		weight, err := configuredWeight(backend, defaultWeight)
+		if err != nil {
+			return nil, 0, err
+		}
+		weights[i] = weight
+		total += weight
+	}
+
+	// Each canary gets percent*total and every current weight is scaled by
+	// the canaries' share of the rest, so the split is percent:100-percent
+	canaryWeight := max(1, int(math.Round(percent*float64(total))))
+	scale := len(canaries) * max(1, int(math.Round(100-percent)))
+	divisor := gcd(canaryWeight, scale)
+	canaryWeight, scale = canaryWeight/divisor, scale/divisor
 
-			// Wait for health checks to stabilize
-			time.Sleep(config.Interval)
+	pool := make([]config.Backend, 0, len(current)+len(canaries))
+	for i, backend := range current {
+		backend.Weight = weights[i] * scale
+		pool = append(pool, backend)
+	}
+	for _, u := range canaries {
+		pool = append(pool, config.Backend{URL: u, Weight: canaryWeight})
+	}
+	canaryTotal := float64(canaryWeight * len(canaries))
+	return pool, 100 * canaryTotal / (canaryTotal + float64(total*scale)), nil
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// outcomeCount is a count of the requests a backend has served and how
+// many of them failed
+type outcomeCount struct {
+	requests, failures uint64
+}
+
+// backendOutcomes totals the per-backend requests metric by backend URL,
+// counting 5xx statuses as failures
+func (lb *LoadBalancer) backendOutcomes() map[string]outcomeCount {
+	collected := make(chan prometheus.Metric)
+	go func() {
+		lb.metrics.RequestsByBackend.Collect(collected)
+		close(collected)
+	}()
+
+	counts := make(map[string]outcomeCount)
+	for metric := range collected {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			continue
+		}
+		var backend string
+		var status int
+		for _, label := range m.GetLabel() {
+			switch label.GetName() {
+			case "backend_url":
+				backend = label.GetValue()
+			case "status":
+				status, _ = strconv.Atoi(label.GetValue())
+			}
+		}
+		n := uint64(m.GetCounter().GetValue())
+		count := counts[backend]
+		count.requests += n
+		if status >= 500 {
+			count.failures += n
 		}
+		counts[backend] = count
 	}
+	return counts
+}
+
+// outcomeCounts records how many requests the backends at urls have served
+// so far, so that checkErrorRatio can judge only the ones that follow
+func (lb *LoadBalancer) outcomeCounts(urls []string) map[string]outcomeCount {
+	all := lb.backendOutcomes()
+	counts := make(map[string]outcomeCount, len(urls))
+	for _, u := range urls {
+		counts[backendID(u)] = all[backendID(u)]
+	}
+	return counts
+}
 
+// checkErrorRatio fails with errElevatedErrors if the backends at urls
+// failed more than threshold of the requests they served since the counts
+// in since were taken. Fewer than minRequests requests are not judged.
+func (lb *LoadBalancer) checkErrorRatio(urls []string, since map[string]outcomeCount, threshold float64, minRequests int) error {
+	if ratio, requests := lb.errorRatio(urls, since); requests >= minRequests && requests > 0 && ratio > threshold {
+		return fmt.Errorf("%w: %.3f of %d requests failed, above %.3f", errElevatedErrors, ratio, requests, threshold)
+	}
 	return nil
 }
 
-// RolloutState tracks the state of ongoing rollouts
+// errorRatio returns the share of failed requests to the backends at urls
+// since the counts in since were taken, and how many requests that covers.
+// A backend whose series were dropped since then, having left the pool, is
+// counted from zero.
+func (lb *LoadBalancer) errorRatio(urls []string, since map[string]outcomeCount) (float64, int) {
+	current := lb.backendOutcomes()
+
+	var requests, failures uint64
+	for _, u := range urls {
+		now, base := current[backendID(u)], since[backendID(u)]
+		if now.requests < base.requests || now.failures < base.failures {
+			base = outcomeCount{}
+		}
+		requests += now.requests - base.requests
+		failures += now.failures - base.failures
+	}
+	if requests == 0 {
+		return 0, 0
+	}
+	return float64(failures) / float64(requests), int(requests)
+}
+
+// poolSnapshot returns a copy of the main pool configuration
+func (lb *LoadBalancer) poolSnapshot() []config.Backend {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return append([]config.Backend(nil), lb.pool...)
+}
+
+// updateBackendURLs replaces the main pool with urls at default settings
+func (lb *LoadBalancer) updateBackendURLs(urls []string) error {
+	return lb.updateBackends(config.BackendsFromURLs(urls))
+}
+
+// RolloutState tracks the state of ongoing rollouts. Progress is the
+// percentage of batches applied.
 type RolloutState struct {
 	InProgress bool
 	Phase      string
 	Progress   float64
 	Error      error
-	mu         sync.RWMutex
+	// CanaryWeight is the percentage of the main pool's weight held by the
+	// backends of a canary rollout
+	CanaryWeight float64
+	mu           sync.RWMutex
+}
+
+// start marks a rollout as begun, failing if one already is
+func (rs *RolloutState) start() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.InProgress {
+		return errors.New(errors.ErrRolloutInProgress, fmt.Sprintf("a rollout is already in progress (%s)", rs.Phase), nil)
+	}
+	rs.InProgress = true
+	rs.Phase = "starting"
+	rs.Progress = 0
+	rs.Error = nil
+	rs.CanaryWeight = 0
+	return nil
 }
 
 func (rs *RolloutState) update(phase string, progress float64, err error) {
@@ -140,8 +535,47 @@ func (rs *RolloutState) update(phase string, progress float64, err error) {
 	rs.Error = err
 }
 
-func (rs *RolloutState) getStatus() (string, float64, error) {
-	rs.mu.RLock()
-	defer rs.mu.RUnlock()
-	return rs.Phase, rs.Progress, rs.Error
+func (rs *RolloutState) setCanaryWeight(weight float64) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.CanaryWeight = weight
+}
+
+// finish records how a rollout ended and allows the next one
+func (rs *RolloutState) finish(phase string, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.InProgress = false
+	rs.Phase = phase
+	if err == nil {
+		rs.Progress = 100
+	}
+	rs.Error = err
+}
+
+// RolloutStatus is the admin API's view of the current or last rollout
+type RolloutStatus struct {
+	InProgress   bool    `json:"inProgress"`
+	Phase        string  `json:"phase,omitempty"`
+	Progress     float64 `json:"progress"`
+	Error        string  `json:"error,omitempty"`
+	CanaryWeight float64 `json:"canaryWeight"`
+}
+
+// RolloutStatus reports the progress of the running rollout or rollback,
+// or how the last one ended
+func (lb *LoadBalancer) RolloutStatus() RolloutStatus {
+	state := lb.rolloutState
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	status := RolloutStatus{
+		InProgress:   state.InProgress,
+		Phase:        state.Phase,
+		Progress:     state.Progress,
+		CanaryWeight: state.CanaryWeight,
+	}
+	if state.Error != nil {
+		status.Error = state.Error.Error()
+	}
+	return status
 }
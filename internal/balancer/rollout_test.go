@@ -2,12 +2,20 @@ package balancer
 
 import (
 	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
 	"loadbalancer/internal/metrics"
 )
 
@@ -39,7 +47,7 @@ func TestRollout(t *testing.T) {
 
 	// Create load balancer with initial backends
 	lb, err := New(&config.Config{
-		Backends: urls[:2], // Start with 2 backends
+		Backends: config.BackendsFromURLs(urls[:2]), // Start with 2 backends
 	}, metrics.New())
 	if err != nil {
 		t.Fatalf("Failed to create load balancer: %v", err)
@@ -95,7 +103,7 @@ func TestRolloutErrors(t *testing.T) {
 
 	// Create load balancer
 	lb, err := New(&config.Config{
-		Backends: []string{server.URL},
+		Backends: []config.Backend{{URL: server.URL}},
 	}, metrics.New())
 	if err != nil {
 		t.Fatalf("Failed to create load balancer: %v", err)
@@ -148,7 +156,7 @@ func TestRolloutConcurrency(t *testing.T) {
 
 	// Create load balancer
 	lb, err := New(&config.Config{
-		Backends: urls[:2],
+		Backends: config.BackendsFromURLs(urls[:2]),
 	}, metrics.New())
 	if err != nil {
 		t.Fatalf("Failed to create load balancer: %v", err)
@@ -189,3 +197,620 @@ func TestRolloutConcurrency(t *testing.T) {
 		t.Errorf("Expected 2 backends after rollout, got %d", len(lb.backends))
 	}
 }
+
+func TestRolloutStatus(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	servers, urls := setupTestBackends(t, 2)
+	defer func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}()
+	// The last backend fails its health checks until ready
+	var ready atomic.Bool
+	starting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer starting.Close()
+	urls = append(urls, starting.URL)
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs(urls[:1]),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	admin := httptest.NewServer(lb.adminHandler())
+	defer admin.Close()
+
+	getStatus := func() RolloutStatus {
+		t.Helper()
+		resp, err := http.Get(admin.URL + "/rollout")
+		if err != nil {
+			t.Fatalf("GET /rollout failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var status RolloutStatus
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatalf("Failed to decode rollout status: %v", err)
+		}
+		return status
+	}
+
+	if status := getStatus(); status.InProgress || status.Phase != "" {
+		t.Errorf("Expected no rollout yet, got %+v", status)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- lb.Rollout(context.Background(), RolloutConfig{
+			NewBackends: urls[1:],
+			BatchSize:   1,
+			Interval:    10 * time.Millisecond,
+		})
+	}()
+
+	// The first batch is healthy at once; the second waits on its backend
+	deadline := time.Now().Add(5 * time.Second)
+	status := getStatus()
+	for status.Phase != "batch 2/2" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		status = getStatus()
+	}
+	if !status.InProgress || status.Progress != 50 {
+		t.Errorf("Expected the rollout half way, got %+v", status)
+	}
+
+	// A second rollout is refused while the first runs
+	err = lb.Rollback(context.Background(), RollbackConfig{PreviousBackends: urls[:1]})
+	if errors.GetCode(err) != errors.ErrRolloutInProgress {
+		t.Errorf("Expected a concurrent rollback to be rejected, got %v", err)
+	}
+
+	ready.Store(true)
+	if err := <-done; err != nil {
+		t.Fatalf("Rollout failed: %v", err)
+	}
+	if status := getStatus(); status.InProgress || status.Phase != "complete" || status.Progress != 100 {
+		t.Errorf("Expected the rollout complete, got %+v", status)
+	}
+
+	err = lb.Rollback(context.Background(), RollbackConfig{
+		PreviousBackends: urls[:1],
+		Interval:         time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if status := getStatus(); status.InProgress || status.Phase != "rolled-back" {
+		t.Errorf("Expected the rollback reported, got %+v", status)
+	}
+
+	// A failed rollout reports its error and allows the next one
+	err = lb.Rollout(context.Background(), RolloutConfig{NewBackends: []string{"invalid-url"}})
+	if err == nil {
+		t.Fatal("Expected error for invalid backend URL")
+	}
+	if status := getStatus(); status.InProgress || status.Phase != "failed" || status.Error == "" {
+		t.Errorf("Expected the failure reported, got %+v", status)
+	}
+}
+
+func TestRolloutHealthTimeout(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	servers, urls := setupTestBackends(t, 2)
+	defer func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs(urls[:1]),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// The second batch never comes up, so the rollout is abandoned
+	err = lb.Rollout(context.Background(), RolloutConfig{
+		NewBackends:   []string{urls[1], broken.URL},
+		BatchSize:     1,
+		Interval:      10 * time.Millisecond,
+		HealthTimeout: 100 * time.Millisecond,
+	})
+	if err == nil {
+		t.Fatal("Expected the rollout to fail on an unhealthy batch")
+	}
+
+	pool := lb.poolSnapshot()
+	if len(pool) != 1 || pool[0].URL != urls[0] {
+		t.Errorf("Expected the original backend restored, got %v", pool)
+	}
+	if len(lb.backends) != 1 || lb.backends[0].URL.String() != urls[0] {
+		t.Errorf("Expected only the original backend serving, got %d backends", len(lb.backends))
+	}
+	if status := lb.RolloutStatus(); status.Phase != "failed" || !strings.Contains(status.Error, broken.URL) {
+		t.Errorf("Expected the unhealthy backend reported, got %+v", status)
+	}
+}
+
+func TestCanaryWeights(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     []config.Backend
+		canaries    int
+		percent     float64
+		wantWeights []int
+		wantShare   float64
+	}{
+		{"Equal", config.BackendsFromURLs([]string{"http://a", "http://b"}), 1, 5, []int{19, 19, 2}, 5},
+		{"Weighted", []config.Backend{{URL: "http://a", Weight: 3}, {URL: "http://b"}}, 2, 10, []int{27, 9, 2, 2}, 10},
+		{"Half", config.BackendsFromURLs([]string{"http://a"}), 1, 50, []int{1, 1}, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var canaries []string
+			for i := 0; i < tt.canaries; i++ {
+				canaries = append(canaries, fmt.Sprintf("http://canary-%d", i))
+			}
+			pool, share, err := canaryWeights(tt.current, canaries, tt.percent, 0)
+			if err != nil {
+				t.Fatalf("canaryWeights failed: %v", err)
+			}
+			var weights []int
+			for _, backend := range pool {
+				weights = append(weights, backend.Weight)
+			}
+			if !slices.Equal(weights, tt.wantWeights) {
+				t.Errorf("Expected weights %v, got %v", tt.wantWeights, weights)
+			}
+			if math.Abs(share-tt.wantShare) > 1e-9 {
+				t.Errorf("Expected a %v%% canary share, got %v", tt.wantShare, share)
+			}
+		})
+	}
+
+	if _, _, err := canaryWeights(nil, []string{"http://canary"}, 5, 0); err == nil {
+		t.Error("Expected an error without a main pool")
+	}
+}
+
+// countingBackend counts the requests it serves, failing them with status
+func TestRolloutKeepsUnchangedBackends(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	servers, urls := setupTestBackends(t, 2)
+	defer func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}()
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs(urls[:1]),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	kept := lb.byID[urls[0]]
+	kept.ActiveConns.Add(1) // a request still in flight
+	defer kept.ActiveConns.Add(-1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := lb.Rollout(ctx, RolloutConfig{
+		NewBackends: urls,
+		BatchSize:   1,
+		Interval:    10 * time.Millisecond,
+	}); err != nil {
+		t.Fatalf("Rollout failed: %v", err)
+	}
+
+	// The backend in both pools carries on as it was rather than draining
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	if lb.byID[urls[0]] != kept {
+		t.Fatal("Expected the unchanged backend to be kept across the rollout")
+	}
+	if got := kept.TotalRequests.Load(); got != 3 {
+		t.Errorf("Expected the kept backend's 3 requests, got %d", got)
+	}
+	if got := kept.ActiveConns.Load(); got != 1 {
+		t.Errorf("Expected the kept backend's request in flight, got %d", got)
+	}
+	if len(lb.backends) != 2 {
+		t.Errorf("Expected 2 backends after the rollout, got %d", len(lb.backends))
+	}
+}
+
+func countingBackend(status int) (*httptest.Server, *atomic.Int64) {
+	var served atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		w.WriteHeader(status)
+	}))
+	return server, &served
+}
+
+func TestCanaryPromote(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	stable, _ := countingBackend(http.StatusOK)
+	defer stable.Close()
+	canary, canaryServed := countingBackend(http.StatusOK)
+	defer canary.Close()
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs([]string{stable.URL}),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- lb.Canary(context.Background(), CanaryConfig{
+			NewBackends: []string{canary.URL},
+			Percent:     20,
+			Duration:    300 * time.Millisecond,
+			Interval:    10 * time.Millisecond,
+		})
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for lb.RolloutStatus().Phase != "canary" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if status := lb.RolloutStatus(); !status.InProgress || status.CanaryWeight != 20 {
+		t.Errorf("Expected the canary to hold 20%% of the weight, got %+v", status)
+	}
+
+	// Weighted round-robin sends exactly one request in five to the canary
+	for i := 0; i < 100; i++ {
+		lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if got := canaryServed.Load(); got != 20 {
+		t.Errorf("Expected the canary to serve 20 of 100 requests, got %d", got)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Canary failed: %v", err)
+	}
+	if status := lb.RolloutStatus(); status.Phase != "promoted" || status.CanaryWeight != 100 {
+		t.Errorf("Expected the canary promoted, got %+v", status)
+	}
+	if pool := lb.poolSnapshot(); len(pool) != 1 || pool[0].URL != canary.URL {
+		t.Errorf("Expected only the canary backend after promotion, got %v", pool)
+	}
+}
+
+func TestCanaryRollback(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	stable, _ := countingBackend(http.StatusOK)
+	defer stable.Close()
+	canary, _ := countingBackend(http.StatusInternalServerError)
+	defer canary.Close()
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs([]string{stable.URL}),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- lb.Canary(context.Background(), CanaryConfig{
+			NewBackends:    []string{canary.URL},
+			Percent:        50,
+			ErrorThreshold: 0.1,
+			Duration:       time.Minute,
+			Interval:       10 * time.Millisecond,
+		})
+	}()
+
+	// Keep traffic flowing until the failing canary is rolled back
+	var canaryErr error
+	timeout := time.After(5 * time.Second)
+wait:
+	for {
+		select {
+		case canaryErr = <-done:
+			break wait
+		case <-timeout:
+			t.Fatal("Timed out waiting for the canary to be rolled back")
+		default:
+			lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+	}
+
+	if canaryErr == nil {
+		t.Fatal("Expected the failing canary to be rolled back")
+	}
+	if status := lb.RolloutStatus(); status.Phase != "rolled-back" || status.CanaryWeight != 0 || status.Error == "" {
+		t.Errorf("Expected the rollback reported, got %+v", status)
+	}
+	if pool := lb.poolSnapshot(); len(pool) != 1 || pool[0].URL != stable.URL {
+		t.Errorf("Expected the stable backend restored, got %v", pool)
+	}
+
+	if err := lb.Canary(context.Background(), CanaryConfig{NewBackends: []string{canary.URL}, Percent: 100}); errors.GetCode(err) != errors.ErrConfigInvalid {
+		t.Errorf("Expected a 100%% canary to be rejected, got %v", err)
+	}
+}
+
+func TestCanaryMinRequests(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	stable, _ := countingBackend(http.StatusOK)
+	defer stable.Close()
+	canary, canaryServed := countingBackend(http.StatusInternalServerError)
+	defer canary.Close()
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs([]string{stable.URL}),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- lb.Canary(context.Background(), CanaryConfig{
+			NewBackends:    []string{canary.URL},
+			Percent:        50,
+			ErrorThreshold: 0.1,
+			MinRequests:    1000,
+			Duration:       200 * time.Millisecond,
+			Interval:       10 * time.Millisecond,
+		})
+	}()
+
+	// The canary fails every request, but never serves enough to be judged
+	var canaryErr error
+	timeout := time.After(5 * time.Second)
+wait:
+	for {
+		select {
+		case canaryErr = <-done:
+			break wait
+		case <-timeout:
+			t.Fatal("Timed out waiting for the canary")
+		default:
+			if canaryServed.Load() < 3 {
+				lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			} else {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+
+	if canaryErr != nil {
+		t.Fatalf("Expected too few requests not to roll the canary back, got %v", canaryErr)
+	}
+	if status := lb.RolloutStatus(); status.Phase != "promoted" {
+		t.Errorf("Expected the canary promoted, got %+v", status)
+	}
+}
+
+func TestRolloutErrorRate(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	stable, _ := countingBackend(http.StatusOK)
+	defer stable.Close()
+	// Passes its health checks but fails every request
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer failing.Close()
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs([]string{stable.URL}),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- lb.Rollout(context.Background(), RolloutConfig{
+			NewBackends:    []string{failing.URL},
+			Interval:       10 * time.Millisecond,
+			ErrorThreshold: 0.2,
+			SampleWindow:   time.Minute,
+		})
+	}()
+
+	// Keep traffic flowing until the failing batch is rolled back
+	var rolloutErr error
+	timeout := time.After(5 * time.Second)
+wait:
+	for {
+		select {
+		case rolloutErr = <-done:
+			break wait
+		case <-timeout:
+			t.Fatal("Timed out waiting for the rollout to be rolled back")
+		default:
+			lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+	}
+
+	if !stderrors.Is(rolloutErr, errElevatedErrors) {
+		t.Fatalf("Expected the rollout rolled back for its error ratio, got %v", rolloutErr)
+	}
+	if status := lb.RolloutStatus(); status.Phase != "aborted" || status.InProgress {
+		t.Errorf("Expected the rollback reported, got %+v", status)
+	}
+	if pool := lb.poolSnapshot(); len(pool) != 1 || pool[0].URL != stable.URL {
+		t.Errorf("Expected the stable backend restored, got %v", pool)
+	}
+}
+
+func TestErrorRatioSinceBatch(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	failing, _ := countingBackend(http.StatusInternalServerError)
+	defer failing.Close()
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs([]string{failing.URL}),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	urls := []string{failing.URL}
+	serve := func(n int) {
+		for i := 0; i < n; i++ {
+			lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+	}
+
+	// Failures from before the batch went live are not counted
+	serve(3)
+	since := lb.outcomeCounts(urls)
+	if ratio, requests := lb.errorRatio(urls, since); requests != 0 || ratio != 0 {
+		t.Errorf("Expected no requests since the baseline, got %d at %.2f", requests, ratio)
+	}
+
+	// Too few requests are not judged
+	serve(1)
+	if err := lb.checkErrorRatio(urls, since, 0.5, 2); err != nil {
+		t.Errorf("Expected one request to be too few to judge, got %v", err)
+	}
+	serve(1)
+	if err := lb.checkErrorRatio(urls, since, 0.5, 2); !stderrors.Is(err, errElevatedErrors) {
+		t.Errorf("Expected two failed requests to exceed the threshold, got %v", err)
+	}
+
+	// A backend whose series were dropped is counted from zero
+	lb.dropBackendMetrics(lb.backends[0])
+	if ratio, requests := lb.errorRatio(urls, since); requests != 0 || ratio != 0 {
+		t.Errorf("Expected no requests after the series were dropped, got %d at %.2f", requests, ratio)
+	}
+}
+
+func TestRolloutCancelRestoresPool(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	stable, _ := countingBackend(http.StatusOK)
+	defer stable.Close()
+	unhealthy, _ := countingBackend(http.StatusServiceUnavailable)
+	defer unhealthy.Close()
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs([]string{stable.URL}),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// The new backend never comes up, so the rollout waits until cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- lb.Rollout(ctx, RolloutConfig{
+			NewBackends:   []string{unhealthy.URL},
+			Interval:      10 * time.Millisecond,
+			HealthTimeout: time.Minute,
+		})
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for lb.RolloutStatus().Phase != "batch 1/1" {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the batch to go live")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if !stderrors.Is(err, context.Canceled) {
+			t.Errorf("Expected the rollout to be cancelled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the rollout to stop")
+	}
+	if status := lb.RolloutStatus(); status.Phase != "cancelled" {
+		t.Errorf("Expected phase cancelled, got %q", status.Phase)
+	}
+	if pool := lb.poolSnapshot(); len(pool) != 1 || pool[0].URL != stable.URL {
+		t.Errorf("Expected the stable backend restored, got %v", pool)
+	}
+}
+
+func TestRolloutErrorThresholdLimits(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	stable, _ := countingBackend(http.StatusOK)
+	defer stable.Close()
+	// Passes its health checks but fails every request
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer failing.Close()
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs([]string{stable.URL}),
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	err = lb.Rollout(context.Background(), RolloutConfig{NewBackends: []string{failing.URL}, ErrorThreshold: 1.5})
+	if errors.GetCode(err) != errors.ErrConfigInvalid {
+		t.Errorf("Expected an error threshold above 1 to be rejected, got %v", err)
+	}
+	if status := lb.RolloutStatus(); status.Phase != "" {
+		t.Errorf("Expected a rejected rollout not to start, got %+v", status)
+	}
+
+	// A negative threshold turns the check off, so failing requests during
+	// the sample window do not stop the rollout
+	done := make(chan error, 1)
+	go func() {
+		done <- lb.Rollout(context.Background(), RolloutConfig{
+			NewBackends:    []string{failing.URL},
+			Interval:       10 * time.Millisecond,
+			ErrorThreshold: -1,
+			SampleWindow:   200 * time.Millisecond,
+		})
+	}()
+	var rolloutErr error
+	timeout := time.After(5 * time.Second)
+wait:
+	for {
+		select {
+		case rolloutErr = <-done:
+			break wait
+		case <-timeout:
+			t.Fatal("Timed out waiting for the rollout")
+		default:
+			lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+	}
+	if rolloutErr != nil {
+		t.Errorf("Expected the rollout to complete with the check off, got %v", rolloutErr)
+	}
+	if status := lb.RolloutStatus(); status.Phase != "complete" {
+		t.Errorf("Expected the rollout complete, got %+v", status)
+	}
+}
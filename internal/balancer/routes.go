@@ -0,0 +1,95 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+const (
+	bufferingStream = "stream"
+	bufferingBuffer = "buffer"
+)
+
+const (
+	trailingSlashPassthrough = "passthrough"
+	trailingSlashRedirect    = "redirect"
+	trailingSlashStrip       = "strip"
+)
+
+// validateRoutes checks per-route options before the balancer starts
+func validateRoutes(routes []config.Route) error {
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, "/") {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("route path %q must start with /", route.Path), nil)
+		}
+		switch route.Buffering {
+		case "", bufferingStream, bufferingBuffer:
+		default:
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown buffering mode %q for route %s", route.Buffering, route.Path), nil)
+		}
+	}
+	return nil
+}
+
+// validateTrailingSlash checks the trailing slash normalization mode
+func validateTrailingSlash(mode string) error {
+	switch mode {
+	case "", trailingSlashPassthrough, trailingSlashRedirect, trailingSlashStrip:
+		return nil
+	default:
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown trailing slash mode %q", mode), nil)
+	}
+}
+
+// normalizeTrailingSlash applies the configured trailing slash mode to r so
+// that /path and /path/ match the same routes. It reports whether it has
+// answered the request with a redirect.
+func (lb *LoadBalancer) normalizeTrailingSlash(w http.ResponseWriter, r *http.Request) bool {
+	if lb.config == nil || r.URL.Path == "/" || r.URL.Path == "" {
+		return false
+	}
+
+	switch lb.config.TrailingSlash {
+	case trailingSlashRedirect:
+		if strings.HasSuffix(r.URL.Path, "/") {
+			return false
+		}
+		target := *r.URL
+		target.Path += "/"
+		if target.RawPath != "" {
+			target.RawPath += "/"
+		}
+		http.Redirect(w, r, target.RequestURI(), http.StatusPermanentRedirect)
+		return true
+	case trailingSlashStrip:
+		r.URL.Path = strings.TrimRight(r.URL.Path, "/")
+		if r.URL.RawPath != "" {
+			r.URL.RawPath = strings.TrimRight(r.URL.RawPath, "/")
+		}
+		if r.URL.Path == "" {
+			r.URL.Path, r.URL.RawPath = "/", ""
+		}
+	}
+	return false
+}
+
+// routeFor returns the route with the longest path prefix matching path,
+// or nil if none match
+func (lb *LoadBalancer) routeFor(path string) *config.Route {
+	if lb.config == nil {
+		return nil
+	}
+
+	var best *config.Route
+	for i := range lb.config.Routes {
+		route := &lb.config.Routes[i]
+		if strings.HasPrefix(path, route.Path) && (best == nil || len(route.Path) > len(best.Path)) {
+			best = route
+		}
+	}
+	return best
+}
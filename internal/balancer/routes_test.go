@@ -0,0 +1,74 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestTrailingSlash(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer backend.Close()
+
+	newLB := func(mode, routePath string) *LoadBalancer {
+		metrics.Reset() // Reset metrics before test
+		lb, err := New(&config.Config{
+			Backends:      []config.Backend{{URL: backend.URL}},
+			Routes:        []config.Route{{Path: routePath, Buffering: "buffer"}},
+			TrailingSlash: mode,
+		}, metrics.New())
+		if err != nil {
+			t.Fatalf("Failed to create load balancer: %v", err)
+		}
+		return lb
+	}
+
+	send := func(lb *LoadBalancer, path string) (*httptest.ResponseRecorder, *config.Route) {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+		return w, lb.routeFor(req.URL.Path)
+	}
+
+	// By default a route with a trailing slash misses the bare path
+	lb := newLB("", "/api/")
+	if _, route := send(lb, "/api"); route != nil {
+		t.Errorf("Expected /api to miss the /api/ route in passthrough mode")
+	}
+
+	// Stripping sends both forms to the same route and backend path
+	lb = newLB(trailingSlashStrip, "/api")
+	var routes []*config.Route
+	for _, path := range []string{"/api", "/api/"} {
+		w, route := send(lb, path)
+		if w.Body.String() != "/api" {
+			t.Errorf("Expected %s to be forwarded as /api, got %q", path, w.Body.String())
+		}
+		routes = append(routes, route)
+	}
+	if routes[0] == nil || routes[0] != routes[1] {
+		t.Errorf("Expected /api and /api/ to match the same route, got %v and %v", routes[0], routes[1])
+	}
+	if w, _ := send(lb, "/"); w.Body.String() != "/" {
+		t.Errorf("Expected the root path to be left alone, got %q", w.Body.String())
+	}
+
+	// Redirecting sends clients to the slash form, keeping the query
+	lb = newLB(trailingSlashRedirect, "/api/")
+	w, _ := send(lb, "/api?page=2")
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "/api/?page=2" {
+		t.Errorf("Expected 308 to /api/?page=2, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if w, route := send(lb, "/api/"); w.Code != http.StatusOK || route == nil {
+		t.Errorf("Expected /api/ to be served by the /api/ route, got %d", w.Code)
+	}
+
+	if err := validateTrailingSlash("sometimes"); err == nil {
+		t.Error("Expected error for unknown trailing slash mode")
+	}
+}
@@ -0,0 +1,100 @@
+package balancer
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"loadbalancer/internal/config"
+)
+
+// weightSchedule tracks the configured weight steps still to be applied
+type weightSchedule struct {
+	steps []config.WeightStep // sorted by time
+	next  int                 // index of the first step not yet applied
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+}
+
+func newWeightSchedule(steps []config.WeightStep) *weightSchedule {
+	s := &weightSchedule{
+		steps: append([]config.WeightStep(nil), steps...),
+		now:   time.Now,
+		after: time.After,
+	}
+	sort.SliceStable(s.steps, func(i, j int) bool { return s.steps[i].At.Before(s.steps[j].At) })
+	return s
+}
+
+// due returns the steps whose time has come since the last call, and how
+// long until the next one. more is false once every step has been taken.
+func (s *weightSchedule) due() (steps []config.WeightStep, wait time.Duration, more bool) {
+	now := s.now()
+	for s.next < len(s.steps) && !s.steps[s.next].At.After(now) {
+		steps = append(steps, s.steps[s.next])
+		s.next++
+	}
+	if s.next == len(s.steps) {
+		return steps, 0, false
+	}
+	return steps, s.steps[s.next].At.Sub(now), true
+}
+
+// runWeightSchedule applies the weight schedule until every step has been
+// taken or ctx is cancelled
+func (lb *LoadBalancer) runWeightSchedule(ctx context.Context) {
+	for {
+		steps, wait, more := lb.schedule.due()
+		for _, step := range steps {
+			lb.applyWeightStep(step)
+		}
+		if !more {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-lb.schedule.after(wait):
+		}
+	}
+}
+
+// applyWeightStep sets the weight of the step's backend in whichever pool
+// it rotates in. The pool configuration is updated too, so the weight
+// survives the pool being rebuilt.
+func (lb *LoadBalancer) applyWeightStep(step config.WeightStep) {
+	id := backendID(step.Backend)
+
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	updated := lb.selector.UpdateWeight(id, step.Weight)
+	if updated {
+		lb.pool = withWeight(lb.pool, id, step.Weight)
+	}
+	for _, pool := range lb.sidePools() {
+		if pool.selector.UpdateWeight(id, step.Weight) {
+			pool.backends = withWeight(pool.backends, id, step.Weight)
+			updated = true
+		}
+	}
+	if !updated {
+		lb.logger.Warn("scheduled weight change for a backend no longer in the pool", "backend", step.Backend, "weight", step.Weight)
+		return
+	}
+	lb.logger.Info("applied scheduled weight change", "backend", step.Backend, "weight", step.Weight)
+}
+
+// withWeight returns a copy of backends with the weight of the entry for id
+// set to weight. The slice may be shared with the configuration, so it is
+// not changed in place.
+func withWeight(backends []config.Backend, id string, weight int) []config.Backend {
+	updated := append([]config.Backend(nil), backends...)
+	for i := range updated {
+		if backendID(updated[i].URL) == id {
+			updated[i].Weight = weight
+		}
+	}
+	return updated
+}
@@ -0,0 +1,99 @@
+package balancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/balancer/algorithm"
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestWeightSchedule(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	start := time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC)
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{
+			{URL: "http://localhost:8001", Weight: 10},
+			{URL: "http://localhost:8002", Weight: 1},
+		},
+		WeightSchedule: []config.WeightStep{
+			// Listed out of order; applied by time
+			{Backend: "http://localhost:8002", At: start.Add(2 * time.Hour), Weight: 10},
+			{Backend: "http://localhost:8002", At: start.Add(time.Hour), Weight: 5},
+			{Backend: "http://localhost:8001", At: start.Add(-time.Hour), Weight: 8},
+		},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	// Drive the scheduler with a fake clock
+	now := start
+	ticks := make(chan time.Time)
+	waits := make(chan time.Duration, 1)
+	lb.schedule.now = func() time.Time { return now }
+	lb.schedule.after = func(d time.Duration) <-chan time.Time {
+		waits <- d
+		return ticks
+	}
+
+	weight := func(id string) int {
+		t.Helper()
+		weight, _, _ := lb.selector.(algorithm.WeightAdjuster).Weights(id)
+		return weight
+	}
+
+	done := make(chan struct{})
+	go func() {
+		lb.runWeightSchedule(context.Background())
+		close(done)
+	}()
+
+	// A step already past is applied at once; the scheduler then waits for
+	// the next one
+	if wait := <-waits; wait != time.Hour {
+		t.Errorf("Expected to wait an hour for the next step, got %v", wait)
+	}
+	if got := weight("http://localhost:8001"); got != 8 {
+		t.Errorf("Expected past step to set weight 8, got %d", got)
+	}
+	if got := weight("http://localhost:8002"); got != 1 {
+		t.Errorf("Expected weight 1 before the first step, got %d", got)
+	}
+
+	now = start.Add(time.Hour)
+	ticks <- now
+	if wait := <-waits; wait != time.Hour {
+		t.Errorf("Expected to wait an hour for the last step, got %v", wait)
+	}
+	if got := weight("http://localhost:8002"); got != 5 {
+		t.Errorf("Expected weight 5 after the first step, got %d", got)
+	}
+
+	// The scheduler stops after the last step
+	now = start.Add(2 * time.Hour)
+	ticks <- now
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the scheduler to stop after the last step")
+	}
+	if got := weight("http://localhost:8002"); got != 10 {
+		t.Errorf("Expected weight 10 after the last step, got %d", got)
+	}
+
+	// The pool configuration keeps the scheduled weights, so rebuilding the
+	// pool does not undo them
+	if err := lb.updateBackends(lb.poolSnapshot()); err != nil {
+		t.Fatalf("Failed to rebuild the pool: %v", err)
+	}
+	if got := weight("http://localhost:8001"); got != 8 {
+		t.Errorf("Expected weight 8 after rebuilding the pool, got %d", got)
+	}
+	if got := weight("http://localhost:8002"); got != 10 {
+		t.Errorf("Expected weight 10 after rebuilding the pool, got %d", got)
+	}
+}
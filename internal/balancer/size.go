@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net/http"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+// Where requests of unknown length go when large-request routing is on
+const (
+	unknownLengthDefault = "default"
+	unknownLengthLarge   = "large"
+)
+
+// largeRequestPool holds the backends that serve requests with large bodies
+type largeRequestPool struct {
+	sidePool
+	threshold     int64
+	unknownLength string
+}
+
+func newLargeRequestPool(cfg config.LargeRequests) (*largeRequestPool, error) {
+	if cfg.Threshold <= 0 {
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("large request threshold must be positive, got %d", cfg.Threshold), nil)
+	}
+
+	pool := &largeRequestPool{
+		sidePool:      sidePool{backends: cfg.Backends},
+		threshold:     cfg.Threshold,
+		unknownLength: cfg.UnknownLength,
+	}
+	switch pool.unknownLength {
+	case "":
+		pool.unknownLength = unknownLengthDefault
+	case unknownLengthDefault, unknownLengthLarge:
+	default:
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown large request routing %q for requests of unknown length", cfg.UnknownLength), nil)
+	}
+	return pool, nil
+}
+
+// matches reports whether r should be sent to the large-payload pool
+func (p *largeRequestPool) matches(r *http.Request) bool {
+	if r.ContentLength < 0 {
+		return p.unknownLength == unknownLengthLarge
+	}
+	return r.ContentLength > p.threshold
+}
+
+// limitRequestBody applies MaxRequestBodyBytes to r. A body declaring a
+// larger Content-Length is refused with 413 straight away, reporting true;
+// any other body is wrapped so reading past the limit fails.
+func (lb *LoadBalancer) limitRequestBody(w *responseWriter, r *http.Request) bool {
+	if lb.config == nil || lb.config.MaxRequestBodyBytes <= 0 {
+		return false
+	}
+	limit := lb.config.MaxRequestBodyBytes
+
+	if r.ContentLength > limit {
+		lb.logger.Debug("rejecting oversized request body", "method", r.Method, "path", r.URL.Path, "length", r.ContentLength, "limit", limit)
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		lb.metrics.ErrorsTotal.Inc()
+		return true
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(w.ResponseWriter, r.Body, limit)
+	}
+	return false
+}
+
+// requestBodyError answers a request whose body could not be read in full:
+// 413 if it went past MaxRequestBodyBytes, 400 otherwise
+func (lb *LoadBalancer) requestBodyError(w http.ResponseWriter, err error) {
+	if isBodyTooLarge(err) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Failed to read request body", http.StatusBadRequest)
+}
+
+// isBodyTooLarge reports whether err came from a request body passing
+// MaxRequestBodyBytes
+func isBodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return stderrors.As(err, &tooLarge)
+}
@@ -0,0 +1,145 @@
+package balancer
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestLargeRequestRouting(t *testing.T) {
+	var urls []string
+	for _, name := range []string{"default", "large"} {
+		name := name
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			w.Write([]byte(name))
+		}))
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+
+	newLB := func(unknownLength string) *LoadBalancer {
+		metrics.Reset() // Reset metrics before test
+		lb, err := New(&config.Config{
+			Backends: config.BackendsFromURLs(urls[:1]),
+			LargeRequests: config.LargeRequests{
+				Backends:      config.BackendsFromURLs(urls[1:]),
+				Threshold:     1024,
+				UnknownLength: unknownLength,
+			},
+		}, metrics.New())
+		if err != nil {
+			t.Fatalf("Failed to create load balancer: %v", err)
+		}
+		return lb
+	}
+
+	send := func(lb *LoadBalancer, size int, chunked bool) string {
+		req := httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("x", size)))
+		if chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	lb := newLB("")
+	if got := send(lb, 4096, false); got != "large" {
+		t.Errorf("Expected large request on the large pool, got %s", got)
+	}
+	if got := send(lb, 100, false); got != "default" {
+		t.Errorf("Expected small request on the default pool, got %s", got)
+	}
+	if got := send(lb, 1024, false); got != "default" {
+		t.Errorf("Expected request at the threshold on the default pool, got %s", got)
+	}
+	if got := send(lb, 4096, true); got != "default" {
+		t.Errorf("Expected chunked request on the default pool, got %s", got)
+	}
+
+	lb = newLB("large")
+	if got := send(lb, 100, true); got != "large" {
+		t.Errorf("Expected chunked request on the large pool, got %s", got)
+	}
+
+	// Large requests fall back to the default pool if no large backend is up
+	lb.backends[1].Healthy.Store(false)
+	if got := send(lb, 4096, false); got != "default" {
+		t.Errorf("Expected fallback to the default pool, got %s", got)
+	}
+
+	for _, cfg := range []config.LargeRequests{
+		{Backends: config.BackendsFromURLs(urls[1:])},
+		{Backends: config.BackendsFromURLs(urls[1:]), Threshold: 1024, UnknownLength: "sometimes"},
+	} {
+		if _, err := New(&config.Config{Backends: config.BackendsFromURLs(urls[:1]), LargeRequests: cfg}, metrics.New()); err == nil {
+			t.Errorf("Expected error for large request config %+v", cfg)
+		}
+	}
+}
+
+func TestMaxRequestBodyBytes(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	var contacted atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contacted.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends:            []config.Backend{{URL: backend.URL}},
+		MaxRequestBodyBytes: 1024,
+		Routes:              []config.Route{{Path: "/buffered/", Buffering: "buffer"}},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	send := func(path string, size int, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(strings.Repeat("x", size)))
+		if chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+		return w
+	}
+
+	// A declared length over the limit is refused before any backend sees it
+	if w := send("/upload", 4096, false); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	if got := contacted.Load(); got != 0 {
+		t.Errorf("Expected backend not to be contacted, got %d requests", got)
+	}
+
+	// So is a buffered body of unknown length that runs past it
+	if w := send("/buffered/upload", 4096, true); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d for buffered body, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	if got := contacted.Load(); got != 0 {
+		t.Errorf("Expected backend not to be contacted for buffered body, got %d requests", got)
+	}
+
+	// A streamed body is cut off once it passes the limit
+	if w := send("/upload", 4096, true); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d for streamed body, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+
+	// Bodies within the limit are forwarded whole
+	for _, chunked := range []bool{false, true} {
+		w := send("/upload", 1024, chunked)
+		if w.Code != http.StatusOK || w.Body.Len() != 1024 {
+			t.Errorf("Expected body within the limit to be forwarded (chunked %v), got %d with %d bytes", chunked, w.Code, w.Body.Len())
+		}
+	}
+}
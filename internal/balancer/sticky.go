@@ -0,0 +1,206 @@
+package balancer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+const defaultStickyCookie = "lb_affinity"
+
+// Ways of resolving a backend cookie named like the affinity cookie
+const (
+	cookieConflictBalancer = "balancer"
+	cookieConflictBackend  = "backend"
+)
+
+// stickySessions pins clients to a backend with a cookie. The cookie holds
+// an opaque token for the backend and the time it was last renewed, so
+// affinity lapses once a client has been idle for longer than idleTimeout.
+// Both are signed with key so clients cannot tamper with either.
+type stickySessions struct {
+	cookieName     string
+	idleTimeout    time.Duration
+	cookieConflict string
+	key            []byte
+	now            func() time.Time
+}
+
+func newStickySessions(cfg config.Sticky) (*stickySessions, error) {
+	s := &stickySessions{
+		cookieName:     cfg.CookieName,
+		idleTimeout:    cfg.IdleTimeout,
+		cookieConflict: cfg.CookieConflict,
+		now:            time.Now,
+	}
+	if s.cookieName == "" {
+		s.cookieName = defaultStickyCookie
+	}
+	switch s.cookieConflict {
+	case "":
+		s.cookieConflict = cookieConflictBalancer
+	case cookieConflictBalancer, cookieConflictBackend:
+	default:
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown sticky cookie conflict mode %q", cfg.CookieConflict), nil)
+	}
+	if cfg.Secret != "" {
+		s.key = []byte(cfg.Secret)
+	} else {
+		s.key = make([]byte, 32)
+		if _, err := rand.Read(s.key); err != nil {
+			return nil, errors.New(errors.ErrConfigInvalid, "failed to generate sticky session secret", err)
+		}
+	}
+	return s, nil
+}
+
+// sign returns the signature of an affinity cookie value
+func (s *stickySessions) sign(value string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// affinityToken derives the opaque cookie token for a backend ID so the
+// backend address is never exposed to clients
+func affinityToken(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// token returns the backend token from the request's affinity cookie, or
+// false if the cookie is absent, malformed, badly signed or idle for too
+// long
+func (s *stickySessions) token(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(s.cookieName)
+	if err != nil {
+		return "", false
+	}
+
+	value, signature, ok := cutLast(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(value))) {
+		return "", false
+	}
+	token, issued, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	seconds, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	if s.idleTimeout > 0 && s.now().Sub(time.Unix(seconds, 0)) > s.idleTimeout {
+		return "", false
+	}
+	return token, true
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// apply issues or renews the affinity cookie for b on the backend's
+// response. It is appended alongside the backend's own Set-Cookie headers;
+// only a backend cookie with the affinity cookie's name is affected, as
+// decided by the conflict mode.
+func (s *stickySessions) apply(resp *http.Response, b *Backend) {
+	var kept []string
+	conflict := false
+	for _, line := range resp.Header.Values("Set-Cookie") {
+		name, _, _ := strings.Cut(line, "=")
+		if strings.TrimSpace(name) == s.cookieName {
+			conflict = true
+			if s.cookieConflict == cookieConflictBalancer {
+				continue
+			}
+		}
+		kept = append(kept, line)
+	}
+	if conflict && s.cookieConflict == cookieConflictBackend {
+		return
+	}
+
+	resp.Header.Del("Set-Cookie")
+	for _, line := range kept {
+		resp.Header.Add("Set-Cookie", line)
+	}
+	resp.Header.Add("Set-Cookie", s.cookie(b).String())
+}
+
+// cookie builds the affinity cookie for b
+func (s *stickySessions) cookie(b *Backend) *http.Cookie {
+	value := b.affinityToken + "." + strconv.FormatInt(s.now().Unix(), 10)
+	cookie := &http.Cookie{
+		Name:     s.cookieName,
+		Value:    value + "." + s.sign(value),
+		Path:     "/",
+		HttpOnly: true,
+	}
+	if s.idleTimeout > 0 {
+		cookie.MaxAge = int(s.idleTimeout.Seconds())
+	}
+	return cookie
+}
+
+// stickyKey is the context key marking requests for the main pool, the
+// only pool affinity cookies pin clients within
+type stickyKey struct{}
+
+// withSticky marks r as one whose client may be pinned to a main pool
+// backend
+func withSticky(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), stickyKey{}, true))
+}
+
+// isSticky reports whether r was marked by withSticky
+func isSticky(r *http.Request) bool {
+	sticky, _ := r.Context().Value(stickyKey{}).(bool)
+	return sticky
+}
+
+// stickyBackend returns the healthy main pool backend named by the
+// request's affinity cookie, if any
+func (lb *LoadBalancer) stickyBackend(r *http.Request) *Backend {
+	token, ok := lb.sticky.token(r)
+	if !ok {
+		return nil
+	}
+
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	for _, backend := range lb.pool {
+		b := lb.byID[backendID(backend.URL)]
+		if b != nil && b.affinityToken == token && b.Healthy.Load() {
+			return b
+		}
+	}
+	return nil
+}
+
+// inMainPool reports whether b is a member of the main pool. Backends that
+// only serve a side pool, such as canaries or a route's pool, are never
+// pinned to, since the cookie would draw main pool traffic to them.
+func (lb *LoadBalancer) inMainPool(b *Backend) bool {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	for _, backend := range lb.pool {
+		if backendID(backend.URL) == b.ID {
+			return true
+		}
+	}
+	return false
+}
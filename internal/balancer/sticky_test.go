@@ -0,0 +1,265 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestStickySessionIdleTimeout(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	var urls []string
+	for _, name := range []string{"backend1", "backend2"} {
+		name := name
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+
+	lb, err := New(&config.Config{
+		Backends: config.BackendsFromURLs(urls),
+		Sticky: config.Sticky{
+			Enabled:     true,
+			IdleTimeout: 10 * time.Minute,
+		},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	lb.sticky.now = func() time.Time { return now }
+
+	var cookie *http.Cookie
+	send := func() string {
+		req := httptest.NewRequest("GET", "/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == defaultStickyCookie {
+				cookie = c
+			}
+		}
+		return w.Body.String()
+	}
+
+	if got := send(); got != "backend1" {
+		t.Fatalf("Expected first request on backend1, got %s", got)
+	}
+	if cookie == nil {
+		t.Fatal("Expected affinity cookie to be set")
+	}
+	if cookie.MaxAge != int((10 * time.Minute).Seconds()) {
+		t.Errorf("Expected cookie Max-Age of 600, got %d", cookie.MaxAge)
+	}
+
+	// Each request within the idle window renews affinity
+	for i := 0; i < 3; i++ {
+		now = now.Add(6 * time.Minute)
+		if got := send(); got != "backend1" {
+			t.Errorf("Expected sticky request %d on backend1, got %s", i, got)
+		}
+	}
+
+	// After the idle window the client is rebalanced
+	now = now.Add(11 * time.Minute)
+	if got := send(); got != "backend2" {
+		t.Errorf("Expected idle client to be rebalanced to backend2, got %s", got)
+	}
+}
+
+func TestStickySessionFallback(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: "http://localhost:8001"}, {URL: "http://localhost:8002"}},
+		Sticky:   config.Sticky{Enabled: true},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	target := lb.backends[1]
+	payload := target.affinityToken + ".1700000000"
+	validCookie := &http.Cookie{
+		Name:  defaultStickyCookie,
+		Value: payload + "." + lb.sticky.sign(payload),
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(validCookie)
+	if b := lb.stickyBackend(req); b != target {
+		t.Error("Expected cookie to select its backend")
+	}
+
+	// Unhealthy backends are not honoured
+	target.Healthy.Store(false)
+	if b := lb.stickyBackend(req); b != nil {
+		t.Error("Expected no sticky backend when it is unhealthy")
+	}
+
+	// Malformed, unsigned, tampered and unknown cookies fall back to normal
+	// selection
+	for _, value := range []string{
+		"garbage",
+		payload,
+		payload + ".0123456789abcdef0123456789abcdef",
+		target.affinityToken + ".1800000000." + lb.sticky.sign(payload),
+		"deadbeef.1700000000." + lb.sticky.sign("deadbeef.1700000000"),
+		target.affinityToken + ".notatime." + lb.sticky.sign(target.affinityToken+".notatime"),
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: defaultStickyCookie, Value: value})
+		if b := lb.stickyBackend(req); b != nil {
+			t.Errorf("Expected no sticky backend for cookie %q", value)
+		}
+	}
+}
+
+func TestStickyCookieAlongsideBackendCookies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		http.SetCookie(w, &http.Cookie{Name: "theme", Value: "dark"})
+		if r.URL.Path == "/conflict" {
+			http.SetCookie(w, &http.Cookie{Name: defaultStickyCookie, Value: "from-backend"})
+		}
+	}))
+	defer backend.Close()
+
+	for _, tt := range []struct {
+		mode     string
+		path     string
+		affinity string // expected affinity cookie value, "" for the balancer's own
+	}{
+		{mode: "", path: "/"},
+		{mode: cookieConflictBalancer, path: "/conflict"},
+		{mode: cookieConflictBackend, path: "/conflict", affinity: "from-backend"},
+	} {
+		metrics.Reset() // Reset metrics before test
+		lb, err := New(&config.Config{
+			Backends: []config.Backend{{URL: backend.URL}},
+			Sticky:   config.Sticky{Enabled: true, CookieConflict: tt.mode},
+		}, metrics.New())
+		if err != nil {
+			t.Fatalf("Failed to create load balancer: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+		cookies := map[string][]string{}
+		for _, c := range w.Result().Cookies() {
+			cookies[c.Name] = append(cookies[c.Name], c.Value)
+		}
+		if got := cookies["session"]; len(got) != 1 || got[0] != "abc" {
+			t.Errorf("mode %q: expected backend session cookie to be kept, got %v", tt.mode, got)
+		}
+		if got := cookies["theme"]; len(got) != 1 || got[0] != "dark" {
+			t.Errorf("mode %q: expected backend theme cookie to be kept, got %v", tt.mode, got)
+		}
+
+		affinity := cookies[defaultStickyCookie]
+		if len(affinity) != 1 {
+			t.Fatalf("mode %q: expected exactly one affinity cookie, got %v", tt.mode, affinity)
+		}
+		want := tt.affinity
+		if want == "" {
+			want = lb.backends[0].affinityToken
+			if !strings.HasPrefix(affinity[0], want+".") {
+				t.Errorf("mode %q: expected the balancer's affinity cookie, got %q", tt.mode, affinity[0])
+			}
+		} else if affinity[0] != want {
+			t.Errorf("mode %q: expected affinity cookie %q, got %q", tt.mode, want, affinity[0])
+		}
+	}
+
+	if _, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		Sticky:   config.Sticky{Enabled: true, CookieConflict: "merge"},
+	}, metrics.New()); err == nil {
+		t.Error("Expected error for unknown cookie conflict mode")
+	}
+}
+
+func TestStickySessionsStayInMainPool(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	urls := map[string]string{}
+	for _, name := range []string{"main", "api", "canary"} {
+		name := name
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer server.Close()
+		urls[name] = server.URL
+	}
+
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: urls["main"]}},
+		Pools:    []config.Pool{{Name: "api", Backends: []config.Backend{{URL: urls["api"]}}}},
+		Routes:   []config.Route{{Path: "/api", Pool: "api"}},
+		Canary: config.Canary{
+			Backends: []config.Backend{{URL: urls["canary"]}},
+			Header:   "X-Canary", HeaderValue: "yes",
+		},
+		Sticky: config.Sticky{Enabled: true},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	send := func(path string, header http.Header, cookie *http.Cookie) (string, *http.Cookie) {
+		req := httptest.NewRequest("GET", path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		lb.ServeHTTP(w, req)
+		for _, c := range w.Result().Cookies() {
+			if c.Name == defaultStickyCookie {
+				return w.Body.String(), c
+			}
+		}
+		return w.Body.String(), nil
+	}
+
+	// Side pool backends do not issue affinity cookies
+	if got, cookie := send("/api/users", nil, nil); got != "api" || cookie != nil {
+		t.Errorf("Expected the route pool to serve /api without an affinity cookie, got %s with %v", got, cookie)
+	}
+	if got, cookie := send("/", http.Header{"X-Canary": {"yes"}}, nil); got != "canary" || cookie != nil {
+		t.Errorf("Expected the canary to serve without an affinity cookie, got %s with %v", got, cookie)
+	}
+
+	// A cookie naming a side pool backend does not draw main pool traffic
+	// to it
+	for _, name := range []string{"api", "canary"} {
+		payload := affinityToken(backendID(urls[name])) + ".1700000000"
+		forged := &http.Cookie{Name: defaultStickyCookie, Value: payload + "." + lb.sticky.sign(payload)}
+		if got, _ := send("/", nil, forged); got != "main" {
+			t.Errorf("Expected a cookie for the %s backend to leave / on the main pool, got %s", name, got)
+		}
+	}
+
+	// Main pool traffic is still pinned
+	got, cookie := send("/", nil, nil)
+	if got != "main" || cookie == nil {
+		t.Fatalf("Expected the main pool to issue an affinity cookie, got %s with %v", got, cookie)
+	}
+	if got, _ := send("/", nil, cookie); got != "main" {
+		t.Errorf("Expected the pinned request on main, got %s", got)
+	}
+}
@@ -0,0 +1,87 @@
+package balancer
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"time"
+
+	"loadbalancer/internal/config"
+)
+
+// defaultRequestTimeout bounds proxied requests when no request timeout is
+// configured
+const defaultRequestTimeout = 30 * time.Second
+
+// requestTimeout is the context of a proxied request bounded by the request
+// timeout. Unlike context.WithTimeout, the timer can be stopped once the
+// backend starts streaming, so long-lived streams outlast the timeout while
+// a backend that stalls before responding, or part way through a response
+// of known length, still times out.
+type requestTimeout struct {
+	context.Context
+	timer *time.Timer
+}
+
+type requestTimeoutKey struct{}
+
+// Err reports context.DeadlineExceeded once the timeout has fired, as a
+// context.WithTimeout context would
+func (t *requestTimeout) Err() error {
+	err := t.Context.Err()
+	if err != nil && context.Cause(t.Context) == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func (t *requestTimeout) Value(key any) any {
+	if key == (requestTimeoutKey{}) {
+		return t
+	}
+	return t.Context.Value(key)
+}
+
+// exempt stops the timeout, if it has not already fired
+func (t *requestTimeout) exempt() {
+	t.timer.Stop()
+}
+
+// requestTimeoutFrom returns the request timeout governing ctx, if any
+func requestTimeoutFrom(ctx context.Context) *requestTimeout {
+	timeout, _ := ctx.Value(requestTimeoutKey{}).(*requestTimeout)
+	return timeout
+}
+
+// requestContext derives the context for proxying r, bounded by route's
+// timeout or else the configured request timeout, unless that is disabled.
+// The bound is lifted for streaming responses.
+func (lb *LoadBalancer) requestContext(r *http.Request, route *config.Route) (context.Context, context.CancelFunc) {
+	timeout := defaultRequestTimeout
+	if lb.config != nil && lb.config.RequestTimeout != 0 {
+		timeout = lb.config.RequestTimeout
+	}
+	if route != nil && route.Timeout > 0 {
+		timeout = route.Timeout
+	}
+	if timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	t := &requestTimeout{Context: ctx}
+	t.timer = time.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+	return t, func() {
+		t.timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// isStreaming reports whether resp is a stream rather than a document:
+// server-sent events, or a body of unknown length such as a chunked one
+func isStreaming(resp *http.Response) bool {
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && mediaType == "text/event-stream" {
+		return true
+	}
+	return resp.ContentLength < 0 && resp.Request.Method != http.MethodHead
+}
@@ -0,0 +1,103 @@
+package balancer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestRequestTimeoutSparesStreams(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/events":
+			// Server-sent events, spread over several timeouts
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := 0; i < 5; i++ {
+				fmt.Fprintf(w, "data: %d\n\n", i)
+				w.(http.Flusher).Flush()
+				time.Sleep(30 * time.Millisecond)
+			}
+		case "/download":
+			// A chunked body of unknown length
+			for i := 0; i < 5; i++ {
+				w.Write([]byte("chunk"))
+				w.(http.Flusher).Flush()
+				time.Sleep(30 * time.Millisecond)
+			}
+		case "/stalled":
+			// A document whose body stops part way
+			w.Header().Set("Content-Length", "10")
+			w.Write([]byte("half"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			// No response at all
+			<-r.Context().Done()
+		}
+	}))
+	defer backend.Close()
+
+	lb, err := New(&config.Config{
+		Backends:       []config.Backend{{URL: backend.URL}},
+		RequestTimeout: 50 * time.Millisecond,
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	frontend := httptest.NewServer(lb)
+	defer frontend.Close()
+
+	get := func(path string) (int, string, error) {
+		resp, err := http.Get(frontend.URL + path)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), err
+	}
+
+	status, body, err := get("/events")
+	if err != nil || status != http.StatusOK || strings.Count(body, "data: ") != 5 {
+		t.Errorf("Expected every event of the stream, got %d %q (%v)", status, body, err)
+	}
+	status, body, err = get("/download")
+	if err != nil || status != http.StatusOK || body != strings.Repeat("chunk", 5) {
+		t.Errorf("Expected the whole chunked download, got %d %q (%v)", status, body, err)
+	}
+
+	// A stalled document is still cut off
+	if _, body, err = get("/stalled"); err == nil {
+		t.Errorf("Expected stalled response to be cut off, got %q", body)
+	}
+	if status, _, _ = get("/"); status != http.StatusGatewayTimeout {
+		t.Errorf("Expected status code %d, got %d", http.StatusGatewayTimeout, status)
+	}
+}
+
+func TestRequestTimeoutDefault(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, tt := range []struct {
+		configured time.Duration
+		bounded    bool
+	}{
+		{0, true}, // a Config built in code gets the default
+		{time.Second, true},
+		{-1, false},
+	} {
+		lb := &LoadBalancer{config: &config.Config{RequestTimeout: tt.configured}}
+		ctx, cancel := lb.requestContext(req, nil)
+		if bounded := requestTimeoutFrom(ctx) != nil; bounded != tt.bounded {
+			t.Errorf("RequestTimeout %v: expected bounded %v, got %v", tt.configured, tt.bounded, bounded)
+		}
+		cancel()
+	}
+}
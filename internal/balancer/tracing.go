@@ -0,0 +1,37 @@
+package balancer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// Trace context headers. They are end-to-end headers, so the reverse proxy
+// forwards them unchanged; these names are only needed to detect whether a
+// request already carries trace context.
+const (
+	traceparentHeader = "traceparent"
+	b3Header          = "b3"
+	b3TraceIDHeader   = "X-B3-TraceId"
+)
+
+// hasTraceContext reports whether r carries W3C or B3 trace context
+func hasTraceContext(r *http.Request) bool {
+	return r.Header.Get(traceparentHeader) != "" ||
+		r.Header.Get(b3Header) != "" ||
+		r.Header.Get(b3TraceIDHeader) != ""
+}
+
+// ensureTraceparent starts a new sampled W3C trace for requests that arrive
+// without any trace context, so backend spans can still be correlated
+func ensureTraceparent(r *http.Request) {
+	if hasTraceContext(r) {
+		return
+	}
+
+	var id [24]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return
+	}
+	r.Header.Set(traceparentHeader, "00-"+hex.EncodeToString(id[:16])+"-"+hex.EncodeToString(id[16:])+"-01")
+}
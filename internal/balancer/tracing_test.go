@@ -0,0 +1,84 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestTraceHeadersPassthrough(t *testing.T) {
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	traceHeaders := map[string]string{
+		"traceparent":       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"tracestate":        "vendor=value",
+		"b3":                "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1",
+		"X-B3-TraceId":      "80f198ee56343ba864fe8b2a57d3eff7",
+		"X-B3-SpanId":       "e457b5a2e4d86bd1",
+		"X-B3-ParentSpanId": "05e3ac9a4f6e3b90",
+		"X-B3-Sampled":      "1",
+	}
+
+	for _, generate := range []bool{false, true} {
+		metrics.Reset() // Reset metrics before test
+		lb, err := New(&config.Config{
+			Backends: []config.Backend{{URL: backend.URL}},
+			Tracing:  config.Tracing{GenerateTraceparent: generate},
+		}, metrics.New())
+		if err != nil {
+			t.Fatalf("Failed to create load balancer: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		for name, value := range traceHeaders {
+			req.Header.Set(name, value)
+		}
+		lb.ServeHTTP(httptest.NewRecorder(), req)
+
+		got := <-received
+		for name, value := range traceHeaders {
+			if got.Get(name) != value {
+				t.Errorf("generate=%v: expected %s %q to reach the backend, got %q", generate, name, value, got.Get(name))
+			}
+		}
+	}
+}
+
+func TestGenerateTraceparent(t *testing.T) {
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	metrics.Reset() // Reset metrics before test
+	lb, err := New(&config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		Tracing:  config.Tracing{GenerateTraceparent: true},
+	}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	traceparent := (<-received).Get("traceparent")
+	if !regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`).MatchString(traceparent) {
+		t.Errorf("Expected a generated W3C traceparent, got %q", traceparent)
+	}
+
+	// Requests already carrying B3 context are not given a second trace
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-B3-TraceId", "80f198ee56343ba864fe8b2a57d3eff7")
+	lb.ServeHTTP(httptest.NewRecorder(), req)
+	if got := (<-received).Get("traceparent"); got != "" {
+		t.Errorf("Expected no traceparent alongside B3 context, got %q", got)
+	}
+}
@@ -0,0 +1,130 @@
+package balancer
+
+import (
+	"context"
+	stderrors "errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"loadbalancer/internal/config"
+)
+
+// Keepalive probe defaults for backend connections
+const (
+	defaultKeepAliveIdle     = 30 * time.Second
+	defaultKeepAliveInterval = 15 * time.Second
+	defaultKeepAliveCount    = 9
+)
+
+// newDialer builds the dialer for backend connections, with TCP keepalive
+// probes configured so that dead connections are detected and pruned
+func (lb *LoadBalancer) newDialer() *net.Dialer {
+	var t config.Transport
+	if lb.config != nil {
+		t = lb.config.Transport
+	}
+
+	keepAlive := net.KeepAliveConfig{
+		Enable:   t.KeepAliveIdle >= 0,
+		Idle:     t.KeepAliveIdle,
+		Interval: t.KeepAliveInterval,
+		Count:    t.KeepAliveCount,
+	}
+	if keepAlive.Idle <= 0 {
+		keepAlive.Idle = defaultKeepAliveIdle
+	}
+	if keepAlive.Interval <= 0 {
+		keepAlive.Interval = defaultKeepAliveInterval
+	}
+	if keepAlive.Count <= 0 {
+		keepAlive.Count = defaultKeepAliveCount
+	}
+
+	dialer := &net.Dialer{
+		Timeout:         30 * time.Second,
+		KeepAliveConfig: keepAlive,
+	}
+	if !keepAlive.Enable {
+		dialer.KeepAlive = -1
+	}
+	return dialer
+}
+
+// newTransport builds the HTTP transport used to reach a backend
+func (lb *LoadBalancer) newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if lb.config != nil && lb.config.Transport.MaxResponseHeaderBytes > 0 {
+		transport.MaxResponseHeaderBytes = lb.config.Transport.MaxResponseHeaderBytes
+	}
+	if lb.dns != nil {
+		transport.DialContext = lb.dns.dialContext
+	} else {
+		transport.DialContext = lb.newDialer().DialContext
+	}
+
+	return transport
+}
+
+// proxyErrorHandler replies with 502 when the proxy fails to reach a backend
+// or cannot read its response, and with 504 when the request timed out.
+// Retryable failures of requests that will be retried are only recorded.
+func (lb *LoadBalancer) proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if context.Cause(r.Context()) == errHedgeLost {
+		return
+	}
+	if attempt := retryAttemptFrom(r.Context()); attempt != nil && isRetryable(err) {
+		attempt.err = err
+		return
+	}
+	var status *upstreamStatusError
+	if stderrors.As(err, &status) {
+		http.Error(w, "Backend error", status.status)
+		return
+	}
+	if stderrors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "Backend timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if isBodyTooLarge(err) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if isHeaderTooLarge(err) {
+		lb.metrics.HeaderTooLarge.Inc()
+		http.Error(w, "Backend response headers too large", http.StatusBadGateway)
+		return
+	}
+
+	lb.logger.Error("proxy error", "method", r.Method, "path", r.URL.Path, "error", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// modifyResponse is the proxy's ModifyResponse hook for backend b. It runs
+// before any of the backend's response is sent to the client.
+func (lb *LoadBalancer) modifyResponse(resp *http.Response, b *Backend) error {
+	if err := lb.checkRetryableResponse(resp); err != nil {
+		return err
+	}
+	if isStreaming(resp) {
+		if timeout := requestTimeoutFrom(resp.Request.Context()); timeout != nil {
+			timeout.exempt()
+		}
+	}
+	if recorder := cacheRecorderFrom(resp.Request.Context()); recorder != nil {
+		recorder.captureUpstream(resp.Header)
+	}
+	if lb.sticky != nil && isSticky(resp.Request) && lb.inMainPool(b) {
+		lb.sticky.apply(resp, b)
+	}
+	return nil
+}
+
+// isHeaderTooLarge reports whether err was caused by a backend response
+// exceeding the transport's MaxResponseHeaderBytes. net/http does not export
+// a sentinel for this, so the message is matched instead.
+func isHeaderTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "server response headers exceeded")
+}
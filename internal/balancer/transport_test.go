@@ -0,0 +1,137 @@
+package balancer
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/metrics"
+)
+
+func TestMaxResponseHeaderBytes(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	// Backend that sends a header well above the configured limit
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Oversized", strings.Repeat("a", 8192))
+		w.Write([]byte("should not be relayed"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		Transport: config.Transport{
+			MaxResponseHeaderBytes: 1024,
+		},
+	}
+	lb, err := New(cfg, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadGateway, w.Code)
+	}
+	if w.Header().Get("X-Oversized") != "" {
+		t.Error("Expected oversized header not to be relayed to the client")
+	}
+	if got := testutil.ToFloat64(lb.metrics.HeaderTooLarge); got != 1 {
+		t.Errorf("Expected HeaderTooLarge to be 1, got %f", got)
+	}
+}
+
+func TestMaxResponseHeaderBytesWithinLimit(t *testing.T) {
+	metrics.Reset() // Reset metrics before test
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Small", "ok")
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Backends: []config.Backend{{URL: backend.URL}},
+		Transport: config.Transport{
+			MaxResponseHeaderBytes: 1024,
+		},
+	}
+	lb, err := New(cfg, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	lb.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if w.Header().Get("X-Small") != "ok" {
+		t.Error("Expected backend header to be relayed")
+	}
+	if got := testutil.ToFloat64(lb.metrics.HeaderTooLarge); got != 0 {
+		t.Errorf("Expected HeaderTooLarge to be 0, got %f", got)
+	}
+}
+
+func TestDialerKeepAlive(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport config.Transport
+		want      net.KeepAliveConfig
+	}{
+		{
+			name: "defaults",
+			want: net.KeepAliveConfig{Enable: true, Idle: 30 * time.Second, Interval: 15 * time.Second, Count: 9},
+		},
+		{
+			name: "configured",
+			transport: config.Transport{
+				KeepAliveIdle:     10 * time.Second,
+				KeepAliveInterval: 2 * time.Second,
+				KeepAliveCount:    3,
+			},
+			want: net.KeepAliveConfig{Enable: true, Idle: 10 * time.Second, Interval: 2 * time.Second, Count: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics.Reset() // Reset metrics before test
+			tt.transport.DNSRefreshInterval = time.Minute
+			lb, err := New(&config.Config{Transport: tt.transport}, metrics.New())
+			if err != nil {
+				t.Fatalf("Failed to create load balancer: %v", err)
+			}
+
+			if got := lb.newDialer().KeepAliveConfig; got != tt.want {
+				t.Errorf("Expected keepalive %+v, got %+v", tt.want, got)
+			}
+			// Connections dialled through the DNS cache are probed too
+			if got := lb.dns.dialer.KeepAliveConfig; got != tt.want {
+				t.Errorf("Expected DNS cache dialer keepalive %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	// A negative idle time turns probes off
+	metrics.Reset()
+	lb, err := New(&config.Config{Transport: config.Transport{KeepAliveIdle: -1}}, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+	if dialer := lb.newDialer(); dialer.KeepAliveConfig.Enable || dialer.KeepAlive >= 0 {
+		t.Errorf("Expected keepalive probes to be disabled, got %+v", dialer.KeepAliveConfig)
+	}
+}
@@ -1,12 +1,22 @@
 package circuitbreaker
 
 import (
+	"context"
+	stderrors "errors"
+	"log/slog"
 	"sync"
 	"time"
 
 	"loadbalancer/internal/errors"
+	"loadbalancer/internal/logging"
+	"loadbalancer/internal/rolling"
 )
 
+// ErrAbandoned marks an operation its caller gave up on before it could
+// succeed or fail, such as a hedged request another backend answered
+// first. Execute returns it without recording a result.
+var ErrAbandoned = stderrors.New("operation abandoned")
+
 type State int
 
 const (
@@ -15,6 +25,19 @@ const (
 	StateOpen
 )
 
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
 type CircuitBreaker struct {
 	mu sync.RWMutex
 
@@ -25,12 +48,48 @@ type CircuitBreaker struct {
 	state        State
 	halfOpenMax  int
 	successCount int
+
+	// halfOpenFailures counts failures since the breaker last went
+	// half-open; it reopens once they reach halfOpenFailureThreshold
+	halfOpenFailures         int
+	halfOpenFailureThreshold int
+
+	// window is set in failure-ratio mode, replacing the consecutive
+	// failure count as the trip condition
+	window       *rolling.Window
+	failureRatio float64
+	minRequests  int
+
+	name   string
+	logger *slog.Logger
+
+	onStateChange    func(from, to State)
+	onHalfOpenResult func(success bool)
 }
 
 type Config struct {
-	Threshold   int
-	Timeout     time.Duration
+	Threshold int
+	Timeout   time.Duration
+	// HalfOpenMax is how many trial requests must succeed before a
+	// half-open breaker closes, 3 by default
 	HalfOpenMax int
+	// HalfOpenFailureThreshold is how many failures a half-open breaker
+	// tolerates before reopening; the default of 1 reopens on the first
+	HalfOpenFailureThreshold int
+
+	// FailureRatio, when set, opens the circuit once this fraction of the
+	// requests seen in the last Window have failed, instead of after
+	// Threshold consecutive failures. At least MinRequests requests must
+	// have been seen in the window before the ratio is considered.
+	FailureRatio float64
+	MinRequests  int
+	// Window is the span failure ratios are measured over, 10s by default
+	Window time.Duration
+
+	// Name identifies the breaker in log messages, e.g. the backend URL
+	Name string
+	// Logger receives state transitions; nil discards them
+	Logger *slog.Logger
 }
 
 func New(config Config) *CircuitBreaker {
@@ -43,13 +102,35 @@ func New(config Config) *CircuitBreaker {
 	if config.HalfOpenMax <= 0 {
 		config.HalfOpenMax = 3
 	}
+	if config.HalfOpenFailureThreshold <= 0 {
+		config.HalfOpenFailureThreshold = 1
+	}
+	if config.Logger == nil {
+		config.Logger = logging.Discard()
+	}
 
-	return &CircuitBreaker{
+	cb := &CircuitBreaker{
 		threshold:   config.Threshold,
 		timeout:     config.Timeout,
 		halfOpenMax: config.HalfOpenMax,
 		state:      StateClosed,
+		name:        config.Name,
+		logger:      config.Logger,
+
+		halfOpenFailureThreshold: config.HalfOpenFailureThreshold,
+	}
+	if config.FailureRatio > 0 {
+		if config.Window <= 0 {
+			config.Window = 10 * time.Second
+		}
+		if config.MinRequests <= 0 {
+			config.MinRequests = 1
+		}
+		cb.window = rolling.New(config.Window)
+		cb.failureRatio = config.FailureRatio
+		cb.minRequests = config.MinRequests
 	}
+	return cb
 }
 
 func (cb *CircuitBreaker) Execute(operation func() error) error {
@@ -58,6 +139,9 @@ func (cb *CircuitBreaker) Execute(operation func() error) error {
 	}
 
 	err := operation()
+	if stderrors.Is(err, ErrAbandoned) {
+		return err
+	}
 	cb.RecordResult(err)
 	return err
 }
@@ -73,9 +157,17 @@ func (cb *CircuitBreaker) AllowRequest() bool {
 		if time.Since(cb.lastFailure) > cb.timeout {
 			cb.mu.RUnlock()
 			cb.mu.Lock()
-			cb.state = StateHalfOpen
+			changed := cb.state == StateOpen
+			if changed {
+				cb.setState(StateHalfOpen)
+			}
 			cb.successCount = 0
+			cb.halfOpenFailures = 0
+			hook := cb.onStateChange
 			cb.mu.Unlock()
+			if changed && hook != nil {
+				hook(StateOpen, StateHalfOpen)
+			}
 			cb.mu.RLock()
 			return true
 		}
@@ -87,26 +179,73 @@ func (cb *CircuitBreaker) AllowRequest() bool {
 	}
 }
 
+// Ready reports whether AllowRequest would currently let a request through,
+// without changing the breaker's state
+func (cb *CircuitBreaker) Ready() bool {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return cb.state != StateOpen || time.Since(cb.lastFailure) > cb.timeout
+}
+
+// RetryAfter returns how long until an open breaker admits a trial
+// request, or 0 if it already would
+func (cb *CircuitBreaker) RetryAfter() time.Duration {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	if cb.state != StateOpen {
+		return 0
+	}
+	if wait := cb.timeout - time.Since(cb.lastFailure); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
 func (cb *CircuitBreaker) RecordResult(err error) {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	from := cb.state
+	cb.recordResult(err)
+	to, hook, probeHook := cb.state, cb.onStateChange, cb.onHalfOpenResult
+	cb.mu.Unlock()
+
+	if from == StateHalfOpen && probeHook != nil {
+		probeHook(err == nil)
+	}
+	if to != from && hook != nil {
+		hook(from, to)
+	}
+}
+
+// recordResult updates the breaker with the outcome of a request. Callers
+// must hold cb.mu for writing.
+func (cb *CircuitBreaker) recordResult(err error) {
+	now := time.Now()
+	if cb.window != nil && cb.state == StateClosed {
+		cb.window.Record(now, err != nil)
+	}
 
 	if err != nil {
 		cb.failures++
-		cb.lastFailure = time.Now()
+		cb.lastFailure = now
 
-		if cb.state == StateClosed && cb.failures >= cb.threshold {
-			cb.state = StateOpen
+		if cb.state == StateClosed && cb.shouldTrip(now) {
+			cb.setState(StateOpen)
 		} else if cb.state == StateHalfOpen {
-			cb.state = StateOpen
+			cb.halfOpenFailures++
+			if cb.halfOpenFailures >= cb.halfOpenFailureThreshold {
+				cb.setState(StateOpen)
+			}
 		}
 	} else {
 		switch cb.state {
 		case StateHalfOpen:
 			cb.successCount++
 			if cb.successCount >= cb.halfOpenMax {
-				cb.state = StateClosed
+				cb.setState(StateClosed)
 				cb.failures = 0
+				if cb.window != nil {
+					cb.window.Reset()
+				}
 			}
 		case StateClosed:
 			cb.failures = 0
@@ -114,17 +253,119 @@ func (cb *CircuitBreaker) RecordResult(err error) {
 	}
 }
 
+// shouldTrip reports whether a closed breaker should open after a failure
+// at now. Callers must hold cb.mu for writing.
+func (cb *CircuitBreaker) shouldTrip(now time.Time) bool {
+	if cb.window == nil {
+		return cb.failures >= cb.threshold
+	}
+	requests, failures := cb.window.Counts(now)
+	return requests >= cb.minRequests && float64(failures) >= cb.failureRatio*float64(requests)
+}
+
+// setState moves the breaker to state and logs the transition. Callers must
+// hold cb.mu for writing.
+func (cb *CircuitBreaker) setState(state State) {
+	from := cb.state
+	cb.state = state
+
+	level := slog.LevelInfo
+	if state == StateOpen {
+		level = slog.LevelWarn
+	}
+	cb.logger.Log(context.Background(), level, "circuit breaker state changed",
+		"name", cb.name, "from", from.String(), "to", state.String(), "failures", cb.failures)
+}
+
 func (cb *CircuitBreaker) GetState() State {
 	cb.mu.RLock()
 	defer cb.mu.RUnlock()
 	return cb.state
 }
 
-func (cb *CircuitBreaker) Reset() {
+// Stats is a snapshot of a breaker's state and of how its recovery is
+// going
+type Stats struct {
+	State State
+	// Failures counts consecutive failures
+	Failures int
+	// HalfOpenSuccesses and HalfOpenFailures count the trial requests that
+	// succeeded and failed since the breaker last went half-open
+	HalfOpenSuccesses int
+	HalfOpenFailures  int
+	// SuccessesNeeded is how many trial successes close the breaker, and
+	// FailuresAllowed how many trial failures reopen it
+	SuccessesNeeded int
+	FailuresAllowed int
+}
+
+// Stats returns a snapshot of the breaker
+func (cb *CircuitBreaker) Stats() Stats {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return Stats{
+		State:             cb.state,
+		Failures:          cb.failures,
+		HalfOpenSuccesses: cb.successCount,
+		HalfOpenFailures:  cb.halfOpenFailures,
+		SuccessesNeeded:   cb.halfOpenMax,
+		FailuresAllowed:   cb.halfOpenFailureThreshold,
+	}
+}
+
+// SetHalfOpenFailureThreshold changes how many failures the breaker
+// tolerates while half-open before reopening; zero or less means one
+func (cb *CircuitBreaker) SetHalfOpenFailureThreshold(threshold int) {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.halfOpenFailureThreshold = threshold
+}
+
+// SetHalfOpenMax changes how many trial requests must succeed before the
+// half-open breaker closes; zero or less means the default of 3
+func (cb *CircuitBreaker) SetHalfOpenMax(max int) {
+	if max <= 0 {
+		max = 3
+	}
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
-	
+	cb.halfOpenMax = max
+}
+
+func (cb *CircuitBreaker) Reset() {
+	cb.mu.Lock()
+	from, hook := cb.state, cb.onStateChange
 	cb.failures = 0
 	cb.state = StateClosed
 	cb.successCount = 0
+	cb.halfOpenFailures = 0
+	if cb.window != nil {
+		cb.window.Reset()
+	}
+	cb.mu.Unlock()
+
+	if from != StateClosed && hook != nil {
+		hook(from, StateClosed)
+	}
+}
+
+// SetOnStateChange registers a callback invoked whenever the breaker moves
+// between states. It runs after the breaker's lock is released, so it may
+// do slow work or call back into the breaker.
+func (cb *CircuitBreaker) SetOnStateChange(hook func(from, to State)) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.onStateChange = hook
+}
+
+// SetOnHalfOpenResult registers a callback invoked with the outcome of each
+// trial request recorded while the breaker is half-open. Like the state
+// change callback, it runs after the breaker's lock is released.
+func (cb *CircuitBreaker) SetOnHalfOpenResult(hook func(success bool)) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.onHalfOpenResult = hook
 }
@@ -1,8 +1,12 @@
 package circuitbreaker
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
 	"testing"
 	"time"
 )
@@ -189,3 +193,221 @@ func TestCircuitBreakerEdgeCases(t *testing.T) {
 		t.Error("Expected positive half-open max despite zero input")
 	}
 }
+
+func TestCircuitBreakerLogsTransitions(t *testing.T) {
+	var buf bytes.Buffer
+	cb := New(Config{
+		Threshold:   1,
+		Timeout:     10 * time.Millisecond,
+		HalfOpenMax: 1,
+		Name:        "http://backend1:9001",
+		Logger:      slog.New(slog.NewTextHandler(&buf, nil)),
+	})
+
+	cb.RecordResult(errors.New("boom"))
+	time.Sleep(20 * time.Millisecond)
+	cb.AllowRequest()
+	cb.RecordResult(nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{"from=closed to=open", "from=open to=half-open", "from=half-open to=closed"}
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d transitions logged, got %q", len(want), buf.String())
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, want[i]) || !strings.Contains(line, "name=http://backend1:9001") {
+			t.Errorf("Expected transition %d to log %q, got %q", i, want[i], line)
+		}
+	}
+}
+
+func TestCircuitBreakerFailureRatio(t *testing.T) {
+	cb := New(Config{
+		Threshold:    3,
+		Timeout:      100 * time.Millisecond,
+		HalfOpenMax:  1,
+		FailureRatio: 0.5,
+		MinRequests:  10,
+	})
+
+	failingOp := func() error {
+		return errors.New("test error")
+	}
+	succeedingOp := func() error {
+		return nil
+	}
+
+	// Alternating outcomes never reach the consecutive threshold, and the
+	// ratio is not considered until enough requests have been seen
+	for i := 0; i < 4; i++ {
+		_ = cb.Execute(failingOp)
+		_ = cb.Execute(succeedingOp)
+	}
+	if state := cb.GetState(); state != StateClosed {
+		t.Fatalf("Expected state to be Closed below the minimum volume, got %v", state)
+	}
+
+	_ = cb.Execute(succeedingOp)
+	_ = cb.Execute(failingOp)
+	if state := cb.GetState(); state != StateOpen {
+		t.Errorf("Expected state to be Open at a 50%% failure ratio, got %v", state)
+	}
+
+	// A mostly healthy backend stays closed
+	cb.Reset()
+	for i := 0; i < 20; i++ {
+		if i%4 == 0 {
+			_ = cb.Execute(failingOp)
+		} else {
+			_ = cb.Execute(succeedingOp)
+		}
+	}
+	if state := cb.GetState(); state != StateClosed {
+		t.Errorf("Expected state to be Closed at a 25%% failure ratio, got %v", state)
+	}
+}
+
+func TestCircuitBreakerOnStateChange(t *testing.T) {
+	cb := New(Config{
+		Threshold:   1,
+		Timeout:     10 * time.Millisecond,
+		HalfOpenMax: 1,
+	})
+
+	var transitions []string
+	cb.SetOnStateChange(func(from, to State) {
+		// The callback runs outside the lock, so it may use the breaker
+		if state := cb.GetState(); state != to {
+			t.Errorf("Expected breaker in state %v during callback, got %v", to, state)
+		}
+		transitions = append(transitions, from.String()+"->"+to.String())
+	})
+
+	cb.RecordResult(nil)
+	cb.RecordResult(errors.New("boom"))
+	cb.RecordResult(errors.New("boom"))
+	time.Sleep(20 * time.Millisecond)
+	cb.AllowRequest()
+	cb.RecordResult(nil)
+	cb.Reset()
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if strings.Join(transitions, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected transitions %v, got %v", want, transitions)
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureThreshold(t *testing.T) {
+	cb := New(Config{
+		Threshold:                1,
+		Timeout:                  10 * time.Millisecond,
+		HalfOpenMax:              3,
+		HalfOpenFailureThreshold: 2,
+	})
+
+	cb.RecordResult(errors.New("boom"))
+	time.Sleep(20 * time.Millisecond)
+	if !cb.AllowRequest() {
+		t.Fatal("Expected circuit to allow request after timeout")
+	}
+
+	// One failure is tolerated while half-open
+	cb.RecordResult(errors.New("boom"))
+	if state := cb.GetState(); state != StateHalfOpen {
+		t.Errorf("Expected state to stay Half-Open after one failure, got %v", state)
+	}
+
+	// The second reopens the circuit
+	cb.RecordResult(errors.New("boom"))
+	if state := cb.GetState(); state != StateOpen {
+		t.Errorf("Expected state to be Open after two half-open failures, got %v", state)
+	}
+
+	// The count starts again on the next half-open period
+	time.Sleep(20 * time.Millisecond)
+	cb.AllowRequest()
+	cb.RecordResult(errors.New("boom"))
+	if state := cb.GetState(); state != StateHalfOpen {
+		t.Errorf("Expected failure count to reset on entering Half-Open, got %v", state)
+	}
+}
+
+func TestCircuitBreakerRetryAfter(t *testing.T) {
+	cb := New(Config{Threshold: 1, Timeout: 200 * time.Millisecond})
+	if wait := cb.RetryAfter(); wait != 0 {
+		t.Errorf("Expected no wait while closed, got %v", wait)
+	}
+
+	cb.RecordResult(errors.New("failure"))
+	if wait := cb.RetryAfter(); wait <= 100*time.Millisecond || wait > 200*time.Millisecond {
+		t.Errorf("Expected a wait of about the timeout once open, got %v", wait)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if wait := cb.RetryAfter(); wait != 0 {
+		t.Errorf("Expected no wait once a trial request is due, got %v", wait)
+	}
+}
+
+func TestCircuitBreakerAbandoned(t *testing.T) {
+	cb := New(Config{Threshold: 2, Timeout: time.Minute})
+
+	// Abandoned operations neither trip the breaker nor reset its failures
+	cb.Execute(func() error { return errors.New("failure") })
+	for i := 0; i < 5; i++ {
+		err := cb.Execute(func() error { return fmt.Errorf("hedge lost: %w", ErrAbandoned) })
+		if !errors.Is(err, ErrAbandoned) {
+			t.Fatalf("Expected ErrAbandoned to be returned, got %v", err)
+		}
+	}
+	if state := cb.GetState(); state != StateClosed {
+		t.Fatalf("Expected abandoned operations not to open the circuit, got %v", state)
+	}
+
+	cb.Execute(func() error { return errors.New("failure") })
+	if state := cb.GetState(); state != StateOpen {
+		t.Errorf("Expected the earlier failure to still count, got %v", state)
+	}
+}
+
+func TestCircuitBreakerStats(t *testing.T) {
+	cb := New(Config{
+		Threshold:                1,
+		Timeout:                  10 * time.Millisecond,
+		HalfOpenMax:              3,
+		HalfOpenFailureThreshold: 2,
+	})
+	var probes []bool
+	cb.SetOnHalfOpenResult(func(success bool) { probes = append(probes, success) })
+
+	cb.RecordResult(errors.New("boom"))
+	if stats := cb.Stats(); stats.State != StateOpen || stats.Failures != 1 || stats.SuccessesNeeded != 3 || stats.FailuresAllowed != 2 {
+		t.Errorf("Unexpected stats once open: %+v", stats)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	cb.AllowRequest()
+	cb.RecordResult(nil)
+	cb.RecordResult(errors.New("boom"))
+	cb.RecordResult(nil)
+	want := Stats{State: StateHalfOpen, Failures: 2, HalfOpenSuccesses: 2, HalfOpenFailures: 1, SuccessesNeeded: 3, FailuresAllowed: 2}
+	if stats := cb.Stats(); stats != want {
+		t.Errorf("Expected probe progress %+v, got %+v", want, stats)
+	}
+	if len(probes) != 3 || !probes[0] || probes[1] || !probes[2] {
+		t.Errorf("Expected each probe outcome reported, got %v", probes)
+	}
+
+	// Needing fewer successes closes the circuit on the next one
+	cb.SetHalfOpenMax(1)
+	cb.RecordResult(nil)
+	if stats := cb.Stats(); stats.State != StateClosed || stats.SuccessesNeeded != 1 {
+		t.Errorf("Expected the circuit to close, got %+v", stats)
+	}
+
+	// Results while closed are not probes
+	cb.RecordResult(nil)
+	if len(probes) != 4 {
+		t.Errorf("Expected only half-open results reported, got %v", probes)
+	}
+}
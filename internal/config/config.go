@@ -4,32 +4,95 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"os"
 	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"loadbalancer/internal/errors"
 )
 
 type Frontend struct {
 	Port int `yaml:"port"`
+	// MaxConcurrentStreams bounds the HTTP/2 streams a client may have open
+	// on one connection. Zero uses the HTTP/2 server default.
+	MaxConcurrentStreams uint32 `yaml:"maxConcurrentStreams"`
+	// MaxConcurrentRequests caps the requests this frontend handles at
+	// once; requests beyond it are rejected with 503. Zero means no limit.
+	MaxConcurrentRequests int `yaml:"maxConcurrentRequests"`
+	// MaxRequestsPerConnection closes an HTTP/1.x client connection after
+	// it has carried this many requests, so long-lived clients are
+	// periodically rebalanced. Zero means no limit.
+	MaxRequestsPerConnection int `yaml:"maxRequestsPerConnection"`
 }
 
 type Backend struct {
 	URL    string `yaml:"url"`
 	Weight int    `yaml:"weight"`
+	// HealthPath overrides HealthCheck.Path for this backend
+	HealthPath string `yaml:"healthPath"`
+	// MaxConns caps the requests in flight to this backend. Requests
+	// beyond it go to another backend. 0 means no limit.
+	MaxConns int `yaml:"maxConns"`
+}
+
+// Custom unmarshaler for Backend so a backend can be given as a bare URL
+// string or as a mapping with per-backend settings
+func (b *Backend) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var url string
+	if err := unmarshal(&url); err == nil {
+		*b = Backend{URL: url}
+		return nil
+	}
+
+	type rawBackend Backend
+	raw := rawBackend{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	*b = Backend(raw)
+	return nil
+}
+
+// WeightStep changes a backend's weight at a set time, e.g. to ramp it up
+// over a maintenance window or shift traffic at a cutover
+type WeightStep struct {
+	// Backend is the URL of the backend, as configured
+	Backend string    `yaml:"backend"`
+	At      time.Time `yaml:"at"`
+	Weight  int       `yaml:"weight"`
+}
+
+// BackendsFromURLs builds backend entries with default settings for urls
+func BackendsFromURLs(urls []string) []Backend {
+	backends := make([]Backend, len(urls))
+	for i, url := range urls {
+		backends[i] = Backend{URL: url}
+	}
+	return backends
 }
 
 type HealthCheck struct {
-	Interval time.Duration `yaml:"interval"`
-	Timeout  time.Duration `yaml:"timeout"`
-	Path     string        `yaml:"path"`
+	Interval          time.Duration `yaml:"interval"`
+	UnhealthyInterval time.Duration `yaml:"unhealthyInterval"`
+	Timeout           time.Duration `yaml:"timeout"`
+	Path              string        `yaml:"path"`
+	// LoadHeader names a health check response header in which backends
+	// report their load from 0 (idle) to 1 (saturated). Busy backends get
+	// proportionally less traffic. Empty ignores reported load.
+	LoadHeader string `yaml:"loadHeader"`
 }
 
 // Custom unmarshaler for HealthCheck to parse duration strings
 func (h *HealthCheck) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type rawHealthCheck struct {
-		Interval string `yaml:"interval"`
-		Timeout  string `yaml:"timeout"`
-		Path     string `yaml:"path"`
+		Interval          string `yaml:"interval"`
+		UnhealthyInterval string `yaml:"unhealthyInterval"`
+		Timeout           string `yaml:"timeout"`
+		Path              string `yaml:"path"`
+		LoadHeader        string `yaml:"loadHeader"`
 	}
 	raw := &rawHealthCheck{}
 	if err := unmarshal(raw); err != nil {
@@ -46,6 +109,16 @@ func (h *HealthCheck) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		}
 	}
 
+	// Unhealthy backends default to the normal cadence
+	if raw.UnhealthyInterval == "" {
+		h.UnhealthyInterval = h.Interval
+	} else {
+		h.UnhealthyInterval, err = time.ParseDuration(raw.UnhealthyInterval)
+		if err != nil {
+			return fmt.Errorf("invalid unhealthyInterval duration: %v", err)
+		}
+	}
+
 	if raw.Timeout == "" {
 		h.Timeout = 2 * time.Second
 	} else {
@@ -60,13 +133,24 @@ func (h *HealthCheck) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	} else {
 		h.Path = raw.Path
 	}
+	h.LoadHeader = raw.LoadHeader
 
 	return nil
 }
 
+// IntervalFor returns how long to wait before the next probe of a backend
+// in the given health state
+func (h HealthCheck) IntervalFor(healthy bool) time.Duration {
+	if !healthy && h.UnhealthyInterval > 0 {
+		return h.UnhealthyInterval
+	}
+	return h.Interval
+}
+
 type Logging struct {
-	Level  string `yaml:"level"`
-	Format string `yaml:"format"`
+	Level     string `yaml:"level"`
+	Format    string `yaml:"format"`
+	AccessLog bool   `yaml:"accessLog"`
 }
 
 type Metrics struct {
@@ -74,20 +158,482 @@ type Metrics struct {
 	Port    int  `yaml:"port"`
 }
 
+// Startup controls how frontends bind their ports
+type Startup struct {
+	// BindTimeout is how long to keep retrying a frontend port that is in
+	// use, e.g. still held by a previous process during a restart
+	BindTimeout time.Duration `yaml:"bindTimeout"`
+	// BindBackoff is the initial delay between bind attempts; it doubles
+	// after each attempt
+	BindBackoff time.Duration `yaml:"bindBackoff"`
+}
+
+// Admin configures the admin HTTP server, which is disabled when Port is zero
+type Admin struct {
+	// Address is the interface to listen on; empty listens on all interfaces
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+	// StatsWindow is the span over which /backends reports each backend's
+	// recent successes and failures; zero uses one minute
+	StatsWindow time.Duration `yaml:"statsWindow"`
+	// TLS serves the admin API over HTTPS with its own certificates,
+	// independently of the frontends. When a CAFile is set, clients must
+	// present a certificate it signed unless ClientAuth says otherwise.
+	TLS *SSL `yaml:"tls"`
+	// Auth requires credentials on every admin request
+	Auth AdminAuth `yaml:"auth"`
+}
+
+// AdminAuth holds the credentials admin requests must present: a bearer
+// Token, or HTTP basic auth with Username and Password. Either is accepted
+// when both are set. With neither, the admin API is open to anyone who can
+// reach it.
+type AdminAuth struct {
+	Token    string `yaml:"token"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Enabled reports whether any credentials are configured
+func (a AdminAuth) Enabled() bool {
+	return a.Token != "" || a.Username != ""
+}
+
+// RateLimit holds settings for the per-backend rate limiters
+type RateLimit struct {
+	// FailureMode is "open" (allow traffic) or "closed" (reject traffic)
+	// when the limiter cannot reach its backing store
+	FailureMode string `yaml:"failureMode"`
+	// RetryAfterBackoff adds a Retry-After header to 429 responses that
+	// grows exponentially, with jitter, while the same client keeps being
+	// rejected
+	RetryAfterBackoff bool `yaml:"retryAfterBackoff"`
+	// BackoffBase and BackoffMax bound the suggested wait; they default to
+	// 1s and 60 times BackoffBase
+	BackoffBase time.Duration `yaml:"backoffBase"`
+	BackoffMax  time.Duration `yaml:"backoffMax"`
+}
+
+// LegacyHTTP controls handling of HTTP/1.0 clients
+type LegacyHTTP struct {
+	// CloseConnections closes HTTP/1.0 connections after every response,
+	// even when the client asks for keep-alive
+	CloseConnections bool `yaml:"closeConnections"`
+}
+
+// Sticky configures cookie-based session affinity
+type Sticky struct {
+	Enabled    bool   `yaml:"enabled"`
+	CookieName string `yaml:"cookieName"`
+	// IdleTimeout drops affinity for clients idle longer than this; every
+	// request renews it. Zero keeps affinity for the browser session.
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	// CookieConflict decides what happens when a backend sets a cookie with
+	// the same name as the affinity cookie: "balancer" (the default) drops
+	// the backend's cookie, "backend" keeps it and skips the affinity
+	// cookie. Other backend cookies are always passed through.
+	CookieConflict string `yaml:"cookieConflict"`
+	// Secret signs affinity cookies so clients cannot forge or extend them.
+	// Balancers sharing clients need the same secret; when empty a random
+	// one is generated and affinity does not survive restarts.
+	Secret string `yaml:"secret"`
+}
+
+// Tracing configures trace context handling. Incoming W3C and B3 trace
+// headers are always passed through to backends unchanged.
+type Tracing struct {
+	// GenerateTraceparent adds a new traceparent header to requests that
+	// arrive without any trace context
+	GenerateTraceparent bool `yaml:"generateTraceparent"`
+}
+
+// Balancing tunes backend selection
+type Balancing struct {
+	// OverloadFactor skips the backend chosen by weighted round-robin when its
+	// active connections exceed this multiple of the pool average, taking the
+	// next candidate instead. Zero disables the check.
+	OverloadFactor float64 `yaml:"overloadFactor"`
+	// DefaultWeight is the weight of backends listed without one, such as
+	// bare URLs. Zero uses a weight of 1.
+	DefaultWeight int `yaml:"defaultWeight"`
+}
+
+// AdaptiveConcurrency caps concurrent requests per backend, cutting the cap
+// when responses are slower than TargetLatency and raising it again as
+// latency recovers. It is disabled when TargetLatency is zero.
+type AdaptiveConcurrency struct {
+	TargetLatency time.Duration `yaml:"targetLatency"`
+	MinLimit      int           `yaml:"minLimit"`
+	MaxLimit      int           `yaml:"maxLimit"`
+	// Increase is how much the cap grows per round of fast responses
+	Increase float64 `yaml:"increase"`
+	// Decrease is the factor, between 0 and 1, applied to the cap after a
+	// slow response
+	Decrease float64 `yaml:"decrease"`
+}
+
+// Canary sends a share of traffic to a separate pool of canary backends
+type Canary struct {
+	Backends []Backend `yaml:"backends"`
+	Percent  float64   `yaml:"percent"`
+	// Header names a request header that forces routing to the canary pool
+	// when it carries HeaderValue, regardless of Percent
+	Header      string `yaml:"header"`
+	HeaderValue string `yaml:"headerValue"`
+	// TrustedSources limits the header override to clients in these CIDRs.
+	// Empty trusts every client.
+	TrustedSources []string `yaml:"trustedSources"`
+}
+
+// LargeRequests sends requests with large bodies to a dedicated pool of
+// backends suited to big payloads
+type LargeRequests struct {
+	Backends []Backend `yaml:"backends"`
+	// Threshold is the Content-Length, in bytes, above which a request is
+	// sent to the large-payload pool
+	Threshold int64 `yaml:"threshold"`
+	// UnknownLength routes requests whose length is not known up front,
+	// such as chunked uploads: "default" (the main pool) or "large"
+	UnknownLength string `yaml:"unknownLength"`
+}
+
+// Compression gzips backend responses sent uncompressed, for clients that
+// accept gzip
+type Compression struct {
+	Enabled bool `yaml:"enabled"`
+	// MinSize is the smallest Content-Length, in bytes, worth compressing;
+	// 1024 by default. Bodies of unknown length are always compressed.
+	MinSize int64 `yaml:"minSize"`
+	// ContentTypes lists the media types to compress, "text/*" matching
+	// every text type. By default text, JSON, JavaScript, XML and SVG are
+	// compressed.
+	ContentTypes []string `yaml:"contentTypes"`
+}
+
+// Cache keeps GET responses that backends mark cacheable with max-age or
+// s-maxage in memory, answering repeat requests without a backend
+type Cache struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxEntries bounds the number of cached responses, evicting the least
+	// recently used; 1000 by default
+	MaxEntries int `yaml:"maxEntries"`
+	// MaxEntryBytes is the largest body cached; 1 MiB by default
+	MaxEntryBytes int64 `yaml:"maxEntryBytes"`
+	// StaleWhileRevalidate is how long after expiring an entry is still
+	// served, while it is refreshed from a backend in the background. A
+	// response's own stale-while-revalidate directive takes precedence.
+	// Zero serves no expired entries unless the response allows it.
+	StaleWhileRevalidate time.Duration `yaml:"staleWhileRevalidate"`
+}
+
+// CircuitBreaker holds settings for the per-backend circuit breakers
+type CircuitBreaker struct {
+	// CountRateLimited counts 429 responses from a backend as failures
+	// towards opening its circuit. By default they are relayed to the client
+	// without affecting the circuit.
+	CountRateLimited bool `yaml:"countRateLimited"`
+	// HalfOpenFailureThreshold is how many failures a recovering backend's
+	// circuit tolerates before reopening. Zero reopens on the first.
+	HalfOpenFailureThreshold int `yaml:"halfOpenFailureThreshold"`
+	// HalfOpenSuccesses is how many trial requests a recovering backend's
+	// circuit needs to succeed before it closes, 2 by default. Fewer closes
+	// circuits sooner; more waits for steadier evidence of recovery.
+	HalfOpenSuccesses int `yaml:"halfOpenSuccesses"`
+	// OpenStatus is the status returned when every backend's circuit is
+	// open, 503 by default; some clients retry 503 forever
+	OpenStatus int `yaml:"openStatus"`
+	// OpenHeaders are added to those responses. Unless they include one,
+	// Retry-After is set to when the first circuit admits a trial request.
+	OpenHeaders map[string]string `yaml:"openHeaders"`
+	// GRPCFailureCodes names the gRPC status codes, such as UNAVAILABLE,
+	// that count as failures when a backend returns them in grpc-status.
+	// gRPC responses are HTTP 200 whatever the outcome, so by default every
+	// gRPC call counts as a success.
+	GRPCFailureCodes []string `yaml:"grpcFailureCodes"`
+}
+
+// grpcCodes maps gRPC status code names to the values sent in grpc-status
+var grpcCodes = map[string]string{
+	"CANCELLED":           "1",
+	"UNKNOWN":             "2",
+	"INVALID_ARGUMENT":    "3",
+	"DEADLINE_EXCEEDED":   "4",
+	"NOT_FOUND":           "5",
+	"ALREADY_EXISTS":      "6",
+	"PERMISSION_DENIED":   "7",
+	"RESOURCE_EXHAUSTED":  "8",
+	"FAILED_PRECONDITION": "9",
+	"ABORTED":             "10",
+	"OUT_OF_RANGE":        "11",
+	"UNIMPLEMENTED":       "12",
+	"INTERNAL":            "13",
+	"UNAVAILABLE":         "14",
+	"DATA_LOSS":           "15",
+	"UNAUTHENTICATED":     "16",
+}
+
+// GRPCFailureStatuses returns the grpc-status values named by
+// GRPCFailureCodes. Unknown names are skipped; Validate rejects them.
+func (cb CircuitBreaker) GRPCFailureStatuses() map[string]bool {
+	statuses := make(map[string]bool, len(cb.GRPCFailureCodes))
+	for _, name := range cb.GRPCFailureCodes {
+		if status, ok := grpcCodes[name]; ok {
+			statuses[status] = true
+		}
+	}
+	return statuses
+}
+
+// Route holds per-path-prefix request handling options
+type Route struct {
+	Path string `yaml:"path"`
+	// Buffering is "stream" to pass bodies through as they arrive, "buffer"
+	// to read request and response bodies fully before forwarding, or empty
+	// for the proxy's default behaviour. Unbuffered responses whose backend
+	// delimits the body by closing its connection are sent to HTTP/1.1
+	// clients chunked; buffering sends them with a Content-Length instead.
+	Buffering string `yaml:"buffering"`
+	// Pool names the backend pool serving this prefix; empty uses the
+	// default pool
+	Pool string `yaml:"pool"`
+	// Timeout overrides RequestTimeout for this prefix, e.g. for slow
+	// exports; zero uses RequestTimeout
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// VirtualHost sends requests for a host name to a backend pool
+type VirtualHost struct {
+	// Host is an exact name, or "*.example.com" to match any subdomain of
+	// example.com
+	Host string `yaml:"host"`
+	Pool string `yaml:"pool"`
+}
+
+// Pool is a named group of backends that routes send traffic to. Each pool
+// has its own rotation; its backends are health checked like any other.
+type Pool struct {
+	Name     string    `yaml:"name"`
+	Backends []Backend `yaml:"backends"`
+}
+
+// Discovery fills the main pool from a service registry instead of the
+// static backends list. Type is "consul", "kubernetes" or "dns".
+type Discovery struct {
+	Type string `yaml:"type"`
+	// Address is the registry's HTTP API, e.g. "http://127.0.0.1:8500".
+	// Kubernetes defaults to the in-cluster API server.
+	Address string `yaml:"address"`
+	// Service is the name whose healthy instances become backends
+	Service string `yaml:"service"`
+	// Namespace holds the Kubernetes service; defaults to the balancer's
+	// own namespace
+	Namespace string `yaml:"namespace"`
+	// Port picks the Kubernetes endpoint port by name or number when the
+	// service has several; the first is used otherwise
+	Port string `yaml:"port"`
+	// Domain is the SRV record name resolved for DNS discovery, e.g.
+	// "_http._tcp.web.example.com"
+	Domain string `yaml:"domain"`
+	// MinInterval is the shortest time between DNS lookups, 5s by default.
+	// Lookups otherwise follow the records' TTL.
+	MinInterval time.Duration `yaml:"minInterval"`
+	// Scheme is used in the backend URLs built from instances; defaults to
+	// http
+	Scheme string `yaml:"scheme"`
+	// Token is sent as the Consul ACL token when set
+	Token string `yaml:"token"`
+}
+
+// ServerOptions configures the balancer's own reply to server-wide
+// "OPTIONS *" requests, which are never forwarded to a backend
+type ServerOptions struct {
+	// Allow lists the methods advertised in the Allow header; empty
+	// advertises the standard HTTP methods
+	Allow []string `yaml:"allow"`
+}
+
+// ForwardedHeaders controls the forwarding headers sent to backends
+type ForwardedHeaders struct {
+	// XForwardedFor is "append" (the default) to add the client address to
+	// any X-Forwarded-For set by an upstream proxy, or "overwrite" to
+	// replace it with the client address alone
+	XForwardedFor string `yaml:"xForwardedFor"`
+	// ClientCert tells backends about the verified client certificate of a
+	// mutual TLS connection in X-Client-Cert-CN and
+	// X-Client-Cert-Fingerprint (hex SHA-256). Client-supplied values of
+	// those headers are dropped so they cannot be spoofed.
+	ClientCert bool `yaml:"clientCert"`
+	// Mappings copy a value from each request into a header for backends,
+	// e.g. a JWT's sub claim into X-User-ID. Claims are read from the
+	// bearer token without checking its signature, so map them only when
+	// the token is verified before or after the balancer. A value the
+	// client sent in a target header is always dropped.
+	Mappings []HeaderMapping `yaml:"mappings"`
+}
+
+// HeaderMapping copies a request value into a header sent to backends
+type HeaderMapping struct {
+	// From is where the value comes from: "claim" of the bearer JWT,
+	// "cookie" or "header"
+	From string `yaml:"from"`
+	// Name is the claim, cookie or header to read
+	Name string `yaml:"name"`
+	// Header is the header set on the backend request
+	Header string `yaml:"header"`
+}
+
+// Transport holds settings for the HTTP transport used to reach backends
+type Transport struct {
+	MaxResponseHeaderBytes int64 `yaml:"maxResponseHeaderBytes"`
+	// DNSRefreshInterval is how often hostname backends are re-resolved so
+	// traffic follows IP changes. A negative value disables re-resolution.
+	DNSRefreshInterval time.Duration `yaml:"dnsRefreshInterval"`
+	// KeepAliveIdle is how long a backend connection may sit idle before
+	// TCP keepalive probes start, KeepAliveInterval the time between probes
+	// and KeepAliveCount how many unanswered probes drop the connection.
+	// Probes detect half-open connections, e.g. ones silently dropped by a
+	// stateful firewall. Zero values use the defaults of 30s, 15s and 9; a
+	// negative KeepAliveIdle disables probes.
+	KeepAliveIdle     time.Duration `yaml:"keepAliveIdle"`
+	KeepAliveInterval time.Duration `yaml:"keepAliveInterval"`
+	KeepAliveCount    int           `yaml:"keepAliveCount"`
+}
+
 type SSL struct {
 	CertFile   string            `yaml:"certFile"`
 	KeyFile    string            `yaml:"keyFile"`
 	CAFile     string            `yaml:"caFile"`
 	ClientAuth tls.ClientAuthType `yaml:"clientAuth"`
+
+	// Certificates serves further domains from one frontend, picking the
+	// certificate by the server name the client sends. CertFile and KeyFile
+	// may be left empty when certificates are listed here; the first
+	// certificate is the default for clients matching none.
+	Certificates []CertificatePair `yaml:"certificates"`
+
+	// MinVersion is the oldest TLS version accepted, "1.0" to "1.3";
+	// empty means 1.2
+	MinVersion string `yaml:"minVersion"`
+	// CipherSuites restricts TLS 1.2 and older connections to the named
+	// suites, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Empty uses the
+	// Go defaults. TLS 1.3 suites are not configurable.
+	CipherSuites []string `yaml:"cipherSuites"`
+}
+
+// CertificatePair names the files of one certificate and its private key
+type CertificatePair struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
 }
 
 type Config struct {
 	Frontends   []Frontend  `yaml:"frontends"`
-	Backends    []string    `yaml:"backends"`
+	Backends    []Backend   `yaml:"backends"`
 	HealthCheck HealthCheck `yaml:"healthcheck"`
 	Logging     Logging     `yaml:"logging"`
 	Metrics     Metrics     `yaml:"metrics"`
+	Admin       Admin       `yaml:"admin"`
+	Startup     Startup     `yaml:"startup"`
 	SSL         *SSL        `yaml:"ssl"`
+	Transport   Transport   `yaml:"transport"`
+	RateLimit   RateLimit   `yaml:"ratelimit"`
+	LegacyHTTP  LegacyHTTP  `yaml:"legacyHTTP"`
+	Sticky      Sticky      `yaml:"sticky"`
+	Balancing   Balancing   `yaml:"balancing"`
+	Tracing     Tracing     `yaml:"tracing"`
+	Canary      Canary      `yaml:"canary"`
+	Routes      []Route     `yaml:"routes"`
+	Pools       []Pool      `yaml:"pools"`
+
+	// Discovery, when set, keeps the main pool in step with a service
+	// registry; the backends list is then ignored
+	Discovery *Discovery `yaml:"discovery"`
+
+	// WeightSchedule lists weight changes applied as their times arrive.
+	// Steps already past at startup are applied straight away, in order.
+	WeightSchedule []WeightStep `yaml:"weightSchedule"`
+
+	AdaptiveConcurrency AdaptiveConcurrency `yaml:"adaptiveConcurrency"`
+	ServerOptions       ServerOptions       `yaml:"serverOptions"`
+	ForwardedHeaders    ForwardedHeaders    `yaml:"forwardedHeaders"`
+	LargeRequests       LargeRequests       `yaml:"largeRequests"`
+	Compression         Compression         `yaml:"compression"`
+	Cache               Cache               `yaml:"cache"`
+	CircuitBreaker      CircuitBreaker      `yaml:"circuitbreaker"`
+
+	// AllowedHosts, when set, rejects requests whose Host header is not
+	// listed. "*.example.com" allows any subdomain of example.com.
+	AllowedHosts []string `yaml:"allowedHosts"`
+
+	// StrictFraming rejects HTTP/1 requests whose body length is ambiguous
+	// with a 400 before they reach a backend: both Transfer-Encoding and
+	// Content-Length, repeated or malformed Content-Length values, a
+	// Transfer-Encoding other than a single "chunked", or folded header
+	// lines. Request heads are checked as they are read off the connection,
+	// since net/http quietly resolves most of these. Backends that parse
+	// such requests differently from the balancer could otherwise be fed a
+	// smuggled request.
+	StrictFraming bool `yaml:"strictFraming"`
+
+	// MaxRequestBodyBytes rejects request bodies larger than this with a
+	// 413. Bodies declaring a larger Content-Length are refused before a
+	// backend is contacted; others are cut off once they pass the limit.
+	// Zero means no limit.
+	MaxRequestBodyBytes int64 `yaml:"maxRequestBodyBytes"`
+
+	// RewriteHost sets the Host header sent to backends to the backend's own
+	// host. By default the client's Host header is passed through, so that
+	// virtual-hosted backends can route on it.
+	RewriteHost bool `yaml:"rewriteHost"`
+
+	// RequestTimeout bounds how long a request may wait on its backend.
+	// Streaming responses, server-sent events and bodies of unknown length,
+	// are exempt once their headers arrive. Zero uses 30s; a negative value
+	// disables the timeout.
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+
+	// HedgeDelay sends a GET, HEAD or OPTIONS request without a body to a
+	// second backend as well when the first has not started responding
+	// within it. The first response is relayed and the other request
+	// cancelled. Zero disables hedging.
+	HedgeDelay time.Duration `yaml:"hedgeDelay"`
+
+	// DrainTimeout is how long a removed backend's in-flight requests are
+	// waited for before its connections are closed, 30s by default
+	DrainTimeout time.Duration `yaml:"drainTimeout"`
+
+	// ShutdownTimeout is how long shutdown waits for in-flight requests
+	// before closing the connections still open, 5s by default
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+
+	// MaxRetries is how many times an idempotent request is retried on a
+	// different backend after a connection failure or a 502, 503 or 504
+	MaxRetries int `yaml:"maxRetries"`
+
+	// VirtualHosts send requests to a pool by their Host header, ahead of
+	// path routes. Exact names win over wildcards, and longer wildcards
+	// over shorter ones.
+	VirtualHosts []VirtualHost `yaml:"virtualHosts"`
+
+	// DefaultPool names the pool for requests whose route has no pool,
+	// including requests matching no route. Empty uses the main backends;
+	// if there are none, such requests get 404.
+	DefaultPool string `yaml:"defaultPool"`
+
+	// TrailingSlash normalizes request paths before routing: "passthrough"
+	// (the default) leaves them alone, "redirect" redirects /path to /path/
+	// and "strip" forwards /path/ as /path
+	TrailingSlash string `yaml:"trailingSlash"`
+
+	// Algorithm names the backend selection algorithm, as registered with
+	// algorithm.RegisterSelector, such as "iphash" or "random"; empty selects
+	// weighted_round_robin
+	Algorithm string `yaml:"algorithm"`
+
+	// Deterministic makes backend selection reproducible: weights are never
+	// adjusted at runtime and the rotation starts at Seed
+	Deterministic bool  `yaml:"deterministic"`
+	Seed          int64 `yaml:"seed"`
 }
 
 func Load(path string) (*Config, error) {
@@ -97,7 +643,7 @@ func Load(path string) (*Config, error) {
 	}
 
 	config := &Config{}
-	if err := yaml.Unmarshal(data, config); err != nil {
+	if err := yaml.Unmarshal(expandEnv(data), config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %v", err)
 	}
 
@@ -108,12 +654,33 @@ func Load(path string) (*Config, error) {
 	if config.HealthCheck.Interval == 0 {
 		config.HealthCheck.Interval = 10 * time.Second
 	}
+	if config.HealthCheck.UnhealthyInterval == 0 {
+		config.HealthCheck.UnhealthyInterval = config.HealthCheck.Interval
+	}
 	if config.HealthCheck.Timeout == 0 {
 		config.HealthCheck.Timeout = 2 * time.Second
 	}
 	if config.Metrics.Port == 0 {
 		config.Metrics.Port = 9090
 	}
+	if config.Transport.MaxResponseHeaderBytes == 0 {
+		config.Transport.MaxResponseHeaderBytes = 1 << 20
+	}
+	if config.Transport.DNSRefreshInterval == 0 {
+		config.Transport.DNSRefreshInterval = 30 * time.Second
+	}
+	if config.Startup.BindTimeout == 0 {
+		config.Startup.BindTimeout = 5 * time.Second
+	}
+	if config.Startup.BindBackoff == 0 {
+		config.Startup.BindBackoff = 50 * time.Millisecond
+	}
+	if config.RateLimit.FailureMode == "" {
+		config.RateLimit.FailureMode = "open"
+	}
+	if config.Sticky.CookieName == "" {
+		config.Sticky.CookieName = "lb_affinity"
+	}
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
@@ -121,5 +688,198 @@ func Load(path string) (*Config, error) {
 		config.Logging.Format = "json"
 	}
 
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
+
+// expandEnv replaces ${VAR} and $VAR references in data with the values
+// of the environment variables, so secrets and ports can be templated into
+// the file. $$ is a literal $. Unset variables expand to empty and are
+// logged.
+func expandEnv(data []byte) []byte {
+	return []byte(os.Expand(string(data), func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			slog.Warn("config references unset environment variable", "variable", name)
+		}
+		return value
+	}))
+}
+
+// Validate checks that the configuration can run: at least one frontend on
+// distinct ports, at least one backend with an http or https URL unless
+// discovery is configured, and
+// certificate files that exist wherever TLS is enabled. It returns an
+// ErrConfigInvalid error describing the first problem found.
+func (c *Config) Validate() error {
+	if len(c.Frontends) == 0 {
+		return errors.New(errors.ErrConfigInvalid, "at least one frontend is required", nil)
+	}
+	ports := make(map[int]bool, len(c.Frontends))
+	for _, frontend := range c.Frontends {
+		if frontend.Port <= 0 || frontend.Port > 65535 {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid frontend port %d", frontend.Port), nil)
+		}
+		if ports[frontend.Port] {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("duplicate frontend port %d", frontend.Port), nil)
+		}
+		ports[frontend.Port] = true
+	}
+
+	backends := c.Backends
+	for _, pool := range c.Pools {
+		backends = append(backends[:len(backends):len(backends)], pool.Backends...)
+	}
+	if c.Discovery != nil {
+		if err := c.Discovery.validate(); err != nil {
+			return err
+		}
+	} else if len(backends) == 0 {
+		return errors.New(errors.ErrConfigInvalid, "at least one backend is required", nil)
+	}
+	for _, backend := range backends {
+		u, err := url.Parse(backend.URL)
+		if err != nil {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid backend URL %q", backend.URL), err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("backend URL %q must use http or https", backend.URL), nil)
+		}
+		if u.Host == "" {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("backend URL %q has no host", backend.URL), nil)
+		}
+		if backend.MaxConns < 0 {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid maxConns %d for backend %s", backend.MaxConns, backend.URL), nil)
+		}
+	}
+
+	configured := make(map[string]bool, len(backends))
+	for _, backend := range backends {
+		configured[backend.URL] = true
+	}
+	for _, step := range c.WeightSchedule {
+		if !configured[step.Backend] {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("weight schedule names unknown backend %q", step.Backend), nil)
+		}
+		if step.At.IsZero() || step.Weight <= 0 {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("weight schedule step for %s needs a time and a positive weight", step.Backend), nil)
+		}
+	}
+
+	if c.Compression.MinSize < 0 {
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid compression minSize %d", c.Compression.MinSize), nil)
+	}
+	if c.Cache.MaxEntries < 0 || c.Cache.MaxEntryBytes < 0 || c.Cache.StaleWhileRevalidate < 0 {
+		return errors.New(errors.ErrConfigInvalid, "cache limits and staleWhileRevalidate must not be negative", nil)
+	}
+	if c.HedgeDelay < 0 {
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid hedgeDelay %v", c.HedgeDelay), nil)
+	}
+	if c.MaxRequestBodyBytes < 0 {
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid maxRequestBodyBytes %d", c.MaxRequestBodyBytes), nil)
+	}
+
+	if c.CircuitBreaker.HalfOpenSuccesses < 0 {
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid circuit breaker halfOpenSuccesses %d", c.CircuitBreaker.HalfOpenSuccesses), nil)
+	}
+	if status := c.CircuitBreaker.OpenStatus; status != 0 && (status < 400 || status > 599) {
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("circuit breaker open status %d is not an error status", status), nil)
+	}
+	for _, name := range c.CircuitBreaker.GRPCFailureCodes {
+		if _, ok := grpcCodes[name]; !ok {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown gRPC status code %q", name), nil)
+		}
+	}
+
+	if c.SSL != nil {
+		if err := c.SSL.validate("ssl"); err != nil {
+			return err
+		}
+	}
+	if (c.Admin.Auth.Username == "") != (c.Admin.Auth.Password == "") {
+		return errors.New(errors.ErrConfigInvalid, "admin.auth basic auth requires both username and password", nil)
+	}
+	if c.Admin.TLS != nil {
+		if err := c.Admin.TLS.validate("admin.tls"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks that the certificate, key and CA files named in the TLS
+// settings at path exist
+func (s *SSL) validate(path string) error {
+	pairs := s.Certificates
+	if s.CertFile != "" || s.KeyFile != "" || len(pairs) == 0 {
+		pairs = append([]CertificatePair{{CertFile: s.CertFile, KeyFile: s.KeyFile}}, pairs...)
+	}
+
+	checkFile := func(name, file string) error {
+		if _, err := os.Stat(file); err != nil {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("%s %s %q is not readable", path, name, file), err)
+		}
+		return nil
+	}
+	for _, pair := range pairs {
+		if pair.CertFile == "" || pair.KeyFile == "" {
+			return errors.New(errors.ErrConfigInvalid, path+" requires both a certFile and a keyFile", nil)
+		}
+		if err := checkFile("certFile", pair.CertFile); err != nil {
+			return err
+		}
+		if err := checkFile("keyFile", pair.KeyFile); err != nil {
+			return err
+		}
+	}
+	if s.CAFile != "" {
+		if err := checkFile("caFile", s.CAFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks that the discovery settings name a supported registry,
+// where to reach it if that cannot be found, and which service or domain to
+// watch
+func (d *Discovery) validate() error {
+	switch d.Type {
+	case "consul", "kubernetes":
+		if d.Service == "" {
+			return errors.New(errors.ErrConfigInvalid, "discovery requires a service", nil)
+		}
+	case "dns":
+		if d.Domain == "" {
+			return errors.New(errors.ErrConfigInvalid, "dns discovery requires a domain", nil)
+		}
+		if d.MinInterval < 0 {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid discovery minInterval %v", d.MinInterval), nil)
+		}
+		return d.validateScheme()
+	default:
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unsupported discovery type %q", d.Type), nil)
+	}
+	// Only Kubernetes can find its API server unaided
+	if d.Address != "" || d.Type == "consul" {
+		u, err := url.Parse(d.Address)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("discovery address %q must be an http or https URL", d.Address), err)
+		}
+	}
+	return d.validateScheme()
+}
+
+// validateScheme checks the scheme of the backend URLs built from
+// discovered instances
+func (d *Discovery) validateScheme() error {
+	if d.Scheme != "" && d.Scheme != "http" && d.Scheme != "https" {
+		return errors.New(errors.ErrConfigInvalid, fmt.Sprintf("discovery scheme %q must be http or https", d.Scheme), nil)
+	}
+	return nil
+}
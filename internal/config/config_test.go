@@ -1,11 +1,25 @@
 package config
 
 import (
+	"bytes"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"loadbalancer/internal/errors"
 )
 
+// minimalConfig is the smallest configuration that passes validation
+const minimalConfig = `
+frontends:
+- port: 8080
+backends:
+- "http://backend1:9001"
+`
+
 func TestLoad(t *testing.T) {
 	// Create a temporary config file
 	content := `
@@ -64,11 +78,11 @@ metrics:
 	if len(cfg.Backends) != 2 {
 		t.Errorf("Expected 2 backends, got %d", len(cfg.Backends))
 	}
-	if cfg.Backends[0] != "http://backend1:9001" {
-		t.Errorf("Expected backend1 URL, got %s", cfg.Backends[0])
+	if cfg.Backends[0].URL != "http://backend1:9001" {
+		t.Errorf("Expected backend1 URL, got %s", cfg.Backends[0].URL)
 	}
-	if cfg.Backends[1] != "http://backend2:9002" {
-		t.Errorf("Expected backend2 URL, got %s", cfg.Backends[1])
+	if cfg.Backends[1].URL != "http://backend2:9002" {
+		t.Errorf("Expected backend2 URL, got %s", cfg.Backends[1].URL)
 	}
 
 	// Verify healthcheck
@@ -168,3 +182,345 @@ func TestLoadInvalidFile(t *testing.T) {
 		t.Error("Expected error loading invalid YAML")
 	}
 }
+
+func TestLoadUnhealthyInterval(t *testing.T) {
+	content := `
+frontends:
+- port: 8080
+
+backends:
+- "http://backend1:9001"
+
+healthcheck:
+  interval: "10s"
+  unhealthyInterval: "2s"
+`
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatalf("Failed to close temp file: %v", err)
+	}
+
+	cfg, err := Load(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.HealthCheck.UnhealthyInterval != 2*time.Second {
+		t.Errorf("Expected 2s unhealthy interval, got %v", cfg.HealthCheck.UnhealthyInterval)
+	}
+	if got := cfg.HealthCheck.IntervalFor(true); got != 10*time.Second {
+		t.Errorf("Expected healthy backends to be probed every 10s, got %v", got)
+	}
+	if got := cfg.HealthCheck.IntervalFor(false); got != 2*time.Second {
+		t.Errorf("Expected unhealthy backends to be probed every 2s, got %v", got)
+	}
+
+	// Without an override unhealthy backends use the normal interval
+	hc := HealthCheck{Interval: 5 * time.Second}
+	if got := hc.IntervalFor(false); got != 5*time.Second {
+		t.Errorf("Expected fallback to 5s interval, got %v", got)
+	}
+}
+
+func TestLoadBackendObjects(t *testing.T) {
+	content := `
+frontends:
+- port: 8080
+
+backends:
+- "http://backend1:9001"
+- url: "http://backend2:9002"
+  weight: 3
+  healthPath: "/status"
+`
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatalf("Failed to close temp file: %v", err)
+	}
+
+	cfg, err := Load(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	want := []Backend{
+		{URL: "http://backend1:9001"},
+		{URL: "http://backend2:9002", Weight: 3, HealthPath: "/status"},
+	}
+	if len(cfg.Backends) != len(want) {
+		t.Fatalf("Expected %d backends, got %d", len(want), len(cfg.Backends))
+	}
+	for i, b := range cfg.Backends {
+		if b != want[i] {
+			t.Errorf("Expected backend %d to be %+v, got %+v", i, want[i], b)
+		}
+	}
+}
+
+func TestLoadRequestTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{`"5s"`, 5 * time.Second},
+		{`"1m30s"`, 90 * time.Second},
+		{`"-1s"`, -time.Second}, // disabled
+	}
+
+	for _, tt := range tests {
+		tmpfile, err := os.CreateTemp("", "config-*.yaml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(tmpfile.Name())
+
+		content := minimalConfig + "requestTimeout: " + tt.value + "\n"
+		if _, err := tmpfile.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write temp file: %v", err)
+		}
+		if err := tmpfile.Close(); err != nil {
+			t.Fatalf("Failed to close temp file: %v", err)
+		}
+
+		cfg, err := Load(tmpfile.Name())
+		if err != nil {
+			t.Fatalf("Failed to load config with requestTimeout %s: %v", tt.value, err)
+		}
+		if cfg.RequestTimeout != tt.want {
+			t.Errorf("Expected request timeout %v for %s, got %v", tt.want, tt.value, cfg.RequestTimeout)
+		}
+	}
+}
+
+func TestLoadSSLCertificates(t *testing.T) {
+	// Certificate files only have to exist to load
+	dir := t.TempDir()
+	for _, name := range []string{"default.pem", "default-key.pem", "api.pem", "api-key.pem"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0600)
+	}
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	content := minimalConfig + `
+ssl:
+  certFile: "default.pem"
+  keyFile: "default-key.pem"
+  certificates:
+  - certFile: "api.pem"
+    keyFile: "api-key.pem"
+`
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatalf("Failed to close temp file: %v", err)
+	}
+
+	cfg, err := Load(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.SSL == nil || cfg.SSL.CertFile != "default.pem" || cfg.SSL.KeyFile != "default-key.pem" {
+		t.Fatalf("Expected the single certificate form to load, got %+v", cfg.SSL)
+	}
+	want := []CertificatePair{{CertFile: "api.pem", KeyFile: "api-key.pem"}}
+	if len(cfg.SSL.Certificates) != 1 || cfg.SSL.Certificates[0] != want[0] {
+		t.Errorf("Expected certificates %+v, got %+v", want, cfg.SSL.Certificates)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, nil, 0600)
+	os.WriteFile(keyFile, nil, 0600)
+	missing := filepath.Join(dir, "missing.pem")
+
+	valid := func() *Config {
+		return &Config{
+			Frontends: []Frontend{{Port: 8080}},
+			Backends:  []Backend{{URL: "http://backend1:9001"}},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string // substring of the error, "" for a valid config
+	}{
+		{name: "valid", modify: func(c *Config) {}},
+		{name: "valid ssl", modify: func(c *Config) { c.SSL = &SSL{CertFile: certFile, KeyFile: keyFile} }},
+		{name: "no frontends", modify: func(c *Config) { c.Frontends = nil }, want: "frontend is required"},
+		{name: "duplicate frontend port", modify: func(c *Config) { c.Frontends = append(c.Frontends, Frontend{Port: 8080}) }, want: "duplicate frontend port 8080"},
+		{name: "invalid frontend port", modify: func(c *Config) { c.Frontends[0].Port = 70000 }, want: "invalid frontend port"},
+		{name: "no backends", modify: func(c *Config) { c.Backends = nil }, want: "backend is required"},
+		{name: "backends in pools only", modify: func(c *Config) {
+			c.Pools = []Pool{{Name: "api", Backends: c.Backends}}
+			c.Backends = nil
+		}},
+		{name: "malformed pool backend URL", modify: func(c *Config) {
+			c.Pools = []Pool{{Name: "api", Backends: []Backend{{URL: "backend2"}}}}
+		}, want: "must use http or https"},
+		{name: "malformed backend URL", modify: func(c *Config) { c.Backends[0].URL = "http://[::1" }, want: "invalid backend URL"},
+		{name: "backend scheme", modify: func(c *Config) { c.Backends[0].URL = "ftp://backend1:21" }, want: "must use http or https"},
+		{name: "backend without scheme", modify: func(c *Config) { c.Backends[0].URL = "backend1:9001" }, want: "must use http or https"},
+		{name: "negative maxConns", modify: func(c *Config) { c.Backends[0].MaxConns = -1 }, want: "invalid maxConns"},
+		{name: "backend without host", modify: func(c *Config) { c.Backends[0].URL = "http:///path" }, want: "has no host"},
+		{name: "ssl without key", modify: func(c *Config) { c.SSL = &SSL{CertFile: certFile} }, want: "requires both"},
+		{name: "ssl without certificates", modify: func(c *Config) { c.SSL = &SSL{} }, want: "requires both"},
+		{name: "ssl missing cert file", modify: func(c *Config) { c.SSL = &SSL{CertFile: missing, KeyFile: keyFile} }, want: "certFile"},
+		{name: "ssl missing key file", modify: func(c *Config) { c.SSL = &SSL{CertFile: certFile, KeyFile: missing} }, want: "keyFile"},
+		{name: "ssl missing CA file", modify: func(c *Config) { c.SSL = &SSL{CertFile: certFile, KeyFile: keyFile, CAFile: missing} }, want: "caFile"},
+		{name: "ssl missing SNI certificate", modify: func(c *Config) {
+			c.SSL = &SSL{Certificates: []CertificatePair{{CertFile: missing, KeyFile: keyFile}}}
+		}, want: "certFile"},
+		{name: "negative halfOpenSuccesses", modify: func(c *Config) { c.CircuitBreaker.HalfOpenSuccesses = -1 }, want: "invalid circuit breaker halfOpenSuccesses"},
+		{name: "circuit open status", modify: func(c *Config) { c.CircuitBreaker.OpenStatus = 529 }},
+		{name: "circuit open success status", modify: func(c *Config) { c.CircuitBreaker.OpenStatus = 200 }, want: "not an error status"},
+		{name: "negative compression minSize", modify: func(c *Config) { c.Compression.MinSize = -1 }, want: "invalid compression minSize"},
+		{name: "negative cache staleWhileRevalidate", modify: func(c *Config) { c.Cache.StaleWhileRevalidate = -time.Second }, want: "must not be negative"},
+		{name: "negative hedgeDelay", modify: func(c *Config) { c.HedgeDelay = -time.Second }, want: "invalid hedgeDelay"},
+		{name: "negative maxRequestBodyBytes", modify: func(c *Config) { c.MaxRequestBodyBytes = -1 }, want: "invalid maxRequestBodyBytes"},
+		{name: "grpc failure codes", modify: func(c *Config) { c.CircuitBreaker.GRPCFailureCodes = []string{"UNAVAILABLE", "INTERNAL"} }},
+		{name: "unknown grpc failure code", modify: func(c *Config) { c.CircuitBreaker.GRPCFailureCodes = []string{"unavailable"} }, want: "unknown gRPC status code"},
+		{name: "admin basic auth", modify: func(c *Config) { c.Admin.Auth = AdminAuth{Username: "ops", Password: "secret"} }},
+		{name: "admin basic auth without password", modify: func(c *Config) { c.Admin.Auth.Username = "ops" }, want: "requires both username and password"},
+		{name: "admin tls missing cert file", modify: func(c *Config) { c.Admin.TLS = &SSL{CertFile: missing, KeyFile: keyFile} }, want: "admin.tls certFile"},
+		{name: "weight step", modify: func(c *Config) {
+			c.WeightSchedule = []WeightStep{{Backend: "http://backend1:9001", At: time.Now(), Weight: 2}}
+		}},
+		{name: "weight step for unknown backend", modify: func(c *Config) {
+			c.WeightSchedule = []WeightStep{{Backend: "http://backend9:9009", At: time.Now(), Weight: 2}}
+		}, want: "unknown backend"},
+		{name: "weight step without weight", modify: func(c *Config) {
+			c.WeightSchedule = []WeightStep{{Backend: "http://backend1:9001", At: time.Now()}}
+		}, want: "positive weight"},
+		{name: "discovery without backends", modify: func(c *Config) {
+			c.Backends = nil
+			c.Discovery = &Discovery{Type: "consul", Address: "http://127.0.0.1:8500", Service: "web"}
+		}},
+		{name: "kubernetes discovery in cluster", modify: func(c *Config) {
+			c.Discovery = &Discovery{Type: "kubernetes", Service: "web"}
+		}},
+		{name: "kubernetes discovery with malformed address", modify: func(c *Config) {
+			c.Discovery = &Discovery{Type: "kubernetes", Address: "127.0.0.1:8001", Service: "web"}
+		}, want: "discovery address"},
+		{name: "dns discovery", modify: func(c *Config) {
+			c.Discovery = &Discovery{Type: "dns", Domain: "_http._tcp.web.example.com", MinInterval: time.Second}
+		}},
+		{name: "dns discovery without domain", modify: func(c *Config) {
+			c.Discovery = &Discovery{Type: "dns", Service: "web"}
+		}, want: "requires a domain"},
+		{name: "unknown discovery type", modify: func(c *Config) {
+			c.Discovery = &Discovery{Type: "etcd", Address: "http://127.0.0.1:2379", Service: "web"}
+		}, want: "unsupported discovery type"},
+		{name: "discovery without address", modify: func(c *Config) {
+			c.Discovery = &Discovery{Type: "consul", Service: "web"}
+		}, want: "discovery address"},
+		{name: "discovery without service", modify: func(c *Config) {
+			c.Discovery = &Discovery{Type: "consul", Address: "http://127.0.0.1:8500"}
+		}, want: "requires a service"},
+	}
+
+	for _, tt := range tests {
+		cfg := valid()
+		tt.modify(cfg)
+		err := cfg.Validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: expected valid config, got %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected error containing %q", tt.name, tt.want)
+			continue
+		}
+		if code := errors.GetCode(err); code != errors.ErrConfigInvalid {
+			t.Errorf("%s: expected %s, got %s", tt.name, errors.ErrConfigInvalid, code)
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestLoadExpandsEnvironment(t *testing.T) {
+	t.Setenv("LB_TEST_BACKEND_HOST", "backend1")
+	t.Setenv("LB_TEST_BACKEND_PORT", "9001")
+
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	content := `
+frontends:
+- port: 8080
+
+backends:
+- "http://${LB_TEST_BACKEND_HOST}:$LB_TEST_BACKEND_PORT"
+- "http://backend2${LB_TEST_UNSET}:9002"
+
+healthcheck:
+  path: "/health$$"
+`
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		t.Fatalf("Failed to close temp file: %v", err)
+	}
+
+	cfg, err := Load(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Backends[0].URL != "http://backend1:9001" {
+		t.Errorf("Expected expanded backend URL, got %s", cfg.Backends[0].URL)
+	}
+
+	// Unset variables expand to empty with a warning
+	if cfg.Backends[1].URL != "http://backend2:9002" {
+		t.Errorf("Expected unset variable to expand to empty, got %s", cfg.Backends[1].URL)
+	}
+	if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), "LB_TEST_UNSET") {
+		t.Errorf("Expected a warning naming the unset variable, got %q", logs.String())
+	}
+
+	// $$ escapes a literal $
+	if cfg.HealthCheck.Path != "/health$" {
+		t.Errorf("Expected escaped dollar sign, got %s", cfg.HealthCheck.Path)
+	}
+}
@@ -0,0 +1,151 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+// consulWait is how long a blocking query waits for a change before Consul
+// answers with the unchanged list
+const consulWait = 5 * time.Minute
+
+// ConsulProvider lists the instances of a service that pass their Consul
+// health checks, following changes with blocking queries
+type ConsulProvider struct {
+	endpoint string // health endpoint of the service, without query
+	scheme   string
+	token    string
+	client   *http.Client
+	logger   *slog.Logger
+	wait     time.Duration
+	retry    func(attempt int) time.Duration
+}
+
+// NewConsul returns a provider for the service cfg names in the Consul agent
+// at cfg.Address
+func NewConsul(cfg config.Discovery, logger *slog.Logger) (*ConsulProvider, error) {
+	address, err := url.Parse(cfg.Address)
+	if err != nil || address.Host == "" {
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("invalid discovery address %q", cfg.Address), err)
+	}
+	scheme := cfg.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return &ConsulProvider{
+		endpoint: address.JoinPath("v1/health/service", cfg.Service).String(),
+		scheme:   scheme,
+		token:    cfg.Token,
+		// Blocking queries are held open for up to wait, plus the jitter
+		// Consul adds of up to a sixteenth of it
+		client: &http.Client{Timeout: consulWait + consulWait/16 + 10*time.Second},
+		logger: logger,
+		wait:   consulWait,
+		retry:  retryDelay,
+	}, nil
+}
+
+// consulEntry is the part of a Consul health API entry the provider uses
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+// Instances returns the sorted URLs of the service's healthy instances and
+// the Consul index they reflect. A non-zero index makes the query block
+// until the list changes from the one at that index, or the wait elapses.
+func (c *ConsulProvider) Instances(ctx context.Context, index uint64) ([]string, uint64, error) {
+	query := url.Values{"passing": {"true"}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(c.wait.Seconds())))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul returned %s", resp.Status)
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode consul response: %w", err)
+	}
+	next, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("consul response has no valid X-Consul-Index: %w", err)
+	}
+
+	urls := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		if host == "" || entry.Service.Port <= 0 {
+			continue
+		}
+		u := url.URL{Scheme: c.scheme, Host: net.JoinHostPort(host, strconv.Itoa(entry.Service.Port))}
+		urls = append(urls, u.String())
+	}
+	sort.Strings(urls)
+	return slices.Compact(urls), next, nil
+}
+
+// Watch follows the service's healthy instances with blocking queries,
+// calling update with the first list and every changed one. Failed queries
+// are retried with backoff, keeping the last list.
+func (c *ConsulProvider) Watch(ctx context.Context, update func(urls []string)) {
+	changes := &changes{update: update}
+	var index uint64
+	for failures := 0; ; {
+		urls, next, err := c.Instances(ctx, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.logger.Warn("consul query failed", "endpoint", c.endpoint, "error", err)
+			if !sleep(ctx, c.retry(failures)) {
+				return
+			}
+			failures++
+			continue
+		}
+		failures = 0
+
+		// An index that goes backwards means Consul's state was reset, so
+		// start over rather than block on an index that may never come
+		if next < index {
+			next = 0
+		}
+		index = next
+		changes.report(urls)
+	}
+}
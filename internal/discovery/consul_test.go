@@ -0,0 +1,201 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/config"
+)
+
+// fakeConsul serves the health endpoint of one service, blocking queries
+// until the instances change
+type fakeConsul struct {
+	mu      sync.Mutex
+	index   uint64
+	body    string
+	status  int
+	changed chan struct{}
+	queries []string
+	tokens  []string
+}
+
+func newFakeConsul(body string) *fakeConsul {
+	return &fakeConsul{index: 1, body: body, status: http.StatusOK, changed: make(chan struct{})}
+}
+
+// set publishes body as the service's instances under a new index
+func (f *fakeConsul) set(body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	f.body = body
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.queries = append(f.queries, r.URL.RequestURI())
+	f.tokens = append(f.tokens, r.Header.Get("X-Consul-Token"))
+	index, changed := f.index, f.changed
+	f.mu.Unlock()
+
+	if wanted, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); wanted >= index {
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	w.WriteHeader(f.status)
+	io.WriteString(w, f.body)
+}
+
+func instances(addrs ...string) string {
+	body := "["
+	for i, addr := range addrs {
+		if i > 0 {
+			body += ","
+		}
+		body += addr
+	}
+	return body + "]"
+}
+
+func instance(node, service string, port int) string {
+	return fmt.Sprintf(`{"Node":{"Address":%q},"Service":{"Address":%q,"Port":%d}}`, node, service, port)
+}
+
+func newTestProvider(t *testing.T, consul http.Handler, token string) *ConsulProvider {
+	server := httptest.NewServer(consul)
+	t.Cleanup(server.Close)
+	provider, err := NewConsul(config.Discovery{Type: "consul", Address: server.URL, Service: "web", Token: token}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	provider.retry = func(int) time.Duration { return time.Millisecond }
+	return provider
+}
+
+func TestConsulInstances(t *testing.T) {
+	consul := newFakeConsul(instances(
+		instance("10.0.0.2", "", 8080),
+		instance("10.0.0.1", "192.168.0.1", 9000),
+		instance("10.0.0.3", "fd00::1", 8080),
+		instance("10.0.0.2", "", 8080),
+		instance("10.0.0.4", "", 0),
+	))
+	provider := newTestProvider(t, consul, "secret")
+
+	urls, index, err := provider.Instances(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Instances failed: %v", err)
+	}
+	want := []string{"http://10.0.0.2:8080", "http://192.168.0.1:9000", "http://[fd00::1]:8080"}
+	if !slices.Equal(urls, want) {
+		t.Errorf("Expected %v, got %v", want, urls)
+	}
+	if index != 1 {
+		t.Errorf("Expected index 1, got %d", index)
+	}
+	if consul.queries[0] != "/v1/health/service/web?passing=true" {
+		t.Errorf("Expected a query for passing instances, got %s", consul.queries[0])
+	}
+	if consul.tokens[0] != "secret" {
+		t.Errorf("Expected the ACL token to be sent, got %q", consul.tokens[0])
+	}
+
+	consul.status = http.StatusForbidden
+	if _, _, err := provider.Instances(context.Background(), 0); err == nil {
+		t.Error("Expected an error for a failed query")
+	}
+}
+
+func TestConsulWatch(t *testing.T) {
+	consul := newFakeConsul(instances(instance("10.0.0.1", "", 8080)))
+	provider := newTestProvider(t, consul, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan []string, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		provider.Watch(ctx, func(urls []string) { updates <- urls })
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	next := func() []string {
+		t.Helper()
+		select {
+		case urls := <-updates:
+			return urls
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for an update")
+			return nil
+		}
+	}
+
+	if urls := next(); !slices.Equal(urls, []string{"http://10.0.0.1:8080"}) {
+		t.Errorf("Expected the initial instance, got %v", urls)
+	}
+
+	// A new index with the same instances is not reported
+	consul.set(instances(instance("10.0.0.1", "", 8080)))
+	consul.set(instances(instance("10.0.0.1", "", 8080), instance("10.0.0.2", "", 8080)))
+	if urls := next(); !slices.Equal(urls, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}) {
+		t.Errorf("Expected the added instance, got %v", urls)
+	}
+
+	consul.set(instances())
+	if urls := next(); len(urls) != 0 {
+		t.Errorf("Expected no instances, got %v", urls)
+	}
+
+	consul.mu.Lock()
+	blocking := consul.queries[1]
+	consul.mu.Unlock()
+	if want := "/v1/health/service/web?index=1&passing=true&wait=300s"; blocking != want {
+		t.Errorf("Expected blocking query %s, got %s", want, blocking)
+	}
+}
+
+func TestConsulWatchRetries(t *testing.T) {
+	consul := newFakeConsul(instances(instance("10.0.0.1", "", 8080)))
+	consul.status = http.StatusInternalServerError
+	provider := newTestProvider(t, consul, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan []string, 1)
+	go provider.Watch(ctx, func(urls []string) { updates <- urls })
+
+	time.Sleep(20 * time.Millisecond)
+	consul.mu.Lock()
+	consul.status = http.StatusOK
+	consul.mu.Unlock()
+
+	select {
+	case urls := <-updates:
+		if !slices.Equal(urls, []string{"http://10.0.0.1:8080"}) {
+			t.Errorf("Expected the instance once Consul recovered, got %v", urls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the watch to recover")
+	}
+}
@@ -0,0 +1,75 @@
+// Package discovery finds backends in a service registry and follows their
+// changes.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+const (
+	minRetry = time.Second
+	maxRetry = 30 * time.Second
+)
+
+// Provider reports the backend URLs of a discovered service
+type Provider interface {
+	// Watch calls update with the sorted backend URLs once they are first
+	// known and again whenever they change, until ctx is cancelled
+	Watch(ctx context.Context, update func(urls []string))
+}
+
+// New returns the provider for the registry cfg names
+func New(cfg config.Discovery, logger *slog.Logger) (Provider, error) {
+	switch cfg.Type {
+	case "consul":
+		return NewConsul(cfg, logger)
+	case "kubernetes":
+		return NewKubernetes(cfg, logger)
+	case "dns":
+		return NewDNS(cfg, logger)
+	default:
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unsupported discovery type %q", cfg.Type), nil)
+	}
+}
+
+// retryDelay doubles the wait after each consecutive failure, up to maxRetry
+func retryDelay(attempt int) time.Duration {
+	delay := minRetry << min(attempt, 5)
+	return min(delay, maxRetry)
+}
+
+// sleep waits for d, reporting false if ctx ends first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// changes passes lists of backend URLs on to update, skipping any equal to
+// the last one passed
+type changes struct {
+	update   func(urls []string)
+	last     []string
+	reported bool
+}
+
+func (c *changes) report(urls []string) {
+	if c.reported && slices.Equal(urls, c.last) {
+		return
+	}
+	c.reported = true
+	c.last = urls
+	c.update(urls)
+}
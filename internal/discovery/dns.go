@@ -0,0 +1,277 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"loadbalancer/internal/config"
+)
+
+const (
+	// defaultDNSMinInterval is the shortest time between lookups when none
+	// is configured
+	defaultDNSMinInterval = 5 * time.Second
+	// defaultDNSInterval is the time between lookups when the records' TTL
+	// is not known
+	defaultDNSInterval = 30 * time.Second
+	// dnsQueryTimeout bounds each query to a nameserver
+	dnsQueryTimeout = 2 * time.Second
+)
+
+// SRVResolver looks up the SRV records of a name. ttl is how long the
+// answer may be cached, or 0 if that is not known.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, name string) (records []*net.SRV, ttl time.Duration, err error)
+}
+
+// DNSProvider resolves the SRV records of a domain, polling as often as
+// their TTL allows
+type DNSProvider struct {
+	domain      string
+	scheme      string
+	minInterval time.Duration
+	resolver    SRVResolver
+	logger      *slog.Logger
+	retry       func(attempt int) time.Duration
+}
+
+// NewDNS returns a provider for the SRV records of cfg.Domain, resolved
+// through the system's nameservers
+func NewDNS(cfg config.Discovery, logger *slog.Logger) (*DNSProvider, error) {
+	d := &DNSProvider{
+		domain:      cfg.Domain,
+		scheme:      cfg.Scheme,
+		minInterval: cfg.MinInterval,
+		resolver:    newSystemResolver("/etc/resolv.conf"),
+		logger:      logger,
+		retry:       retryDelay,
+	}
+	if d.scheme == "" {
+		d.scheme = "http"
+	}
+	if d.minInterval == 0 {
+		d.minInterval = defaultDNSMinInterval
+	}
+	return d, nil
+}
+
+// Targets returns the sorted URLs of the domain's SRV targets and how long
+// until they should be looked up again. Only the targets with the lowest
+// priority are used; the others are backups for when none of them resolve.
+func (d *DNSProvider) Targets(ctx context.Context) ([]string, time.Duration, error) {
+	records, ttl, err := d.resolver.LookupSRV(ctx, d.domain)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var urls []string
+	var priority uint16
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		// A target of "." means the service is decidedly not available
+		if host == "" || record.Port == 0 {
+			continue
+		}
+		if len(urls) > 0 && record.Priority > priority {
+			continue
+		}
+		if len(urls) == 0 || record.Priority < priority {
+			urls, priority = urls[:0], record.Priority
+		}
+		u := url.URL{Scheme: d.scheme, Host: net.JoinHostPort(host, strconv.Itoa(int(record.Port)))}
+		urls = append(urls, u.String())
+	}
+	sort.Strings(urls)
+	return slices.Compact(urls), d.interval(ttl), nil
+}
+
+// interval returns how long to wait before the next lookup of records with
+// the given TTL
+func (d *DNSProvider) interval(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		ttl = defaultDNSInterval
+	}
+	return max(ttl, d.minInterval)
+}
+
+// Watch resolves the domain's SRV records whenever their TTL runs out,
+// calling update with the first list of targets and every changed one. A
+// failed lookup keeps the last list and is retried with backoff.
+func (d *DNSProvider) Watch(ctx context.Context, update func(urls []string)) {
+	changes := &changes{update: update}
+	for failures := 0; ; {
+		urls, wait, err := d.Targets(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			d.logger.Warn("dns discovery lookup failed, keeping the last known backends", "domain", d.domain, "error", err)
+			wait = d.retry(failures)
+			failures++
+		} else {
+			failures = 0
+			changes.report(urls)
+		}
+		if !sleep(ctx, wait) {
+			return
+		}
+	}
+}
+
+// systemResolver queries the nameservers in resolv.conf directly, as the
+// Go resolver does not report TTLs. If none answers, it falls back to the
+// Go resolver, without a TTL.
+type systemResolver struct {
+	servers  []string
+	fallback *net.Resolver
+}
+
+func newSystemResolver(resolvConf string) *systemResolver {
+	r := &systemResolver{fallback: net.DefaultResolver}
+	file, err := os.Open(resolvConf)
+	if err != nil {
+		return r
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+			r.servers = append(r.servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	return r
+}
+
+func (r *systemResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	var err error
+	for _, server := range r.servers {
+		var records []*net.SRV
+		var ttl time.Duration
+		if records, ttl, err = querySRV(ctx, server, name); err == nil {
+			return records, ttl, nil
+		}
+	}
+
+	_, records, fallbackErr := r.fallback.LookupSRV(ctx, "", "", name)
+	if fallbackErr != nil {
+		return nil, 0, errors.Join(err, fallbackErr)
+	}
+	return records, 0, nil
+}
+
+// querySRV asks server for the SRV records of name over UDP, or over TCP if
+// the answer does not fit. The TTL returned is the lowest in the answer.
+func querySRV(ctx context.Context, server, name string) ([]*net.SRV, time.Duration, error) {
+	fqdn, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	id := uint16(rand.Uint32())
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: fqdn, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer cancel()
+	response, err := exchange(ctx, "udp", server, packed)
+	if err == nil && response.Header.Truncated {
+		response, err = exchange(ctx, "tcp", server, packed)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if response.Header.ID != id {
+		return nil, 0, fmt.Errorf("dns answer from %s does not match the query", server)
+	}
+	if response.Header.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("dns lookup of %s failed: %s", name, response.Header.RCode)
+	}
+
+	var records []*net.SRV
+	var ttl time.Duration
+	for _, answer := range response.Answers {
+		srv, ok := answer.Body.(*dnsmessage.SRVResource)
+		if !ok {
+			continue
+		}
+		records = append(records, &net.SRV{
+			Target:   srv.Target.String(),
+			Port:     srv.Port,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+		})
+		if answerTTL := time.Duration(answer.Header.TTL) * time.Second; len(records) == 1 || answerTTL < ttl {
+			ttl = answerTTL
+		}
+	}
+	return records, ttl, nil
+}
+
+// exchange sends a packed query to server and reads the answer. Messages
+// over TCP are prefixed with their length.
+func exchange(ctx context.Context, network, server string, query []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var answer []byte
+	if network == "tcp" {
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		if _, err := conn.Write(append(framed, query...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		answer = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, answer); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		answer = make([]byte, 1232)
+		n, err := conn.Read(answer)
+		if err != nil {
+			return nil, err
+		}
+		answer = answer[:n]
+	}
+
+	var message dnsmessage.Message
+	if err := message.Unpack(answer); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
@@ -0,0 +1,190 @@
+package discovery
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"loadbalancer/internal/config"
+)
+
+// fakeSRVResolver answers lookups with whatever records it currently holds
+type fakeSRVResolver struct {
+	mu      sync.Mutex
+	records []*net.SRV
+	ttl     time.Duration
+	err     error
+	lookups []string
+}
+
+func (f *fakeSRVResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lookups = append(f.lookups, name)
+	return f.records, f.ttl, f.err
+}
+
+func (f *fakeSRVResolver) set(records []*net.SRV, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records, f.err = records, err
+}
+
+func newTestDNSProvider(t *testing.T, cfg config.Discovery, resolver SRVResolver) *DNSProvider {
+	provider, err := NewDNS(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	provider.resolver = resolver
+	provider.retry = func(int) time.Duration { return time.Millisecond }
+	return provider
+}
+
+func TestDNSTargets(t *testing.T) {
+	resolver := &fakeSRVResolver{
+		records: []*net.SRV{
+			{Target: "backup.example.com.", Port: 8080, Priority: 20},
+			{Target: "web-2.example.com.", Port: 8080, Priority: 10, Weight: 5},
+			{Target: "web-1.example.com.", Port: 8443, Priority: 10, Weight: 5},
+			{Target: ".", Port: 0, Priority: 0},
+		},
+		ttl: time.Minute,
+	}
+	provider := newTestDNSProvider(t, config.Discovery{Type: "dns", Domain: "_https._tcp.web.example.com", Scheme: "https"}, resolver)
+
+	urls, wait, err := provider.Targets(context.Background())
+	if err != nil {
+		t.Fatalf("Targets failed: %v", err)
+	}
+	if want := []string{"https://web-1.example.com:8443", "https://web-2.example.com:8080"}; !slices.Equal(urls, want) {
+		t.Errorf("Expected the lowest priority targets %v, got %v", want, urls)
+	}
+	if wait != time.Minute {
+		t.Errorf("Expected to wait out the TTL, got %v", wait)
+	}
+	if resolver.lookups[0] != "_https._tcp.web.example.com" {
+		t.Errorf("Expected a lookup of the domain, got %s", resolver.lookups[0])
+	}
+
+	// Short TTLs are held to the minimum interval; unknown ones use the
+	// default
+	tests := []struct {
+		ttl, minInterval, want time.Duration
+	}{
+		{time.Second, 0, defaultDNSMinInterval},
+		{time.Second, 10 * time.Second, 10 * time.Second},
+		{0, 0, defaultDNSInterval},
+		{0, time.Minute, time.Minute},
+		{time.Hour, 10 * time.Second, time.Hour},
+	}
+	for _, tt := range tests {
+		provider := newTestDNSProvider(t, config.Discovery{Type: "dns", Domain: "x", MinInterval: tt.minInterval}, resolver)
+		if got := provider.interval(tt.ttl); got != tt.want {
+			t.Errorf("TTL %v with minimum %v: expected %v, got %v", tt.ttl, tt.minInterval, tt.want, got)
+		}
+	}
+}
+
+func TestDNSWatch(t *testing.T) {
+	resolver := &fakeSRVResolver{records: []*net.SRV{{Target: "web-1.", Port: 80}}}
+	provider := newTestDNSProvider(t, config.Discovery{Type: "dns", Domain: "_http._tcp.web"}, resolver)
+	// Poll quickly rather than waiting out the TTL
+	provider.minInterval = time.Millisecond
+	resolver.ttl = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan []string, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		provider.Watch(ctx, func(urls []string) { updates <- urls })
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	next := func() []string {
+		t.Helper()
+		select {
+		case urls := <-updates:
+			return urls
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for an update")
+			return nil
+		}
+	}
+
+	if urls := next(); !slices.Equal(urls, []string{"http://web-1:80"}) {
+		t.Errorf("Expected the first target, got %v", urls)
+	}
+
+	// Failed lookups keep the last targets
+	resolver.set(nil, stderrors.New("no such host"))
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case urls := <-updates:
+		t.Errorf("Expected no update while lookups fail, got %v", urls)
+	default:
+	}
+
+	resolver.set([]*net.SRV{{Target: "web-1.", Port: 80}, {Target: "web-2.", Port: 80}}, nil)
+	if urls := next(); !slices.Equal(urls, []string{"http://web-1:80", "http://web-2:80"}) {
+		t.Errorf("Expected the new target, got %v", urls)
+	}
+}
+
+func TestQuerySRV(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	// Answer every SRV query with two targets of different TTLs
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			question := query.Questions[0]
+			answer := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.Header.ID, Response: true},
+				Questions: query.Questions,
+			}
+			for i, ttl := range []uint32{300, 60} {
+				answer.Answers = append(answer.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: ttl},
+					Body:   &dnsmessage.SRVResource{Priority: 10, Weight: 1, Port: uint16(8080 + i), Target: dnsmessage.MustNewName("web.example.com.")},
+				})
+			}
+			packed, _ := answer.Pack()
+			conn.WriteTo(packed, addr)
+		}
+	}()
+
+	records, ttl, err := querySRV(context.Background(), conn.LocalAddr().String(), "_http._tcp.web.example.com")
+	if err != nil {
+		t.Fatalf("querySRV failed: %v", err)
+	}
+	if len(records) != 2 || records[0].Target != "web.example.com." || records[1].Port != 8081 {
+		t.Errorf("Unexpected records: %+v %+v", records[0], records[1])
+	}
+	if ttl != time.Minute {
+		t.Errorf("Expected the lowest TTL, got %v", ttl)
+	}
+}
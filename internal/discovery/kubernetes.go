@@ -0,0 +1,252 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into every pod
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesWatchTimeout is how long the API server keeps a watch open
+// before the provider lists the endpoints afresh
+const kubernetesWatchTimeout = 5 * time.Minute
+
+// KubernetesProvider lists the ready addresses of a Kubernetes service's
+// Endpoints, following changes with a watch
+type KubernetesProvider struct {
+	apiServer string
+	namespace string
+	service   string
+	port      string
+	scheme    string
+	tokenFile string
+	client    *http.Client
+	logger    *slog.Logger
+	retry     func(attempt int) time.Duration
+}
+
+// NewKubernetes returns a provider for the service cfg names, reaching the
+// API server with the pod's service account unless cfg.Address points
+// elsewhere, such as a kubectl proxy
+func NewKubernetes(cfg config.Discovery, logger *slog.Logger) (*KubernetesProvider, error) {
+	k := &KubernetesProvider{
+		apiServer: strings.TrimSuffix(cfg.Address, "/"),
+		namespace: cfg.Namespace,
+		service:   cfg.Service,
+		port:      cfg.Port,
+		scheme:    cfg.Scheme,
+		tokenFile: filepath.Join(serviceAccountDir, "token"),
+		logger:    logger,
+		retry:     retryDelay,
+	}
+	if k.scheme == "" {
+		k.scheme = "http"
+	}
+	if k.namespace == "" {
+		k.namespace = "default"
+		if namespace, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace")); err == nil {
+			k.namespace = strings.TrimSpace(string(namespace))
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if k.apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New(errors.ErrConfigInvalid, "kubernetes discovery needs an address when not running in a cluster", nil)
+		}
+		k.apiServer = "https://" + net.JoinHostPort(host, port)
+
+		ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+		if err != nil {
+			return nil, errors.New(errors.ErrConfigInvalid, "failed to read the service account CA certificate", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New(errors.ErrConfigInvalid, "service account CA certificate is not valid PEM", nil)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	// Watches stay open for kubernetesWatchTimeout, so only connecting and
+	// waiting for headers are bounded
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	k.client = &http.Client{Transport: transport}
+	return k, nil
+}
+
+// kubernetesEndpoints is the part of a Kubernetes Endpoints object the
+// provider uses
+type kubernetesEndpoints struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Subsets []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+// kubernetesEvent is one change reported by a watch
+type kubernetesEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// urls returns the sorted backend URLs of the ready addresses in e
+func (k *KubernetesProvider) urls(e *kubernetesEndpoints) []string {
+	var urls []string
+	for _, subset := range e.Subsets {
+		port := 0
+		for _, p := range subset.Ports {
+			if k.port == "" || p.Name == k.port || strconv.Itoa(p.Port) == k.port {
+				port = p.Port
+				break
+			}
+		}
+		if port == 0 {
+			continue
+		}
+		for _, address := range subset.Addresses {
+			u := url.URL{Scheme: k.scheme, Host: net.JoinHostPort(address.IP, strconv.Itoa(port))}
+			urls = append(urls, u.String())
+		}
+	}
+	sort.Strings(urls)
+	return slices.Compact(urls)
+}
+
+// get sends an authenticated GET for path and query to the API server
+func (k *KubernetesProvider) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	target := k.apiServer + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The token is read on every request, as Kubernetes rotates it
+	if token, err := os.ReadFile(k.tokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes API returned %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// Endpoints returns the sorted URLs of the service's ready endpoints and
+// the resource version they reflect
+func (k *KubernetesProvider) Endpoints(ctx context.Context) ([]string, string, error) {
+	resp, err := k.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/endpoints/%s", url.PathEscape(k.namespace), url.PathEscape(k.service)), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var endpoints kubernetesEndpoints
+	if err := json.NewDecoder(resp.Body).Decode(&endpoints); err != nil {
+		return nil, "", fmt.Errorf("failed to decode endpoints: %w", err)
+	}
+	return k.urls(&endpoints), endpoints.Metadata.ResourceVersion, nil
+}
+
+// watch reports every change to the service's endpoints after version
+// until the API server ends the watch, which returns nil, or it fails
+func (k *KubernetesProvider) watch(ctx context.Context, version string, report func(urls []string)) error {
+	resp, err := k.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/endpoints", url.PathEscape(k.namespace)), url.Values{
+		"watch":           {"true"},
+		"fieldSelector":   {"metadata.name=" + k.service},
+		"resourceVersion": {version},
+		"timeoutSeconds":  {strconv.Itoa(int(kubernetesWatchTimeout.Seconds()))},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event kubernetesEvent
+		if err := decoder.Decode(&event); err != nil {
+			if ctx.Err() != nil || stderrors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("watch interrupted: %w", err)
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			var endpoints kubernetesEndpoints
+			if err := json.Unmarshal(event.Object, &endpoints); err != nil {
+				return fmt.Errorf("failed to decode endpoints: %w", err)
+			}
+			report(k.urls(&endpoints))
+		case "DELETED":
+			report(nil)
+		case "ERROR":
+			// Usually 410 Gone once version is too old; listing again
+			// catches up
+			return fmt.Errorf("watch failed: %s", event.Object)
+		}
+	}
+}
+
+// Watch lists the service's endpoints, then watches them for changes,
+// calling update with the first list and every changed one. While the API
+// server cannot be reached the last list stands, and it is retried with
+// backoff.
+func (k *KubernetesProvider) Watch(ctx context.Context, update func(urls []string)) {
+	changes := &changes{update: update}
+	for failures := 0; ; {
+		urls, version, err := k.Endpoints(ctx)
+		if err == nil {
+			changes.report(urls)
+			err = k.watch(ctx, version, changes.report)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		k.logger.Warn("kubernetes endpoints query failed, keeping the last known backends",
+			"namespace", k.namespace, "service", k.service, "error", err)
+		if !sleep(ctx, k.retry(failures)) {
+			return
+		}
+		failures++
+	}
+}
@@ -0,0 +1,175 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"loadbalancer/internal/config"
+)
+
+// fakeAPIServer serves the Endpoints of one service. Each watch streams the
+// events sent on its events channel; closing a watch's channel ends it.
+type fakeAPIServer struct {
+	mu        sync.Mutex
+	endpoints string
+	failing   bool
+	requests  []string
+	auth      []string
+	watches   chan chan string
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.URL.RequestURI())
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	failing, endpoints := f.failing, f.endpoints
+	f.mu.Unlock()
+	if failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.URL.Query().Get("watch") != "true" {
+		io.WriteString(w, endpoints)
+		return
+	}
+	events := make(chan string)
+	select {
+	case f.watches <- events:
+	case <-r.Context().Done():
+		return
+	}
+	w.(http.Flusher).Flush()
+	for event := range events {
+		io.WriteString(w, event+"\n")
+		w.(http.Flusher).Flush()
+	}
+}
+
+func endpoints(version string, ips []string, ports string) string {
+	addresses := ""
+	for i, ip := range ips {
+		if i > 0 {
+			addresses += ","
+		}
+		addresses += fmt.Sprintf(`{"ip":%q}`, ip)
+	}
+	return fmt.Sprintf(`{"kind":"Endpoints","metadata":{"name":"web","resourceVersion":%q},"subsets":[{"addresses":[%s],"notReadyAddresses":[{"ip":"10.0.9.9"}],"ports":[%s]}]}`, version, addresses, ports)
+}
+
+func TestKubernetesWatch(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "token"), []byte("sa-token\n"), 0o600)
+	os.WriteFile(filepath.Join(dir, "namespace"), []byte("shop"), 0o600)
+	defer func(old string) { serviceAccountDir = old }(serviceAccountDir)
+	serviceAccountDir = dir
+
+	ports := `{"name":"metrics","port":9100},{"name":"http","port":8080}`
+	api := &fakeAPIServer{endpoints: endpoints("5", []string{"10.0.0.2", "10.0.0.1"}, ports), watches: make(chan chan string)}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	provider, err := NewKubernetes(config.Discovery{Type: "kubernetes", Address: server.URL, Service: "web", Port: "http"}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	provider.retry = func(int) time.Duration { return time.Millisecond }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan []string, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		provider.Watch(ctx, func(urls []string) { updates <- urls })
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	next := func() []string {
+		t.Helper()
+		select {
+		case urls := <-updates:
+			return urls
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for an update")
+			return nil
+		}
+	}
+	watch := func() chan string {
+		t.Helper()
+		select {
+		case events := <-api.watches:
+			return events
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a watch")
+			return nil
+		}
+	}
+
+	if urls := next(); !slices.Equal(urls, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}) {
+		t.Errorf("Expected the ready addresses on the named port, got %v", urls)
+	}
+
+	events := watch()
+	api.mu.Lock()
+	watchQuery, auth := api.requests[1], api.auth[1]
+	api.mu.Unlock()
+	if want := "/api/v1/namespaces/shop/endpoints?fieldSelector=metadata.name%3Dweb&resourceVersion=5&timeoutSeconds=300&watch=true"; watchQuery != want {
+		t.Errorf("Expected watch %s, got %s", want, watchQuery)
+	}
+	if auth != "Bearer sa-token" {
+		t.Errorf("Expected the service account token, got %q", auth)
+	}
+
+	events <- `{"type":"MODIFIED","object":` + endpoints("6", []string{"10.0.0.3"}, ports) + `}`
+	if urls := next(); !slices.Equal(urls, []string{"http://10.0.0.3:8080"}) {
+		t.Errorf("Expected the modified endpoints, got %v", urls)
+	}
+
+	// While the API server is unreachable nothing is reported, so the last
+	// known backends stay in place
+	api.mu.Lock()
+	api.failing = true
+	api.mu.Unlock()
+	close(events)
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case urls := <-updates:
+		t.Errorf("Expected no update while the API server is down, got %v", urls)
+	default:
+	}
+
+	api.mu.Lock()
+	api.failing = false
+	api.endpoints = endpoints("7", []string{"10.0.0.3", "10.0.0.4"}, ports)
+	api.mu.Unlock()
+	if urls := next(); !slices.Equal(urls, []string{"http://10.0.0.3:8080", "http://10.0.0.4:8080"}) {
+		t.Errorf("Expected the endpoints once the API server is back, got %v", urls)
+	}
+
+	events = watch()
+	events <- `{"type":"DELETED","object":` + endpoints("8", nil, ports) + `}`
+	if urls := next(); len(urls) != 0 {
+		t.Errorf("Expected no endpoints once deleted, got %v", urls)
+	}
+	close(events)
+}
+
+func TestKubernetesOutsideCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if _, err := NewKubernetes(config.Discovery{Type: "kubernetes", Service: "web"}, slog.New(slog.NewTextHandler(io.Discard, nil))); err == nil {
+		t.Error("Expected an error without an address outside a cluster")
+	}
+}
@@ -16,6 +16,10 @@ const (
 	ErrCircuitOpen        ErrorCode = "CIRCUIT_OPEN"
 	ErrTimeout            ErrorCode = "TIMEOUT"
 	ErrSSLCertificate     ErrorCode = "SSL_CERTIFICATE_ERROR"
+	ErrLimiterUnavailable ErrorCode = "LIMITER_UNAVAILABLE"
+	ErrBackendExists      ErrorCode = "BACKEND_EXISTS"
+	ErrBackendSaturated   ErrorCode = "BACKEND_SATURATED"
+	ErrRolloutInProgress  ErrorCode = "ROLLOUT_IN_PROGRESS"
 )
 
 // LoadBalancerError represents a custom error with context
@@ -0,0 +1,56 @@
+// Package logging builds the structured logger used throughout the load
+// balancer from the logging section of the configuration.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"loadbalancer/internal/config"
+	"loadbalancer/internal/errors"
+)
+
+// New returns a logger writing to w at the configured level and in the
+// configured format, "json" or "text". Empty values select info and json,
+// matching the config defaults.
+func New(cfg config.Logging, w io.Writer) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(cfg.Format) {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown log format %q", cfg.Format), nil)
+	}
+}
+
+// ParseLevel converts a config level (debug, info, warn or error) to a
+// slog level. An empty level is info.
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown log level %q", level), nil)
+	}
+}
+
+// Discard returns a logger that drops everything, for components created
+// without one
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"loadbalancer/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(config.Logging{Level: "warn", Format: "json"}, &buf)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+
+	// Messages below the configured level are dropped
+	logger.Info("ignored")
+	logger.Warn("backend unhealthy", "backend", "http://backend1:9001")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 log line, got %d: %q", len(lines), buf.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Expected JSON log line, got %q: %v", lines[0], err)
+	}
+	if entry["msg"] != "backend unhealthy" || entry["backend"] != "http://backend1:9001" || entry["level"] != "WARN" {
+		t.Errorf("Unexpected log entry: %v", entry)
+	}
+
+	// Text format
+	buf.Reset()
+	logger, err = New(config.Logging{Level: "debug", Format: "text"}, &buf)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	logger.Debug("probe", "backend", "b1")
+	if out := buf.String(); !strings.Contains(out, "level=DEBUG") || !strings.Contains(out, "backend=b1") {
+		t.Errorf("Expected text debug line, got %q", out)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	if _, err := New(config.Logging{Level: "loud"}, &bytes.Buffer{}); err == nil {
+		t.Error("Expected error for unknown level")
+	}
+	if _, err := New(config.Logging{Format: "xml"}, &bytes.Buffer{}); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -13,7 +14,39 @@ type Metrics struct {
 	ActiveConnections prometheus.Gauge
 	BackendHealth     *prometheus.GaugeVec
 	ErrorsTotal       prometheus.Counter
+	HeaderTooLarge    prometheus.Counter
+	AllCircuitsOpen   prometheus.Counter
+	RetriesTotal      prometheus.Counter
+	CertReloadErrors  prometheus.Counter
 	registry         *prometheus.Registry
+
+	// CircuitBreakerState is each backend's breaker state: 0 closed,
+	// 1 half-open, 2 open
+	CircuitBreakerState *prometheus.GaugeVec
+	// CircuitBreakerTrips counts transitions of any breaker to open
+	CircuitBreakerTrips prometheus.Counter
+	// CircuitHalfOpenProbes counts the trial requests sent to backends
+	// whose circuit is half-open, by backend and result
+	CircuitHalfOpenProbes *prometheus.CounterVec
+
+	// RateLimitRejections counts requests refused by a backend's rate
+	// limiter, separately from backend errors
+	RateLimitRejections prometheus.Counter
+	// SaturationRejections counts requests refused because every backend
+	// that could take them was at its connection limit
+	SaturationRejections prometheus.Counter
+	// HedgedRequests counts requests also sent to a second backend because
+	// the first was slow to respond
+	HedgedRequests prometheus.Counter
+	// CacheRequests counts cacheable requests by how the response cache
+	// answered them: hit, stale or miss
+	CacheRequests *prometheus.CounterVec
+	// RequestsByBackend counts requests by the backend chosen for them and
+	// the status returned to the client
+	RequestsByBackend *prometheus.CounterVec
+
+	// users counts running components that update these metrics
+	users atomic.Int32
 }
 
 var (
@@ -50,17 +83,81 @@ func New() *Metrics {
 				Name: "loadbalancer_errors_total",
 				Help: "The total number of errors encountered",
 			}),
+			HeaderTooLarge: factory.NewCounter(prometheus.CounterOpts{
+				Name: "loadbalancer_backend_header_too_large_total",
+				Help: "The total number of backend responses rejected for oversized headers",
+			}),
+			AllCircuitsOpen: factory.NewCounter(prometheus.CounterOpts{
+				Name: "loadbalancer_all_circuits_open_total",
+				Help: "The total number of requests rejected because every backend circuit was open",
+			}),
+			RetriesTotal: factory.NewCounter(prometheus.CounterOpts{
+				Name: "loadbalancer_retries_total",
+				Help: "The total number of requests retried on another backend after a failure",
+			}),
+			CircuitBreakerState: factory.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "loadbalancer_circuit_breaker_state",
+				Help: "Circuit breaker state of backends (0 closed, 1 half-open, 2 open)",
+			}, []string{"backend_url"}),
+			CircuitBreakerTrips: factory.NewCounter(prometheus.CounterOpts{
+				Name: "loadbalancer_circuit_breaker_trips_total",
+				Help: "The total number of times a backend circuit breaker opened",
+			}),
+			CircuitHalfOpenProbes: factory.NewCounterVec(prometheus.CounterOpts{
+				Name: "loadbalancer_circuit_half_open_probes_total",
+				Help: "The total number of trial requests to backends with a half-open circuit, by result (success or failure)",
+			}, []string{"backend_url", "result"}),
+			RateLimitRejections: factory.NewCounter(prometheus.CounterOpts{
+				Name: "loadbalancer_rate_limit_rejections_total",
+				Help: "The total number of requests rejected by a backend rate limiter",
+			}),
+			SaturationRejections: factory.NewCounter(prometheus.CounterOpts{
+				Name: "loadbalancer_saturation_rejections_total",
+				Help: "The total number of requests rejected because every available backend was at its connection limit",
+			}),
+			HedgedRequests: factory.NewCounter(prometheus.CounterOpts{
+				Name: "loadbalancer_hedged_requests_total",
+				Help: "The total number of requests sent to a second backend because the first was slow to respond",
+			}),
+			CacheRequests: factory.NewCounterVec(prometheus.CounterOpts{
+				Name: "loadbalancer_cache_requests_total",
+				Help: "The total number of cacheable requests by cache result (hit, stale or miss)",
+			}, []string{"result"}),
+			RequestsByBackend: factory.NewCounterVec(prometheus.CounterOpts{
+				Name: "loadbalancer_backend_requests_total",
+				Help: "The total number of requests by backend and response status",
+			}, []string{"backend_url", "status"}),
+			CertReloadErrors: factory.NewCounter(prometheus.CounterOpts{
+				Name: "loadbalancer_cert_reload_failures_total",
+				Help: "The total number of certificate reloads that failed, leaving the previous certificate in use",
+			}),
 		}
 	})
 	return instance
 }
 
-// Reset resets all metrics (useful for testing)
+// Reset discards the shared instance so the next New builds fresh metrics on
+// a new registry. It is meant for tests only: anything still holding the old
+// instance keeps updating metrics that are no longer exported. To catch
+// that, Reset panics while the current instance is in use.
 func Reset() {
+	if instance != nil && instance.users.Load() > 0 {
+		panic("metrics: Reset called while metrics are in use by a running load balancer")
+	}
 	once = sync.Once{}
 	instance = nil
 }
 
+// Use marks m as in use by a running component until the returned function
+// is called
+func (m *Metrics) Use() (release func()) {
+	m.users.Add(1)
+	var released sync.Once
+	return func() {
+		released.Do(func() { m.users.Add(-1) })
+	}
+}
+
 // GetRegistry returns the Prometheus registry
 func (m *Metrics) GetRegistry() *prometheus.Registry {
 	return m.registry
@@ -167,3 +167,29 @@ func TestMetricsSingleton(t *testing.T) {
 		t.Error("Expected metrics instances to share the same registry")
 	}
 }
+
+func TestResetWhileInUse(t *testing.T) {
+	Reset() // Reset metrics before test
+	m := New()
+	release := m.Use()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected Reset to panic while metrics are in use")
+			}
+		}()
+		Reset()
+	}()
+	if New() != m {
+		t.Error("Expected the in-use instance to survive a refused Reset")
+	}
+
+	// Once released, Reset builds a fresh instance again
+	release()
+	release() // releasing twice is harmless
+	Reset()
+	if New() == m {
+		t.Error("Expected a new instance after Reset")
+	}
+}
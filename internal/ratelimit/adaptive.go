@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"loadbalancer/internal/errors"
+)
+
+// AdaptiveLimiter caps the number of concurrent requests to a backend and
+// adjusts the cap from observed response latency using AIMD: each response
+// within the target latency raises the limit additively, and each slower
+// response cuts it multiplicatively. A struggling backend therefore sees
+// its load shed quickly and restored gradually as latency recovers.
+type AdaptiveLimiter struct {
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+
+	target   time.Duration
+	min      float64
+	max      float64
+	increase float64
+	decrease float64
+}
+
+// AdaptiveConfig holds configuration for the adaptive concurrency limiter
+type AdaptiveConfig struct {
+	TargetLatency time.Duration // responses slower than this shrink the limit
+	MinLimit      int           // the limit never drops below this
+	MaxLimit      int           // the limit never grows above this
+	Increase      float64       // added to the limit over one limit's worth of fast responses
+	Decrease      float64       // factor applied to the limit on a slow response
+}
+
+// NewAdaptive creates an adaptive limiter starting at MaxLimit
+func NewAdaptive(config AdaptiveConfig) *AdaptiveLimiter {
+	if config.MinLimit <= 0 {
+		config.MinLimit = 1
+	}
+	if config.MaxLimit <= 0 {
+		config.MaxLimit = 100 // default to 100 concurrent requests
+	}
+	if config.MaxLimit < config.MinLimit {
+		config.MaxLimit = config.MinLimit
+	}
+	if config.Increase <= 0 {
+		config.Increase = 1
+	}
+	if config.Decrease <= 0 || config.Decrease >= 1 {
+		config.Decrease = 0.9
+	}
+
+	return &AdaptiveLimiter{
+		limit:    float64(config.MaxLimit),
+		target:   config.TargetLatency,
+		min:      float64(config.MinLimit),
+		max:      float64(config.MaxLimit),
+		increase: config.Increase,
+		decrease: config.Decrease,
+	}
+}
+
+// Acquire admits a request if fewer than the current limit are in flight.
+// Every successful Acquire must be paired with a call to Release.
+func (a *AdaptiveLimiter) Acquire() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.inFlight >= a.currentLimit() {
+		return errors.New(errors.ErrRateLimitExceeded, "adaptive concurrency limit exceeded", nil)
+	}
+	a.inFlight++
+	return nil
+}
+
+// Release records the latency of a completed request and adjusts the limit
+func (a *AdaptiveLimiter) Release(latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.inFlight--
+	if latency > a.target {
+		a.limit = math.Max(a.min, a.limit*a.decrease)
+	} else {
+		// Spread the increase over a full limit's worth of responses so
+		// the limit grows by Increase per round of requests
+		a.limit = math.Min(a.max, a.limit+a.increase/a.limit)
+	}
+}
+
+// Limit returns the current concurrency limit
+func (a *AdaptiveLimiter) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.currentLimit()
+}
+
+// currentLimit rounds the limit down to whole requests. Callers must hold a.mu.
+func (a *AdaptiveLimiter) currentLimit() int {
+	return int(a.limit)
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"loadbalancer/internal/errors"
+)
+
+// admitted reports how many concurrent requests the limiter admits right now
+func admitted(a *AdaptiveLimiter) int {
+	n := 0
+	for a.Acquire() == nil {
+		n++
+	}
+	for i := 0; i < n; i++ {
+		a.inFlight--
+	}
+	return n
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	limiter := NewAdaptive(AdaptiveConfig{
+		TargetLatency: 100 * time.Millisecond,
+		MinLimit:      2,
+		MaxLimit:      10,
+		Decrease:      0.8,
+	})
+
+	// Fast responses keep the limit at its ceiling
+	for i := 0; i < 10; i++ {
+		if err := limiter.Acquire(); err != nil {
+			t.Fatalf("Expected request %d to be admitted, got %v", i, err)
+		}
+	}
+	if code := errors.GetCode(limiter.Acquire()); code != errors.ErrRateLimitExceeded {
+		t.Errorf("Expected %s once the limit is reached, got %s", errors.ErrRateLimitExceeded, code)
+	}
+	for i := 0; i < 10; i++ {
+		limiter.Release(10 * time.Millisecond)
+	}
+	if limit := limiter.Limit(); limit != 10 {
+		t.Fatalf("Expected limit to stay at 10, got %d", limit)
+	}
+
+	// Rising latency cuts the admitted rate, down to the floor
+	previous := limiter.Limit()
+	for _, latency := range []time.Duration{150, 200, 300} {
+		limiter.Acquire()
+		limiter.Release(latency * time.Millisecond)
+		if limit := limiter.Limit(); limit >= previous {
+			t.Errorf("Expected limit to drop below %d after %dms response, got %d", previous, latency, limit)
+		}
+		previous = limiter.Limit()
+	}
+	for i := 0; i < 5; i++ {
+		limiter.Acquire()
+		limiter.Release(time.Second)
+	}
+	if n := admitted(limiter); n != 2 {
+		t.Errorf("Expected admitted requests to bottom out at 2, got %d", n)
+	}
+
+	// Once latency recovers the limit climbs back to the ceiling
+	for i := 0; i < 200; i++ {
+		limiter.Acquire()
+		limiter.Release(10 * time.Millisecond)
+	}
+	if n := admitted(limiter); n != 10 {
+		t.Errorf("Expected admitted requests to recover to 10, got %d", n)
+	}
+}
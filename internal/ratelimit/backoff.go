@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// maxBackoffClients bounds how many clients are tracked before idle ones
+// are pruned
+const maxBackoffClients = 10000
+
+// Backoff suggests how long a rate-limited client should wait before
+// retrying. Each rejection within Window of the previous one doubles the
+// suggestion, up to Max, so clients that keep hitting the limit are pushed
+// back progressively. Jitter spreads retries out so rejected clients do not
+// return in lockstep.
+type Backoff struct {
+	mu      sync.Mutex
+	base    time.Duration
+	max     time.Duration
+	window  time.Duration
+	clients map[string]*backoffState
+
+	now    func() time.Time
+	jitter func() float64 // returns a value in [0, 1)
+}
+
+type backoffState struct {
+	rejections int
+	last       time.Time
+}
+
+// BackoffConfig holds configuration for Backoff
+type BackoffConfig struct {
+	Base   time.Duration // suggestion after the first rejection
+	Max    time.Duration // largest suggestion
+	Window time.Duration // rejections further apart than this start over
+}
+
+// NewBackoff creates a Backoff
+func NewBackoff(config BackoffConfig) *Backoff {
+	if config.Base <= 0 {
+		config.Base = time.Second
+	}
+	if config.Max < config.Base {
+		config.Max = 60 * config.Base
+	}
+	if config.Window <= 0 {
+		config.Window = config.Max
+	}
+
+	return &Backoff{
+		base:    config.Base,
+		max:     config.Max,
+		window:  config.Window,
+		clients: make(map[string]*backoffState),
+		now:     time.Now,
+		jitter:  rand.Float64,
+	}
+}
+
+// Next records a rejection of client and returns how long it should wait.
+// The wait is drawn from the upper half of the current backoff step, so it
+// never shrinks while a client keeps being rejected.
+func (b *Backoff) Next(client string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	state, ok := b.clients[client]
+	if !ok || now.Sub(state.last) > b.window {
+		if len(b.clients) >= maxBackoffClients {
+			b.prune(now)
+		}
+		state = &backoffState{}
+		b.clients[client] = state
+	}
+	state.rejections++
+	state.last = now
+
+	step := b.base
+	for i := 1; i < state.rejections && step < b.max; i++ {
+		step *= 2
+	}
+	if step > b.max {
+		step = b.max
+	}
+	return step/2 + time.Duration(b.jitter()*float64(step/2))
+}
+
+// prune forgets clients that have not been rejected within the window.
+// Callers must hold b.mu.
+func (b *Backoff) prune(now time.Time) {
+	for client, state := range b.clients {
+		if now.Sub(state.last) > b.window {
+			delete(b.clients, client)
+		}
+	}
+}
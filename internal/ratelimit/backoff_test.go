@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	newBackoff := func(seed int64) *Backoff {
+		b := NewBackoff(BackoffConfig{Base: time.Second, Max: 16 * time.Second, Window: time.Minute})
+		b.now = func() time.Time { return now }
+		b.jitter = rand.New(rand.NewSource(seed)).Float64
+		return b
+	}
+
+	// Repeated rejections back the client off further each time, up to Max
+	b := newBackoff(1)
+	var previous time.Duration
+	var waits []time.Duration
+	for i := 0; i < 8; i++ {
+		wait := b.Next("10.0.0.1")
+		if i < 5 && wait < previous {
+			// Steps double from 1s until they reach Max on the fifth
+			t.Errorf("Expected rejection %d to wait at least %v, got %v", i, previous, wait)
+		}
+		if wait > 16*time.Second || (i >= 4 && wait < 8*time.Second) {
+			t.Errorf("Expected wait at the cap to be between 8s and 16s, got %v", wait)
+		}
+		previous = wait
+		waits = append(waits, wait)
+	}
+	if waits[4] <= waits[0] {
+		t.Errorf("Expected waits to grow, got %v", waits)
+	}
+
+	// Jitter: the same sequence with a different random source differs
+	other := newBackoff(2)
+	same := true
+	for _, wait := range waits {
+		if other.Next("10.0.0.1") != wait {
+			same = false
+		}
+	}
+	if same {
+		t.Error("Expected jitter to vary the suggested waits")
+	}
+
+	// Other clients are tracked separately
+	if wait := b.Next("10.0.0.2"); wait > time.Second {
+		t.Errorf("Expected a new client to start at the base step, got %v", wait)
+	}
+
+	// A client that stops being rejected for the window starts over
+	now = now.Add(2 * time.Minute)
+	if wait := b.Next("10.0.0.1"); wait > time.Second {
+		t.Errorf("Expected backoff to reset after the window, got %v", wait)
+	}
+}
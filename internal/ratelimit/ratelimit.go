@@ -1,12 +1,99 @@
 package ratelimit
 
 import (
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"loadbalancer/internal/errors"
 )
 
+// Limiter decides whether a request may proceed. Allow returns an
+// ErrRateLimitExceeded error when the limit is hit; any other error means
+// the limiter itself could not make a decision (e.g. a remote store is down).
+type Limiter interface {
+	Allow() error
+}
+
+// RetryAdvisor is implemented by limiters that can tell a rejected client
+// how long to wait before another request would be admitted
+type RetryAdvisor interface {
+	// RetryAfter returns the time until the limiter next has room, or 0 if
+	// a request would be admitted now
+	RetryAfter() time.Duration
+}
+
+// FailureModeSetter is implemented by limiters whose FailureMode can be
+// changed while they are in use
+type FailureModeSetter interface {
+	SetFailureMode(mode FailureMode)
+}
+
+// FailureMode controls what happens when a Limiter cannot make a decision
+type FailureMode int
+
+const (
+	// FailOpen lets traffic through, prioritizing availability
+	FailOpen FailureMode = iota
+	// FailClosed rejects traffic, prioritizing backend protection
+	FailClosed
+)
+
+// ParseFailureMode converts a config value ("open" or "closed") to a FailureMode
+func ParseFailureMode(mode string) (FailureMode, error) {
+	switch mode {
+	case "", "open":
+		return FailOpen, nil
+	case "closed":
+		return FailClosed, nil
+	default:
+		return FailOpen, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown rate limiter failure mode %q", mode), nil)
+	}
+}
+
+// guardedLimiter applies a FailureMode to limiter errors
+type guardedLimiter struct {
+	limiter Limiter
+	mode    atomic.Int32 // a FailureMode
+}
+
+// WithFailureMode wraps limiter so that errors other than an exceeded limit
+// either allow the request (FailOpen) or reject it with ErrLimiterUnavailable
+// (FailClosed)
+func WithFailureMode(limiter Limiter, mode FailureMode) Limiter {
+	g := &guardedLimiter{limiter: limiter}
+	g.mode.Store(int32(mode))
+	return g
+}
+
+// SetFailureMode implements FailureModeSetter
+func (g *guardedLimiter) SetFailureMode(mode FailureMode) {
+	g.mode.Store(int32(mode))
+}
+
+// Allow implements Limiter
+func (g *guardedLimiter) Allow() error {
+	err := g.limiter.Allow()
+	if err == nil || errors.GetCode(err) == errors.ErrRateLimitExceeded {
+		return err
+	}
+
+	if FailureMode(g.mode.Load()) == FailOpen {
+		return nil
+	}
+	return errors.Wrap(err, errors.ErrLimiterUnavailable, "rate limiter unavailable")
+}
+
+// RetryAfter implements RetryAdvisor for limiters that support it, and
+// returns 0 otherwise
+func (g *guardedLimiter) RetryAfter() time.Duration {
+	if advisor, ok := g.limiter.(RetryAdvisor); ok {
+		return advisor.RetryAfter()
+	}
+	return 0
+}
+
 // TokenBucket implements the token bucket algorithm for rate limiting
 type TokenBucket struct {
 	rate       float64    // tokens per second
@@ -55,6 +142,18 @@ func (tb *TokenBucket) Allow() error {
 	return errors.New(errors.ErrRateLimitExceeded, "rate limit exceeded", nil)
 }
 
+// RetryAfter returns how long until a token is available
+func (tb *TokenBucket) RetryAfter() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill(time.Now())
+	if tb.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+}
+
 // refill adds tokens based on elapsed time
 func (tb *TokenBucket) refill(now time.Time) {
 	elapsed := now.Sub(tb.lastRefill).Seconds()
@@ -67,19 +166,35 @@ func (tb *TokenBucket) refill(now time.Time) {
 	tb.lastRefill = now
 }
 
-// WindowRateLimiter implements a sliding window rate limiter
+// WindowRateLimiter implements a sliding window rate limiter. The window is
+// split into a fixed ring of buckets, each counting the requests of one
+// slice of time, so memory and the cost of every call are bounded by the
+// number of buckets however many requests arrive. A bucket is reused once
+// it falls out of the window, so there is no cleanup pass; requests age out
+// up to one bucket width early.
 type WindowRateLimiter struct {
-	mu          sync.Mutex
-	window      time.Duration
-	limit       int
-	requests    map[int64]int
-	cleanupTime time.Duration
+	mu      sync.Mutex
+	window  time.Duration
+	limit   int
+	width   time.Duration
+	buckets []windowBucket
+}
+
+// windowBucket counts the requests of the time slice numbered epoch
+type windowBucket struct {
+	epoch int64
+	count int
 }
 
 // WindowConfig holds configuration for the sliding window rate limiter
 type WindowConfig struct {
-	Window      time.Duration
-	Limit       int
+	Window time.Duration
+	Limit  int
+	// Buckets is the number of slices the window is split into; more give
+	// finer accuracy at the cost of more work per request. Defaults to 10.
+	Buckets int
+	// Deprecated: CleanupTime is ignored; expired buckets are reused in
+	// place instead of being cleaned up
 	CleanupTime time.Duration
 }
 
@@ -91,66 +206,99 @@ func NewWindow(config WindowConfig) *WindowRateLimiter {
 	if config.Limit <= 0 {
 		config.Limit = 100
 	}
-	if config.CleanupTime <= 0 {
-		config.CleanupTime = time.Minute
+	if config.Buckets <= 0 {
+		config.Buckets = 10
 	}
-
-	limiter := &WindowRateLimiter{
-		window:      config.Window,
-		limit:       config.Limit,
-		requests:    make(map[int64]int),
-		cleanupTime: config.CleanupTime,
+	if time.Duration(config.Buckets) > config.Window {
+		config.Buckets = int(config.Window)
 	}
 
-	go limiter.cleanup()
-	return limiter
+	buckets := make([]windowBucket, config.Buckets)
+	for i := range buckets {
+		buckets[i].epoch = -1
+	}
+	return &WindowRateLimiter{
+		window:  config.Window,
+		limit:   config.Limit,
+		width:   config.Window / time.Duration(config.Buckets),
+		buckets: buckets,
+	}
 }
 
-// Allow checks if a request should be allowed under the sliding window
-func (wrl *WindowRateLimiter) Allow() error {
-	wrl.mu.Lock()
-	defer wrl.mu.Unlock()
+// epoch returns the number of the time slice t falls in
+func (wrl *WindowRateLimiter) epoch(t time.Time) int64 {
+	return t.UnixNano() / int64(wrl.width)
+}
 
-	now := time.Now()
-	windowStart := now.Add(-wrl.window).UnixNano()
+// live reports whether bucket is within the window ending in slice current
+func (wrl *WindowRateLimiter) live(bucket windowBucket, current int64) bool {
+	return bucket.epoch > current-int64(len(wrl.buckets)) && bucket.epoch <= current
+}
 
-	// Count requests in current window
+// count returns the number of requests in the window ending in slice
+// current. Callers must hold mu.
+func (wrl *WindowRateLimiter) count(current int64) int {
 	var count int
-	for timestamp, reqs := range wrl.requests {
-		if timestamp >= windowStart {
-			count += reqs
+	for _, bucket := range wrl.buckets {
+		if wrl.live(bucket, current) {
+			count += bucket.count
 		}
 	}
+	return count
+}
 
-	if count >= wrl.limit {
+// Allow checks if a request should be allowed under the sliding window
+func (wrl *WindowRateLimiter) Allow() error {
+	wrl.mu.Lock()
+	defer wrl.mu.Unlock()
+
+	current := wrl.epoch(time.Now())
+	if wrl.count(current) >= wrl.limit {
 		return errors.New(errors.ErrRateLimitExceeded, "rate limit exceeded", nil)
 	}
 
-	// Record new request
-	bucket := now.UnixNano()
-	wrl.requests[bucket]++
+	// Record the request, reusing the slot of an expired bucket
+	bucket := &wrl.buckets[current%int64(len(wrl.buckets))]
+	if bucket.epoch != current {
+		bucket.epoch = current
+		bucket.count = 0
+	}
+	bucket.count++
 
 	return nil
 }
 
-// cleanup periodically removes old entries
-func (wrl *WindowRateLimiter) cleanup() {
-	ticker := time.NewTicker(wrl.cleanupTime)
-	for range ticker.C {
-		wrl.mu.Lock()
-		threshold := time.Now().Add(-wrl.window).UnixNano()
-		for timestamp := range wrl.requests {
-			if timestamp < threshold {
-				delete(wrl.requests, timestamp)
-			}
+// RetryAfter returns how long until enough requests age out of the window
+// for another to be admitted
+func (wrl *WindowRateLimiter) RetryAfter() time.Duration {
+	wrl.mu.Lock()
+	defer wrl.mu.Unlock()
+
+	now := time.Now()
+	current := wrl.epoch(now)
+	count := wrl.count(current)
+	if count < wrl.limit {
+		return 0
+	}
+
+	// Walk the window from its oldest bucket until enough requests have
+	// expired to drop below the limit. A bucket leaves the window once
+	// len(buckets) newer slices have begun.
+	n := int64(len(wrl.buckets))
+	excess := count - wrl.limit + 1
+	for epoch := current - n + 1; epoch <= current; epoch++ {
+		bucket := wrl.buckets[epoch%n]
+		if bucket.epoch != epoch {
+			continue
+		}
+		excess -= bucket.count
+		if excess <= 0 {
+			return time.Unix(0, (epoch+n)*int64(wrl.width)).Sub(now)
 		}
-		wrl.mu.Unlock()
 	}
+	return wrl.window
 }
 
-// Stop stops the cleanup goroutine
-func (wrl *WindowRateLimiter) Stop() {
-	wrl.mu.Lock()
-	defer wrl.mu.Unlock()
-	wrl.requests = nil
-}
+// Stop releases the limiter. It runs no background work, so this is kept
+// only for compatibility.
+func (wrl *WindowRateLimiter) Stop() {}
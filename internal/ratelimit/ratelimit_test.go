@@ -1,9 +1,12 @@
 package ratelimit
 
 import (
+	stderrors "errors"
 	"sync"
 	"testing"
 	"time"
+
+	"loadbalancer/internal/errors"
 )
 
 func TestTokenBucket(t *testing.T) {
@@ -199,8 +202,8 @@ func TestRateLimiterEdgeCases(t *testing.T) {
 	if windowLimiter.limit <= 0 {
 		t.Error("Expected positive limit despite zero input")
 	}
-	if windowLimiter.cleanupTime <= 0 {
-		t.Error("Expected positive cleanup time despite zero input")
+	if len(windowLimiter.buckets) <= 0 || windowLimiter.width <= 0 {
+		t.Error("Expected positive bucket count and width despite zero input")
 	}
 }
 
@@ -228,3 +231,153 @@ func TestRateLimiterBurstHandling(t *testing.T) {
 		t.Error("Expected request to be allowed after recovery period")
 	}
 }
+
+// unavailableLimiter simulates a limiter whose backing store is unreachable
+type unavailableLimiter struct{}
+
+func (unavailableLimiter) Allow() error {
+	return stderrors.New("dial tcp 10.0.0.1:6379: connection refused")
+}
+
+func TestFailureMode(t *testing.T) {
+	// Fail open lets traffic through when the limiter errors
+	open := WithFailureMode(unavailableLimiter{}, FailOpen)
+	if err := open.Allow(); err != nil {
+		t.Errorf("Expected fail-open limiter to allow request, got %v", err)
+	}
+
+	// Fail closed rejects traffic with a distinct error code
+	closed := WithFailureMode(unavailableLimiter{}, FailClosed)
+	err := closed.Allow()
+	if err == nil {
+		t.Fatal("Expected fail-closed limiter to reject request")
+	}
+	if code := errors.GetCode(err); code != errors.ErrLimiterUnavailable {
+		t.Errorf("Expected %s, got %s", errors.ErrLimiterUnavailable, code)
+	}
+
+	// An exceeded limit is reported as such regardless of the mode
+	bucket := New(Config{Rate: 1, Capacity: 1})
+	guarded := WithFailureMode(bucket, FailOpen)
+	if err := guarded.Allow(); err != nil {
+		t.Errorf("Expected first request to be allowed, got %v", err)
+	}
+	if code := errors.GetCode(guarded.Allow()); code != errors.ErrRateLimitExceeded {
+		t.Errorf("Expected %s, got %s", errors.ErrRateLimitExceeded, code)
+	}
+}
+
+func TestParseFailureMode(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    FailureMode
+		wantErr bool
+	}{
+		{"", FailOpen, false},
+		{"open", FailOpen, false},
+		{"closed", FailClosed, false},
+		{"sideways", FailOpen, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFailureMode(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFailureMode(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("ParseFailureMode(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestTokenBucketRetryAfter(t *testing.T) {
+	limiter := New(Config{Rate: 2, Capacity: 1})
+
+	if wait := limiter.RetryAfter(); wait != 0 {
+		t.Errorf("Expected no wait with a token available, got %v", wait)
+	}
+	if err := limiter.Allow(); err != nil {
+		t.Fatalf("First request should be allowed: %v", err)
+	}
+
+	// A token arrives every 500ms
+	wait := limiter.RetryAfter()
+	if wait <= 400*time.Millisecond || wait > 500*time.Millisecond {
+		t.Errorf("Expected a wait of just under 500ms, got %v", wait)
+	}
+}
+
+func TestWindowRateLimiterRetryAfter(t *testing.T) {
+	limiter := NewWindow(WindowConfig{Window: time.Second, Limit: 2})
+	defer limiter.Stop()
+
+	if wait := limiter.RetryAfter(); wait != 0 {
+		t.Errorf("Expected no wait below the limit, got %v", wait)
+	}
+	limiter.Allow()
+	time.Sleep(300 * time.Millisecond)
+	limiter.Allow()
+
+	// Room frees up when the first request ages out, about 700ms from now
+	wait := limiter.RetryAfter()
+	if wait <= 500*time.Millisecond || wait > 700*time.Millisecond {
+		t.Errorf("Expected a wait of about 700ms, got %v", wait)
+	}
+
+	// Wrapping keeps the advice available
+	if _, ok := WithFailureMode(limiter, FailOpen).(RetryAdvisor); !ok {
+		t.Error("Expected a guarded limiter to advise on retries")
+	}
+}
+
+func TestWindowRateLimiterBuckets(t *testing.T) {
+	limiter := NewWindow(WindowConfig{Window: 400 * time.Millisecond, Limit: 5, Buckets: 4})
+	defer limiter.Stop()
+
+	for i := 0; i < 5; i++ {
+		if err := limiter.Allow(); err != nil {
+			t.Fatalf("Request %d should be allowed within limit", i)
+		}
+	}
+	if err := limiter.Allow(); err == nil {
+		t.Error("Expected rate limit to be exceeded")
+	}
+
+	// Requests age out with their bucket, at most one bucket width early
+	time.Sleep(250 * time.Millisecond)
+	if err := limiter.Allow(); err == nil {
+		t.Error("Expected requests to stay in the window until their bucket expires")
+	}
+	time.Sleep(170 * time.Millisecond)
+	if err := limiter.Allow(); err != nil {
+		t.Error("Request should be allowed once the window has slid past the burst")
+	}
+
+	// A window shorter than the bucket count still gets non-empty buckets
+	tiny := NewWindow(WindowConfig{Window: 3, Buckets: 10})
+	if len(tiny.buckets) != 3 || tiny.width != 1 {
+		t.Errorf("Expected 3 buckets of 1ns, got %d of %v", len(tiny.buckets), tiny.width)
+	}
+}
+
+func TestWindowRateLimiterBoundedMemory(t *testing.T) {
+	limiter := NewWindow(WindowConfig{Window: time.Second, Limit: 1 << 30})
+	defer limiter.Stop()
+
+	// Recording a request never allocates, however many are in the window
+	if allocs := testing.AllocsPerRun(10000, func() { limiter.Allow() }); allocs != 0 {
+		t.Errorf("Expected Allow not to allocate, got %v allocations per call", allocs)
+	}
+}
+
+func BenchmarkWindowRateLimiterAllow(b *testing.B) {
+	limiter := NewWindow(WindowConfig{Window: time.Second, Limit: 1 << 30})
+	defer limiter.Stop()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			limiter.Allow()
+		}
+	})
+}
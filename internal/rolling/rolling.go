@@ -0,0 +1,72 @@
+// Package rolling counts request outcomes over a rolling time window.
+package rolling
+
+import (
+	"sync"
+	"time"
+)
+
+// buckets is the number of slots a window is divided into. Outcomes expire
+// one bucket at a time, so a window is accurate to within its size divided
+// by buckets.
+const buckets = 10
+
+type bucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// Window counts successes and failures over a rolling time window. It is
+// safe for concurrent use.
+type Window struct {
+	mu      sync.Mutex
+	size    time.Duration
+	width   time.Duration // of each bucket
+	buckets [buckets]bucket
+}
+
+// New returns an empty window spanning size. Buckets are at least a
+// nanosecond wide, so tiny windows still work.
+func New(size time.Duration) *Window {
+	return &Window{size: size, width: max(size/buckets, 1)}
+}
+
+// Record adds one outcome at now
+func (w *Window) Record(now time.Time, failed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	start := now.Truncate(w.width)
+	b := &w.buckets[int(start.UnixNano()/int64(w.width))%buckets]
+	if !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+}
+
+// Counts returns the requests and failures recorded within the window
+// ending at now
+func (w *Window) Counts(now time.Time) (requests, failures int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cutoff := now.Add(-w.size)
+	for _, b := range w.buckets {
+		if b.start.After(cutoff) {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
+
+// Reset discards every recorded outcome
+func (w *Window) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buckets = [buckets]bucket{}
+}
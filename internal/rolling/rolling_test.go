@@ -0,0 +1,46 @@
+package rolling
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowExpiresOutcomes(t *testing.T) {
+	w := New(10 * time.Second)
+	now := time.Unix(1700000000, 0)
+
+	w.Record(now, true)
+	w.Record(now.Add(5*time.Second), false)
+	if requests, failures := w.Counts(now.Add(5 * time.Second)); requests != 2 || failures != 1 {
+		t.Errorf("Expected 2 requests and 1 failure, got %d and %d", requests, failures)
+	}
+
+	// The first outcome has left the window
+	if requests, failures := w.Counts(now.Add(12 * time.Second)); requests != 1 || failures != 0 {
+		t.Errorf("Expected 1 request and no failures, got %d and %d", requests, failures)
+	}
+
+	// Reusing a bucket slot drops what it held before
+	w.Record(now.Add(20*time.Second), false)
+	if requests, _ := w.Counts(now.Add(20 * time.Second)); requests != 1 {
+		t.Errorf("Expected 1 request after the window wrapped, got %d", requests)
+	}
+
+	w.Reset()
+	if requests, _ := w.Counts(now.Add(20 * time.Second)); requests != 0 {
+		t.Errorf("Expected no requests after reset, got %d", requests)
+	}
+}
+
+func TestTinyWindow(t *testing.T) {
+	w := New(5 * time.Nanosecond)
+	now := time.Unix(1700000000, 0)
+
+	w.Record(now, true)
+	if requests, failures := w.Counts(now); requests != 1 || failures != 1 {
+		t.Errorf("Expected 1 request and 1 failure, got %d and %d", requests, failures)
+	}
+	if requests, _ := w.Counts(now.Add(time.Second)); requests != 0 {
+		t.Errorf("Expected the outcome to have left the window, got %d requests", requests)
+	}
+}
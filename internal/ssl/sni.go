@@ -0,0 +1,55 @@
+package ssl
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+// certSelector picks the certificate for a TLS handshake by the server name
+// the client asked for
+type certSelector struct {
+	byName   map[string]*tls.Certificate
+	fallback *tls.Certificate
+}
+
+// newCertSelector indexes certs by the DNS names they cover. When several
+// certificates cover a name the first one listed wins, and the first
+// certificate is served to clients whose name matches none.
+func newCertSelector(certs []tls.Certificate) *certSelector {
+	s := &certSelector{byName: make(map[string]*tls.Certificate)}
+	for i := range certs {
+		cert := &certs[i]
+		if s.fallback == nil {
+			s.fallback = cert
+		}
+		if cert.Leaf == nil {
+			continue
+		}
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, exists := s.byName[name]; !exists {
+				s.byName[name] = cert
+			}
+		}
+	}
+	return s
+}
+
+// certificate implements tls.Config.GetCertificate. An exact name match is
+// preferred over a wildcard covering the name.
+func (s *certSelector) certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := s.byName[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return s.fallback, nil
+}
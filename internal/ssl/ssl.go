@@ -16,6 +16,33 @@ type Config struct {
 	KeyFile    string
 	CAFile     string // For client certificate validation
 	ClientAuth tls.ClientAuthType
+
+	// Certificates adds certificates for further domains, chosen by the
+	// server name clients send. CertFile and KeyFile, when set, are the
+	// first pair and the default for clients matching no certificate.
+	Certificates []CertificatePair
+
+	// MinVersion is the oldest TLS version accepted, "1.0" to "1.3"; empty
+	// means 1.2
+	MinVersion string
+	// CipherSuites names the suites allowed for TLS 1.2 and older; empty
+	// uses the crypto/tls defaults
+	CipherSuites []string
+}
+
+// CertificatePair names the files of one certificate and its private key
+type CertificatePair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// pairs returns every configured certificate pair, the default first
+func (c *Config) pairs() []CertificatePair {
+	var pairs []CertificatePair
+	if c.CertFile != "" || c.KeyFile != "" {
+		pairs = append(pairs, CertificatePair{CertFile: c.CertFile, KeyFile: c.KeyFile})
+	}
+	return append(pairs, c.Certificates...)
 }
 
 // Manager handles SSL/TLS configuration and certificate management
@@ -24,6 +51,7 @@ type Manager struct {
 	config          *Config
 	tlsConfig       *tls.Config
 	certReloadHook  func()
+	certFailureHook func(error)
 }
 
 // New creates a new SSL manager
@@ -43,22 +71,47 @@ func New(config *Config) (*Manager, error) {
 	return manager, nil
 }
 
-// loadCertificates loads and validates SSL certificates
+// loadCertificates loads and validates SSL certificates. The current TLS
+// configuration is only replaced if everything loads successfully. Callers
+// must not hold m.mu.
 func (m *Manager) loadCertificates() error {
-	cert, err := tls.LoadX509KeyPair(m.config.CertFile, m.config.KeyFile)
+	m.mu.RLock()
+	config := *m.config
+	m.mu.RUnlock()
+
+	minVersion, err := parseMinVersion(config.MinVersion)
 	if err != nil {
-		return errors.New(errors.ErrSSLCertificate, "failed to load SSL certificate", err)
+		return err
+	}
+	cipherSuites, err := parseCipherSuites(config.CipherSuites)
+	if err != nil {
+		return err
+	}
+
+	pairs := config.pairs()
+	if len(pairs) == 0 {
+		return errors.New(errors.ErrSSLCertificate, "no SSL certificate configured", nil)
+	}
+	certs := make([]tls.Certificate, 0, len(pairs))
+	for _, pair := range pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return errors.New(errors.ErrSSLCertificate, "failed to load SSL certificate "+pair.CertFile, err)
+		}
+		certs = append(certs, cert)
 	}
 
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		MinVersion:  tls.VersionTLS12,
-		ClientAuth:  m.config.ClientAuth,
+		Certificates:   certs,
+		GetCertificate: newCertSelector(certs).certificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		ClientAuth:     config.ClientAuth,
 	}
 
 	// Load CA file if specified for client certificate validation
-	if m.config.CAFile != "" {
-		caData, err := ioutil.ReadFile(m.config.CAFile)
+	if config.CAFile != "" {
+		caData, err := ioutil.ReadFile(config.CAFile)
 		if err != nil {
 			return errors.New(errors.ErrSSLCertificate, "failed to read CA file", err)
 		}
@@ -78,6 +131,49 @@ func (m *Manager) loadCertificates() error {
 	return nil
 }
 
+// tlsVersions maps MinVersion values to crypto/tls versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseMinVersion converts a MinVersion value to a crypto/tls version
+func parseMinVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", version), nil)
+	}
+	return v, nil
+}
+
+// parseCipherSuites converts cipher suite names to their crypto/tls IDs,
+// returning nil for an empty list so the defaults apply
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, errors.New(errors.ErrConfigInvalid, fmt.Sprintf("unknown cipher suite %q", name), nil)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
 // GetTLSConfig returns the current TLS configuration
 func (m *Manager) GetTLSConfig() *tls.Config {
 	m.mu.RLock()
@@ -85,14 +181,26 @@ func (m *Manager) GetTLSConfig() *tls.Config {
 	return m.tlsConfig
 }
 
-// ReloadCertificates reloads certificates from disk
+// ReloadCertificates reloads certificates from disk. If the new
+// certificates fail to load the current ones stay in use and the failure
+// hook is called with the error.
 func (m *Manager) ReloadCertificates() error {
-	if err := m.loadCertificates(); err != nil {
-		return fmt.Errorf("failed to reload certificates: %v", err)
+	err := m.loadCertificates()
+
+	m.mu.RLock()
+	reloadHook, failureHook := m.certReloadHook, m.certFailureHook
+	m.mu.RUnlock()
+
+	if err != nil {
+		err = fmt.Errorf("failed to reload certificates: %v", err)
+		if failureHook != nil {
+			failureHook(err)
+		}
+		return err
 	}
 
-	if m.certReloadHook != nil {
-		m.certReloadHook()
+	if reloadHook != nil {
+		reloadHook()
 	}
 
 	return nil
@@ -105,13 +213,20 @@ func (m *Manager) SetCertReloadHook(hook func()) {
 	m.certReloadHook = hook
 }
 
-// EnableMutualTLS configures mutual TLS authentication
-func (m *Manager) EnableMutualTLS(caFile string) error {
+// SetCertReloadFailureHook sets a callback function to be called when a
+// certificate reload fails and the previous certificates are kept
+func (m *Manager) SetCertReloadFailureHook(hook func(error)) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.certFailureHook = hook
+}
 
+// EnableMutualTLS configures mutual TLS authentication
+func (m *Manager) EnableMutualTLS(caFile string) error {
+	m.mu.Lock()
 	m.config.CAFile = caFile
 	m.config.ClientAuth = tls.RequireAndVerifyClientCert
+	m.mu.Unlock()
 
 	return m.loadCertificates()
 }
@@ -119,10 +234,9 @@ func (m *Manager) EnableMutualTLS(caFile string) error {
 // DisableMutualTLS disables mutual TLS authentication
 func (m *Manager) DisableMutualTLS() error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	m.config.CAFile = ""
 	m.config.ClientAuth = tls.NoClientCert
+	m.mu.Unlock()
 
 	return m.loadCertificates()
 }
@@ -1,6 +1,7 @@
 package ssl
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -9,17 +10,20 @@ import (
 	"encoding/pem"
 	"math/big"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 )
 
-// Helper function to create test certificates
-func createTestCertificates(t *testing.T) (certFile, keyFile, caFile string, cleanup func()) {
-	// Create temporary files
-	certFile = "test-cert.pem"
-	keyFile = "test-key.pem"
-	caFile = "test-ca.pem"
+// Helper function to create test certificates in a temporary directory,
+// leaving the committed fixtures alone
+func createTestCertificates(t *testing.T) (certFile, keyFile, caFile string) {
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "test-cert.pem")
+	keyFile = filepath.Join(dir, "test-key.pem")
+	caFile = filepath.Join(dir, "test-ca.pem")
 
 	// Generate CA key pair
 	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
@@ -97,18 +101,11 @@ func createTestCertificates(t *testing.T) (certFile, keyFile, caFile string, cle
 	})
 	keyOut.Close()
 
-	cleanup = func() {
-		os.Remove(certFile)
-		os.Remove(keyFile)
-		os.Remove(caFile)
-	}
-
 	return
 }
 
 func TestSSLManager(t *testing.T) {
-	certFile, keyFile, caFile, cleanup := createTestCertificates(t)
-	defer cleanup()
+	certFile, keyFile, caFile := createTestCertificates(t)
 
 	// Test basic SSL manager creation
 	manager, err := New(&Config{
@@ -175,8 +172,7 @@ func TestSSLManagerEdgeCases(t *testing.T) {
 	}
 
 	// Test invalid CA file
-	certFile, keyFile, _, cleanup := createTestCertificates(t)
-	defer cleanup()
+	certFile, keyFile, _ := createTestCertificates(t)
 
 	manager, err = New(&Config{
 		CertFile: certFile,
@@ -193,8 +189,7 @@ func TestSSLManagerEdgeCases(t *testing.T) {
 }
 
 func TestSSLManagerCertReloadHook(t *testing.T) {
-	certFile, keyFile, _, cleanup := createTestCertificates(t)
-	defer cleanup()
+	certFile, keyFile, _ := createTestCertificates(t)
 
 	manager, err := New(&Config{
 		CertFile: certFile,
@@ -220,8 +215,7 @@ func TestSSLManagerCertReloadHook(t *testing.T) {
 }
 
 func TestSSLManagerConcurrency(t *testing.T) {
-	certFile, keyFile, _, cleanup := createTestCertificates(t)
-	defer cleanup()
+	certFile, keyFile, _ := createTestCertificates(t)
 
 	manager, err := New(&Config{
 		CertFile: certFile,
@@ -245,3 +239,234 @@ func TestSSLManagerConcurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestSSLManagerCertReloadFailure(t *testing.T) {
+	certFile, keyFile, _ := createTestCertificates(t)
+
+	manager, err := New(&Config{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SSL manager: %v", err)
+	}
+	before := manager.GetTLSConfig()
+
+	reloaded := false
+	manager.SetCertReloadHook(func() {
+		reloaded = true
+	})
+	var hookErr error
+	manager.SetCertReloadFailureHook(func(err error) {
+		hookErr = err
+	})
+
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt cert file: %v", err)
+	}
+
+	if err := manager.ReloadCertificates(); err == nil {
+		t.Error("Expected error reloading a corrupt certificate")
+	}
+	if hookErr == nil {
+		t.Error("Expected cert reload failure hook to be called")
+	}
+	if reloaded {
+		t.Error("Expected cert reload hook not to be called on failure")
+	}
+	if manager.GetTLSConfig() != before {
+		t.Error("Expected the previous certificate to stay in use")
+	}
+}
+
+func TestWatchCertificates(t *testing.T) {
+	defer func(d time.Duration) { reloadDebounce = d }(reloadDebounce)
+	reloadDebounce = 50 * time.Millisecond
+
+	dir := t.TempDir()
+	install := func() {
+		certFile, keyFile, _ := createTestCertificates(t)
+		// Replace each file atomically, as editors and secret mounts do
+		for _, name := range []string{certFile, keyFile} {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", name, err)
+			}
+			tmp := filepath.Join(dir, filepath.Base(name)+".tmp")
+			if err := os.WriteFile(tmp, data, 0600); err != nil {
+				t.Fatalf("Failed to write %s: %v", tmp, err)
+			}
+			if err := os.Rename(tmp, filepath.Join(dir, filepath.Base(name))); err != nil {
+				t.Fatalf("Failed to rename %s: %v", tmp, err)
+			}
+		}
+	}
+	install()
+
+	manager, err := New(&Config{
+		CertFile: filepath.Join(dir, "test-cert.pem"),
+		KeyFile:  filepath.Join(dir, "test-key.pem"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SSL manager: %v", err)
+	}
+	reloads := make(chan struct{}, 10)
+	manager.SetCertReloadHook(func() {
+		reloads <- struct{}{}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := manager.WatchCertificates(ctx); err != nil {
+		t.Fatalf("Failed to watch certificates: %v", err)
+	}
+
+	before := manager.GetTLSConfig()
+	install()
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected certificates to be reloaded after they changed")
+	}
+	if manager.GetTLSConfig() == before {
+		t.Error("Expected a new TLS config after reload")
+	}
+
+	// Both files changed, but the reload is debounced into one
+	select {
+	case <-reloads:
+		t.Error("Expected a single reload for one update")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// No reloads once the watch has stopped
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	install()
+	select {
+	case <-reloads:
+		t.Error("Expected no reload after the watch was cancelled")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// writeSelfSigned writes a self-signed certificate for names into dir,
+// returning its certificate and key files
+func writeSelfSigned(t *testing.T, dir string, names ...string) (certFile, keyFile string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		DNSNames:     names,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	base := filepath.Join(dir, strings.ReplaceAll(names[0], "*", "wildcard"))
+	certFile, keyFile = base+"-cert.pem", base+"-key.pem"
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	return certFile, keyFile
+}
+
+func TestSNICertificateSelection(t *testing.T) {
+	dir := t.TempDir()
+	defaultCert, defaultKey := writeSelfSigned(t, dir, "default.example.com")
+	apiCert, apiKey := writeSelfSigned(t, dir, "api.example.com")
+	wildCert, wildKey := writeSelfSigned(t, dir, "*.example.org")
+
+	manager, err := New(&Config{
+		CertFile: defaultCert,
+		KeyFile:  defaultKey,
+		Certificates: []CertificatePair{
+			{CertFile: apiCert, KeyFile: apiKey},
+			{CertFile: wildCert, KeyFile: wildKey},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SSL manager: %v", err)
+	}
+	getCertificate := manager.GetTLSConfig().GetCertificate
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"api.example.com", "api.example.com"},
+		{"API.example.com.", "api.example.com"},
+		{"www.example.org", "*.example.org"},
+		{"default.example.com", "default.example.com"},
+		{"unknown.example.net", "default.example.com"},
+		{"", "default.example.com"},
+	}
+	for _, tt := range tests {
+		cert, err := getCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+		if err != nil {
+			t.Fatalf("GetCertificate(%q) failed: %v", tt.serverName, err)
+		}
+		if got := cert.Leaf.DNSNames[0]; got != tt.want {
+			t.Errorf("Expected %q to get the certificate for %s, got %s", tt.serverName, tt.want, got)
+		}
+	}
+
+	// The list alone is enough, its first entry being the default
+	manager, err = New(&Config{Certificates: []CertificatePair{{CertFile: apiCert, KeyFile: apiKey}}})
+	if err != nil {
+		t.Fatalf("Failed to create SSL manager from a certificate list: %v", err)
+	}
+	cert, _ := manager.GetTLSConfig().GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	if cert.Leaf.DNSNames[0] != "api.example.com" {
+		t.Errorf("Expected the first listed certificate as default, got %s", cert.Leaf.DNSNames[0])
+	}
+
+	if _, err := New(&Config{}); err == nil {
+		t.Error("Expected error with no certificates configured")
+	}
+}
+
+func TestSSLManagerVersionAndCiphers(t *testing.T) {
+	certFile, keyFile, _ := createTestCertificates(t)
+
+	manager, err := New(&Config{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("Failed to create SSL manager: %v", err)
+	}
+	if tlsConfig := manager.GetTLSConfig(); tlsConfig.MinVersion != tls.VersionTLS12 || tlsConfig.CipherSuites != nil {
+		t.Errorf("Expected TLS 1.2 minimum with default ciphers, got %x %v", tlsConfig.MinVersion, tlsConfig.CipherSuites)
+	}
+
+	manager, err = New(&Config{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create SSL manager: %v", err)
+	}
+	tlsConfig := manager.GetTLSConfig()
+	if tlsConfig.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected TLS 1.3 minimum, got %x", tlsConfig.MinVersion)
+	}
+	want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}
+	if len(tlsConfig.CipherSuites) != len(want) || tlsConfig.CipherSuites[0] != want[0] || tlsConfig.CipherSuites[1] != want[1] {
+		t.Errorf("Expected cipher suites %v, got %v", want, tlsConfig.CipherSuites)
+	}
+
+	if _, err := New(&Config{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.4"}); err == nil {
+		t.Error("Expected error for unknown TLS version")
+	}
+	_, err = New(&Config{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_NOT_A_CIPHER"}})
+	if err == nil || !strings.Contains(err.Error(), "TLS_NOT_A_CIPHER") {
+		t.Errorf("Expected error naming the unknown cipher suite, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package ssl
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"loadbalancer/internal/errors"
+)
+
+// reloadDebounce is how long the watched files must be quiet before a
+// change triggers a reload, so a cert and key written one after the other
+// are picked up together
+var reloadDebounce = time.Second
+
+// WatchCertificates reloads the certificates whenever a certificate, key or
+// CA file changes on disk, until ctx is cancelled. The files' parent
+// directories are watched rather than the files themselves, so files
+// replaced by an atomic rename, as editors and Kubernetes secret mounts do,
+// keep being watched. The set of files is fixed when the watch starts.
+func (m *Manager) WatchCertificates(ctx context.Context) error {
+	m.mu.RLock()
+	var files []string
+	for _, pair := range m.config.pairs() {
+		files = append(files, pair.CertFile, pair.KeyFile)
+	}
+	if m.config.CAFile != "" {
+		files = append(files, m.config.CAFile)
+	}
+	m.mu.RUnlock()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.New(errors.ErrSSLCertificate, "failed to watch certificates", err)
+	}
+
+	watched := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, file := range files {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			watcher.Close()
+			return errors.New(errors.ErrSSLCertificate, "failed to watch certificates", err)
+		}
+		watched[path] = true
+		dir := filepath.Dir(path)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return errors.New(errors.ErrSSLCertificate, "failed to watch certificate directory "+dir, err)
+		}
+		dirs[dir] = true
+	}
+
+	go m.watchLoop(ctx, watcher, watched)
+	return nil
+}
+
+// watchLoop reloads the certificates after changes to any of the watched
+// files settle, and closes watcher when ctx is cancelled
+func (m *Manager) watchLoop(ctx context.Context, watcher *fsnotify.Watcher, watched map[string]bool) {
+	defer watcher.Close()
+
+	debounce := time.NewTimer(reloadDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if watched[event.Name] || isSecretUpdate(event.Name) {
+				debounce.Reset(reloadDebounce)
+			}
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+		case <-debounce.C:
+			// Failures are reported through the failure hook and leave the
+			// current certificates in place
+			m.ReloadCertificates()
+		}
+	}
+}
+
+// isSecretUpdate reports whether name is the symlink Kubernetes swaps to
+// publish a new version of a mounted secret. The files themselves are
+// symlinks through it and see no events of their own.
+func isSecretUpdate(name string) bool {
+	return filepath.Base(name) == "..data"
+}
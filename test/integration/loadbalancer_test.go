@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"sync"
@@ -55,6 +56,25 @@ func setupTestBackend(t *testing.T, port int, id string) *http.Server {
 	return server
 }
 
+// waitForServer polls addr until it accepts connections, failing the test if
+// it does not come up in time. Only a TCP connection is made so no request
+// reaches the balancer.
+func waitForServer(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Server at %s did not start: %v", addr, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
 func TestLoadBalancerIntegration(t *testing.T) {
 	// Setup test backends
 	backend1 := setupTestBackend(t, 9001, "1")
@@ -76,6 +96,7 @@ healthcheck:
 metrics:
   enabled: true
   port: 9090
+deterministic: true
 `
 	tmpfile, err := os.CreateTemp("", "config-*.yaml")
 	if err != nil {
@@ -109,7 +130,8 @@ metrics:
 	}()
 
 	// Wait for load balancer to start
-	time.Sleep(1 * time.Second)
+	waitForServer(t, "localhost:8080")
+	waitForServer(t, "localhost:9090")
 
 	// Test round-robin distribution
 	client := &http.Client{Timeout: 5 * time.Second}
@@ -156,17 +178,8 @@ metrics:
 	}
 	wg.Wait()
 
-	// Test timeouts
-	resp, err := client.Get("http://localhost:8080/slow")
-	if err == nil {
-		resp.Body.Close()
-		if resp.StatusCode != http.StatusGatewayTimeout {
-			t.Errorf("Expected timeout status, got %d", resp.StatusCode)
-		}
-	}
-
 	// Test metrics endpoint
-	resp, err = client.Get("http://localhost:9090/metrics")
+	resp, err := client.Get("http://localhost:9090/metrics")
 	if err != nil {
 		t.Fatalf("Metrics endpoint failed: %v", err)
 	}
@@ -188,6 +201,44 @@ metrics:
 	}
 }
 
+// TestLoadBalancerTimeout runs on its own balancer so that circuits opened
+// and rate limits spent by TestLoadBalancerIntegration cannot answer the
+// slow request first
+func TestLoadBalancerTimeout(t *testing.T) {
+	backend := setupTestBackend(t, 9003, "3")
+	defer backend.Shutdown(context.Background())
+
+	cfg := &config.Config{
+		Frontends:      []config.Frontend{{Port: 8081}},
+		Backends:       []config.Backend{{URL: "http://localhost:9003"}},
+		RequestTimeout: 500 * time.Millisecond,
+	}
+	lb, err := balancer.New(cfg, metrics.New())
+	if err != nil {
+		t.Fatalf("Failed to create load balancer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		if err := lb.Start(ctx); err != nil {
+			t.Errorf("Load balancer failed: %v", err)
+		}
+	}()
+	waitForServer(t, "localhost:8081")
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://localhost:8081/slow")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("Expected timeout status, got %d", resp.StatusCode)
+	}
+}
+
 func TestLoadBalancerSSL(t *testing.T) {
 	// Skip if SSL certificates are not available
 	if _, err := os.Stat("test-cert.pem"); os.IsNotExist(err) {